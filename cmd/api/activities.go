@@ -12,11 +12,18 @@ import (
 
 func (app *application) createActivityHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name      string    `json:"name"`
-		Notes     string    `json:"notes"`
-		StartTime time.Time `json:"start_time"`
-		EndTime   time.Time `json:"end_time"`
-		TripID    int64     `json:"trip"`
+		Name      string        `json:"name"`
+		Notes     string        `json:"notes"`
+		Category  string        `json:"category"`
+		Tags      []string      `json:"tags"`
+		Schedule  string        `json:"schedule"`
+		StartTime *time.Time    `json:"start_time"`
+		EndTime   *time.Time    `json:"end_time"`
+		StartDate *data.Date    `json:"start_date"`
+		EndDate   *data.Date    `json:"end_date"`
+		Position  int           `json:"position"`
+		Booking   *bookingInput `json:"booking"`
+		TripID    int64         `json:"trip"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -28,25 +35,37 @@ func (app *application) createActivityHandler(w http.ResponseWriter, r *http.Req
 	activity := &data.Activity{
 		Name:      input.Name,
 		Notes:     input.Notes,
+		Category:  input.Category,
+		Tags:      data.NormalizeTags(input.Tags),
+		Schedule:  input.Schedule,
 		StartTime: input.StartTime,
 		EndTime:   input.EndTime,
+		StartDate: input.StartDate,
+		EndDate:   input.EndDate,
+		Position:  input.Position,
 		TripID:    input.TripID,
 	}
 
+	if input.Booking != nil {
+		input.Booking.apply(&activity.Booking)
+	}
+
+	setActivityDefaults(activity)
+
 	v := validator.New()
 	if data.ValidateActivity(v, activity); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Activities.Insert(activity)
+	err = app.models.Activities.Insert(r.Context(), activity)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/activity/%d", activity.ID))
+	headers.Set("Location", fmt.Sprintf("/v1/activities/%d", activity.ID))
 
 	// write a json response with a 201 created status code
 	err = app.writeJSON(w, http.StatusCreated, envelope{"activity": activity}, headers)
@@ -55,14 +74,110 @@ func (app *application) createActivityHandler(w http.ResponseWriter, r *http.Req
 	}
 }
 
-func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
+// setActivityDefaults fills in what a client may leave out when creating an
+// activity.
+func setActivityDefaults(activity *data.Activity) {
+	if activity.Category == "" {
+		activity.Category = data.CategoryOther
+	}
+
+	if activity.Booking.Status == "" {
+		activity.Booking.Status = data.BookingStatusIdea
+	}
+
+	// clients that predate unscheduled activities always send a time slot, so
+	// infer the schedule from whichever fields are present
+	if activity.Schedule == "" {
+		switch {
+		case activity.StartTime != nil || activity.EndTime != nil:
+			activity.Schedule = data.ScheduleTimed
+		case activity.StartDate != nil:
+			activity.Schedule = data.ScheduleAllDay
+		default:
+			activity.Schedule = data.ScheduleUnscheduled
+		}
+	}
+
+	if activity.Schedule == data.ScheduleAllDay || activity.Schedule == data.ScheduleDate {
+		if activity.StartDate != nil && activity.EndDate == nil {
+			activity.EndDate = activity.StartDate
+		}
+	}
+}
+
+// activityInput is a partial update of an activity. Fields left out of the
+// request are nil and keep their current value.
+type activityInput struct {
+	Name      *string       `json:"name"`
+	Notes     *string       `json:"notes"`
+	Category  *string       `json:"category"`
+	Tags      []string      `json:"tags"`
+	Schedule  *string       `json:"schedule"`
+	StartTime *time.Time    `json:"start_time"`
+	EndTime   *time.Time    `json:"end_time"`
+	StartDate *data.Date    `json:"start_date"`
+	EndDate   *data.Date    `json:"end_date"`
+	Position  *int          `json:"position"`
+	Booking   *bookingInput `json:"booking"`
+}
+
+func (input activityInput) apply(activity *data.Activity) {
+	if input.Name != nil {
+		activity.Name = *input.Name
+	}
+
+	if input.Notes != nil {
+		activity.Notes = *input.Notes
+	}
+
+	if input.Category != nil {
+		activity.Category = *input.Category
+	}
+
+	if input.Tags != nil {
+		activity.Tags = data.NormalizeTags(input.Tags)
+	}
+
+	if input.Schedule != nil && *input.Schedule != activity.Schedule {
+		// moving an activity between schedules drops the fields that belong to
+		// the old one, so the client only has to send the new slot
+		activity.Unschedule()
+		activity.Schedule = *input.Schedule
+	}
+
+	if input.StartTime != nil {
+		activity.StartTime = input.StartTime
+	}
+	if input.EndTime != nil {
+		activity.EndTime = input.EndTime
+	}
+	if input.StartDate != nil {
+		activity.StartDate = input.StartDate
+	}
+	if input.EndDate != nil {
+		activity.EndDate = input.EndDate
+	}
+	if input.Position != nil {
+		activity.Position = *input.Position
+	}
+	if input.Booking != nil {
+		input.Booking.apply(&activity.Booking)
+	}
+}
+
+// batchActivitiesHandler creates and updates many of a trip's activities in
+// one request. Items with an id update that activity the same way PATCH does,
+// the rest are created. Every item is validated before anything is written and
+// the whole batch is saved in a single transaction. An item whose version isn't
+// the activity's current one is reported at its index like any other error.
+func (app *application) batchActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	tripID, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	activity, err := app.models.Activities.Get(id)
+	trip, err := app.models.Trips.Get(r.Context(), tripID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -74,10 +189,11 @@ func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	var input struct {
-		Name      *string    `json:"name"`
-		Notes     *string    `json:"notes"`
-		StartTime *time.Time `json:"start_time"`
-		EndTime   *time.Time `json:"end_time"`
+		Activities []struct {
+			ID      *int64 `json:"id"`
+			Version *int32 `json:"version"`
+			activityInput
+		} `json:"activities"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -86,19 +202,185 @@ func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if input.Name != nil {
-		activity.Name = *input.Name
+	v := validator.New()
+
+	if data.ValidateBatchSize(v, "activities", len(input.Activities)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	if input.Notes != nil {
-		activity.Notes = *input.Notes
+	existing, err := app.models.Activities.GetAllByTrip(r.Context(), trip.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	if input.StartTime != nil {
-		activity.StartTime = *input.StartTime
+	byID := make(map[int64]*data.Activity, len(existing))
+	for _, activity := range existing {
+		byID[activity.ID] = activity
 	}
-	if input.EndTime != nil {
-		activity.EndTime = *input.EndTime
+
+	activities := make([]*data.Activity, len(input.Activities))
+	failed := make(map[int]map[string]string)
+	seen := make(map[int64]bool)
+
+	for i, item := range input.Activities {
+		v := validator.New()
+
+		activity := &data.Activity{TripID: trip.ID, Tags: []string{}}
+
+		if item.ID != nil {
+			activity = byID[*item.ID]
+			if activity == nil {
+				v.AddError("id", "must be an activity on this trip")
+				failed[i] = v.Errors
+				continue
+			}
+
+			v.Check(!seen[*item.ID], "id", "must not appear more than once in a batch")
+			seen[*item.ID] = true
+
+			if item.Version != nil {
+				v.Check(*item.Version == activity.Version, "version", fmt.Sprintf("must be the current version, %d", activity.Version))
+			}
+
+			item.apply(activity)
+		} else {
+			item.apply(activity)
+			setActivityDefaults(activity)
+		}
+
+		if data.ValidateActivity(v, activity); !v.Valid() {
+			failed[i] = v.Errors
+		}
+
+		activities[i] = activity
+	}
+
+	if len(failed) > 0 {
+		app.failedBatchValidationResponse(w, r, failed)
+		return
+	}
+
+	err = app.models.Activities.SaveBatch(r.Context(), activities)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"activities": activities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showActivityHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	activity, err := app.models.Activities.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Locations.LoadForActivities(r.Context(), []*data.Activity{activity})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	etag := activityETag(activity)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"activity": activity}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	activity, err := app.models.Activities.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, activity.Version) {
+		return
+	}
+
+	if patchMediaType(r) != "" {
+		// unlike a plain JSON update, a patch describes the whole schedule, so
+		// nothing is cleared implicitly when the schedule changes
+		var patched data.Activity
+
+		err = app.readPatch(w, r, activity, &patched)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
+
+		if patched.Version != activity.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+
+		if field := activityReadOnlyChange(activity, &patched); field != "" {
+			app.failedValidationResponse(w, r, map[string]string{field: "is read-only"})
+			return
+		}
+
+		activity.Name = patched.Name
+		activity.Notes = patched.Notes
+		activity.Category = patched.Category
+		activity.Tags = data.NormalizeTags(patched.Tags)
+		activity.Schedule = patched.Schedule
+		activity.StartTime = patched.StartTime
+		activity.EndTime = patched.EndTime
+		activity.StartDate = patched.StartDate
+		activity.EndDate = patched.EndDate
+		activity.Position = patched.Position
+		activity.Booking = patched.Booking
+	} else {
+		var input activityInput
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		input.apply(activity)
 	}
 
 	v := validator.New()
@@ -108,7 +390,7 @@ func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.models.Activities.Update(activity)
+	err = app.models.Activities.Update(r.Context(), activity)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -119,7 +401,18 @@ func (app *application) updateActivityHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"activity": activity}, nil)
+	// respond with the activity as GET /v1/activities/:id has it, so the ETag
+	// can be used with If-None-Match there
+	err = app.models.Locations.LoadForActivities(r.Context(), []*data.Activity{activity})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", activityETag(activity))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"activity": activity}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -132,7 +425,7 @@ func (app *application) deleteActivityHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.models.Activities.Delete(id)
+	activity, err := app.models.Activities.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -143,6 +436,21 @@ func (app *application) deleteActivityHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !app.checkIfMatch(w, r, activity.Version) {
+		return
+	}
+
+	err = app.models.Activities.DeleteVersion(r.Context(), activity.ID, activity.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "activity successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -156,20 +464,181 @@ func (app *application) listActivitiesHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	activities, err := app.models.Activities.GetAllByTrip(tripID)
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters = app.readFilters(qs, data.ActivityListing, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	activities, metadata, err := app.models.Activities.GetAll(r.Context(), tripID, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	for _, activity := range activities {
-		locations, err := app.models.Locations.GetAllByActivity(activity.ID)
-		if err != nil {
+	err = app.models.Locations.LoadForActivities(r.Context(), activities)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"activities": activities, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// scheduleActivityHandler moves an activity (usually an idea) into a specific time
+// slot, or onto one or more days when it's all-day or date-only.
+func (app *application) scheduleActivityHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	activity, err := app.models.Activities.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, activity.Version) {
+		return
+	}
+
+	var input struct {
+		Schedule  string     `json:"schedule"`
+		StartTime *time.Time `json:"start_time"`
+		EndTime   *time.Time `json:"end_time"`
+		StartDate *data.Date `json:"start_date"`
+		EndDate   *data.Date `json:"end_date"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Schedule == "" {
+		input.Schedule = data.ScheduleTimed
+	}
+
+	v := validator.New()
+
+	switch input.Schedule {
+	case data.ScheduleTimed:
+		v.Check(input.StartTime != nil, "start_time", "must be provided")
+		v.Check(input.EndTime != nil, "end_time", "must be provided")
+		if v.Valid() {
+			activity.ScheduleAt(*input.StartTime, *input.EndTime)
+		}
+	case data.ScheduleAllDay, data.ScheduleDate:
+		v.Check(input.StartDate != nil, "start_date", "must be provided")
+		if v.Valid() {
+			activity.ScheduleOn(input.Schedule, *input.StartDate, input.EndDate)
+		}
+	default:
+		v.AddError("schedule", "must be one of timed, all_day or date")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if data.ValidateActivity(v, activity); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Activities.Update(r.Context(), activity)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// respond with the activity as GET /v1/activities/:id has it, so the ETag
+	// can be used with If-None-Match there
+	err = app.models.Locations.LoadForActivities(r.Context(), []*data.Activity{activity})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", activityETag(activity))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"activity": activity}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reorderIdeasHandler sets the manual order of a trip's unscheduled activities.
+// The request lists every idea to reorder by ID, first to last.
+func (app *application) reorderIdeasHandler(w http.ResponseWriter, r *http.Request) {
+	tripID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Activities []int64 `json:"activities"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Activities) > 0, "activities", "must contain at least 1 activity")
+	v.Check(validator.Unique(input.Activities), "activities", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Activities.ReorderIdeas(r.Context(), tripID, input.Activities)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("activities", "must only contain unscheduled activities belonging to the trip")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
 			app.serverErrorResponse(w, r, err)
-			return
 		}
+		return
+	}
 
-		activity.Locations = locations
+	activities, err := app.models.Activities.GetAllByTrip(r.Context(), tripID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"activities": activities}, nil)
@@ -177,3 +646,18 @@ func (app *application) listActivitiesHandler(w http.ResponseWriter, r *http.Req
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// activityReadOnlyChange returns the name of the first field a patch changed
+// that clients aren't allowed to set, or an empty string if there isn't one.
+func activityReadOnlyChange(activity, patched *data.Activity) string {
+	switch {
+	case patched.ID != activity.ID:
+		return "id"
+	case patched.TripID != activity.TripID:
+		return "trip"
+	case patched.Locations != nil:
+		return "locations"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+func TestCreateIdea(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	status, env := app.serveTest(t, app.createActivityHandler, http.MethodPost, "", `{"name": "Cubs game", "notes": "If they're home.", "trip": 1}`)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, env["error"])
+	}
+
+	var idea data.Activity
+
+	err := json.Unmarshal(env["activity"], &idea)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if idea.Schedule != data.ScheduleUnscheduled || idea.StartTime != nil || idea.StartDate != nil {
+		t.Errorf("got schedule %q, start time %v and start date %v, want an unscheduled idea", idea.Schedule, idea.StartTime, idea.StartDate)
+	}
+
+	activities, err := app.models.Activities.GetAllByTrip(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, activity := range activities {
+		if activity.ID != idea.ID && activity.Schedule == data.ScheduleUnscheduled && activity.Position >= idea.Position {
+			t.Errorf("new idea has position %d, want it after %q at %d", idea.Position, activity.Name, activity.Position)
+		}
+	}
+}
+
+func TestScheduleActivity(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	trip, err := app.models.Trips.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(day, hour int) string {
+		return trip.StartDate.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour).Format(time.RFC3339)
+	}
+	on := func(day int) string {
+		return trip.StartDate.AddDate(0, 0, day).Format(time.DateOnly)
+	}
+
+	// each case schedules the Green Mill idea, picking up where the last
+	// one left it
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		schedule string
+		field    string
+	}{
+		{"timed", `{"start_time": "` + at(1, 8) + `", "end_time": "` + at(1, 10) + `"}`, http.StatusOK, data.ScheduleTimed, ""},
+		{"all day", `{"schedule": "all_day", "start_date": "` + on(2) + `"}`, http.StatusOK, data.ScheduleAllDay, ""},
+		{"over two days", `{"schedule": "date", "start_date": "` + on(1) + `", "end_date": "` + on(2) + `"}`, http.StatusOK, data.ScheduleDate, ""},
+		{"without an end time", `{"schedule": "timed", "start_time": "` + at(1, 8) + `"}`, http.StatusUnprocessableEntity, "", "end_time"},
+		{"ending before it starts", `{"schedule": "timed", "start_time": "` + at(1, 10) + `", "end_time": "` + at(1, 8) + `"}`, http.StatusUnprocessableEntity, "", "end_time"},
+		{"end date before start date", `{"schedule": "date", "start_date": "` + on(2) + `", "end_date": "` + on(1) + `"}`, http.StatusUnprocessableEntity, "", "end_date"},
+		{"in the past", `{"schedule": "all_day", "start_date": "2020-01-01"}`, http.StatusUnprocessableEntity, "", "start_date"},
+		{"unknown schedule", `{"schedule": "someday"}`, http.StatusUnprocessableEntity, "", "schedule"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, env := app.serveTest(t, app.scheduleActivityHandler, http.MethodPost, "5", tt.body)
+			if status != tt.status {
+				t.Fatalf("got status %d, want %d: %s", status, tt.status, env["error"])
+			}
+
+			if tt.field != "" {
+				var errs map[string]string
+
+				err := json.Unmarshal(env["error"], &errs)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if errs[tt.field] == "" {
+					t.Errorf("got %v, want an error for %s", errs, tt.field)
+				}
+				return
+			}
+
+			var activity data.Activity
+
+			err := json.Unmarshal(env["activity"], &activity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if activity.Schedule != tt.schedule {
+				t.Errorf("got schedule %q, want %q", activity.Schedule, tt.schedule)
+			}
+			if (activity.StartTime != nil) != (tt.schedule == data.ScheduleTimed) || (activity.StartDate != nil) == (tt.schedule == data.ScheduleTimed) {
+				t.Errorf("got start time %v and start date %v for a %s activity", activity.StartTime, activity.StartDate, tt.schedule)
+			}
+		})
+	}
+}
+
+func TestReorderIdeas(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	ideas := func() []int64 {
+		t.Helper()
+
+		activities, err := app.models.Activities.GetAllByTrip(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		activities = slices.DeleteFunc(activities, func(a *data.Activity) bool { return a.Schedule != data.ScheduleUnscheduled })
+		slices.SortFunc(activities, func(a, b *data.Activity) int { return a.Position - b.Position })
+
+		ids := []int64{}
+		for _, activity := range activities {
+			ids = append(ids, activity.ID)
+		}
+		return ids
+	}
+
+	if got := ideas(); !slices.Equal(got, []int64{4, 5}) {
+		t.Fatalf("got ideas %v before reordering, want [4 5]", got)
+	}
+
+	status, env := app.serveTest(t, app.reorderIdeasHandler, http.MethodPut, "1", `{"activities": [5, 4]}`)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", status, http.StatusOK, env["error"])
+	}
+
+	if got := ideas(); !slices.Equal(got, []int64{5, 4}) {
+		t.Errorf("got ideas %v after reordering, want [5 4]", got)
+	}
+
+	invalid := []struct {
+		name string
+		body string
+	}{
+		{"no ideas", `{"activities": []}`},
+		{"duplicates", `{"activities": [4, 4]}`},
+		{"a scheduled activity", `{"activities": [1, 4]}`},
+		{"another trip's activity", `{"activities": [4, 999]}`},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := app.serveTest(t, app.reorderIdeasHandler, http.MethodPut, "1", tt.body)
+			if status != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d, want %d", status, http.StatusUnprocessableEntity)
+			}
+
+			if got := ideas(); !slices.Equal(got, []int64{5, 4}) {
+				t.Errorf("got ideas %v after a failed reorder, want [5 4]", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestBatchActivities(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	activity, err := app.models.Activities.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := fmt.Sprintf(`{"activities": [
+		{"id": 1, "version": %d, "notes": "Bring a jacket."},
+		{"name": "Second City show", "notes": "Mainstage revue.", "category": "entertainment"}
+	]}`, activity.Version)
+
+	status, env := app.serveTest(t, app.batchActivitiesHandler, http.MethodPost, "1", body)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", status, http.StatusOK, env["error"])
+	}
+
+	var saved []struct {
+		ID      int64  `json:"id"`
+		Name    string `json:"name"`
+		Notes   string `json:"notes"`
+		Version int32  `json:"version"`
+	}
+
+	err = json.Unmarshal(env["activities"], &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(saved) != 2 {
+		t.Fatalf("got %d activities, want 2", len(saved))
+	}
+	if saved[0].Notes != "Bring a jacket." || saved[0].Version != activity.Version+1 {
+		t.Errorf("got %+v for the update", saved[0])
+	}
+	if saved[1].ID == 0 || saved[1].Name != "Second City show" {
+		t.Errorf("got %+v for the insert", saved[1])
+	}
+}
+
+func TestBatchVersionMismatch(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		want    map[int][]string
+	}{
+		{"activities", app.batchActivitiesHandler, `{"activities": [
+			{"id": 1, "notes": "Bring a jacket."},
+			{"id": 2, "version": 999, "notes": "Book a table."},
+			{"id": 3, "version": 998, "name": ""}
+		]}`, map[int][]string{1: {"version"}, 2: {"name", "version"}}},
+		{"stays", app.batchStaysHandler, `{"stays": [
+			{"id": 1, "version": 999, "phone": "312-555-0100"},
+			{"id": 2, "phone": "312-555-0101"}
+		]}`, map[int][]string{0: {"version"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, env := app.serveTest(t, tt.handler, http.MethodPost, "1", tt.body)
+			if status != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want %d", status, http.StatusUnprocessableEntity)
+			}
+
+			var failed map[int]map[string]string
+
+			err := json.Unmarshal(env["error"], &failed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(failed) != len(tt.want) {
+				t.Errorf("got errors for %d items, want %d: %v", len(failed), len(tt.want), failed)
+			}
+
+			for i, fields := range tt.want {
+				for _, field := range fields {
+					if failed[i][field] == "" {
+						t.Errorf("item %d: got %v, want an error for %s", i, failed[i], field)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// bookingInput is a partial update of an activity's or stay's booking. Fields
+// left out of the request are nil and keep their current value.
+type bookingInput struct {
+	Status               *string    `json:"status"`
+	ConfirmationNumber   *string    `json:"confirmation_number"`
+	Provider             *string    `json:"provider"`
+	Cost                 *int64     `json:"cost"`
+	Currency             *string    `json:"currency"`
+	CancellationDeadline *time.Time `json:"cancellation_deadline"`
+	PaymentDue           *data.Date `json:"payment_due"`
+}
+
+func (input bookingInput) apply(booking *data.Booking) {
+	if input.Status != nil {
+		booking.Status = strings.ToLower(*input.Status)
+	}
+	if input.ConfirmationNumber != nil {
+		booking.ConfirmationNumber = *input.ConfirmationNumber
+	}
+	if input.Provider != nil {
+		booking.Provider = *input.Provider
+	}
+	if input.Cost != nil {
+		booking.Cost = input.Cost
+	}
+	if input.Currency != nil {
+		booking.Currency = strings.ToUpper(*input.Currency)
+	}
+	if input.CancellationDeadline != nil {
+		booking.CancellationDeadline = input.CancellationDeadline
+	}
+	if input.PaymentDue != nil {
+		booking.PaymentDue = input.PaymentDue
+	}
+}
+
+// listBookingsHandler returns the activities and stays on a trip that still
+// need booking, paying or a decision about cancelling, soonest deadline first.
+func (app *application) listBookingsHandler(w http.ResponseWriter, r *http.Request) {
+	trip, ok := app.tripFromParam(w, r)
+	if !ok || !app.checkTripMember(w, r, trip.ID) {
+		return
+	}
+
+	items, err := app.models.Bookings.GetAllByTrip(r.Context(), trip.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"bookings": items}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// remindBookings emails everyone on a trip about booking deadlines coming up
+// within the reminder lead, checking every interval until ctx is cancelled.
+func (app *application) remindBookings(ctx context.Context) {
+	ticker := time.NewTicker(app.config.reminders.interval)
+	defer ticker.Stop()
+
+	for {
+		err := app.sendBookingReminders(ctx)
+		if err != nil && ctx.Err() == nil {
+			app.logger.Error(err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendBookingReminders sends the reminders that are due now. Each traveler it
+// reaches is recorded, and a reminder is only marked as sent once it has
+// reached all of them, so when sending fails for some the next pass retries
+// just those.
+func (app *application) sendBookingReminders(ctx context.Context) error {
+	reminders, err := app.models.Bookings.GetDueReminders(ctx, app.config.reminders.lead)
+	if err != nil {
+		return err
+	}
+
+	for _, reminder := range reminders {
+		trip, err := app.models.Trips.Get(ctx, reminder.TripID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				// the trip was deleted since the reminders were read
+				continue
+			default:
+				return err
+			}
+		}
+
+		recipients, err := app.tripRecipients(ctx, trip)
+		if err != nil {
+			return err
+		}
+
+		data := map[string]any{
+			"tripName": trip.Name,
+			"kind":     reminder.Item.Kind,
+			"name":     reminder.Item.Name,
+			"deadline": reminder.Deadline,
+			"due":      reminder.Due.UTC().Format("Mon Jan 2, 2006 15:04 MST"),
+			"booking":  reminder.Item.Booking,
+		}
+
+		reminded, err := app.models.Bookings.GetRemindedRecipients(ctx, reminder)
+		if err != nil {
+			return err
+		}
+
+		sent := true
+
+		for _, recipient := range recipients {
+			if slices.ContainsFunc(reminded, func(email string) bool { return strings.EqualFold(email, recipient) }) {
+				continue
+			}
+
+			err = app.mailer.Send(recipient, "booking_reminder.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error(), "trip", trip.ID, "kind", reminder.Item.Kind, "id", reminder.Item.ID)
+				sent = false
+				continue
+			}
+
+			err = app.models.Bookings.MarkRecipientReminded(ctx, reminder, recipient)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !sent {
+			continue
+		}
+
+		err = app.models.Bookings.MarkReminded(ctx, reminder)
+		if err != nil {
+			return err
+		}
+
+		app.logger.Info("booking reminder sent", "trip", trip.ID, "kind", reminder.Item.Kind, "id", reminder.Item.ID, "deadline", reminder.Deadline)
+	}
+
+	return nil
+}
+
+// tripRecipients returns the email addresses of a trip's creator and everyone
+// going on it.
+func (app *application) tripRecipients(ctx context.Context, trip *data.Trip) ([]string, error) {
+	creator, err := app.models.Users.Get(ctx, trip.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	tripgoers, err := app.models.Users.GetAllByTrip(ctx, trip.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := []string{creator.Email}
+
+	for _, user := range tripgoers {
+		if user.ID != creator.ID {
+			recipients = append(recipients, user.Email)
+		}
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+func TestListBookings(t *testing.T) {
+	app := newTestApplication(t, nil)
+	solo := insertSoloTrip(t, app)
+
+	status, env := app.serveTest(t, app.listBookingsHandler, http.MethodGet, "1", "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", status, http.StatusOK)
+	}
+
+	var items []data.BookingItem
+
+	err := json.Unmarshal(env["bookings"], &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) == 0 {
+		t.Error("got no bookings for the demo trip")
+	}
+
+	// confirmation numbers and costs are only for the people on the trip
+	status, _ = app.serveTest(t, app.listBookingsHandler, http.MethodGet, strconv.FormatInt(solo.ID, 10), "")
+	if status != http.StatusForbidden {
+		t.Errorf("got status %d for another trip's bookings, want %d", status, http.StatusForbidden)
+	}
+}
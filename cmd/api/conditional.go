@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// ETags are built from the version column of the record they describe, as
+// "v<version>". Representations that embed other records append a hash of the
+// embedded records' versions (and of the query string that picked them), as
+// "v<version>-<hash>", so a change to a child still changes the tag. If-Match
+// only looks at the version part, since that's what an update is checked against.
+
+func versionETag(version int32) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// compositeETag returns the ETag for a record at version whose representation
+// also depends on parts.
+func compositeETag(version int32, parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintln(h, part)
+	}
+
+	return fmt.Sprintf(`"v%d-%x"`, version, h.Sum(nil)[:8])
+}
+
+// etagVersion extracts the version from an ETag built by versionETag or
+// compositeETag. Weak tags are accepted.
+func etagVersion(tag string) (int32, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	tag = strings.Trim(tag, `"`)
+
+	if !strings.HasPrefix(tag, "v") {
+		return 0, false
+	}
+
+	digits, _, _ := strings.Cut(tag[1:], "-")
+
+	version, err := strconv.ParseInt(digits, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return int32(version), true
+}
+
+func tripETag(trip *data.Trip, rawQuery string) string {
+	parts := []string{rawQuery}
+
+	for _, activity := range trip.Activities {
+		parts = append(parts, fmt.Sprintf("activity:%d:%d", activity.ID, activity.Version))
+		for _, location := range activity.Locations {
+			parts = append(parts, fmt.Sprintf("location:%d:%d", location.ID, location.Version))
+		}
+	}
+
+	for _, segment := range trip.Segments {
+		parts = append(parts, fmt.Sprintf("segment:%d:%d", segment.ID, segment.Version))
+	}
+
+	for _, stay := range trip.Stays {
+		parts = append(parts, fmt.Sprintf("stay:%d:%d", stay.ID, stay.Version))
+	}
+
+	for _, user := range trip.TripGoers {
+		parts = append(parts, fmt.Sprintf("tripgoer:%d:%d", user.ID, user.Version))
+	}
+
+	return compositeETag(trip.Version, parts...)
+}
+
+func activityETag(activity *data.Activity) string {
+	parts := []string{}
+
+	for _, location := range activity.Locations {
+		parts = append(parts, fmt.Sprintf("location:%d:%d", location.ID, location.Version))
+	}
+
+	return compositeETag(activity.Version, parts...)
+}
+
+// notModified handles If-None-Match on GET requests. When the client's cached
+// copy is current it sends a 304 Not Modified response and returns true.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	weak := func(tag string) string {
+		return strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == "*" || weak(tag) == weak(etag) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	errPreconditionFailed   = errors.New("precondition failed")
+	errPreconditionRequired = errors.New("precondition required")
+)
+
+// checkIfMatch handles If-Match on PATCH and DELETE requests against the current
+// version of the record. It sends a 412 Precondition Failed response and returns
+// false if none of the client's tags are for that version, and when
+// -require-if-match is set it rejects requests without the header with 428
+// Precondition Required.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, version int32) bool {
+	switch err := app.ifMatch(r, version); {
+	case errors.Is(err, errPreconditionRequired):
+		app.preconditionRequiredResponse(w, r)
+		return false
+	case errors.Is(err, errPreconditionFailed):
+		app.preconditionFailedResponse(w, r)
+		return false
+	default:
+		return true
+	}
+}
+
+// ifMatch is checkIfMatch for handlers that check inside a transaction. It
+// returns errPreconditionFailed or errPreconditionRequired rather than sending
+// the response, which is left until the transaction has been rolled back.
+func (app *application) ifMatch(r *http.Request, version int32) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if app.config.concurrency.requireIfMatch {
+			return errPreconditionRequired
+		}
+		return nil
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == "*" {
+			return nil
+		}
+
+		if tagVersion, ok := etagVersion(tag); ok && tagVersion == version {
+			return nil
+		}
+	}
+
+	return errPreconditionFailed
+}
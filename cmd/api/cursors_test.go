@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// listActivitiesPage lists a page of the demo trip's activities.
+func listActivitiesPage(t *testing.T, app *application, query url.Values) (int, []string, data.Metadata, map[string]string) {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+	params := httprouter.Params{{Key: "id", Value: "1"}}
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+
+	w := httptest.NewRecorder()
+	app.listActivitiesHandler(w, r)
+
+	var env struct {
+		Activities []struct {
+			Name string `json:"name"`
+		} `json:"activities"`
+		Metadata data.Metadata     `json:"metadata"`
+		Error    map[string]string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("response isn't a JSON object: %s", w.Body)
+	}
+
+	names := []string{}
+	for _, activity := range env.Activities {
+		names = append(names, activity.Name)
+	}
+
+	return w.Code, names, env.Metadata, env.Error
+}
+
+// Rows created while a client pages through a list with cursors don't shift
+// the pages it hasn't fetched yet, so nothing is skipped or repeated.
+func TestCursorPagination(t *testing.T) {
+	app := newTestApplication(t, nil)
+	app.config.cursor.key = []byte("test cursor key")
+
+	query := url.Values{"sort": {"name"}, "page_size": {"2"}}
+
+	status, first, metadata, errs := listActivitiesPage(t, app, query)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %v", status, http.StatusOK, errs)
+	}
+	if metadata.PrevCursor != "" {
+		t.Errorf("got a previous cursor on the first page")
+	}
+
+	// one sorts before the page already seen and one after it
+	for _, name := range []string{"Aquarium", "Zoo"} {
+		err := app.models.Activities.Insert(context.Background(), &data.Activity{
+			Name: name, Notes: "Added mid-scroll.", Category: data.CategorySightseeing, Tags: []string{},
+			Schedule: data.ScheduleUnscheduled, Booking: data.Booking{Status: data.BookingStatusIdea}, TripID: 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := slices.Clone(first)
+	pages := [][]string{first}
+
+	for metadata.NextCursor != "" {
+		query.Set("after", metadata.NextCursor)
+
+		var names []string
+		status, names, metadata, errs = listActivitiesPage(t, app, query)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %v", status, http.StatusOK, errs)
+		}
+		if metadata.TotalRecords != 0 {
+			t.Errorf("got total_records %d on a cursor page, want it left out", metadata.TotalRecords)
+		}
+
+		seen = append(seen, names...)
+		pages = append(pages, names)
+
+		if len(pages) > 10 {
+			t.Fatal("cursors don't reach the end of the list")
+		}
+	}
+
+	want := []string{"Architecture boat tour", "Deep dish dinner", "Jazz at the Green Mill", "Museum day", "Walk the Lakefront Trail", "Zoo"}
+	if !slices.Equal(seen, want) {
+		t.Fatalf("got %q paging forward, want %q", seen, want)
+	}
+
+	// the last page's previous cursor leads back to the page before it
+	query.Del("after")
+	query.Set("before", metadata.PrevCursor)
+
+	status, names, metadata, errs := listActivitiesPage(t, app, query)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %v", status, http.StatusOK, errs)
+	}
+	if want := pages[len(pages)-2]; !slices.Equal(names, want) {
+		t.Errorf("got %q paging back, want %q", names, want)
+	}
+	if metadata.NextCursor == "" || metadata.PrevCursor == "" {
+		t.Errorf("got next cursor %q and previous cursor %q, want both", metadata.NextCursor, metadata.PrevCursor)
+	}
+}
+
+func TestCursorValidation(t *testing.T) {
+	app := newTestApplication(t, nil)
+	app.config.cursor.key = []byte("test cursor key")
+
+	byName := data.EncodeCursor(app.config.cursor.key, data.Cursor{Sort: "name", Value: "Deep dish dinner", ID: 2})
+	payload, _, _ := strings.Cut(byName, ".")
+	forged := data.EncodeCursor([]byte("some other key"), data.Cursor{Sort: "name", Value: "Deep dish dinner", ID: 2})
+
+	tests := []struct {
+		name  string
+		query url.Values
+		key   string
+	}{
+		{"unsigned", url.Values{"sort": {"name"}, "after": {payload}}, "after"},
+		{"signed with another key", url.Values{"sort": {"name"}, "after": {forged}}, "after"},
+		{"garbage", url.Values{"sort": {"name"}, "before": {"not.a-cursor"}}, "before"},
+		{"another sort", url.Values{"sort": {"-name"}, "after": {byName}}, "after"},
+		{"after and before", url.Values{"sort": {"name"}, "after": {byName}, "before": {byName}}, "after"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, _, errs := listActivitiesPage(t, app, tt.query)
+			if status != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want %d", status, http.StatusUnprocessableEntity)
+			}
+			if errs[tt.key] == "" {
+				t.Errorf("got errors %v, want one for %s", errs, tt.key)
+			}
+		})
+	}
+
+	status, names, _, errs := listActivitiesPage(t, app, url.Values{"sort": {"name"}, "after": {byName}})
+	if status != http.StatusOK {
+		t.Fatalf("got status %d for a valid cursor, want %d: %v", status, http.StatusOK, errs)
+	}
+	if len(names) == 0 || names[0] != "Jazz at the Green Mill" {
+		t.Errorf("got %q after Deep dish dinner", names)
+	}
+}
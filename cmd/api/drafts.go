@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// listDraftsHandler returns the drafts waiting on a trip, oldest first.
+func (app *application) listDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	trip, ok := app.tripFromParam(w, r)
+	if !ok || !app.checkTripMember(w, r, trip.ID) {
+		return
+	}
+
+	drafts, err := app.models.Drafts.GetAllByTrip(r.Context(), trip.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"drafts": drafts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showDraftHandler(w http.ResponseWriter, r *http.Request) {
+	draft, ok := app.draftFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"draft": draft}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmDraftHandler turns a draft into the stay or activity it describes
+// and removes the draft. The request can have a body with changes to make
+// first, in the same form as a PATCH of a stay or activity, for whatever the
+// email got wrong or left out. Without one, the draft is saved as it is.
+func (app *application) confirmDraftHandler(w http.ResponseWriter, r *http.Request) {
+	draft, ok := app.draftFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	trip, err := app.models.Trips.Get(r.Context(), draft.TripID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch draft.Kind {
+	case data.DraftKindStay:
+		app.confirmStayDraft(w, r, draft, trip)
+	case data.DraftKindActivity:
+		app.confirmActivityDraft(w, r, draft)
+	default:
+		app.serverErrorResponse(w, r, fmt.Errorf("unknown draft kind %q", draft.Kind))
+	}
+}
+
+func (app *application) confirmStayDraft(w http.ResponseWriter, r *http.Request, draft *data.Draft, trip *data.Trip) {
+	stay := draft.Stay
+	stay.TripID = draft.TripID
+
+	if r.ContentLength != 0 {
+		var input stayInput
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		input.apply(stay)
+	}
+
+	v := validator.New()
+
+	geocoded, err := app.geocodeAddress(r.Context(), v, stay.Address, &stay.Lat, &stay.Lng)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateStay(v, stay)
+	data.ValidateStayInTrip(v, stay, trip)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.saveDraft(r, draft, func(tx data.Models) error {
+		return tx.Stays.Insert(r.Context(), stay)
+	})
+	if err != nil {
+		app.saveDraftFailedResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/stays/%d", stay.ID))
+
+	env := envelope{"stay": stay}
+	if geocoded != nil {
+		env["geocode"] = geocoded
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmActivityDraft(w http.ResponseWriter, r *http.Request, draft *data.Draft) {
+	activity := draft.Activity
+	activity.TripID = draft.TripID
+
+	if r.ContentLength != 0 {
+		var input activityInput
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		input.apply(activity)
+	}
+
+	setActivityDefaults(activity)
+
+	v := validator.New()
+	if data.ValidateActivity(v, activity); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.saveDraft(r, draft, func(tx data.Models) error {
+		return tx.Activities.Insert(r.Context(), activity)
+	})
+	if err != nil {
+		app.saveDraftFailedResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/activities/%d", activity.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"activity": activity}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// saveDraft runs insert and removes the draft in one transaction, so a draft
+// is only ever confirmed once. It returns ErrEditConflict if the draft was
+// confirmed or discarded since it was read.
+func (app *application) saveDraft(r *http.Request, draft *data.Draft, insert func(tx data.Models) error) error {
+	return app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Drafts.Delete(r.Context(), draft.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return data.ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return insert(tx)
+	})
+}
+
+func (app *application) saveDraftFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteDraftHandler discards a draft without adding anything to the trip.
+func (app *application) deleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	draft, ok := app.draftFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Drafts.Delete(r.Context(), draft.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "draft successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// draftFromParam loads the draft named by the :id parameter, sending a 404 or
+// server error and returning false if it can't, or a 403 if the user isn't on
+// the draft's trip.
+func (app *application) draftFromParam(w http.ResponseWriter, r *http.Request) (*data.Draft, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	draft, err := app.models.Drafts.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !app.checkTripMember(w, r, draft.TripID) {
+		return nil, false
+	}
+
+	return draft, true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// Drafts and inboxes are only for the people on a trip.
+func TestDraftsNeedTripMembers(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApplication(t, nil)
+
+	solo := insertSoloTrip(t, app)
+
+	newDraft := func(tripID int64) string {
+		draft := &data.Draft{
+			TripID: tripID,
+			Kind:   data.DraftKindActivity,
+			Activity: &data.Activity{
+				Name:     "Red Rocks show",
+				Category: data.CategoryEntertainment,
+				Tags:     []string{},
+				Schedule: data.ScheduleUnscheduled,
+				Booking:  data.Booking{Status: data.BookingStatusBooked},
+			},
+		}
+
+		err := app.models.Drafts.Insert(ctx, draft)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return strconv.FormatInt(draft.ID, 10)
+	}
+
+	own, other := newDraft(1), newDraft(solo.ID)
+	soloID := strconv.FormatInt(solo.ID, 10)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		id      string
+		status  int
+	}{
+		{"show inbox", app.showInboxHandler, http.MethodGet, "1", http.StatusOK},
+		{"show another trip's inbox", app.showInboxHandler, http.MethodGet, soloID, http.StatusForbidden},
+		{"rotate another trip's inbox", app.rotateInboxHandler, http.MethodPut, soloID, http.StatusForbidden},
+		{"list drafts", app.listDraftsHandler, http.MethodGet, "1", http.StatusOK},
+		{"list another trip's drafts", app.listDraftsHandler, http.MethodGet, soloID, http.StatusForbidden},
+		{"show draft", app.showDraftHandler, http.MethodGet, own, http.StatusOK},
+		{"show another trip's draft", app.showDraftHandler, http.MethodGet, other, http.StatusForbidden},
+		{"confirm another trip's draft", app.confirmDraftHandler, http.MethodPost, other, http.StatusForbidden},
+		{"delete another trip's draft", app.deleteDraftHandler, http.MethodDelete, other, http.StatusForbidden},
+		{"delete draft", app.deleteDraftHandler, http.MethodDelete, own, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := app.serveTest(t, tt.handler, tt.method, tt.id, "")
+			if status != tt.status {
+				t.Errorf("got status %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	id, _ := strconv.ParseInt(other, 10, 64)
+
+	_, err := app.models.Drafts.Get(ctx, id)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		t.Error("another trip's draft was deleted")
+	}
+}
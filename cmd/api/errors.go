@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/rytwalker/kagubird-api/internal/jsonpatch"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -49,7 +52,21 @@ func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+// failedBatchValidationResponse reports validation errors for the items of a
+// batch request, keyed by each item's index in the request.
+func (app *application) failedBatchValidationResponse(w http.ResponseWriter, r *http.Request, errors map[int]map[string]string) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+}
+
+// editConflictResponse is sent when an update loses a race on the version column.
+// A client that sent If-Match asked for the update to be conditional on that
+// version, so it gets a 412 instead.
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("If-Match") != "" {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	message := "uable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
@@ -71,6 +88,11 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidInboundSecretResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or missing inbound secret"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -85,3 +107,28 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since the version given in If-Match, fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must be made conditional with an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+// patchFailedResponse reports an error returned by readPatch. Patches that
+// are well formed but can't be applied to the current representation get a
+// 422, and a failed test operation means the resource isn't in the state the
+// client expected, so it's treated as a conflict.
+func (app *application) patchFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, jsonpatch.ErrPathNotFound):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// The plain parameters lists were filtered with before filter[...] still
+// filter the same way, and tags can be filtered like any other field.
+func TestListFilterAliases(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	list := func(handler http.HandlerFunc, key, query string) (int, []string, map[string]string) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		params := httprouter.Params{{Key: "id", Value: "1"}}
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		var env map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+			t.Fatalf("response isn't a JSON object: %s", w.Body)
+		}
+
+		var items []struct {
+			Name string `json:"name"`
+		}
+		var errs map[string]string
+
+		json.Unmarshal(env[key], &items)
+		json.Unmarshal(env["error"], &errs)
+
+		names := []string{}
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		slices.Sort(names)
+
+		return w.Code, names, errs
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		key     string
+		query   string
+		status  int
+		names   []string
+		errKey  string
+	}{
+		{"category", app.listActivitiesHandler, "activities", "category=sightseeing", http.StatusOK, []string{"Architecture boat tour", "Museum day"}, ""},
+		{"category and its filter", app.listActivitiesHandler, "activities", "category=sightseeing&filter[name][contains]=museum", http.StatusOK, []string{"Museum day"}, ""},
+		{"empty category", app.listActivitiesHandler, "activities", "category=&schedule=unscheduled", http.StatusOK, []string{"Jazz at the Green Mill", "Walk the Lakefront Trail"}, ""},
+		{"tags", app.listActivitiesHandler, "activities", "tags=Booked,+dinner", http.StatusOK, []string{"Deep dish dinner"}, ""},
+		{"tags filter with all", app.listActivitiesHandler, "activities", "filter[tags][all]=booked,dinner", http.StatusOK, []string{"Deep dish dinner"}, ""},
+		{"tags filter with in", app.listActivitiesHandler, "activities", "filter[tags][in]=free,nightlife", http.StatusOK, []string{"Jazz at the Green Mill", "Walk the Lakefront Trail"}, ""},
+		{"tags filter with eq", app.listActivitiesHandler, "activities", "filter[tags][eq]=free", http.StatusUnprocessableEntity, nil, "filter[tags][eq]"},
+		{"stay type", app.listStaysHandler, "stays", "type=friends", http.StatusOK, []string{"Fran's place"}, ""},
+		{"stay tags", app.listStaysHandler, "stays", "tags=booked", http.StatusOK, []string{"The Hoxton"}, ""},
+		{"alias with a bad value", app.listLocationsHandler, "locations", "activity=first", http.StatusUnprocessableEntity, nil, "activity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, names, errs := list(tt.handler, tt.key, tt.query)
+			if status != tt.status {
+				t.Fatalf("got status %d, want %d: %v", status, tt.status, errs)
+			}
+
+			if tt.errKey != "" {
+				if _, ok := errs[tt.errKey]; !ok {
+					t.Errorf("got errors %v, want one for %s", errs, tt.errKey)
+				}
+				return
+			}
+
+			if !slices.Equal(names, tt.names) {
+				t.Errorf("got %q, want %q", names, tt.names)
+			}
+		})
+	}
+}
+
+// Facets count the values across the whole trip, so clients can show how many
+// records each filter would leave whatever is filtered now.
+func TestListFacets(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		query   string
+		want    data.Facets
+	}{
+		{"activities", app.listActivitiesHandler, "category=food", data.Facets{
+			"category": {"sightseeing": 2, "food": 1, "outdoors": 1, "entertainment": 1},
+			"tag":      {"booked": 2, "dinner": 1, "free": 1, "nightlife": 1},
+		}},
+		{"stays", app.listStaysHandler, "type=hotel", data.Facets{
+			"type": {"hotel": 1, "friends": 1},
+			"tag":  {"booked": 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			params := httprouter.Params{{Key: "id", Value: "1"}}
+			r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			var env struct {
+				Metadata data.Metadata `json:"metadata"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(env.Metadata.Facets, tt.want) {
+				t.Errorf("got facets %v, want %v", env.Metadata.Facets, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateNormalizesTags(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	status, env := app.serveTest(t, app.createActivityHandler, http.MethodPost, "", `{"name": "Rooftop drinks", "notes": "Sunset.", "category": "drinks", "tags": [" Rooftop", "rooftop", "", "VIEWS "], "trip": 1}`)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", status, http.StatusCreated, env["error"])
+	}
+
+	var activity data.Activity
+	if err := json.Unmarshal(env["activity"], &activity); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"rooftop", "views"}; !slices.Equal(activity.Tags, want) {
+		t.Errorf("got tags %q, want %q", activity.Tags, want)
+	}
+
+	status, _ = app.serveTest(t, app.createActivityHandler, http.MethodPost, "", `{"name": "Rooftop drinks", "notes": "Sunset.", "category": "nightclub", "trip": 1}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an unknown category, want %d", status, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rytwalker/kagubird-api/internal/geocode"
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// geocodeAddress fills in lat and lng from address when a request gave an
+// address but no coordinates. It returns where the geocoder placed the
+// address, so handlers can report how confident the match was, or nil if
+// nothing was looked up. An address the geocoder can't place is a validation
+// error on the address.
+func (app *application) geocodeAddress(ctx context.Context, v *validator.Validator, address string, lat, lng *float64) (*geocode.Result, error) {
+	if app.geocoder == nil || address == "" || *lat != 0 || *lng != 0 {
+		return nil, nil
+	}
+
+	result, err := app.geocoder.Geocode(ctx, address)
+	if err != nil {
+		switch {
+		case errors.Is(err, geocode.ErrNoMatch):
+			v.AddError("address", "could not be located, provide lat and lng")
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	*lat, *lng = result.Lat, result.Lng
+
+	return &result, nil
+}
+
+// geocodeChangedAddress geocodes an updated address when the update changed
+// the address but left lat and lng as they were, since they'd still place the
+// old address. It returns like geocodeAddress, and leaves lat and lng alone
+// when it doesn't look anything up.
+func (app *application) geocodeChangedAddress(ctx context.Context, v *validator.Validator, oldAddress string, oldLat, oldLng float64, address string, lat, lng *float64) (*geocode.Result, error) {
+	if address == oldAddress || *lat != oldLat || *lng != oldLng {
+		return nil, nil
+	}
+
+	var newLat, newLng float64
+
+	result, err := app.geocodeAddress(ctx, v, address, &newLat, &newLng)
+	if result != nil {
+		*lat, *lng = newLat, newLng
+	}
+
+	return result, err
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/geocode"
+)
+
+var wrigleyField = geocode.Result{Lat: 41.948437, Lng: -87.655334, Confidence: geocode.ConfidenceMedium, Match: "Chicago, IL, US"}
+
+func TestGeocodeOnCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		lat      float64
+		geocoded bool
+	}{
+		{
+			name:     "address without coordinates",
+			body:     `{"name": "Wrigley Field", "address": "1060 W Addison St, Chicago", "google_place_id": "wrigley", "activity": 1}`,
+			status:   http.StatusCreated,
+			lat:      wrigleyField.Lat,
+			geocoded: true,
+		},
+		{
+			name:   "address with coordinates",
+			body:   `{"name": "Wrigley Field", "address": "1060 W Addison St, Chicago", "lat": 41.9, "lng": -87.6, "google_place_id": "wrigley", "activity": 1}`,
+			status: http.StatusCreated,
+			lat:    41.9,
+		},
+		{
+			name:   "address that can't be placed",
+			body:   `{"name": "Nowhere", "address": "1 Nowhere Ln", "google_place_id": "nowhere", "activity": 1}`,
+			status: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := geocode.NewFake(map[string]geocode.Result{"1060 W Addison St, Chicago": wrigleyField})
+			app := newTestApplication(t, fake)
+
+			status, env := app.serveTest(t, app.createLocationHandler, http.MethodPost, "", tt.body)
+			if status != tt.status {
+				t.Fatalf("got status %d, want %d", status, tt.status)
+			}
+
+			if status != http.StatusCreated {
+				return
+			}
+
+			var location data.Location
+
+			err := json.Unmarshal(env["location"], &location)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if location.Lat != tt.lat {
+				t.Errorf("got lat %v, want %v", location.Lat, tt.lat)
+			}
+
+			if _, ok := env["geocode"]; ok != tt.geocoded {
+				t.Errorf("got geocode in the response %t, want %t", ok, tt.geocoded)
+			}
+		})
+	}
+}
+
+func TestGeocodeOnUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(app *application) http.HandlerFunc
+		key     string
+		body    string
+		status  int
+		lat     float64
+		calls   int
+	}{
+		{
+			name:    "location address without coordinates",
+			handler: func(app *application) http.HandlerFunc { return app.updateLocationHandler },
+			key:     "location",
+			body:    `{"address": "1060 W Addison St, Chicago"}`,
+			status:  http.StatusOK,
+			lat:     wrigleyField.Lat,
+			calls:   1,
+		},
+		{
+			name:    "location address with coordinates",
+			handler: func(app *application) http.HandlerFunc { return app.updateLocationHandler },
+			key:     "location",
+			body:    `{"address": "1060 W Addison St, Chicago", "lat": 41.9, "lng": -87.6}`,
+			status:  http.StatusOK,
+			lat:     41.9,
+		},
+		{
+			name:    "location name only",
+			handler: func(app *application) http.HandlerFunc { return app.updateLocationHandler },
+			key:     "location",
+			body:    `{"name": "CAC"}`,
+			status:  http.StatusOK,
+			lat:     41.887668,
+		},
+		{
+			name:    "location address that can't be placed",
+			handler: func(app *application) http.HandlerFunc { return app.updateLocationHandler },
+			key:     "location",
+			body:    `{"address": "1 Nowhere Ln"}`,
+			status:  http.StatusUnprocessableEntity,
+			calls:   1,
+		},
+		{
+			name:    "place address without coordinates",
+			handler: func(app *application) http.HandlerFunc { return app.updatePlaceHandler },
+			key:     "place",
+			body:    `{"address": "1060 W Addison St, Chicago"}`,
+			status:  http.StatusOK,
+			lat:     wrigleyField.Lat,
+			calls:   1,
+		},
+		{
+			name:    "stay address without coordinates",
+			handler: func(app *application) http.HandlerFunc { return app.updateStayHandler },
+			key:     "stay",
+			body:    `{"address": "1060 W Addison St, Chicago"}`,
+			status:  http.StatusOK,
+			lat:     wrigleyField.Lat,
+			calls:   1,
+		},
+		{
+			name:    "stay address with coordinates",
+			handler: func(app *application) http.HandlerFunc { return app.updateStayHandler },
+			key:     "stay",
+			body:    `{"address": "1060 W Addison St, Chicago", "lat": 41.9, "lng": -87.6}`,
+			status:  http.StatusOK,
+			lat:     41.9,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := geocode.NewFake(map[string]geocode.Result{"1060 W Addison St, Chicago": wrigleyField})
+			app := newTestApplication(t, fake)
+
+			status, env := app.serveTest(t, tt.handler(app), http.MethodPatch, "1", tt.body)
+			if status != tt.status {
+				t.Fatalf("got status %d, want %d", status, tt.status)
+			}
+
+			if calls := len(fake.Calls()); calls != tt.calls {
+				t.Errorf("got %d geocoder calls, want %d", calls, tt.calls)
+			}
+
+			if status != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Lat float64 `json:"lat"`
+			}
+
+			err := json.Unmarshal(env[tt.key], &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Lat != tt.lat {
+				t.Errorf("got lat %v, want %v", got.Lat, tt.lat)
+			}
+		})
+	}
+}
+
+func TestGeocoderFailure(t *testing.T) {
+	fake := geocode.NewFake(nil)
+	fake.Err = errors.New("geocoder is down")
+
+	app := newTestApplication(t, fake)
+
+	status, _ := app.serveTest(t, app.updateLocationHandler, http.MethodPatch, "1", `{"address": "1060 W Addison St, Chicago"}`)
+	if status != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", status, http.StatusInternalServerError)
+	}
+}
@@ -7,25 +7,51 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/rytwalker/kagubird-api/internal/data"
 	"github.com/rytwalker/kagubird-api/internal/validator"
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// readNamedIDParam reads an ID from a route parameter other than :id, as in
+// /v1/stays/:id/places/:place.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
+// customMethod serves next only when the route's :verb parameter is the given
+// custom method, as in POST /v1/trips/:id/activities:batch. httprouter treats
+// everything after the colon as a parameter, so without this check any suffix
+// would match.
+func (app *application) customMethod(verb string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if params.ByName("verb") != ":"+verb {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 type envelope map[string]any
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
@@ -54,7 +80,13 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
-	dec := json.NewDecoder(r.Body)
+	return app.decodeJSON(r.Body, dst)
+}
+
+// decodeJSON decodes a single JSON value from body into dst, turning decoder
+// errors into messages that are safe to send back to the client.
+func (app *application) decodeJSON(body io.Reader, dst any) error {
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	// Decode the request body into the target destination.
@@ -142,7 +174,7 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 		return defaultValue
 	}
 
-	return strings.Split(csv, "")
+	return strings.Split(csv, ",")
 }
 
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
@@ -161,6 +193,100 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readFilters reads the paging, sorting and filter parameters shared by every
+// list endpoint.
+func (app *application) readFilters(qs url.Values, listing data.Listing, v *validator.Validator) data.Filters {
+	return data.Filters{
+		Page:           app.readInt(qs, "page", 1, v),
+		PageSize:       app.readInt(qs, "page_size", 20, v),
+		Sort:           app.readString(qs, "sort", listing.DefaultSort),
+		SortSafelist:   listing.SortSafelist(),
+		After:          app.readString(qs, "after", ""),
+		Before:         app.readString(qs, "before", ""),
+		CursorKey:      app.config.cursor.key,
+		FilterSafelist: listing.Filterable,
+		Conditions:     app.readConditions(qs, listing, v),
+	}
+}
+
+var filterParamRX = regexp.MustCompile(`^filter\[([a-z_]+)\](?:\[([a-z]+)\])?$`)
+
+// readConditions parses filter[field][operator]=value parameters, such as
+// filter[start_date][gte]=2024-06-01, and the listing's aliases for them, such
+// as category=museum. A missing operator means eq, and the between, in and all
+// operators take comma separated values. Fields and operators are checked
+// against the resource later, by data.ValidateFilters().
+func (app *application) readConditions(qs url.Values, listing data.Listing, v *validator.Validator) []data.Condition {
+	conditions := []data.Condition{}
+
+	aliases := []string{}
+	for alias := range listing.Aliases {
+		aliases = append(aliases, alias)
+	}
+
+	slices.Sort(aliases)
+
+	for _, alias := range aliases {
+		value := qs.Get(alias)
+		if value == "" {
+			continue
+		}
+
+		operator := listing.Aliases[alias]
+
+		values := app.readConditionValues(listing, alias, operator, value)
+		if len(values) == 0 {
+			continue
+		}
+
+		conditions = append(conditions, data.Condition{Field: alias, Operator: operator, Values: values, Param: alias})
+	}
+
+	keys := []string{}
+	for key := range qs {
+		if strings.HasPrefix(key, "filter[") {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		matches := filterParamRX.FindStringSubmatch(key)
+		if matches == nil {
+			v.AddError(key, "must be of the form filter[field][operator]")
+			continue
+		}
+
+		operator := matches[2]
+		if operator == "" {
+			operator = data.OpEq
+		}
+
+		for _, value := range qs[key] {
+			values := app.readConditionValues(listing, matches[1], operator, value)
+			conditions = append(conditions, data.Condition{Field: matches[1], Operator: operator, Values: values})
+		}
+	}
+
+	return conditions
+}
+
+// readConditionValues splits a filter value for the operators that take more
+// than one, and normalizes tags the way they're stored.
+func (app *application) readConditionValues(listing data.Listing, field, operator, value string) []string {
+	values := []string{value}
+	if operator == data.OpBetween || operator == data.OpIn || operator == data.OpAll {
+		values = strings.Split(value, ",")
+	}
+
+	if listing.Filterable[field].Type == data.FieldTags {
+		values = data.NormalizeTags(values)
+	}
+
+	return values
+}
+
 // the background() helper accepts an arbitary func as a param and
 // launches a bg goroutine that can recover from panic
 func (app *application) background(fn func()) {
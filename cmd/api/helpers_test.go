@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/geocode"
+)
+
+// newTestApplication returns an application backed by the demo data in the
+// memory store, placing addresses with geocoder.
+func newTestApplication(t *testing.T, geocoder geocode.Geocoder) *application {
+	t.Helper()
+
+	models := data.NewMemoryModels()
+
+	err := seedDemoData(context.Background(), models)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models:   models,
+		geocoder: geocoder,
+	}
+}
+
+var (
+	routedOnce    sync.Once
+	routedApp     *application
+	routedHandler http.Handler
+)
+
+// routedTestApplication returns the application shared by the tests that go
+// through routes, and its routes. They can only be built once per process,
+// since the metrics middleware publishes its counters with expvar. The rate
+// limiter lets each client make one request a second, so tests that use it
+// send their requests from a RemoteAddr of their own.
+func routedTestApplication(t *testing.T) (*application, http.Handler) {
+	t.Helper()
+
+	routedOnce.Do(func() {
+		app := newTestApplication(t, nil)
+		app.config.limiter.enabled = true
+		app.config.limiter.rps = 1
+		app.config.limiter.burst = 1
+		app.config.inbound.domain = "in.kagubird.test"
+		app.config.inbound.secret = "mta-secret"
+
+		routedApp, routedHandler = app, app.routes()
+	})
+
+	if routedApp == nil {
+		t.Fatal("couldn't set up the routed application")
+	}
+
+	return routedApp, routedHandler
+}
+
+// serveTest calls handler as if the router had matched id, for the demo user.
+func (app *application) serveTest(t *testing.T, handler http.HandlerFunc, method, id, body string) (int, map[string]json.RawMessage) {
+	t.Helper()
+
+	user, err := app.models.Users.GetByEmail(context.Background(), demoEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	if id != "" {
+		params := httprouter.Params{{Key: "id", Value: id}}
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+	}
+
+	r = app.contextSetUser(r, user)
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var env map[string]json.RawMessage
+
+	err = json.Unmarshal(w.Body.Bytes(), &env)
+	if err != nil {
+		t.Fatalf("response isn't a JSON object: %s", w.Body)
+	}
+
+	return w.Code, env
+}
+
+// insertSoloTrip adds a trip made by the demo user's friend, which the demo
+// user isn't on.
+func insertSoloTrip(t *testing.T, app *application) *data.Trip {
+	t.Helper()
+
+	ctx := context.Background()
+
+	friend, err := app.models.Users.GetByEmail(ctx, "friend@kagubird.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 1, 0)
+
+	trip := &data.Trip{
+		Name:          "Solo trip",
+		City:          "Denver",
+		StateCode:     "CO",
+		GooglePlaceID: "ChIJzxcfI6qAa4cR1jaKJ_j0jhE",
+		Lat:           39.739236,
+		Lng:           -104.990251,
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 2),
+		CreatedBy:     friend.ID,
+	}
+
+	err = app.models.Trips.Insert(ctx, trip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return trip
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/inbound"
+)
+
+// maxMessageBytes is the largest email accepted, attachments included.
+const maxMessageBytes = 10 << 20
+
+// inboxAddressRX matches a trip inbox address, trip+<token>@domain. The domain
+// isn't checked, since the MTA only hands over mail it accepted for ours.
+var inboxAddressRX = regexp.MustCompile(`^trip\+([a-z2-7]{26})@`)
+
+// inboxAddress is the email address of a trip's inbox.
+func (app *application) inboxAddress(inbox *data.Inbox) string {
+	return fmt.Sprintf("trip+%s@%s", inbox.Token, app.config.inbound.domain)
+}
+
+// showInboxHandler returns the address booking confirmations for a trip can be
+// forwarded to, giving the trip one the first time it's asked for.
+func (app *application) showInboxHandler(w http.ResponseWriter, r *http.Request) {
+	trip, ok := app.tripFromParam(w, r)
+	if !ok || !app.checkTripMember(w, r, trip.ID) {
+		return
+	}
+
+	inbox, err := app.models.Inboxes.Get(r.Context(), trip.ID)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		inbox, err = app.models.Inboxes.New(r.Context(), trip.ID)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"inbox": inbox, "address": app.inboxAddress(inbox)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rotateInboxHandler gives a trip a new inbox address. Mail sent to the old one
+// is no longer accepted.
+func (app *application) rotateInboxHandler(w http.ResponseWriter, r *http.Request) {
+	trip, ok := app.tripFromParam(w, r)
+	if !ok || !app.checkTripMember(w, r, trip.ID) {
+		return
+	}
+
+	inbox, err := app.models.Inboxes.New(r.Context(), trip.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"inbox": inbox, "address": app.inboxAddress(inbox)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// inboundEmailHandler accepts a raw RFC 822 message, as piped in by the MTA,
+// and turns the schema.org reservations in it into drafts on the trip whose
+// inbox it was sent to. The MTA can pass the envelope recipient in the
+// recipient query parameter, URL-encoded so its + survives, which is the only
+// way a Bcc'd inbox is found.
+// A message that was already received, or is being received at the same time,
+// returns the drafts made from it the first time. The MTA authenticates with
+// the shared secret in the X-Inbound-Secret header.
+func (app *application) inboundEmailHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxMessageBytes)
+
+	msg, err := inbound.ParseMessage(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("message must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	recipients := append(r.URL.Query()["recipient"], msg.Recipients...)
+
+	inbox, err := app.inboxFor(r.Context(), recipients)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusNotFound, "the message isn't addressed to a trip's inbox")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if msg.ID != "" && app.receivedDraftsResponse(w, r, inbox.TripID, msg.ID) {
+		return
+	}
+
+	reservations := msg.Reservations()
+	if len(reservations) == 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "the message has no usable lodging, flight or event reservation markup")
+		return
+	}
+
+	drafts := make([]*data.Draft, len(reservations))
+	for i, reservation := range reservations {
+		drafts[i] = draftFromReservation(inbox.TripID, msg, reservation)
+		drafts[i].Part = i
+	}
+
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		for _, draft := range drafts {
+			err := tx.Drafts.Insert(r.Context(), draft)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMessage):
+			// another delivery of the message got there first
+			if !app.receivedDraftsResponse(w, r, inbox.TripID, msg.ID) {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Info("drafts received", "trip", inbox.TripID, "drafts", len(drafts), "message_id", msg.ID)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"drafts": drafts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// receivedDraftsResponse sends the drafts a trip already has from a message
+// and returns true, or returns false without writing anything if it has none.
+// On an error it sends a server error and returns true.
+func (app *application) receivedDraftsResponse(w http.ResponseWriter, r *http.Request, tripID int64, messageID string) bool {
+	existing, err := app.models.Drafts.GetAllByMessage(r.Context(), tripID, messageID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	if len(existing) == 0 {
+		return false
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"drafts": existing}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+	return true
+}
+
+// inboxFor returns the inbox of the first recipient that's a trip's inbox
+// address, or ErrRecordNotFound if none are.
+func (app *application) inboxFor(ctx context.Context, recipients []string) (*data.Inbox, error) {
+	for _, recipient := range recipients {
+		match := inboxAddressRX.FindStringSubmatch(strings.ToLower(strings.TrimSpace(recipient)))
+		if match == nil {
+			continue
+		}
+
+		inbox, err := app.models.Inboxes.GetByToken(ctx, match[1])
+		switch {
+		case err == nil:
+			return inbox, nil
+		case errors.Is(err, data.ErrRecordNotFound):
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
+
+// draftFromReservation makes the stay or activity a reservation describes.
+// Lodging becomes a stay, and flights and events become activities. Markup
+// rarely has everything a stay or activity needs, so drafts aren't validated
+// until they're confirmed.
+func draftFromReservation(tripID int64, msg *inbound.Message, reservation inbound.Reservation) *data.Draft {
+	draft := &data.Draft{
+		TripID:    tripID,
+		MessageID: msg.ID,
+		Sender:    msg.From,
+		Subject:   msg.Subject,
+	}
+
+	booking := data.Booking{
+		Status:             bookingStatus(reservation.Status),
+		ConfirmationNumber: reservation.Number,
+		Provider:           reservation.Provider,
+		Cost:               reservation.Cost,
+		Currency:           reservation.Currency,
+	}
+
+	if reservation.Kind == inbound.KindLodging {
+		draft.Kind = data.DraftKindStay
+		draft.Stay = &data.Stay{
+			Name:      reservation.Name,
+			StartTime: stayTime(reservation.Start, reservation.StartHasTime, 15),
+			EndTime:   stayTime(reservation.End, reservation.EndHasTime, 11),
+			Address:   reservation.Address,
+			Lat:       reservation.Lat,
+			Lng:       reservation.Lng,
+			Link:      reservation.URL,
+			Phone:     reservation.Telephone,
+			Type:      stayType(reservation.Type),
+			Tags:      []string{},
+			Booking:   booking,
+			TripID:    tripID,
+		}
+
+		return draft
+	}
+
+	activity := &data.Activity{
+		Name:     reservation.Name,
+		Category: data.CategoryEntertainment,
+		Tags:     []string{},
+		Booking:  booking,
+		TripID:   tripID,
+	}
+
+	var notes []string
+
+	switch reservation.Kind {
+	case inbound.KindFlight:
+		activity.Category = data.CategoryTransport
+		if reservation.Seat != "" {
+			notes = append(notes, "Seat "+reservation.Seat+".")
+		}
+	case inbound.KindEvent:
+		if reservation.Address != "" {
+			notes = append(notes, "At "+reservation.Address+".")
+		}
+	}
+
+	notes = append(notes, fmt.Sprintf("Imported from %q.", msg.Subject))
+	activity.Notes = strings.Join(notes, "\n")
+
+	switch {
+	case reservation.Start.IsZero():
+		activity.Schedule = data.ScheduleUnscheduled
+	case reservation.StartHasTime:
+		// events often leave out when they end
+		end := reservation.End
+		if end.IsZero() || !reservation.EndHasTime {
+			end = reservation.Start.Add(2 * time.Hour)
+		}
+
+		activity.ScheduleAt(reservation.Start, end)
+	default:
+		start := data.DateOf(reservation.Start)
+		end := start
+		if !reservation.End.IsZero() {
+			end = data.DateOf(reservation.End)
+		}
+
+		activity.Schedule = data.ScheduleAllDay
+		activity.StartDate = &start
+		activity.EndDate = &end
+	}
+
+	draft.Kind = data.DraftKindActivity
+	draft.Activity = activity
+
+	return draft
+}
+
+// bookingStatus maps a schema.org reservation status onto a booking status. A
+// confirmation email without a status is taken to mean the booking went
+// through.
+func bookingStatus(status string) string {
+	switch status {
+	case "ReservationCancelled":
+		return data.BookingStatusCancelled
+	case "ReservationPending", "ReservationHold":
+		return data.BookingStatusNeedsBooking
+	default:
+		return data.BookingStatusBooked
+	}
+}
+
+// stayTime is a check-in or check-out time, at hour on the day when the
+// markup only gave a date.
+func stayTime(t time.Time, hasTime bool, hour int) time.Time {
+	if t.IsZero() || hasTime {
+		return t
+	}
+
+	return t.Add(time.Duration(hour) * time.Hour)
+}
+
+// stayType maps the schema.org type of a lodging business onto a stay type.
+func stayType(schemaType string) string {
+	switch schemaType {
+	case "Hostel":
+		return data.StayTypeHostel
+	case "Campground":
+		return data.StayTypeCamping
+	case "VacationRental", "Apartment", "House", "SingleFamilyResidence", "Accommodation":
+		return data.StayTypeRental
+	default:
+		return data.StayTypeHotel
+	}
+}
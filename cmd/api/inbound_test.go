@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hotelMessage is a booking confirmation for the demo trip's inbox at token.
+func hotelMessage(token string) string {
+	return strings.ReplaceAll(`From: Hotels <bookings@hotels.example>
+To: trip+TOKEN@in.kagubird.test
+Subject: Your stay at the Palmer House
+Message-ID: <confirm-8841@hotels.example>
+Content-Type: text/html; charset=utf-8
+
+<html><head><script type="application/ld+json">
+{
+  "@context": "http://schema.org",
+  "@type": "LodgingReservation",
+  "reservationNumber": "PH-8841",
+  "reservationFor": {"@type": "Hotel", "name": "Palmer House", "address": "17 E Monroe St, Chicago, IL 60603"},
+  "checkinDate": "2026-06-01",
+  "checkoutDate": "2026-06-03"
+}
+</script></head><body>See you soon!</body></html>
+`, "TOKEN", token)
+}
+
+func TestInboundEmail(t *testing.T) {
+	app, routes := routedTestApplication(t)
+
+	inbox, err := app.models.Inboxes.New(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := hotelMessage(inbox.Token)
+
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		status int
+	}{
+		{"without the secret", "", message, http.StatusUnauthorized},
+		{"with the wrong secret", "not-the-secret", message, http.StatusUnauthorized},
+		{"with the secret", "mta-secret", message, http.StatusCreated},
+		// these come too fast for the rate limiter, which inbound email skips
+		{"delivered again", "mta-secret", message, http.StatusOK},
+		{"delivered a third time", "mta-secret", message, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/inbound/email", strings.NewReader(tt.body))
+			r.RemoteAddr = "192.0.2.10:25"
+			if tt.secret != "" {
+				r.Header.Set("X-Inbound-Secret", tt.secret)
+			}
+
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	drafts, err := app.models.Drafts.GetAllByTrip(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(drafts) != 1 {
+		t.Errorf("got %d drafts, want 1", len(drafts))
+	}
+}
+
+// Everything else from one client is still rate limited.
+func TestRateLimitOutsideInbound(t *testing.T) {
+	_, routes := routedTestApplication(t)
+
+	var statuses []int
+
+	for range 3 {
+		r := httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil)
+		r.RemoteAddr = "192.0.2.11:4000"
+
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+
+		statuses = append(statuses, w.Code)
+	}
+
+	if statuses[len(statuses)-1] != http.StatusTooManyRequests {
+		t.Errorf("got statuses %v, want the last to be %d", statuses, http.StatusTooManyRequests)
+	}
+}
+
+func TestInboundEmailWithoutUsableMarkup(t *testing.T) {
+	app, routes := routedTestApplication(t)
+
+	inbox, err := app.models.Inboxes.New(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a reservation that doesn't say what it's for or when
+	message := fmt.Sprintf(`From: Airline <noreply@air.example>
+To: trip+%s@in.kagubird.test
+Subject: Your trip
+Message-ID: <empty-1@air.example>
+Content-Type: text/html
+
+<script type="application/ld+json">{"@context": "http://schema.org", "@type": "FlightReservation", "reservationNumber": "XYZ"}</script>
+`, inbox.Token)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/inbound/email", strings.NewReader(message))
+	r.RemoteAddr = "192.0.2.12:25"
+	r.Header.Set("X-Inbound-Secret", "mta-secret")
+
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+}
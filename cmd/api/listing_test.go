@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// Every trip resource lists through the same layer, so they all page, sort and
+// report metadata the same way.
+func TestTripResourceListings(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	list := func(handler http.HandlerFunc, key string, query url.Values) (int, []string, data.Metadata, map[string]string) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+		params := httprouter.Params{{Key: "id", Value: "1"}}
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		var env map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+			t.Fatalf("response isn't a JSON object: %s", w.Body)
+		}
+
+		var items []struct {
+			Name string `json:"name"`
+		}
+		var metadata data.Metadata
+		var errs map[string]string
+
+		json.Unmarshal(env[key], &items)
+		json.Unmarshal(env["metadata"], &metadata)
+		json.Unmarshal(env["error"], &errs)
+
+		names := []string{}
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+
+		return w.Code, names, metadata, errs
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		key     string
+		// all is every item on the demo trip in the default sort order
+		all []string
+	}{
+		{"activities", app.listActivitiesHandler, "activities", []string{"Architecture boat tour", "Deep dish dinner", "Museum day", "Walk the Lakefront Trail", "Jazz at the Green Mill"}},
+		{"stays", app.listStaysHandler, "stays", []string{"The Hoxton", "Fran's place"}},
+		{"locations", app.listLocationsHandler, "locations", []string{"Chicago Architecture Center", "Pequod's Pizza", "Art Institute of Chicago", "Field Museum", "Green Mill Cocktail Lounge"}},
+		{"tripgoers", app.listTripGoersHandler, "tripgoers", []string{"Fran Friend"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, names, metadata, errs := list(tt.handler, tt.key, url.Values{})
+			if status != http.StatusOK {
+				t.Fatalf("got status %d, want %d: %v", status, http.StatusOK, errs)
+			}
+			if !slices.Equal(names, tt.all) {
+				t.Errorf("got %q in the default order, want %q", names, tt.all)
+			}
+			if metadata.TotalRecords != len(tt.all) || metadata.CurrentPage != 1 {
+				t.Errorf("got metadata %+v, want page 1 of %d records", metadata, len(tt.all))
+			}
+
+			sorted := slices.Clone(tt.all)
+			slices.Sort(sorted)
+			paged := []string{}
+
+			for page := 1; page <= (len(tt.all)+1)/2; page++ {
+				query := url.Values{"sort": {"name"}, "page_size": {"2"}, "page": {strconv.Itoa(page)}}
+
+				status, names, metadata, errs := list(tt.handler, tt.key, query)
+				if status != http.StatusOK {
+					t.Fatalf("got status %d for page %d, want %d: %v", status, page, http.StatusOK, errs)
+				}
+				if metadata.LastPage != (len(tt.all)+1)/2 || metadata.PageSize != 2 {
+					t.Errorf("got metadata %+v for page %d", metadata, page)
+				}
+
+				paged = append(paged, names...)
+			}
+
+			if !slices.Equal(paged, sorted) {
+				t.Errorf("got %q paging by name, want %q", paged, sorted)
+			}
+
+			slices.Reverse(sorted)
+
+			status, names, _, _ = list(tt.handler, tt.key, url.Values{"sort": {"-name"}})
+			if status != http.StatusOK || !slices.Equal(names, sorted) {
+				t.Errorf("got status %d and %q sorting by -name, want %q", status, names, sorted)
+			}
+
+			for _, query := range []url.Values{{"sort": {"rank"}}, {"page_size": {"101"}}, {"page": {"0"}}} {
+				status, _, _, errs := list(tt.handler, tt.key, query)
+				if status != http.StatusUnprocessableEntity {
+					t.Errorf("got status %d for %s, want %d", status, query.Encode(), http.StatusUnprocessableEntity)
+				}
+				if len(errs) == 0 {
+					t.Errorf("got no errors for %s", query.Encode())
+				}
+			}
+		})
+	}
+}
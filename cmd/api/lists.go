@@ -0,0 +1,425 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+func (app *application) listListsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	lists, err := app.models.Lists.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lists": lists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	list := &data.List{
+		UserID: app.contextGetUser(r).ID,
+		Name:   input.Name,
+	}
+
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Insert(r.Context(), list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateListName):
+			v.AddError("name", "you already have a list with this name")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d", list.ID))
+	headers.Set("ETag", versionETag(list.Version))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showListHandler returns a list along with the places saved on it.
+func (app *application) showListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.userList(w, r)
+	if !ok {
+		return
+	}
+
+	saved, err := app.models.SavedPlaces.GetAllByList(r.Context(), list.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	list.SavedPlaces = saved
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.userList(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, list.Version) {
+		return
+	}
+
+	if patchMediaType(r) != "" {
+		var patched data.List
+
+		err := app.readPatch(w, r, list, &patched)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
+
+		if patched.Version != list.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+
+		if patched.ID != list.ID {
+			app.failedValidationResponse(w, r, map[string]string{"id": "is read-only"})
+			return
+		}
+
+		list.Name = patched.Name
+	} else {
+		var input struct {
+			Name *string `json:"name"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Name != nil {
+			list.Name = *input.Name
+		}
+	}
+
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Lists.Update(r.Context(), list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateListName):
+			v.AddError("name", "you already have a list with this name")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(list.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.userList(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, list.Version) {
+		return
+	}
+
+	err := app.models.Lists.DeleteVersion(r.Context(), list.ID, list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// savePlaceHandler saves a place to a list. The place comes from the catalog,
+// as the place of one of a trip's locations or stays.
+func (app *application) savePlaceHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.userList(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		PlaceID int64  `json:"place"`
+		Notes   string `json:"notes"`
+		Rating  *int   `json:"rating"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	saved := &data.SavedPlace{
+		ListID: list.ID,
+		Place:  &data.Place{ID: input.PlaceID},
+		Notes:  input.Notes,
+		Rating: input.Rating,
+	}
+
+	v := validator.New()
+	if data.ValidateSavedPlace(v, saved); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Places.Get(r.Context(), input.PlaceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("place", "must be an existing place")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.SavedPlaces.Insert(r.Context(), saved)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSavedPlace):
+			v.AddError("place", "is already on this list")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d/places/%d", list.ID, saved.Place.ID))
+	headers.Set("ETag", versionETag(saved.Version))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"saved_place": saved}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateSavedPlaceHandler changes the notes and rating of a saved place. A
+// merge patch can clear the rating by setting it to null.
+func (app *application) updateSavedPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	saved, ok := app.userSavedPlace(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, saved.Version) {
+		return
+	}
+
+	if patchMediaType(r) != "" {
+		var patched data.SavedPlace
+
+		err := app.readPatch(w, r, saved, &patched)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
+
+		if patched.Version != saved.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+
+		switch {
+		case patched.ListID != saved.ListID:
+			app.failedValidationResponse(w, r, map[string]string{"list": "is read-only"})
+			return
+		case patched.Place == nil || patched.Place.ID != saved.Place.ID:
+			app.failedValidationResponse(w, r, map[string]string{"place": "is read-only"})
+			return
+		}
+
+		saved.Notes = patched.Notes
+		saved.Rating = patched.Rating
+	} else {
+		var input struct {
+			Notes  *string `json:"notes"`
+			Rating *int    `json:"rating"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Notes != nil {
+			saved.Notes = *input.Notes
+		}
+		if input.Rating != nil {
+			saved.Rating = input.Rating
+		}
+	}
+
+	v := validator.New()
+	if data.ValidateSavedPlace(v, saved); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.SavedPlaces.Update(r.Context(), saved)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(saved.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_place": saved}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSavedPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	saved, ok := app.userSavedPlace(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, saved.Version) {
+		return
+	}
+
+	err := app.models.SavedPlaces.DeleteVersion(r.Context(), saved.ListID, saved.Place.ID, saved.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "place successfully removed from list"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userList loads the list named by the :id parameter. Lists are personal, so
+// someone else's list is reported as not found rather than forbidden.
+func (app *application) userList(w http.ResponseWriter, r *http.Request) (*data.List, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	list, err := app.models.Lists.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if list.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return list, true
+}
+
+// userSavedPlace loads the saved place named by the :id and :place parameters
+// from one of the user's lists.
+func (app *application) userSavedPlace(w http.ResponseWriter, r *http.Request) (*data.SavedPlace, bool) {
+	list, ok := app.userList(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	placeID, err := app.readNamedIDParam(r, "place")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	saved, err := app.models.SavedPlaces.Get(r.Context(), list.ID, placeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return saved, true
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
 
 	data "github.com/rytwalker/kagubird-api/internal/data"
 	"github.com/rytwalker/kagubird-api/internal/validator"
@@ -39,22 +39,34 @@ func (app *application) createLocationHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	v := validator.New()
+
+	geocoded, err := app.geocodeAddress(r.Context(), v, location.Address, &location.Lat, &location.Lng)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	if data.ValidateLocation(v, location); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Locations.Insert(location)
+	err = app.models.Locations.Insert(r.Context(), location)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/location/%d", location.ID))
+	headers.Set("Location", fmt.Sprintf("/v1/locations/%d", location.ID))
+
+	env := envelope{"location": location}
+	if geocoded != nil {
+		env["geocode"] = geocoded
+	}
 
 	// write a json response with a 201 created status code
-	err = app.writeJSON(w, http.StatusCreated, envelope{"location": location}, headers)
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -67,18 +79,346 @@ func (app *application) showLocationHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	location := data.Location{
-		ID:        id,
-		CreatedAt: time.Now(),
-		Name:      "The BeerHive",
-		Address:   "2117 Penn Ave, Pittsburgh, PA 15222",
-		Lat:       40.4520999,
-		Lng:       -79.9852922,
-		Website:   "https://thebeerhive.com",
-		Phone:     "+14129044502",
+	location, err := app.models.Locations.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	etag := versionETag(location.Version)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"location": location}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// locationInput is the body of a location update. Fields left out keep their
+// current values. The changes are made to the location's place, so they show
+// up everywhere the place is used; the Google place ID can't be changed.
+type locationInput struct {
+	Name    *string  `json:"name"`
+	Address *string  `json:"address"`
+	Lat     *float64 `json:"lat"`
+	Lng     *float64 `json:"lng"`
+	Website *string  `json:"website"`
+	Phone   *string  `json:"phone"`
+}
+
+func (input locationInput) apply(location *data.Location) {
+	if input.Name != nil {
+		location.Name = *input.Name
+	}
+	if input.Address != nil {
+		location.Address = *input.Address
+	}
+	if input.Lat != nil {
+		location.Lat = *input.Lat
+	}
+	if input.Lng != nil {
+		location.Lng = *input.Lng
+	}
+	if input.Website != nil {
+		location.Website = *input.Website
+	}
+	if input.Phone != nil {
+		location.Phone = *input.Phone
+	}
+}
+
+func (app *application) updateLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	location, err := app.models.Locations.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkPlaceEditor(w, r, location.PlaceID) {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, location.Version) {
+		return
+	}
+
+	oldAddress, oldLat, oldLng := location.Address, location.Lat, location.Lng
+
+	if patchMediaType(r) != "" {
+		var patched data.Location
+
+		err = app.readPatch(w, r, location, &patched)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
+
+		if patched.Version != location.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+
+		if field := locationReadOnlyChange(location, &patched); field != "" {
+			app.failedValidationResponse(w, r, map[string]string{field: "is read-only"})
+			return
+		}
+
+		location.Name = patched.Name
+		location.Address = patched.Address
+		location.Lat = patched.Lat
+		location.Lng = patched.Lng
+		location.Website = patched.Website
+		location.Phone = patched.Phone
+	} else {
+		var input locationInput
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		input.apply(location)
+	}
+
+	v := validator.New()
+
+	geocoded, err := app.geocodeChangedAddress(r.Context(), v, oldAddress, oldLat, oldLng, location.Address, &location.Lat, &location.Lng)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateLocation(v, location); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Locations.Update(r.Context(), location)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(location.Version))
+
+	env := envelope{"location": location}
+	if geocoded != nil {
+		env["geocode"] = geocoded
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// locationReadOnlyChange returns the name of the first field a patch changed
+// that clients aren't allowed to set, or an empty string if there isn't one.
+func locationReadOnlyChange(location, patched *data.Location) string {
+	switch {
+	case patched.ID != location.ID:
+		return "id"
+	case patched.ActivityID != location.ActivityID:
+		return "activity"
+	case patched.PlaceID != location.PlaceID:
+		return "place"
+	case patched.GooglePlaceID != location.GooglePlaceID:
+		return "google_place_id"
+	default:
+		return ""
+	}
+}
+
+func (app *application) deleteLocationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	location, err := app.models.Locations.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, location.Version) {
+		return
+	}
+
+	err = app.models.Locations.DeleteVersion(r.Context(), location.ID, location.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "location successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addActivityPlaceHandler adds a place that's already in the catalog, such as
+// one from the user's saved lists, to an activity in one call.
+func (app *application) addActivityPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	activity, err := app.models.Activities.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		PlaceID int64 `json:"place"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	place, err := app.models.Places.Get(r.Context(), input.PlaceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"place": "must be an existing place"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// link the place itself, rather than looking it up again by Google place
+	// ID, which places from before those were required don't have
+	location := &data.Location{
+		ActivityID: activity.ID,
+		PlaceID:    place.ID,
+	}
+
+	err = app.models.Locations.Insert(r.Context(), location)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/locations/%d", location.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"location": location}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listActivityLocationsHandler returns every location of one activity. There
+// are only ever a few, so unlike the trip-wide listing it isn't paged.
+func (app *application) listActivityLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	activity, err := app.models.Activities.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	locations, err := app.models.Locations.GetAllByActivity(r.Context(), activity.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"locations": locations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	tripID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters = app.readFilters(qs, data.LocationListing, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	locations, metadata, err := app.models.Locations.GetAll(r.Context(), tripID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"location": location}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"locations": locations, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
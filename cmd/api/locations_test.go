@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+func TestLocationCRUD(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	user, err := app.models.Users.GetByEmail(context.Background(), demoEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(handler http.HandlerFunc, method, id, body, ifMatch string) (*httptest.ResponseRecorder, data.Location) {
+		t.Helper()
+
+		r := httptest.NewRequest(method, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+
+		params := httprouter.Params{{Key: "id", Value: id}}
+		r = app.contextSetUser(r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)), user)
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		var env struct {
+			Location data.Location `json:"location"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &env)
+
+		return w, env.Location
+	}
+
+	// every location used to come back as the same fixture
+	for id, name := range map[string]string{"1": "Chicago Architecture Center", "4": "Field Museum"} {
+		w, location := serve(app.showLocationHandler, http.MethodGet, id, "", "")
+		if w.Code != http.StatusOK || location.Name != name {
+			t.Errorf("got status %d and %q for location %s, want %q", w.Code, location.Name, id, name)
+		}
+	}
+
+	for _, id := range []string{"999", "0", "first"} {
+		if w, _ := serve(app.showLocationHandler, http.MethodGet, id, "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("got status %d for location %s, want %d", w.Code, id, http.StatusNotFound)
+		}
+	}
+
+	w, location := serve(app.showLocationHandler, http.MethodGet, "3", "", "")
+	etag := w.Header().Get("ETag")
+
+	w, _ = serve(app.updateLocationHandler, http.MethodPatch, "3", `{"phone": "312-443-3600"}`, `"v999"`)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d updating with a stale If-Match, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+
+	w, _ = serve(app.updateLocationHandler, http.MethodPatch, "3", `{"name": ""}`, etag)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d clearing the name, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	w, updated := serve(app.updateLocationHandler, http.MethodPatch, "3", `{"phone": "312-443-3600"}`, etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d updating the location, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if updated.Phone != "312-443-3600" || updated.Version != location.Version+1 || updated.Name != location.Name {
+		t.Errorf("got %+v after the update", updated)
+	}
+	etag, oldETag := w.Header().Get("ETag"), etag
+
+	w, _ = serve(app.deleteLocationHandler, http.MethodDelete, "3", "", oldETag)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d deleting with the old ETag, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+
+	w, _ = serve(app.deleteLocationHandler, http.MethodDelete, "3", "", etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d deleting the location, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	for _, handler := range []http.HandlerFunc{app.showLocationHandler, app.deleteLocationHandler} {
+		if w, _ := serve(handler, http.MethodGet, "3", "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("got status %d for the deleted location, want %d", w.Code, http.StatusNotFound)
+		}
+	}
+
+	w, _ = serve(app.listActivityLocationsHandler, http.MethodGet, "3", "", "")
+
+	var env struct {
+		Locations []data.Location `json:"locations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Locations) != 1 || env.Locations[0].Name != "Field Museum" {
+		t.Errorf("got %+v for the museum day's locations, want just the Field Museum", env.Locations)
+	}
+
+	if w, _ := serve(app.listActivityLocationsHandler, http.MethodGet, "999", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d listing a missing activity's locations, want %d", w.Code, http.StatusNotFound)
+	}
+}
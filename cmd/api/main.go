@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"expvar"
 	"flag"
@@ -12,12 +13,17 @@ import (
 	"strings"
 	"sync"
 	"time"
+	// segments show times in IANA zones, which shouldn't depend on the host
+	_ "time/tzdata"
 
 	_ "github.com/lib/pq"
 
 	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/geocode"
 	"github.com/rytwalker/kagubird-api/internal/mailer"
+	"github.com/rytwalker/kagubird-api/internal/migrate"
 	"github.com/rytwalker/kagubird-api/internal/vcs"
+	"github.com/rytwalker/kagubird-api/migrations"
 )
 
 var (
@@ -25,13 +31,17 @@ var (
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port  int
+	env   string
+	store string
+	db    struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+		queryTimeout time.Duration
+		migrate      string
+		skipCheck    bool
 	}
 	limiter struct {
 		rps     float64
@@ -48,14 +58,33 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	cursor struct {
+		secret string
+		key    []byte
+	}
+	concurrency struct {
+		requireIfMatch bool
+	}
+	geocoder struct {
+		gazetteer string
+	}
+	reminders struct {
+		interval time.Duration
+		lead     time.Duration
+	}
+	inbound struct {
+		domain string
+		secret string
+	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config   config
+	logger   *slog.Logger
+	models   data.Models
+	mailer   mailer.Mailer
+	geocoder geocode.Geocoder
+	wg       sync.WaitGroup
 }
 
 func main() {
@@ -63,10 +92,14 @@ func main() {
 
 	flag.IntVar(&config.port, "port", 4000, "API server port")
 	flag.StringVar(&config.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&config.store, "store", "postgres", "Data store (postgres|memory)")
 	flag.StringVar(&config.db.dsn, "db-dsn", "", "PostgreSQL DSN")
 	flag.IntVar(&config.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&config.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.DurationVar(&config.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	flag.DurationVar(&config.db.queryTimeout, "db-query-timeout", data.DefaultQueryTimeout, "PostgreSQL default query timeout")
+	flag.StringVar(&config.db.migrate, "migrate", "", "Run migrations and exit (up|down|status|to=N)")
+	flag.BoolVar(&config.db.skipCheck, "db-skip-schema-check", false, "Serve even if the database schema is behind this build")
 	flag.Float64Var(&config.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&config.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&config.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
@@ -81,6 +114,18 @@ func main() {
 		return nil
 	})
 
+	flag.StringVar(&config.cursor.secret, "cursor-secret", "", "Secret for signing pagination cursors (random per process if empty)")
+
+	flag.BoolVar(&config.concurrency.requireIfMatch, "require-if-match", false, "Require If-Match headers on PATCH and DELETE requests")
+
+	flag.StringVar(&config.geocoder.gazetteer, "geocoder-gazetteer", "", "GeoNames dump for placing addresses sent without lat and lng (disabled if empty)")
+
+	flag.DurationVar(&config.reminders.interval, "booking-reminder-interval", time.Hour, "How often to check for booking deadlines to email reminders about (disabled if 0)")
+	flag.DurationVar(&config.reminders.lead, "booking-reminder-lead", 72*time.Hour, "How long before a booking deadline its reminder is sent")
+
+	flag.StringVar(&config.inbound.domain, "inbound-domain", "in.kagubird.com", "Domain of the addresses trips receive forwarded booking confirmations at")
+	flag.StringVar(&config.inbound.secret, "inbound-secret", "", "Secret the MTA sends in X-Inbound-Secret when forwarding email (inbound email is refused if empty)")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -92,35 +137,116 @@ func main() {
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
-	db, err := openDB(config)
-	if err != nil {
-		logger.Error(err.Error())
-		os.Exit(1)
+	if config.cursor.secret != "" {
+		config.cursor.key = []byte(config.cursor.secret)
+	} else {
+		// cursors signed with a random key stop working when the process restarts
+		// and aren't accepted by other instances
+		config.cursor.key = make([]byte, 32)
+		_, err := rand.Read(config.cursor.key)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Warn("no -cursor-secret set, using a random key for pagination cursors")
 	}
 
-	defer db.Close()
-
-	logger.Info("database connection pool established")
-
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() any {
 		return runtime.NumGoroutine()
 	}))
-	expvar.Publish("database", expvar.Func(func() any {
-		return db.Stats()
-	}))
+
+	var models data.Models
+
+	switch config.store {
+	case "postgres":
+		db, err := openDB(config)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer db.Close()
+
+		logger.Info("database connection pool established")
+
+		migrator, err := migrate.New(db, migrations.FS, logger)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		if config.db.migrate != "" {
+			err = runMigrations(context.Background(), migrator, config.db.migrate, logger)
+			if err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+
+		if !config.db.skipCheck {
+			err = checkSchema(context.Background(), migrator)
+			if err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		expvar.Publish("database", expvar.Func(func() any {
+			return db.Stats()
+		}))
+
+		models = data.NewModels(db, config.db.queryTimeout)
+	case "memory":
+		if config.db.migrate != "" {
+			logger.Error("-migrate needs -store=postgres")
+			os.Exit(1)
+		}
+
+		// everything lives in this process and is gone when it exits
+		models = data.NewMemoryModels()
+
+		err := seedDemoData(context.Background(), models)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		logger.Warn("using the in-memory store with demo data", "email", demoEmail, "password", demoPassword)
+	default:
+		logger.Error(fmt.Sprintf("unknown -store %q, must be postgres or memory", config.store))
+		os.Exit(1)
+	}
+
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		return time.Now().Unix()
 	}))
 
+	// without a geocoder, locations and stays need lat and lng from the client
+	var geocoder geocode.Geocoder
+
+	if config.geocoder.gazetteer != "" {
+		gazetteer, err := geocode.OpenGazetteer(config.geocoder.gazetteer)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		logger.Info("gazetteer loaded", "places", gazetteer.Len())
+
+		geocoder = gazetteer
+	}
+
 	app := &application{
-		config: config,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(config.smtp.host, config.smtp.port, config.smtp.username, config.smtp.password, config.smtp.sender),
+		config:   config,
+		logger:   logger,
+		models:   models,
+		mailer:   mailer.New(config.smtp.host, config.smtp.port, config.smtp.username, config.smtp.password, config.smtp.sender),
+		geocoder: geocoder,
 	}
 
-	err = app.serve()
+	err := app.serve()
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
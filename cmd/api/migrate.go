@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/rytwalker/kagubird-api/internal/migrate"
+)
+
+// runMigrations carries out a -migrate command: up, down (one migration),
+// status or to=N.
+func runMigrations(ctx context.Context, migrator *migrate.Migrator, command string, logger *slog.Logger) error {
+	var err error
+
+	switch {
+	case command == "up":
+		err = migrator.Up(ctx)
+	case command == "down":
+		err = migrator.Down(ctx)
+	case command == "status":
+	case strings.HasPrefix(command, "to="):
+		version, parseErr := strconv.ParseUint(strings.TrimPrefix(command, "to="), 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid -migrate %q, the version must be a number", command)
+		}
+		err = migrator.To(ctx, uint(version))
+	default:
+		return fmt.Errorf("invalid -migrate %q, must be up, down, status or to=N", command)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("schema status", "version", status.Version, "dirty", status.Dirty, "latest", status.Latest)
+	return nil
+}
+
+// checkSchema refuses to start against a database that is missing migrations
+// this binary relies on. A database that is ahead is fine, which lets the
+// previous release keep serving while a new one is rolled out.
+func checkSchema(ctx context.Context, migrator *migrate.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if status.Behind() {
+		return fmt.Errorf("database schema is at version %d (dirty: %t) but this build needs %d, run with -migrate=up or -db-skip-schema-check", status.Version, status.Dirty, status.Latest)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/rytwalker/kagubird-api/internal/jsonpatch"
+)
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// patchMediaType returns the patch format of the request body, or an empty
+// string for plain JSON updates.
+func patchMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case mergePatchMediaType, jsonPatchMediaType:
+		return mediaType
+	default:
+		return ""
+	}
+}
+
+// readPatch applies the merge patch or JSON patch in the request body to the
+// JSON representation of current and decodes the patched document into dst.
+// Errors from the patch itself wrap the jsonpatch errors so the caller can
+// tell a bad document from one that doesn't apply.
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, current, dst any) error {
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return err
+	}
+
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	switch patchMediaType(r) {
+	case mergePatchMediaType:
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	default:
+		doc, err = jsonpatch.Apply(doc, patch)
+	}
+	if err != nil {
+		return err
+	}
+
+	return app.decodeJSON(bytes.NewReader(doc), dst)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// listPlacesHandler is the autocomplete for places: it suggests places the
+// user has already used on their trips.
+func (app *application) listPlacesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Limit int
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	if data.ValidatePlaceQuery(v, input.Query, input.Limit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	places, err := app.models.Places.Autocomplete(r.Context(), user.ID, input.Query, input.Limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"places": places}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	place, err := app.models.Places.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	etag := versionETag(place.Version)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"place": place}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// placeInput is the body of a place update. Fields left out keep their
+// current values. The Google place ID is what identifies a place, so it can't
+// be changed.
+type placeInput struct {
+	Name    *string  `json:"name"`
+	Address *string  `json:"address"`
+	Lat     *float64 `json:"lat"`
+	Lng     *float64 `json:"lng"`
+	Website *string  `json:"website"`
+	Phone   *string  `json:"phone"`
+}
+
+func (input placeInput) apply(place *data.Place) {
+	if input.Name != nil {
+		place.Name = *input.Name
+	}
+	if input.Address != nil {
+		place.Address = *input.Address
+	}
+	if input.Lat != nil {
+		place.Lat = *input.Lat
+	}
+	if input.Lng != nil {
+		place.Lng = *input.Lng
+	}
+	if input.Website != nil {
+		place.Website = *input.Website
+	}
+	if input.Phone != nil {
+		place.Phone = *input.Phone
+	}
+}
+
+// updatePlaceHandler edits a place for every activity and stay that uses it.
+// Only users with the place on one of their trips can edit it.
+func (app *application) updatePlaceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	place, err := app.models.Places.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkPlaceEditor(w, r, place.ID) {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, place.Version) {
+		return
+	}
+
+	oldAddress, oldLat, oldLng := place.Address, place.Lat, place.Lng
+
+	if patchMediaType(r) != "" {
+		var patched data.Place
+
+		err = app.readPatch(w, r, place, &patched)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
+
+		if patched.Version != place.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+
+		if field := placeReadOnlyChange(place, &patched); field != "" {
+			app.failedValidationResponse(w, r, map[string]string{field: "is read-only"})
+			return
+		}
+
+		place.Name = patched.Name
+		place.Address = patched.Address
+		place.Lat = patched.Lat
+		place.Lng = patched.Lng
+		place.Website = patched.Website
+		place.Phone = patched.Phone
+	} else {
+		var input placeInput
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		input.apply(place)
+	}
+
+	v := validator.New()
+
+	geocoded, err := app.geocodeChangedAddress(r.Context(), v, oldAddress, oldLat, oldLng, place.Address, &place.Lat, &place.Lng)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidatePlace(v, place); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Places.Update(r.Context(), place)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(place.Version))
+
+	env := envelope{"place": place}
+	if geocoded != nil {
+		env["geocode"] = geocoded
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkPlaceEditor sends a 403 and returns false unless the user has the place
+// on one of their trips. A place is shared, so an edit by anyone else would
+// change trips they have nothing to do with.
+func (app *application) checkPlaceEditor(w http.ResponseWriter, r *http.Request, placeID int64) bool {
+	user := app.contextGetUser(r)
+
+	used, err := app.models.Places.UsedByUser(r.Context(), placeID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !used {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// placeReadOnlyChange returns the name of the first field a patch changed that
+// clients aren't allowed to set, or an empty string if there isn't one.
+func placeReadOnlyChange(place, patched *data.Place) string {
+	switch {
+	case patched.ID != place.ID:
+		return "id"
+	case patched.GooglePlaceID != place.GooglePlaceID:
+		return "google_place_id"
+	default:
+		return ""
+	}
+}
+
+func (app *application) listStayPlacesHandler(w http.ResponseWriter, r *http.Request) {
+	stay, ok := app.stayFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	places, err := app.models.Places.GetAllByStay(r.Context(), stay.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"places": places}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addStayPlaceHandler links a place to a stay, adding the place to the catalog
+// first if its Google place ID is new. A known place is linked as it is stored.
+func (app *application) addStayPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	stay, ok := app.stayFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name          string  `json:"name"`
+		Address       string  `json:"address"`
+		Lat           float64 `json:"lat"`
+		Lng           float64 `json:"lng"`
+		GooglePlaceID string  `json:"google_place_id"`
+		Website       string  `json:"website"`
+		Phone         string  `json:"phone"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	place := &data.Place{
+		GooglePlaceID: input.GooglePlaceID,
+		Name:          input.Name,
+		Address:       input.Address,
+		Lat:           input.Lat,
+		Lng:           input.Lng,
+		Website:       input.Website,
+		Phone:         input.Phone,
+	}
+
+	v := validator.New()
+
+	geocoded, err := app.geocodeAddress(r.Context(), v, place.Address, &place.Lat, &place.Lng)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidatePlace(v, place); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Places.Ensure(r.Context(), place)
+		if err != nil {
+			return err
+		}
+
+		return tx.Places.AddToStay(r.Context(), stay.ID, place.ID)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/places/%d", place.ID))
+
+	env := envelope{"place": place}
+	if geocoded != nil {
+		env["geocode"] = geocoded
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeStayPlaceHandler unlinks a place from a stay. The place stays in the
+// catalog for everything else that uses it.
+func (app *application) removeStayPlaceHandler(w http.ResponseWriter, r *http.Request) {
+	stay, ok := app.stayFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	placeID, err := app.readNamedIDParam(r, "place")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Places.RemoveFromStay(r.Context(), stay.ID, placeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "place successfully removed from stay"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// stayFromParam loads the stay named by the :id parameter, sending a 404 or
+// server error and returning false if it can't.
+func (app *application) stayFromParam(w http.ResponseWriter, r *http.Request) (*data.Stay, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	stay, err := app.models.Stays.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return stay, true
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"expvar"
+	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -17,20 +20,59 @@ func (app *application) routes() http.Handler {
 
 	// ACTIVITIES
 	router.HandlerFunc(http.MethodPost, "/v1/activities", app.createActivityHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/activities/:id", app.showActivityHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/activities/:id", app.updateActivityHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/activities/:id", app.deleteActivityHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/activities/trip/:id", app.listActivitiesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/activities/:id/schedule", app.scheduleActivityHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/activities/:id/locations", app.listActivityLocationsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/activities/:id/locations", app.addActivityPlaceHandler)
+
+	// DRAFTS
+	router.HandlerFunc(http.MethodGet, "/v1/drafts/:id", app.requireActivatedUser(app.showDraftHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/drafts/:id", app.requireActivatedUser(app.deleteDraftHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/drafts/:id/confirm", app.requireActivatedUser(app.confirmDraftHandler))
+
+	// LISTS
+	router.HandlerFunc(http.MethodGet, "/v1/lists", app.requireActivatedUser(app.listListsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/lists", app.requireActivatedUser(app.createListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:id", app.requireActivatedUser(app.showListHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/lists/:id", app.requireActivatedUser(app.updateListHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:id", app.requireActivatedUser(app.deleteListHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/lists/:id/places", app.requireActivatedUser(app.savePlaceHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/lists/:id/places/:place", app.requireActivatedUser(app.updateSavedPlaceHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:id/places/:place", app.requireActivatedUser(app.deleteSavedPlaceHandler))
 
 	// LOCATIONS
 	router.HandlerFunc(http.MethodPost, "/v1/locations", app.createLocationHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/locations/:id", app.showLocationHandler)
-	// more todo...
+	router.HandlerFunc(http.MethodPatch, "/v1/locations/:id", app.requireActivatedUser(app.updateLocationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/locations/:id", app.deleteLocationHandler)
 
 	// METRICS
 	router.Handler(http.MethodGet, "/v1/metrics", expvar.Handler())
 
+	// PLACES
+	router.HandlerFunc(http.MethodGet, "/v1/places", app.requireActivatedUser(app.listPlacesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/places/:id", app.showPlaceHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/places/:id", app.requireActivatedUser(app.updatePlaceHandler))
+
+	// SEARCH
+	router.HandlerFunc(http.MethodGet, "/v1/search", app.requireActivatedUser(app.searchHandler))
+
+	// SEGMENTS
+	router.HandlerFunc(http.MethodPost, "/v1/segments", app.requireActivatedUser(app.createSegmentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/segments/:id", app.requireActivatedUser(app.showSegmentHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/segments/:id", app.requireActivatedUser(app.updateSegmentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/segments/:id", app.requireActivatedUser(app.deleteSegmentHandler))
+
 	// STAYS
 	router.HandlerFunc(http.MethodPost, "/v1/stays", app.createStayHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/stays/:id", app.showStayHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/stays/:id", app.updateStayHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/stays/:id", app.deleteStayHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/stays/:id/places", app.listStayPlacesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/stays/:id/places", app.addStayPlaceHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/stays/:id/places/:place", app.removeStayPlaceHandler)
 
 	// TOKENS
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
@@ -46,10 +88,59 @@ func (app *application) routes() http.Handler {
 	// router.HandlerFunc(http.MethodPatch, "/v1/trips/:id", app.requireActivatedUser(app.updateTripHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/trips/:id", app.updateTripHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/trips/:id", app.requireActivatedUser(app.deleteTripHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/trips/:id/ideas/order", app.reorderIdeasHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/trips/:id/activities", app.listActivitiesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/trips/:id/activities:verb", app.customMethod("batch", app.batchActivitiesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trips/:id/bookings", app.requireActivatedUser(app.listBookingsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trips/:id/drafts", app.requireActivatedUser(app.listDraftsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trips/:id/inbox", app.requireActivatedUser(app.showInboxHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/trips/:id/inbox", app.requireActivatedUser(app.rotateInboxHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trips/:id/locations", app.listLocationsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/trips/:id/segments", app.requireActivatedUser(app.listSegmentsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trips/:id/stays", app.listStaysHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/trips/:id/stays:verb", app.customMethod("batch", app.batchStaysHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trips/:id/tripgoers", app.listTripGoersHandler)
 
 	// USERS
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// DEPRECATED
+	// Routes that moved, kept under their old paths until clients have caught
+	// up. httprouter can't register them next to the routes that replaced them,
+	// so they're matched before the request gets to it.
+	legacy := http.NewServeMux()
+	legacy.Handle("/", router)
+	legacy.HandleFunc("GET /v1/activities/trip/{id}", app.deprecatedRoute("/v1/trips/%s/activities", app.listActivitiesHandler))
+	legacy.HandleFunc("GET /v1/stays/trip/{id}", app.deprecatedRoute("/v1/trips/%s/stays", app.listStaysHandler))
+
+	// inbound email comes from our own MTA, which can forward a burst of mail
+	// from one address, so it's served outside the rate limiter
+	mux := http.NewServeMux()
+	mux.Handle("/", app.rateLimit(app.authenticate(legacy)))
+	mux.HandleFunc("POST /v1/inbound/email", app.requireInboundSecret(app.inboundEmailHandler))
+
+	return app.metrics(app.recoverPanic(app.enableCORS(mux)))
+}
+
+// routesDeprecated is when the routes served by deprecatedRoute were
+// deprecated, as an RFC 9745 Deprecation header value.
+const routesDeprecated = "@1792368000" // 2026-10-19
+
+// deprecatedRoute serves a route that moved with the handler of the route that
+// replaced it, passing on the {id} wildcard as the :id parameter the handler
+// reads. Responses say the route is deprecated and link to its successor,
+// whose path is made by formatting successor with the id.
+func (app *application) deprecatedRoute(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		w.Header().Set("Deprecation", routesDeprecated)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, fmt.Sprintf(successor, url.PathEscape(id))))
+
+		params := httprouter.Params{{Key: "id", Value: id}}
+		ctx := context.WithValue(r.Context(), httprouter.ParamsKey, params)
+
+		next(w, r.WithContext(ctx))
+	}
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestLegacyRoutes(t *testing.T) {
+	_, routes := routedTestApplication(t)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		link   string
+	}{
+		{http.MethodGet, "/v1/activities/trip/1", http.StatusOK, `</v1/trips/1/activities>; rel="successor-version"`},
+		{http.MethodHead, "/v1/activities/trip/1", http.StatusOK, `</v1/trips/1/activities>; rel="successor-version"`},
+		{http.MethodGet, "/v1/activities/trip/x", http.StatusNotFound, `</v1/trips/x/activities>; rel="successor-version"`},
+		{http.MethodPost, "/v1/activities/trip/1", http.StatusNotFound, ""},
+		{http.MethodGet, "/v1/activities/trip/1/extra", http.StatusNotFound, ""},
+		{http.MethodGet, "/v1/stays/trip/1", http.StatusOK, `</v1/trips/1/stays>; rel="successor-version"`},
+		{http.MethodGet, "/v1/trips/1/activities", http.StatusOK, ""},
+		{http.MethodGet, "/v1/nowhere", http.StatusNotFound, ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.RemoteAddr = fmt.Sprintf("192.0.2.%d:4000", 100+i)
+
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if got := w.Header().Get("Link"); got != tt.link {
+				t.Errorf("got Link %q, want %q", got, tt.link)
+			}
+
+			if deprecated := w.Header().Get("Deprecation") != ""; deprecated != (tt.link != "") {
+				t.Errorf("got Deprecation %q", w.Header().Get("Deprecation"))
+			}
+
+			if tt.method != http.MethodHead && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				t.Errorf("got Content-Type %q, want JSON", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// The ETag an update responds with is the one a GET of the same resource
+// gives, so clients can revalidate what they were sent.
+func TestUpdateETags(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	user, err := app.models.Users.GetByEmail(context.Background(), demoEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trip, err := app.models.Trips.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := trip.StartDate.AddDate(0, 0, 1).Format(time.DateOnly)
+
+	serve := func(handler http.HandlerFunc, method, body, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		params := httprouter.Params{{Key: "id", Value: "1"}}
+		r = app.contextSetUser(r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)), user)
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		update http.HandlerFunc
+		body   string
+		show   http.HandlerFunc
+	}{
+		{"activity", app.updateActivityHandler, `{"notes": "Bring a jacket."}`, app.showActivityHandler},
+		{"scheduled activity", app.scheduleActivityHandler, `{"schedule": "all_day", "start_date": "` + day + `"}`, app.showActivityHandler},
+		{"trip", app.updateTripHandler, `{"name": "Chicago again"}`, app.showTripHandler},
+		{"stay", app.updateStayHandler, `{"phone": "312-555-0100"}`, app.showStayHandler},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.update, http.MethodPatch, tt.body, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d from the update: %s", w.Code, w.Body)
+			}
+
+			etag := w.Header().Get("ETag")
+
+			w = serve(tt.show, http.MethodGet, "", etag)
+			if w.Code != http.StatusNotModified {
+				t.Errorf("got status %d for If-None-Match %s, want %d (ETag %s)", w.Code, etag, http.StatusNotModified, w.Header().Get("ETag"))
+			}
+		})
+	}
+}
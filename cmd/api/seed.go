@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// The credentials of the demo user created by -store=memory.
+const (
+	demoEmail    = "demo@kagubird.dev"
+	demoPassword = "pa55word"
+)
+
+// seedDemoData fills an empty store with an activated demo user, a friend on
+// their trip, and a trip a few weeks out with timed and all-day activities,
+// ideas and stays. It's meant for -store=memory, so frontend work can start
+// from something other than a blank slate.
+func seedDemoData(ctx context.Context, models data.Models) error {
+	return models.Transaction(ctx, func(tx data.Models) error {
+		demo, err := seedUser(ctx, tx, "Demo User", demoEmail)
+		if err != nil {
+			return err
+		}
+
+		friend, err := seedUser(ctx, tx, "Fran Friend", "friend@kagubird.dev")
+		if err != nil {
+			return err
+		}
+
+		start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 21)
+		at := func(day, hour, minute int) *time.Time {
+			t := start.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+			return &t
+		}
+		on := func(day int) *data.Date {
+			d := data.Date{Time: start.AddDate(0, 0, day)}
+			return &d
+		}
+		cost := func(cents int64) *int64 {
+			return &cents
+		}
+
+		trip := &data.Trip{
+			Name:          "Long weekend in Chicago",
+			City:          "Chicago",
+			StateCode:     "IL",
+			GooglePlaceID: "ChIJ7cv00DwsDogRAMDACa2m4K8",
+			Lat:           41.878113,
+			Lng:           -87.629799,
+			StartDate:     start,
+			EndDate:       start.AddDate(0, 0, 3),
+			CreatedBy:     demo.ID,
+		}
+
+		err = tx.Trips.Insert(ctx, trip)
+		if err != nil {
+			return err
+		}
+
+		err = tx.TripGoers.Insert(ctx, friend.ID, trip.ID)
+		if err != nil {
+			return err
+		}
+
+		activities := []*data.Activity{
+			{
+				Name:      "Architecture boat tour",
+				Notes:     "Meet at the dock 15 minutes early.",
+				Category:  data.CategorySightseeing,
+				Tags:      []string{"booked"},
+				Schedule:  data.ScheduleTimed,
+				StartTime: at(0, 14, 0),
+				EndTime:   at(0, 15, 30),
+				Booking: data.Booking{
+					Status:             data.BookingStatusBooked,
+					ConfirmationNumber: "CAC-88214",
+					Provider:           "Chicago Architecture Center",
+					Cost:               cost(11800),
+					Currency:           "USD",
+				},
+				Locations: []*data.Location{
+					{Name: "Chicago Architecture Center", Address: "111 E Wacker Dr, Chicago, IL 60601", Lat: 41.887668, Lng: -87.623854, GooglePlaceID: "ChIJdemoChicagoArchCenter01"},
+				},
+			},
+			{
+				Name:      "Deep dish dinner",
+				Category:  data.CategoryFood,
+				Tags:      []string{"booked", "dinner"},
+				Schedule:  data.ScheduleTimed,
+				StartTime: at(0, 19, 0),
+				EndTime:   at(0, 21, 0),
+				Booking:   data.Booking{Status: data.BookingStatusNeedsBooking},
+				Locations: []*data.Location{
+					{Name: "Pequod's Pizza", Address: "2207 N Clybourn Ave, Chicago, IL 60614", Lat: 41.922018, Lng: -87.664444, GooglePlaceID: "ChIJdemoPequodsPizza000001"},
+				},
+			},
+			{
+				Name:      "Museum day",
+				Notes:     "Art Institute in the morning, Field Museum after lunch.",
+				Category:  data.CategorySightseeing,
+				Tags:      []string{},
+				Schedule:  data.ScheduleAllDay,
+				StartDate: on(1),
+				EndDate:   on(1),
+				Locations: []*data.Location{
+					{Name: "Art Institute of Chicago", Address: "111 S Michigan Ave, Chicago, IL 60603", Lat: 41.879585, Lng: -87.623713, GooglePlaceID: "ChIJdemoArtInstitute000001"},
+					{Name: "Field Museum", Address: "1400 S DuSable Lake Shore Dr, Chicago, IL 60605", Lat: 41.866261, Lng: -87.616981, GooglePlaceID: "ChIJdemoFieldMuseum0000001"},
+				},
+			},
+			{
+				Name:     "Walk the Lakefront Trail",
+				Category: data.CategoryOutdoors,
+				Tags:     []string{"free"},
+				Schedule: data.ScheduleUnscheduled,
+			},
+			{
+				Name:     "Jazz at the Green Mill",
+				Notes:    "No reservations, get there early.",
+				Category: data.CategoryEntertainment,
+				Tags:     []string{"nightlife"},
+				Schedule: data.ScheduleUnscheduled,
+				Locations: []*data.Location{
+					{Name: "Green Mill Cocktail Lounge", Address: "4802 N Broadway, Chicago, IL 60640", Lat: 41.969034, Lng: -87.659729, GooglePlaceID: "ChIJdemoGreenMillLounge001"},
+				},
+			},
+		}
+
+		for _, activity := range activities {
+			locations := activity.Locations
+			activity.TripID = trip.ID
+
+			if activity.Booking.Status == "" {
+				activity.Booking.Status = data.BookingStatusIdea
+			}
+
+			err = tx.Activities.Insert(ctx, activity)
+			if err != nil {
+				return err
+			}
+
+			for _, location := range locations {
+				location.ActivityID = activity.ID
+
+				err = tx.Locations.Insert(ctx, location)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		stays := []*data.Stay{
+			{
+				Name:      "The Hoxton",
+				StartTime: *at(0, 15, 0),
+				EndTime:   *at(2, 11, 0),
+				Address:   "200 N Green St, Chicago, IL 60607",
+				Lat:       41.885499,
+				Lng:       -87.648849,
+				Link:      "https://thehoxton.com/chicago/",
+				Type:      data.StayTypeHotel,
+				Tags:      []string{"booked"},
+				Booking: data.Booking{
+					Status:               data.BookingStatusBooked,
+					ConfirmationNumber:   "HX-4471-CHI",
+					Provider:             "The Hoxton",
+					Cost:                 cost(61200),
+					Currency:             "USD",
+					CancellationDeadline: at(-2, 15, 0),
+					PaymentDue:           on(-7),
+				},
+			},
+			{
+				Name:      "Fran's place",
+				StartTime: *at(2, 18, 0),
+				EndTime:   *at(3, 10, 0),
+				Address:   "Logan Square, Chicago, IL",
+				Lat:       41.923210,
+				Lng:       -87.707390,
+				Type:      data.StayTypeFriends,
+				Tags:      []string{},
+				Booking:   data.Booking{Status: data.BookingStatusIdea},
+			},
+		}
+
+		for _, stay := range stays {
+			stay.TripID = trip.ID
+
+			err = tx.Stays.Insert(ctx, stay)
+			if err != nil {
+				return err
+			}
+		}
+
+		flight := &data.Segment{
+			TripID:  trip.ID,
+			Mode:    data.SegmentModeFlight,
+			Carrier: "United",
+			Number:  "UA 1234",
+			Departure: data.Endpoint{
+				Name:     "ORD",
+				Time:     *at(3, 17, 0),
+				TimeZone: "America/Chicago",
+			},
+			Arrival: data.Endpoint{
+				Name:     "SFO",
+				Time:     *at(3, 21, 30),
+				TimeZone: "America/Los_Angeles",
+			},
+			ConfirmationCode: "KGB7Q2",
+			Travelers:        []int64{demo.ID, friend.ID},
+		}
+
+		err = tx.Segments.Insert(ctx, flight)
+		if err != nil {
+			return err
+		}
+
+		hotel := &data.Place{
+			GooglePlaceID: "ChIJdemoTheHoxtonChicago01",
+			Name:          "The Hoxton",
+			Address:       "200 N Green St, Chicago, IL 60607",
+			Lat:           41.885499,
+			Lng:           -87.648849,
+			Website:       "https://thehoxton.com/chicago/",
+		}
+
+		err = tx.Places.Ensure(ctx, hotel)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Places.AddToStay(ctx, stays[0].ID, hotel.ID)
+		if err != nil {
+			return err
+		}
+
+		list := &data.List{UserID: demo.ID, Name: "Best pizza"}
+
+		err = tx.Lists.Insert(ctx, list)
+		if err != nil {
+			return err
+		}
+
+		rating := 5
+
+		return tx.SavedPlaces.Insert(ctx, &data.SavedPlace{
+			ListID: list.ID,
+			Place:  &data.Place{ID: activities[1].Locations[0].PlaceID},
+			Notes:  "Caramelized crust, worth the wait.",
+			Rating: &rating,
+		})
+	})
+}
+
+func seedUser(ctx context.Context, models data.Models, name, email string) (*data.User, error) {
+	user := &data.User{
+		Name:      name,
+		Email:     email,
+		Activated: true,
+	}
+
+	err := user.Password.Set(demoPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	err = models.Users.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = models.Permissions.AddForUser(ctx, user.ID, "trips:read", "trips:write")
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
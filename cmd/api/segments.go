@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+func (app *application) createSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TripID int64 `json:"trip"`
+		segmentInput
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	segment := &data.Segment{TripID: input.TripID, Travelers: []int64{}}
+	input.apply(segment)
+
+	v := validator.New()
+	data.ValidateSegment(v, segment)
+
+	if segment.TripID == 0 {
+		v.AddError("trip", "must be provided")
+	} else {
+		trip, err := app.models.Trips.Get(r.Context(), segment.TripID)
+		switch {
+		case err == nil:
+			if !app.checkTripMember(w, r, trip.ID) {
+				return
+			}
+
+			err = app.validateSegmentOnTrip(r.Context(), v, segment, trip)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("trip", "must be an existing trip")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Segments.Insert(r.Context(), segment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/segments/%d", segment.ID))
+	headers.Set("ETag", versionETag(segment.Version))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"segment": segment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// segmentInput is a partial update of a segment. Fields left out of the
+// request are nil and keep their current value.
+type segmentInput struct {
+	Mode             *string        `json:"mode"`
+	Carrier          *string        `json:"carrier"`
+	Number           *string        `json:"number"`
+	Departure        *endpointInput `json:"departure"`
+	Arrival          *endpointInput `json:"arrival"`
+	ConfirmationCode *string        `json:"confirmation_code"`
+	Seat             *string        `json:"seat"`
+	Travelers        []int64        `json:"travelers"`
+}
+
+type endpointInput struct {
+	Name     *string    `json:"name"`
+	PlaceID  *int64     `json:"place"`
+	Time     *time.Time `json:"time"`
+	TimeZone *string    `json:"time_zone"`
+}
+
+func (input segmentInput) apply(segment *data.Segment) {
+	if input.Mode != nil {
+		segment.Mode = strings.ToLower(*input.Mode)
+	}
+	if input.Carrier != nil {
+		segment.Carrier = *input.Carrier
+	}
+	if input.Number != nil {
+		segment.Number = *input.Number
+	}
+	if input.Departure != nil {
+		input.Departure.apply(&segment.Departure)
+	}
+	if input.Arrival != nil {
+		input.Arrival.apply(&segment.Arrival)
+	}
+	if input.ConfirmationCode != nil {
+		segment.ConfirmationCode = *input.ConfirmationCode
+	}
+	if input.Seat != nil {
+		segment.Seat = *input.Seat
+	}
+	if input.Travelers != nil {
+		segment.Travelers = input.Travelers
+	}
+}
+
+func (input endpointInput) apply(endpoint *data.Endpoint) {
+	if input.Name != nil {
+		endpoint.Name = *input.Name
+	}
+	if input.PlaceID != nil {
+		endpoint.PlaceID = input.PlaceID
+	}
+	if input.Time != nil {
+		endpoint.Time = *input.Time
+	}
+	if input.TimeZone != nil {
+		endpoint.TimeZone = *input.TimeZone
+	}
+}
+
+// validateSegmentOnTrip checks a segment against the rest of its trip: it must
+// fall inside the trip, its places must be in the catalog, its travelers must
+// be going, and none of them can be booked on another segment or be due at a
+// timed activity at the same time.
+func (app *application) validateSegmentOnTrip(ctx context.Context, v *validator.Validator, segment *data.Segment, trip *data.Trip) error {
+	data.ValidateSegmentInTrip(v, segment, trip)
+
+	endpoints := map[string]*data.Endpoint{"departure": &segment.Departure, "arrival": &segment.Arrival}
+
+	for key, endpoint := range endpoints {
+		if endpoint.PlaceID == nil {
+			continue
+		}
+
+		_, err := app.models.Places.Get(ctx, *endpoint.PlaceID)
+		switch {
+		case err == nil:
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError(key+".place", "must be an existing place")
+		default:
+			return err
+		}
+	}
+
+	tripgoers, err := app.models.Users.GetAllByTrip(ctx, trip.ID)
+	if err != nil {
+		return err
+	}
+
+	data.ValidateSegmentTravelers(v, segment, trip, tripgoers)
+
+	others, err := app.models.Segments.GetAllByTrip(ctx, trip.ID)
+	if err != nil {
+		return err
+	}
+
+	data.ValidateSegmentConflicts(v, segment, others)
+
+	activities, err := app.models.Activities.GetAllByTrip(ctx, trip.ID)
+	if err != nil {
+		return err
+	}
+
+	data.ValidateSegmentActivityConflicts(v, segment, activities)
+
+	return nil
+}
+
+func (app *application) showSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	segment, ok := app.segmentFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	etag := versionETag(segment.Version)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"segment": segment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	segment, ok := app.segmentFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, segment.Version) {
+		return
+	}
+
+	if patchMediaType(r) != "" {
+		var patched data.Segment
+
+		err := app.readPatch(w, r, segment, &patched)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
+
+		if patched.Version != segment.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+
+		if field := segmentReadOnlyChange(segment, &patched); field != "" {
+			app.failedValidationResponse(w, r, map[string]string{field: "is read-only"})
+			return
+		}
+
+		segment.Mode = strings.ToLower(patched.Mode)
+		segment.Carrier = patched.Carrier
+		segment.Number = patched.Number
+		segment.Departure = patched.Departure
+		segment.Arrival = patched.Arrival
+		segment.ConfirmationCode = patched.ConfirmationCode
+		segment.Seat = patched.Seat
+		segment.Travelers = patched.Travelers
+
+		if segment.Travelers == nil {
+			segment.Travelers = []int64{}
+		}
+	} else {
+		var input segmentInput
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		input.apply(segment)
+	}
+
+	trip, err := app.models.Trips.Get(r.Context(), segment.TripID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateSegment(v, segment)
+
+	err = app.validateSegmentOnTrip(r.Context(), v, segment, trip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Segments.Update(r.Context(), segment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(segment.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"segment": segment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// segmentReadOnlyChange returns the name of the first field a patch changed
+// that clients aren't allowed to set, or an empty string if there isn't one.
+func segmentReadOnlyChange(segment, patched *data.Segment) string {
+	switch {
+	case patched.ID != segment.ID:
+		return "id"
+	case patched.TripID != segment.TripID:
+		return "trip"
+	default:
+		return ""
+	}
+}
+
+func (app *application) deleteSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	segment, ok := app.segmentFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, segment.Version) {
+		return
+	}
+
+	err := app.models.Segments.DeleteVersion(r.Context(), segment.ID, segment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "segment successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSegmentsHandler returns all of a trip's segments in departure order.
+func (app *application) listSegmentsHandler(w http.ResponseWriter, r *http.Request) {
+	trip, ok := app.tripFromParam(w, r)
+	if !ok || !app.checkTripMember(w, r, trip.ID) {
+		return
+	}
+
+	segments, err := app.models.Segments.GetAllByTrip(r.Context(), trip.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"segments": segments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// segmentFromParam loads the segment named by the :id parameter, sending a 404
+// or server error and returning false if it can't, or a 403 if the user isn't
+// on the segment's trip.
+func (app *application) segmentFromParam(w http.ResponseWriter, r *http.Request) (*data.Segment, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	segment, err := app.models.Segments.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !app.checkTripMember(w, r, segment.TripID) {
+		return nil, false
+	}
+
+	return segment, true
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// localTime formats the instant day days and hour hours (UTC) after start as
+// the wall-clock time in zone, with that zone's offset.
+func localTime(t *testing.T, start time.Time, day, hour int, zone string) string {
+	t.Helper()
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return start.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour).In(loc).Format(time.RFC3339)
+}
+
+func TestCreateSegment(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	trip, err := app.models.Trips.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	solo := insertSoloTrip(t, app)
+
+	body := func(tripID int64, mode, departure, departureZone, arrival string) string {
+		return fmt.Sprintf(`{"trip": %d, "mode": %q, "carrier": "Amtrak", "number": "7",
+			"departure": {"name": "Chicago Union Station", "time": %q, "time_zone": %q},
+			"arrival": {"name": "Milwaukee", "time": %q, "time_zone": "America/Chicago"},
+			"travelers": [1]}`, tripID, mode, departure, departureZone, arrival)
+	}
+
+	chicago := func(day, hour int) string {
+		return localTime(t, trip.StartDate, day, hour, "America/Chicago")
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		key    string
+	}{
+		{"train on a free afternoon", body(trip.ID, "train", chicago(2, 20), "America/Chicago", chicago(2, 22)), http.StatusCreated, ""},
+		{"offset that isn't the time zone's", body(trip.ID, "train", trip.StartDate.AddDate(0, 0, 2).Add(20*time.Hour).Format(time.RFC3339), "America/Chicago", chicago(2, 22)), http.StatusUnprocessableEntity, "departure.time"},
+		{"before the trip", body(trip.ID, "train", chicago(-1, 20), "America/Chicago", chicago(-1, 22)), http.StatusUnprocessableEntity, "departure.time"},
+		{"after the trip", body(trip.ID, "train", "2030-06-01T10:00:00-05:00", "America/Chicago", "2030-06-01T12:00:00-05:00"), http.StatusUnprocessableEntity, "arrival.time"},
+		{"during the boat tour", body(trip.ID, "train", chicago(0, 13), "America/Chicago", chicago(0, 15)), http.StatusUnprocessableEntity, "travelers"},
+		{"car rental during the boat tour", body(trip.ID, "car_rental", chicago(0, 13), "America/Chicago", chicago(0, 15)), http.StatusCreated, ""},
+		{"another trip", body(solo.ID, "train", chicago(2, 20), "America/Chicago", chicago(2, 22)), http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, env := app.serveTest(t, app.createSegmentHandler, http.MethodPost, "", tt.body)
+			if status != tt.status {
+				t.Fatalf("got status %d, want %d: %s", status, tt.status, env["error"])
+			}
+
+			if tt.key == "" {
+				return
+			}
+
+			var errs map[string]string
+
+			err := json.Unmarshal(env["error"], &errs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := errs[tt.key]; !ok {
+				t.Errorf("got errors %v, want one for %s", errs, tt.key)
+			}
+		})
+	}
+}
+
+// Segments are only for the people on a trip.
+func TestSegmentsNeedTripMembers(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	soloID := strconv.FormatInt(insertSoloTrip(t, app).ID, 10)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		id      string
+		body    string
+		status  int
+	}{
+		{"list segments", app.listSegmentsHandler, http.MethodGet, "1", "", http.StatusOK},
+		{"list another trip's segments", app.listSegmentsHandler, http.MethodGet, soloID, "", http.StatusForbidden},
+		{"show segment", app.showSegmentHandler, http.MethodGet, "1", "", http.StatusOK},
+		{"update segment", app.updateSegmentHandler, http.MethodPatch, "1", `{"seat": "12A"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, env := app.serveTest(t, tt.handler, tt.method, tt.id, tt.body)
+			if status != tt.status {
+				t.Errorf("got status %d, want %d: %s", status, tt.status, env["error"])
+			}
+		})
+	}
+}
+
+// A trip embeds its segments for the people on it, and only for them.
+func TestTripIncludesSegments(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	soloID := strconv.FormatInt(insertSoloTrip(t, app).ID, 10)
+
+	tests := []struct {
+		name     string
+		id       string
+		query    string
+		status   int
+		segments bool
+	}{
+		{"own trip", "1", "", http.StatusOK, true},
+		{"own trip, segments only", "1", "?include=segments", http.StatusOK, true},
+		{"another trip", soloID, "", http.StatusOK, false},
+		{"another trip, segments only", soloID, "?include=segments", http.StatusForbidden, false},
+	}
+
+	user, err := app.models.Users.GetByEmail(context.Background(), demoEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			params := httprouter.Params{{Key: "id", Value: tt.id}}
+			r = app.contextSetUser(r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)), user)
+
+			w := httptest.NewRecorder()
+			app.showTripHandler(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var env struct {
+				Trip map[string]json.RawMessage `json:"trip"`
+			}
+
+			err := json.Unmarshal(w.Body.Bytes(), &env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := env.Trip["segments"]; ok != tt.segments {
+				t.Errorf("got segments in the trip %t, want %t", ok, tt.segments)
+			}
+		})
+	}
+}
+
+func TestSegmentCRUD(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	user, err := app.models.Users.GetByEmail(context.Background(), demoEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(handler http.HandlerFunc, method, id, body, ifMatch string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+		t.Helper()
+
+		r := httptest.NewRequest(method, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+
+		params := httprouter.Params{{Key: "id", Value: id}}
+		r = app.contextSetUser(r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)), user)
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		var env map[string]json.RawMessage
+		json.Unmarshal(w.Body.Bytes(), &env)
+
+		return w, env
+	}
+
+	w, env := serve(app.showSegmentHandler, http.MethodGet, "1", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d showing the flight, want %d", w.Code, http.StatusOK)
+	}
+
+	var flight data.Segment
+	if err := json.Unmarshal(env["segment"], &flight); err != nil {
+		t.Fatal(err)
+	}
+
+	// each endpoint's time reads as the local time there
+	if _, offset := flight.Departure.Time.Zone(); offset != -5*3600 && offset != -6*3600 {
+		t.Errorf("got departure time %s, want it in Chicago time", env["segment"])
+	}
+	if _, offset := flight.Arrival.Time.Zone(); offset != -7*3600 && offset != -8*3600 {
+		t.Errorf("got arrival time %s, want it in Los Angeles time", env["segment"])
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("showing the flight didn't set an ETag")
+	}
+
+	invalid := []struct {
+		name    string
+		body    string
+		ifMatch string
+		status  int
+		field   string
+	}{
+		{"stale If-Match", `{"seat": "12A"}`, `"v999"`, http.StatusPreconditionFailed, ""},
+		{"flight without a carrier", `{"carrier": ""}`, etag, http.StatusUnprocessableEntity, "carrier"},
+		{"unknown mode", `{"mode": "ferry"}`, etag, http.StatusUnprocessableEntity, "mode"},
+		{"someone not on the trip", `{"travelers": [999]}`, etag, http.StatusUnprocessableEntity, "travelers"},
+		{"unknown time zone", `{"arrival": {"time_zone": "America/Gotham"}}`, etag, http.StatusUnprocessableEntity, "arrival.time_zone"},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			w, env := serve(app.updateSegmentHandler, http.MethodPatch, "1", tt.body, tt.ifMatch)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.status, env["error"])
+			}
+
+			if tt.field != "" {
+				var errs map[string]string
+				json.Unmarshal(env["error"], &errs)
+				if errs[tt.field] == "" {
+					t.Errorf("got errors %v, want one for %s", errs, tt.field)
+				}
+			}
+		})
+	}
+
+	w, env = serve(app.updateSegmentHandler, http.MethodPatch, "1", `{"seat": "12A", "travelers": [`+strconv.FormatInt(user.ID, 10)+`]}`, etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d updating the flight, want %d: %s", w.Code, http.StatusOK, env["error"])
+	}
+
+	var updated data.Segment
+	if err := json.Unmarshal(env["segment"], &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Seat != "12A" || len(updated.Travelers) != 1 || updated.Version != flight.Version+1 || updated.Number != flight.Number {
+		t.Errorf("got %s after the update", env["segment"])
+	}
+
+	w, _ = serve(app.deleteSegmentHandler, http.MethodDelete, "1", "", etag)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d deleting with the old ETag, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+
+	w, _ = serve(app.deleteSegmentHandler, http.MethodDelete, "1", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d deleting the flight, want %d", w.Code, http.StatusOK)
+	}
+
+	if w, _ := serve(app.showSegmentHandler, http.MethodGet, "1", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d showing the deleted flight, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w, env = serve(app.listSegmentsHandler, http.MethodGet, "1", "", "")
+	if w.Code != http.StatusOK || string(env["segments"]) != "[]" {
+		t.Errorf("got status %d and segments %s after the delete, want none", w.Code, env["segments"])
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -23,6 +24,27 @@ func (app *application) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
+	// every request context, and so every query, derives from baseCtx. It's
+	// cancelled if requests are still running when the shutdown grace period
+	// runs out, which cancels their statements in Postgres too.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	srv.BaseContext = func(net.Listener) context.Context {
+		return baseCtx
+	}
+
+	// background jobs stop as soon as shutdown starts, rather than holding it
+	// up until the grace period runs out
+	jobsCtx, stopJobs := context.WithCancel(baseCtx)
+	defer stopJobs()
+
+	if app.config.reminders.interval > 0 {
+		app.background(func() {
+			app.remindBookings(jobsCtx)
+		})
+	}
+
 	shutdownError := make(chan error)
 	// start background goroutine
 	go func() {
@@ -37,10 +59,12 @@ func (app *application) serve() error {
 
 		err := srv.Shutdown(ctx)
 		if err != nil {
+			cancelBase()
 			shutdownError <- err
 		}
 
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
+		stopJobs()
 		app.wg.Wait()
 
 		shutdownError <- nil
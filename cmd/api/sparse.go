@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// includes is the set of relationships a client asked to have embedded, such as
+// "activities" or "activities.locations".
+type includes map[string]bool
+
+// fieldsets holds the fields a client asked for, per resource type. A type
+// without an entry is returned in full.
+type fieldsets map[string][]string
+
+// The relationships that can be embedded in a trip, and the JSON keys they're
+// embedded under.
+var tripIncludes = []string{"activities", "activities.locations", "segments", "stays", "tripgoers"}
+
+// The resource types fields[...] can be given for.
+var fieldsetTypes = map[string]reflect.Type{
+	"trip":     reflect.TypeOf(data.Trip{}),
+	"activity": reflect.TypeOf(data.Activity{}),
+	"location": reflect.TypeOf(data.Location{}),
+	"segment":  reflect.TypeOf(data.Segment{}),
+	"stay":     reflect.TypeOf(data.Stay{}),
+	"tripgoer": reflect.TypeOf(data.User{}),
+}
+
+// readIncludes reads the include parameter. When it's missing every permitted
+// relationship is included, so existing clients keep getting the full graph; an
+// empty include= asks for none. Nested includes imply their parents. A trip
+// fieldset narrows the default includes further, see includes.restrict.
+func (app *application) readIncludes(qs url.Values, permitted []string, v *validator.Validator) includes {
+	inc := includes{}
+
+	if !qs.Has("include") {
+		for _, name := range permitted {
+			inc[name] = true
+		}
+		return inc
+	}
+
+	for _, name := range app.readCSV(qs, "include", []string{}) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if !validator.PermittedValue(name, permitted...) {
+			v.AddError("include", "invalid include value "+name)
+			continue
+		}
+
+		inc[name] = true
+		for i := strings.LastIndex(name, "."); i != -1; i = strings.LastIndex(name, ".") {
+			name = name[:i]
+			inc[name] = true
+		}
+	}
+
+	return inc
+}
+
+// restrict drops the relationships a fieldset leaves out, along with anything
+// nested under them, so a request like fields[trip]=name,start_date doesn't
+// load relationships only to throw them away. It's only for the default
+// includes: ones asked for with include= are returned whatever the fieldset.
+func (inc includes) restrict(fields []string) {
+	for name := range inc {
+		relationship, _, _ := strings.Cut(name, ".")
+		if !slices.Contains(fields, relationship) {
+			delete(inc, name)
+		}
+	}
+}
+
+// readFieldsets reads fields[type]=a,b,c parameters.
+func (app *application) readFieldsets(qs url.Values, v *validator.Validator) fieldsets {
+	sets := fieldsets{}
+
+	for key := range qs {
+		if !strings.HasPrefix(key, "fields[") {
+			continue
+		}
+
+		typeName := strings.TrimSuffix(strings.TrimPrefix(key, "fields["), "]")
+
+		t, ok := fieldsetTypes[typeName]
+		if !ok || !strings.HasSuffix(key, "]") {
+			v.AddError(key, "unknown resource type")
+			continue
+		}
+
+		permitted := jsonFields(t)
+		fields := []string{}
+
+		for _, field := range app.readCSV(qs, key, []string{}) {
+			field = strings.TrimSpace(field)
+			if !validator.PermittedValue(field, permitted...) {
+				v.AddError(key, "invalid field "+field)
+				continue
+			}
+			fields = append(fields, field)
+		}
+
+		sets[typeName] = fields
+	}
+
+	return sets
+}
+
+// jsonFields returns the JSON keys a struct type encodes to.
+func jsonFields(t reflect.Type) []string {
+	fields := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+
+	return fields
+}
+
+// sparse encodes v as a JSON object keeping only the requested fields, plus id.
+// A nil fields slice keeps every field.
+func sparse(v any, fields []string) (map[string]any, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+
+	// decode numbers as json.Number so large IDs survive the round trip
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	err = dec.Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	if fields != nil {
+		for key := range m {
+			if key != "id" && !slices.Contains(fields, key) {
+				delete(m, key)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// sparseTrip renders a trip with only the requested relationships and fields.
+func sparseTrip(trip *data.Trip, inc includes, sets fieldsets) (map[string]any, error) {
+	m, err := sparse(trip, sets["trip"])
+	if err != nil {
+		return nil, err
+	}
+
+	delete(m, "activities")
+	delete(m, "segments")
+	delete(m, "stays")
+	delete(m, "tripgoers")
+
+	if inc["activities"] {
+		activities := []map[string]any{}
+
+		for _, activity := range trip.Activities {
+			am, err := sparse(activity, sets["activity"])
+			if err != nil {
+				return nil, err
+			}
+
+			delete(am, "locations")
+
+			if inc["activities.locations"] {
+				locations := []map[string]any{}
+
+				for _, location := range activity.Locations {
+					lm, err := sparse(location, sets["location"])
+					if err != nil {
+						return nil, err
+					}
+					locations = append(locations, lm)
+				}
+
+				am["locations"] = locations
+			}
+
+			activities = append(activities, am)
+		}
+
+		m["activities"] = activities
+	}
+
+	if inc["segments"] {
+		segments := []map[string]any{}
+
+		for _, segment := range trip.Segments {
+			sm, err := sparse(segment, sets["segment"])
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, sm)
+		}
+
+		m["segments"] = segments
+	}
+
+	if inc["stays"] {
+		stays := []map[string]any{}
+
+		for _, stay := range trip.Stays {
+			sm, err := sparse(stay, sets["stay"])
+			if err != nil {
+				return nil, err
+			}
+			stays = append(stays, sm)
+		}
+
+		m["stays"] = stays
+	}
+
+	if inc["tripgoers"] {
+		users := []map[string]any{}
+
+		for _, user := range trip.TripGoers {
+			um, err := sparse(user, sets["tripgoer"])
+			if err != nil {
+				return nil, err
+			}
+			users = append(users, um)
+		}
+
+		m["tripgoers"] = users
+	}
+
+	return m, nil
+}
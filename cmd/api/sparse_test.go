@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestTripFieldsetsAndIncludes(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	user, err := app.models.Users.GetByEmail(context.Background(), demoEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		keys  []string
+	}{
+		{"", []string{"activities", "created_by", "end_date", "google_place_id", "id", "lat", "lng", "name", "segments", "start_date", "state_code", "stays", "city", "tripgoers", "version"}},
+		{"fields[trip]=name", []string{"id", "name"}},
+		{"fields[trip]=name,stays", []string{"id", "name", "stays"}},
+		{"include=stays&fields[trip]=name", []string{"id", "name", "stays"}},
+		{"include=activities.locations&fields[trip]=name", []string{"activities", "id", "name"}},
+		{"include=&fields[trip]=name,stays", []string{"id", "name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			params := httprouter.Params{{Key: "id", Value: "1"}}
+			r = app.contextSetUser(r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)), user)
+
+			w := httptest.NewRecorder()
+			app.showTripHandler(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body)
+			}
+
+			var env struct {
+				Trip map[string]json.RawMessage `json:"trip"`
+			}
+
+			err := json.Unmarshal(w.Body.Bytes(), &env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := []string{}
+			for key := range env.Trip {
+				keys = append(keys, key)
+			}
+
+			slices.Sort(keys)
+			slices.Sort(tt.keys)
+
+			if !slices.Equal(keys, tt.keys) {
+				t.Errorf("got keys %q, want %q", keys, tt.keys)
+			}
+		})
+	}
+}
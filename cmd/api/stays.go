@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	data "github.com/rytwalker/kagubird-api/internal/data"
@@ -11,16 +13,18 @@ import (
 
 func (app *application) createStayHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name      string    `json:"name"`
-		Address   string    `json:"address"`
-		Lat       float64   `json:"lat"`
-		Lng       float64   `json:"lng"`
-		StartTime time.Time `json:"start_time"`
-		EndTime   time.Time `json:"end_time"`
-		Link      string    `json:"link"`
-		Phone     string    `json:"phone"`
-		Type      string    `json:"type"`
-		TripID    int64     `json:"trip"`
+		Name      string        `json:"name"`
+		Address   string        `json:"address"`
+		Lat       float64       `json:"lat"`
+		Lng       float64       `json:"lng"`
+		StartTime time.Time     `json:"start_time"`
+		EndTime   time.Time     `json:"end_time"`
+		Link      string        `json:"link"`
+		Phone     string        `json:"phone"`
+		Type      string        `json:"type"`
+		Tags      []string      `json:"tags"`
+		Booking   *bookingInput `json:"booking"`
+		TripID    int64         `json:"trip"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -38,27 +42,453 @@ func (app *application) createStayHandler(w http.ResponseWriter, r *http.Request
 		EndTime:   input.EndTime,
 		Link:      input.Link,
 		Phone:     input.Phone,
-		Type:      input.Type,
+		Type:      strings.ToLower(input.Type),
+		Tags:      data.NormalizeTags(input.Tags),
+		Booking:   data.Booking{Status: data.BookingStatusIdea},
 		TripID:    input.TripID,
 	}
 
+	if input.Booking != nil {
+		input.Booking.apply(&stay.Booking)
+	}
+
 	v := validator.New()
-	if data.ValidateStay(v, stay); !v.Valid() {
+
+	geocoded, err := app.geocodeAddress(r.Context(), v, stay.Address, &stay.Lat, &stay.Lng)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateStay(v, stay)
+
+	if stay.TripID != 0 {
+		trip, err := app.models.Trips.Get(r.Context(), stay.TripID)
+		switch {
+		case err == nil:
+			data.ValidateStayInTrip(v, stay, trip)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("trip", "must be an existing trip")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Stays.Insert(stay)
+	err = app.models.Stays.Insert(r.Context(), stay)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/stay/%d", stay.ID))
+	headers.Set("Location", fmt.Sprintf("/v1/stays/%d", stay.ID))
+
+	env := envelope{"stay": stay}
+	if geocoded != nil {
+		env["geocode"] = geocoded
+	}
 
 	// write a json response with a 201 created status code
-	err = app.writeJSON(w, http.StatusCreated, envelope{"stay": stay}, headers)
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// stayInput is a partial update of a stay. Fields left out of the request are
+// nil and keep their current value.
+type stayInput struct {
+	Name      *string       `json:"name"`
+	Address   *string       `json:"address"`
+	Lat       *float64      `json:"lat"`
+	Lng       *float64      `json:"lng"`
+	StartTime *time.Time    `json:"start_time"`
+	EndTime   *time.Time    `json:"end_time"`
+	Link      *string       `json:"link"`
+	Phone     *string       `json:"phone"`
+	Type      *string       `json:"type"`
+	Tags      []string      `json:"tags"`
+	Booking   *bookingInput `json:"booking"`
+}
+
+func (input stayInput) apply(stay *data.Stay) {
+	if input.Name != nil {
+		stay.Name = *input.Name
+	}
+	if input.Address != nil {
+		stay.Address = *input.Address
+	}
+	if input.Lat != nil {
+		stay.Lat = *input.Lat
+	}
+	if input.Lng != nil {
+		stay.Lng = *input.Lng
+	}
+	if input.StartTime != nil {
+		stay.StartTime = *input.StartTime
+	}
+	if input.EndTime != nil {
+		stay.EndTime = *input.EndTime
+	}
+	if input.Link != nil {
+		stay.Link = *input.Link
+	}
+	if input.Phone != nil {
+		stay.Phone = *input.Phone
+	}
+	if input.Type != nil {
+		stay.Type = strings.ToLower(*input.Type)
+	}
+	if input.Tags != nil {
+		stay.Tags = data.NormalizeTags(input.Tags)
+	}
+	if input.Booking != nil {
+		input.Booking.apply(&stay.Booking)
+	}
+}
+
+// batchStaysHandler creates and updates many of a trip's stays in one request.
+// Items with an id update that stay, the rest are created. Every item is
+// validated before anything is written and the whole batch is saved in a
+// single transaction. An item whose version isn't the stay's current one is
+// reported at its index like any other error.
+func (app *application) batchStaysHandler(w http.ResponseWriter, r *http.Request) {
+	tripID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	trip, err := app.models.Trips.Get(r.Context(), tripID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Stays []struct {
+			ID      *int64 `json:"id"`
+			Version *int32 `json:"version"`
+			stayInput
+		} `json:"stays"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateBatchSize(v, "stays", len(input.Stays)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	existing, err := app.models.Stays.GetAllByTrip(r.Context(), trip.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	byID := make(map[int64]*data.Stay, len(existing))
+	for _, stay := range existing {
+		stay.TripID = trip.ID
+		byID[stay.ID] = stay
+	}
+
+	stays := make([]*data.Stay, len(input.Stays))
+	failed := make(map[int]map[string]string)
+	seen := make(map[int64]bool)
+
+	for i, item := range input.Stays {
+		v := validator.New()
+
+		stay := &data.Stay{TripID: trip.ID, Tags: []string{}, Booking: data.Booking{Status: data.BookingStatusIdea}}
+
+		if item.ID != nil {
+			stay = byID[*item.ID]
+			if stay == nil {
+				v.AddError("id", "must be a stay on this trip")
+				failed[i] = v.Errors
+				continue
+			}
+
+			v.Check(!seen[*item.ID], "id", "must not appear more than once in a batch")
+			seen[*item.ID] = true
+
+			if item.Version != nil {
+				v.Check(*item.Version == stay.Version, "version", fmt.Sprintf("must be the current version, %d", stay.Version))
+			}
+		}
+
+		item.apply(stay)
+
+		data.ValidateStay(v, stay)
+		if data.ValidateStayInTrip(v, stay, trip); !v.Valid() {
+			failed[i] = v.Errors
+		}
+
+		stays[i] = stay
+	}
+
+	if len(failed) > 0 {
+		app.failedBatchValidationResponse(w, r, failed)
+		return
+	}
+
+	err = app.models.Stays.SaveBatch(r.Context(), stays)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stays": stays}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showStayHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	stay, err := app.models.Stays.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	etag := versionETag(stay.Version)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stay": stay}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateStayHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	stay, err := app.models.Stays.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, stay.Version) {
+		return
+	}
+
+	oldAddress, oldLat, oldLng := stay.Address, stay.Lat, stay.Lng
+
+	if patchMediaType(r) != "" {
+		var patched data.Stay
+
+		err = app.readPatch(w, r, stay, &patched)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
+
+		if patched.Version != stay.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+
+		if field := stayReadOnlyChange(stay, &patched); field != "" {
+			app.failedValidationResponse(w, r, map[string]string{field: "is read-only"})
+			return
+		}
+
+		stay.Name = patched.Name
+		stay.Address = patched.Address
+		stay.Lat = patched.Lat
+		stay.Lng = patched.Lng
+		stay.StartTime = patched.StartTime
+		stay.EndTime = patched.EndTime
+		stay.Link = patched.Link
+		stay.Phone = patched.Phone
+		stay.Type = strings.ToLower(patched.Type)
+		stay.Tags = data.NormalizeTags(patched.Tags)
+		stay.Booking = patched.Booking
+	} else {
+		var input stayInput
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		input.apply(stay)
+	}
+
+	trip, err := app.models.Trips.Get(r.Context(), stay.TripID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	geocoded, err := app.geocodeChangedAddress(r.Context(), v, oldAddress, oldLat, oldLng, stay.Address, &stay.Lat, &stay.Lng)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateStay(v, stay)
+	if data.ValidateStayInTrip(v, stay, trip); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Stays.Update(r.Context(), stay)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(stay.Version))
+
+	env := envelope{"stay": stay}
+	if geocoded != nil {
+		env["geocode"] = geocoded
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// stayReadOnlyChange returns the name of the first field a patch changed that
+// clients aren't allowed to set, or an empty string if there isn't one.
+func stayReadOnlyChange(stay, patched *data.Stay) string {
+	switch {
+	case patched.ID != stay.ID:
+		return "id"
+	case patched.TripID != stay.TripID:
+		return "trip"
+	default:
+		return ""
+	}
+}
+
+func (app *application) deleteStayHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	stay, err := app.models.Stays.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, stay.Version) {
+		return
+	}
+
+	err = app.models.Stays.DeleteVersion(r.Context(), stay.ID, stay.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "stay successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listStaysHandler(w http.ResponseWriter, r *http.Request) {
+	tripID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters = app.readFilters(qs, data.StayListing, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stays, metadata, err := app.models.Stays.GetAll(r.Context(), tripID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stays": stays, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
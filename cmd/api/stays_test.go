@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStayCRUD(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	trip, err := app.models.Trips.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkIn := trip.StartDate.Add(15 * time.Hour).Format(time.RFC3339)
+	checkOut := trip.EndDate.Add(11 * time.Hour).Format(time.RFC3339)
+	stay := func(stayType, start, end string) string {
+		return fmt.Sprintf(`{"name": "Freehand", "address": "19 E Ohio St, Chicago", "lat": 41.8925, "lng": -87.6267, "type": %q, "start_time": %q, "end_time": %q, "link": "https://freehandhotels.com", "trip": 1}`, stayType, start, end)
+	}
+
+	invalid := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"retired type", stay("other", checkIn, checkOut), "type"},
+		{"unknown type", stay("treehouse", checkIn, checkOut), "type"},
+		{"check-in before the trip", stay("hostel", trip.StartDate.AddDate(0, 0, -1).Format(time.RFC3339), checkOut), "start_time"},
+		{"check-out after the trip", stay("hostel", checkIn, trip.EndDate.AddDate(0, 0, 1).Format(time.RFC3339)), "end_time"},
+		{"link", `{"name": "Freehand", "address": "19 E Ohio St", "lat": 41.8925, "lng": -87.6267, "type": "hostel", "start_time": "` + checkIn + `", "end_time": "` + checkOut + `", "link": "javascript:alert(1)", "trip": 1}`, "link"},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			status, env := app.serveTest(t, app.createStayHandler, http.MethodPost, "", tt.body)
+			if status != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want %d", status, http.StatusUnprocessableEntity)
+			}
+
+			var errs map[string]string
+
+			err := json.Unmarshal(env["error"], &errs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if errs[tt.field] == "" {
+				t.Errorf("got %v, want an error for %s", errs, tt.field)
+			}
+		})
+	}
+
+	var created struct {
+		ID      int64  `json:"id"`
+		Type    string `json:"type"`
+		Version int32  `json:"version"`
+	}
+
+	status, env := app.serveTest(t, app.createStayHandler, http.MethodPost, "", stay("Hostel", checkIn, checkOut))
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d creating the stay, want %d: %s", status, http.StatusCreated, env["error"])
+	}
+
+	err = json.Unmarshal(env["stay"], &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Type != "hostel" {
+		t.Errorf("got type %q, want hostel", created.Type)
+	}
+
+	id := strconv.FormatInt(created.ID, 10)
+
+	status, _ = app.serveTest(t, app.updateStayHandler, http.MethodPatch, id, `{"type": "other"}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d updating to a retired type, want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	status, env = app.serveTest(t, app.updateStayHandler, http.MethodPatch, id, `{"phone": "312-555-0100"}`)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d updating the stay, want %d: %s", status, http.StatusOK, env["error"])
+	}
+
+	var updated struct {
+		Phone   string `json:"phone"`
+		Version int32  `json:"version"`
+	}
+
+	err = json.Unmarshal(env["stay"], &updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Phone != "312-555-0100" || updated.Version != created.Version+1 {
+		t.Errorf("got %+v after the update", updated)
+	}
+
+	status, _ = app.serveTest(t, app.showStayHandler, http.MethodGet, id, "")
+	if status != http.StatusOK {
+		t.Errorf("got status %d showing the stay, want %d", status, http.StatusOK)
+	}
+
+	status, _ = app.serveTest(t, app.deleteStayHandler, http.MethodDelete, id, "")
+	if status != http.StatusOK {
+		t.Fatalf("got status %d deleting the stay, want %d", status, http.StatusOK)
+	}
+
+	status, _ = app.serveTest(t, app.showStayHandler, http.MethodGet, id, "")
+	if status != http.StatusNotFound {
+		t.Errorf("got status %d showing the deleted stay, want %d", status, http.StatusNotFound)
+	}
+}
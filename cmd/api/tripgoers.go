@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/validator"
 )
 
 func (app *application) addTripGoer(w http.ResponseWriter, r *http.Request) {
@@ -20,7 +21,7 @@ func (app *application) addTripGoer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// look up email
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -36,7 +37,7 @@ func (app *application) addTripGoer(w http.ResponseWriter, r *http.Request) {
 		TripID: input.TripID,
 	}
 
-	err = app.models.TripGoers.Insert(user.ID, input.TripID)
+	err = app.models.TripGoers.Insert(r.Context(), user.ID, input.TripID)
 	if err != nil {
 		return
 	}
@@ -46,3 +47,36 @@ func (app *application) addTripGoer(w http.ResponseWriter, r *http.Request) {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) listTripGoersHandler(w http.ResponseWriter, r *http.Request) {
+	tripID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters = app.readFilters(qs, data.TripGoerListing, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.TripGoers.GetAll(r.Context(), tripID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tripgoers": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/rytwalker/kagubird-api/internal/data"
@@ -48,7 +49,7 @@ func (app *application) createTripHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Trips.Insert(trip)
+	err = app.models.Trips.Insert(r.Context(), trip)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -71,7 +72,22 @@ func (app *application) showTripHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	trip, err := app.models.Trips.Get(id)
+	v := validator.New()
+	qs := r.URL.Query()
+
+	inc := app.readIncludes(qs, tripIncludes, v)
+	sets := app.readFieldsets(qs, v)
+
+	if fields, ok := sets["trip"]; ok && !qs.Has("include") {
+		inc.restrict(fields)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	trip, err := app.models.Trips.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -82,44 +98,102 @@ func (app *application) showTripHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	activities, err := app.models.Activities.GetAllByTrip(trip.ID)
+	if !app.loadTripIncludes(w, r, trip, inc, qs.Has("include")) {
+		return
+	}
+
+	etag := tripETag(trip, r.URL.RawQuery)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	body, err := sparseTrip(trip, inc, sets)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	for _, activity := range activities {
-		locations, err := app.models.Locations.GetAllByActivity(activity.ID)
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"trip": body}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loadTripIncludes loads the relationships in inc into trip. explicit is
+// whether the client named them, rather than getting the default graph. It
+// sends an error response and returns false if they can't be loaded.
+func (app *application) loadTripIncludes(w http.ResponseWriter, r *http.Request, trip *data.Trip, inc includes, explicit bool) bool {
+	if inc["activities"] {
+		activities, err := app.models.Activities.GetAllByTrip(r.Context(), trip.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
-			return
+			return false
+		}
+
+		if inc["activities.locations"] {
+			err = app.models.Locations.LoadForActivities(r.Context(), activities)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return false
+			}
 		}
 
-		activity.Locations = locations
+		trip.Activities = activities
 	}
 
-	trip.Activities = activities
+	// segments hold confirmation codes and seats, so unlike the rest of the trip
+	// they're only for the people on it; they're left out of the default graph
+	// for anyone else, and asking for them explicitly is refused
+	if inc["segments"] {
+		member, err := app.models.TripGoers.IsMember(r.Context(), app.contextGetUser(r).ID, trip.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
 
-	stays, err := app.models.Stays.GetAllByTrip(trip.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		if !member {
+			if explicit {
+				app.notPermittedResponse(w, r)
+				return false
+			}
+			delete(inc, "segments")
+		}
 	}
 
-	trip.Stays = stays
+	if inc["segments"] {
+		segments, err := app.models.Segments.GetAllByTrip(r.Context(), trip.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
 
-	users, err := app.models.Users.GetAllByTrip(trip.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+		trip.Segments = segments
 	}
 
-	trip.TripGoers = users
+	if inc["stays"] {
+		stays, err := app.models.Stays.GetAllByTrip(r.Context(), trip.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"trip": trip}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		trip.Stays = stays
+	}
+
+	if inc["tripgoers"] {
+		users, err := app.models.Users.GetAllByTrip(r.Context(), trip.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+
+		trip.TripGoers = users
 	}
+
+	return true
 }
 
 func (app *application) updateTripHandler(w http.ResponseWriter, r *http.Request) {
@@ -129,7 +203,7 @@ func (app *application) updateTripHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	trip, err := app.models.Trips.Get(id)
+	trip, err := app.models.Trips.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -140,48 +214,83 @@ func (app *application) updateTripHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var input struct {
-		Name          *string    `json:"name"`
-		City          *string    `json:"city"`
-		StateCode     *string    `json:"state_code"`
-		GooglePlaceID *string    `json:"google_place_id"`
-		Lat           *float64   `json:"lat"`
-		Lng           *float64   `json:"lng"`
-		StartDate     *time.Time `json:"start_date"`
-		EndDate       *time.Time `json:"end_date"`
-	}
-
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	if !app.checkIfMatch(w, r, trip.Version) {
 		return
 	}
 
-	if input.Name != nil {
-		trip.Name = *input.Name
-	}
+	if patchMediaType(r) != "" {
+		// the patched document replaces every editable field, so members a merge
+		// patch sets to null or a JSON patch removes end up cleared
+		var patched data.Trip
 
-	if input.City != nil {
-		trip.City = *input.City
-	}
+		err = app.readPatch(w, r, trip, &patched)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
 
-	if input.StateCode != nil {
-		trip.StateCode = *input.StateCode
-	}
-	if input.GooglePlaceID != nil {
-		trip.GooglePlaceID = *input.GooglePlaceID
-	}
-	if input.Lat != nil {
-		trip.Lat = *input.Lat
-	}
-	if input.Lng != nil {
-		trip.Lng = *input.Lng
-	}
-	if input.StartDate != nil {
-		trip.StartDate = *input.StartDate
-	}
-	if input.EndDate != nil {
-		trip.EndDate = *input.EndDate
+		if patched.Version != trip.Version {
+			app.editConflictResponse(w, r)
+			return
+		}
+
+		if field := tripReadOnlyChange(trip, &patched); field != "" {
+			app.failedValidationResponse(w, r, map[string]string{field: "is read-only"})
+			return
+		}
+
+		trip.Name = patched.Name
+		trip.City = patched.City
+		trip.StateCode = patched.StateCode
+		trip.GooglePlaceID = patched.GooglePlaceID
+		trip.Lat = patched.Lat
+		trip.Lng = patched.Lng
+		trip.StartDate = patched.StartDate
+		trip.EndDate = patched.EndDate
+	} else {
+		var input struct {
+			Name          *string    `json:"name"`
+			City          *string    `json:"city"`
+			StateCode     *string    `json:"state_code"`
+			GooglePlaceID *string    `json:"google_place_id"`
+			Lat           *float64   `json:"lat"`
+			Lng           *float64   `json:"lng"`
+			StartDate     *time.Time `json:"start_date"`
+			EndDate       *time.Time `json:"end_date"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Name != nil {
+			trip.Name = *input.Name
+		}
+
+		if input.City != nil {
+			trip.City = *input.City
+		}
+
+		if input.StateCode != nil {
+			trip.StateCode = *input.StateCode
+		}
+		if input.GooglePlaceID != nil {
+			trip.GooglePlaceID = *input.GooglePlaceID
+		}
+		if input.Lat != nil {
+			trip.Lat = *input.Lat
+		}
+		if input.Lng != nil {
+			trip.Lng = *input.Lng
+		}
+		if input.StartDate != nil {
+			trip.StartDate = *input.StartDate
+		}
+		if input.EndDate != nil {
+			trip.EndDate = *input.EndDate
+		}
 	}
 
 	v := validator.New()
@@ -191,7 +300,7 @@ func (app *application) updateTripHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Trips.Update(trip)
+	err = app.models.Trips.Update(r.Context(), trip)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -202,7 +311,24 @@ func (app *application) updateTripHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"trip": trip}, nil)
+	// respond with the trip as GET /v1/trips/:id has it, so the ETag can be used
+	// with If-None-Match there
+	inc := app.readIncludes(url.Values{}, tripIncludes, v)
+
+	if !app.loadTripIncludes(w, r, trip, inc, false) {
+		return
+	}
+
+	body, err := sparseTrip(trip, inc, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", tripETag(trip, ""))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"trip": body}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -215,18 +341,38 @@ func (app *application) deleteTripHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Trips.Delete(id)
+	// the trip stays locked from the If-Match check until it's deleted, so the
+	// version the client matched is the one that goes
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		trip, err := tx.Trips.GetForUpdate(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		err = app.ifMatch(r, trip.Version)
+		if err != nil {
+			return err
+		}
+
+		return tx.Trips.DeleteVersion(r.Context(), trip.ID, trip.Version)
+	})
 	if err != nil {
 		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		case errors.Is(err, errPreconditionFailed):
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "trip successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -247,17 +393,14 @@ func (app *application) listTripsHandler(w http.ResponseWriter, r *http.Request)
 	input.StartDate = app.readString(qs, "start_date", "")
 	input.EndDate = app.readString(qs, "end_date", "")
 
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "start_date", "-id", "-name", "-start_date"}
+	input.Filters = app.readFilters(qs, data.TripListing, v)
 
-	if !v.Valid() {
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	trips, metadata, err := app.models.Trips.GetAll(input.Name, input.StartDate, input.EndDate, input.Filters)
+	trips, metadata, err := app.models.Trips.GetAll(r.Context(), input.Name, input.StartDate, input.EndDate, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -268,3 +411,64 @@ func (app *application) listTripsHandler(w http.ResponseWriter, r *http.Request)
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// tripReadOnlyChange returns the name of the first field a patch changed that
+// clients aren't allowed to set, or an empty string if there isn't one.
+func tripReadOnlyChange(trip, patched *data.Trip) string {
+	switch {
+	case patched.ID != trip.ID:
+		return "id"
+	case patched.CreatedBy != trip.CreatedBy:
+		return "created_by"
+	case patched.Activities != nil:
+		return "activities"
+	case patched.Stays != nil:
+		return "stays"
+	case patched.TripGoers != nil:
+		return "tripgoers"
+	default:
+		return ""
+	}
+}
+
+// tripFromParam loads the trip named by the :id parameter, sending a 404 or
+// server error and returning false if it can't.
+func (app *application) tripFromParam(w http.ResponseWriter, r *http.Request) (*data.Trip, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	trip, err := app.models.Trips.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return trip, true
+}
+
+// checkTripMember sends a 403 and returns false unless the user created or is
+// going on the trip.
+func (app *application) checkTripMember(w http.ResponseWriter, r *http.Request, tripID int64) bool {
+	user := app.contextGetUser(r)
+
+	member, err := app.models.TripGoers.IsMember(r.Context(), user.ID, tripID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !member {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+func TestDeleteTripIfMatch(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	user, err := app.models.Users.GetByEmail(context.Background(), demoEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trip := insertSoloTrip(t, app)
+	etag := `"v` + strconv.Itoa(int(trip.Version)) + `"`
+
+	del := func(ifMatch string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/", nil)
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+
+		params := httprouter.Params{{Key: "id", Value: strconv.FormatInt(trip.ID, 10)}}
+		r = app.contextSetUser(r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)), user)
+
+		w := httptest.NewRecorder()
+		app.deleteTripHandler(w, r)
+		return w.Code
+	}
+
+	app.config.concurrency.requireIfMatch = true
+	if code := del(""); code != http.StatusPreconditionRequired {
+		t.Errorf("got status %d without If-Match, want %d", code, http.StatusPreconditionRequired)
+	}
+
+	if code := del(`"v999"`); code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d for a stale If-Match, want %d", code, http.StatusPreconditionFailed)
+	}
+
+	_, err = app.models.Trips.Get(context.Background(), trip.ID)
+	if err != nil {
+		t.Fatalf("trip is gone after the failed preconditions: %v", err)
+	}
+
+	if code := del(etag); code != http.StatusOK {
+		t.Fatalf("got status %d for If-Match %s, want %d", code, etag, http.StatusOK)
+	}
+
+	_, err = app.models.Trips.Get(context.Background(), trip.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got %v getting the deleted trip, want %v", err, data.ErrRecordNotFound)
+	}
+
+	if code := del(etag); code != http.StatusNotFound {
+		t.Errorf("got status %d deleting it again, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,216 @@
+// Command kaguctl is the operator's tool for support requests that would
+// otherwise need raw SQL: finding users, (de)activating accounts, granting
+// permissions, issuing and revoking tokens, resetting passwords and moving
+// trips between users. It works on the database directly through
+// internal/data, so the API doesn't need to be running.
+//
+// Usage:
+//
+//	kaguctl [-db-dsn=...] [-json] <group> <command> [flags] [args]
+//
+// Flags for a command come before its arguments. Run kaguctl with no
+// arguments for the list of commands.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(ctl *ctl, args []string) error
+}
+
+var commands = []command{
+	{"users list", "[-email s] [-name s] [-activated bool] [-sort s] [-page n] [-page-size n]", "list users, optionally filtered", usersList},
+	{"users find", "<user>", "show a user and their permissions", usersFind},
+	{"users activate", "<user>", "activate an account", usersActivate},
+	{"users deactivate", "<user>", "deactivate an account and sign it out", usersDeactivate},
+	{"users reset-password", "[-password p] <user>", "set a new password, random if not given, and sign the user out", usersResetPassword},
+	{"permissions list", "<user>", "list a user's permission codes", permissionsList},
+	{"permissions grant", "<user> <code>...", "grant permission codes", permissionsGrant},
+	{"permissions revoke", "<user> <code>...", "revoke permission codes", permissionsRevoke},
+	{"tokens issue", "[-scope s] [-ttl d] <user>", "issue a token, authentication by default", tokensIssue},
+	{"tokens revoke", "[-scope s] <user>", "revoke a user's tokens in one scope, or all", tokensRevoke},
+	{"trips transfer", "[-keep-access=bool] <trip id> <user>", "make another user the trip's creator", tripsTransfer},
+}
+
+// ctl is what every command runs with. A <user> argument is either an ID or an
+// email address.
+type ctl struct {
+	ctx    context.Context
+	models data.Models
+	out    io.Writer
+	json   bool
+}
+
+func main() {
+	dsn := flag.String("db-dsn", os.Getenv("KAGUBIRD_DB_DSN"), "PostgreSQL DSN (default $KAGUBIRD_DB_DSN)")
+	jsonOutput := flag.Bool("json", false, "Print JSON instead of tables")
+	timeout := flag.Duration("timeout", 30*time.Second, "Time limit for the whole command")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := lookup(flag.Arg(0), flag.Arg(1))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "kaguctl: unknown command %q\n\n", flag.Arg(0)+" "+flag.Arg(1))
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("postgres", *dsn)
+	if err != nil {
+		fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	ctl := &ctl{
+		ctx:    ctx,
+		models: data.NewModels(db, data.DefaultQueryTimeout),
+		out:    os.Stdout,
+		json:   *jsonOutput,
+	}
+
+	err = cmd.run(ctl, flag.Args()[2:])
+	if err != nil {
+		var usageErr usageError
+		if errors.As(err, &usageErr) {
+			fmt.Fprintf(os.Stderr, "kaguctl: %s\nusage: kaguctl %s %s\n", err, cmd.name, cmd.args)
+			os.Exit(2)
+		}
+
+		fatal(err)
+	}
+}
+
+// lookup finds the command for a group and command name, such as "users" and
+// "list".
+func lookup(group, name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == group+" "+name {
+			return c, true
+		}
+	}
+
+	return command{}, false
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: kaguctl [flags] <group> <command> [flags] [args]\n\nflags:\n")
+	flag.PrintDefaults()
+
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+
+	tw := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.usage)
+	}
+	tw.Flush()
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "kaguctl: %s\n", err)
+	os.Exit(1)
+}
+
+// usageError is a problem with how a command was called, as opposed to
+// something going wrong while running it.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// parse parses a command's flags and checks it got at least n arguments.
+func parse(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, usageError(err.Error())
+	}
+
+	if fs.NArg() < n {
+		return nil, usageError("missing arguments")
+	}
+
+	return fs.Args(), nil
+}
+
+// user looks a user up by ID or email address.
+func (ctl *ctl) user(ref string) (*data.User, error) {
+	return findUser(ctl.ctx, ctl.models, ref)
+}
+
+func findUser(ctx context.Context, models data.Models, ref string) (*data.User, error) {
+	var user *data.User
+	var err error
+
+	if id, parseErr := strconv.ParseInt(ref, 10, 64); parseErr == nil {
+		user, err = models.Users.Get(ctx, id)
+	} else {
+		user, err = models.Users.GetByEmail(ctx, ref)
+	}
+
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return nil, fmt.Errorf("no user %q", ref)
+	}
+
+	return user, err
+}
+
+// print writes v as indented JSON with -json, and otherwise as a table with
+// the given header and rows.
+func (ctl *ctl) print(v any, header []string, rows [][]string) error {
+	if ctl.json {
+		enc := json.NewEncoder(ctl.out)
+		enc.SetIndent("", "\t")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(ctl.out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// done reports a change that has nothing else to print.
+func (ctl *ctl) done(message string, v any) error {
+	if ctl.json {
+		return ctl.print(v, nil, nil)
+	}
+
+	_, err := fmt.Fprintln(ctl.out, message)
+	return err
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// newTestCtl returns a ctl backed by a memory store with two users, the
+// activated ops@kagubird.dev (ID 1) who has a trip and new@kagubird.dev (ID 2)
+// who hasn't activated their account, and the buffer it prints to.
+func newTestCtl(t *testing.T) (*ctl, *bytes.Buffer) {
+	t.Helper()
+
+	ctx := context.Background()
+	models := data.NewMemoryModels()
+
+	ops := &data.User{Name: "Ops", Email: "ops@kagubird.dev", Activated: true}
+	err := ops.Password.Set("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newcomer := &data.User{Name: "Newcomer", Email: "new@kagubird.dev", Password: ops.Password}
+
+	for _, user := range []*data.User{ops, newcomer} {
+		err = models.Users.Insert(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = models.Permissions.AddForUser(ctx, ops.ID, "trips:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 1, 0)
+
+	err = models.Trips.Insert(ctx, &data.Trip{
+		Name:          "Chicago",
+		City:          "Chicago",
+		StateCode:     "IL",
+		GooglePlaceID: "ChIJ7cv00DwsDogRAMDACa2m4K8",
+		Lat:           41.878114,
+		Lng:           -87.629798,
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 3),
+		CreatedBy:     ops.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+
+	return &ctl{ctx: ctx, models: models, out: &out}, &out
+}
+
+// run runs a command the way main does, returning what it printed.
+func run(t *testing.T, ctl *ctl, out *bytes.Buffer, command string, args ...string) (string, error) {
+	t.Helper()
+
+	group, name, _ := strings.Cut(command, " ")
+
+	c, ok := lookup(group, name)
+	if !ok {
+		t.Fatalf("no command %q", command)
+	}
+
+	out.Reset()
+	err := c.run(ctl, args)
+
+	return out.String(), err
+}
+
+func isUsageError(err error) bool {
+	var usageErr usageError
+	return errors.As(err, &usageErr)
+}
+
+func TestLookup(t *testing.T) {
+	for _, c := range commands {
+		group, name, _ := strings.Cut(c.name, " ")
+
+		found, ok := lookup(group, name)
+		if !ok || found.name != c.name {
+			t.Errorf("lookup(%q, %q) = %q, %t", group, name, found.name, ok)
+		}
+	}
+
+	for _, args := range [][2]string{{"users", "delete"}, {"user", "list"}, {"users list", ""}, {"", ""}} {
+		if _, ok := lookup(args[0], args[1]); ok {
+			t.Errorf("lookup(%q, %q) found a command", args[0], args[1])
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		n         int
+		want      []string
+		wantUsage bool
+	}{
+		{"flags then arguments", []string{"-scope", "all", "ops@kagubird.dev"}, 1, []string{"ops@kagubird.dev"}, false},
+		{"no flags", []string{"1", "2"}, 2, []string{"1", "2"}, false},
+		{"extra arguments", []string{"1", "2", "3"}, 2, []string{"1", "2", "3"}, false},
+		{"missing arguments", []string{"-scope", "all"}, 1, nil, true},
+		{"unknown flag", []string{"-force", "1"}, 1, nil, true},
+		{"flag without a value", []string{"-scope"}, 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.String("scope", "", "")
+
+			got, err := parse(fs, tt.args, tt.n)
+			if tt.wantUsage {
+				if !isUsageError(err) {
+					t.Errorf("got %q, %v, want a usage error", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got arguments %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsersList(t *testing.T) {
+	ctl, out := newTestCtl(t)
+
+	ops, err := ctl.models.Users.Get(ctl.ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newcomer, err := ctl.models.Users.Get(ctl.ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := run(t, ctl, out, "users list")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"ID  NAME      EMAIL             ACTIVATED  CREATED",
+		"1   Ops       ops@kagubird.dev  true       " + ops.CreatedAt.Format(time.RFC3339),
+		"2   Newcomer  new@kagubird.dev  false      " + newcomer.CreatedAt.Format(time.RFC3339),
+		"",
+		"page 1 of 1, 2 users",
+		"",
+	}, "\n")
+
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	tests := []struct {
+		args   []string
+		emails []string
+	}{
+		{[]string{"-email", "NEW"}, []string{"new@kagubird.dev"}},
+		{[]string{"-activated", "true"}, []string{"ops@kagubird.dev"}},
+		{[]string{"-sort", "-id"}, []string{"new@kagubird.dev", "ops@kagubird.dev"}},
+		{[]string{"-page-size", "1", "-page", "2"}, []string{"new@kagubird.dev"}},
+		{[]string{"-name", "nobody"}, []string{}},
+	}
+
+	ctl.json = true
+
+	for _, tt := range tests {
+		got, err := run(t, ctl, out, "users list", tt.args...)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.args, err)
+		}
+
+		var result struct {
+			Users    []data.User   `json:"users"`
+			Metadata data.Metadata `json:"metadata"`
+		}
+
+		err = json.Unmarshal([]byte(got), &result)
+		if err != nil {
+			t.Fatalf("%q: output isn't JSON: %s", tt.args, got)
+		}
+
+		emails := []string{}
+		for _, user := range result.Users {
+			emails = append(emails, user.Email)
+		}
+
+		if !slices.Equal(emails, tt.emails) {
+			t.Errorf("%q: got %q, want %q", tt.args, emails, tt.emails)
+		}
+	}
+
+	for _, args := range [][]string{{"-sort", "password"}, {"-page", "0"}, {"-activated", "maybe"}, {"-page", "x"}} {
+		_, err := run(t, ctl, out, "users list", args...)
+		if !isUsageError(err) {
+			t.Errorf("%q: got %v, want a usage error", args, err)
+		}
+	}
+}
+
+func TestUsersFind(t *testing.T) {
+	ctl, out := newTestCtl(t)
+
+	for _, ref := range []string{"1", "ops@kagubird.dev", "OPS@kagubird.dev"} {
+		got, err := run(t, ctl, out, "users find", ref)
+		if err != nil {
+			t.Fatalf("%s: %v", ref, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(got), "\n")
+		if len(lines) != 2 || !strings.HasSuffix(lines[0], "PERMISSIONS") || !strings.HasSuffix(lines[1], "trips:read") {
+			t.Errorf("%s: got\n%s", ref, got)
+		}
+	}
+
+	_, err := run(t, ctl, out, "users find", "nobody@kagubird.dev")
+	if err == nil || err.Error() != `no user "nobody@kagubird.dev"` {
+		t.Errorf("got %v for a user that doesn't exist", err)
+	}
+
+	_, err = run(t, ctl, out, "users find")
+	if !isUsageError(err) {
+		t.Errorf("got %v without a user, want a usage error", err)
+	}
+}
+
+// Deactivating an account signs it out as well.
+func TestUsersActivation(t *testing.T) {
+	ctl, out := newTestCtl(t)
+
+	token, err := ctl.models.Tokens.New(ctl.ctx, 1, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := run(t, ctl, out, "users deactivate", "ops@kagubird.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "ops@kagubird.dev is now activated: false\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	user, err := ctl.models.Users.Get(ctl.ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Activated {
+		t.Error("the user is still activated")
+	}
+
+	_, err = ctl.models.Users.GetForToken(ctl.ctx, data.ScopeAuthentication, token.Plaintext)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got %v looking up the user's token, want %v", err, data.ErrRecordNotFound)
+	}
+
+	ctl.json = true
+
+	got, err = run(t, ctl, out, "users activate", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		User data.User `json:"user"`
+	}
+
+	err = json.Unmarshal([]byte(got), &result)
+	if err != nil || !result.User.Activated || result.User.Email != "new@kagubird.dev" {
+		t.Errorf("got %s (%v), want the activated user", got, err)
+	}
+}
+
+func TestUsersResetPassword(t *testing.T) {
+	ctl, out := newTestCtl(t)
+
+	got, err := run(t, ctl, out, "users reset-password", "-password", "correct horse", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "password reset for ops@kagubird.dev\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	user, err := ctl.models.Users.Get(ctl.ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := user.Password.Matches("correct horse"); !ok {
+		t.Error("the new password doesn't match")
+	}
+
+	ctl.json = true
+
+	got, err = run(t, ctl, out, "users reset-password", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Password string `json:"password"`
+	}
+
+	err = json.Unmarshal([]byte(got), &result)
+	if err != nil || result.Password == "" {
+		t.Fatalf("got %s (%v), want the generated password", got, err)
+	}
+
+	user, err = ctl.models.Users.Get(ctl.ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := user.Password.Matches(result.Password); !ok {
+		t.Error("the generated password doesn't match")
+	}
+
+	_, err = run(t, ctl, out, "users reset-password", "-password", "short", "1")
+	if !isUsageError(err) {
+		t.Errorf("got %v for a short password, want a usage error", err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	ctl, out := newTestCtl(t)
+
+	steps := []struct {
+		command string
+		args    []string
+		want    string
+	}{
+		{"permissions list", []string{"1"}, "PERMISSION\ntrips:read\n"},
+		{"permissions grant", []string{"1", "trips:write", "trips:read"}, "PERMISSION\ntrips:read\ntrips:write\n"},
+		{"permissions grant", []string{"1", "trips:write"}, "PERMISSION\ntrips:read\ntrips:write\n"},
+		{"permissions revoke", []string{"ops@kagubird.dev", "trips:read"}, "PERMISSION\ntrips:write\n"},
+		{"permissions revoke", []string{"1", "trips:read"}, "PERMISSION\ntrips:write\n"},
+		{"permissions list", []string{"2"}, "PERMISSION\n"},
+	}
+
+	for _, step := range steps {
+		got, err := run(t, ctl, out, step.command, step.args...)
+		if err != nil {
+			t.Fatalf("%s %q: %v", step.command, step.args, err)
+		}
+
+		if got != step.want {
+			t.Errorf("%s %q: got %q, want %q", step.command, step.args, got, step.want)
+		}
+	}
+
+	_, err := run(t, ctl, out, "permissions grant", "1", "trips:admin")
+	if !isUsageError(err) {
+		t.Errorf("got %v granting an unknown permission, want a usage error", err)
+	}
+
+	ctl.json = true
+
+	got, err := run(t, ctl, out, "permissions list", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "{\n\t\"permissions\": [\n\t\t\"trips:write\"\n\t],\n\t\"user\": 1\n}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	ctl, out := newTestCtl(t)
+	ctl.json = true
+
+	got, err := run(t, ctl, out, "tokens issue", "-ttl", "2h", "ops@kagubird.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Token data.Token `json:"token"`
+		Scope string     `json:"scope"`
+	}
+
+	err = json.Unmarshal([]byte(got), &result)
+	if err != nil {
+		t.Fatalf("output isn't JSON: %s", got)
+	}
+
+	if result.Scope != data.ScopeAuthentication || time.Until(result.Token.Expiry) > 2*time.Hour || time.Until(result.Token.Expiry) < time.Hour {
+		t.Errorf("got %s, want an authentication token for two hours", got)
+	}
+
+	user, err := ctl.models.Users.GetForToken(ctl.ctx, data.ScopeAuthentication, result.Token.Plaintext)
+	if err != nil || user.ID != 1 {
+		t.Fatalf("got %v, %v for the issued token, want user 1", user, err)
+	}
+
+	ctl.json = false
+
+	got, err = run(t, ctl, out, "tokens revoke", "-scope", "all", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "revoked all tokens for ops@kagubird.dev\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	_, err = ctl.models.Users.GetForToken(ctl.ctx, data.ScopeAuthentication, result.Token.Plaintext)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got %v for a revoked token, want %v", err, data.ErrRecordNotFound)
+	}
+
+	for _, args := range [][]string{
+		{"tokens issue", "-scope", "password-reset", "1"},
+		{"tokens issue", "-ttl", "-1h", "1"},
+		{"tokens revoke", "-scope", "everything", "1"},
+	} {
+		_, err := run(t, ctl, out, args[0], args[1:]...)
+		if !isUsageError(err) {
+			t.Errorf("%q: got %v, want a usage error", args, err)
+		}
+	}
+}
+
+func TestTripsTransfer(t *testing.T) {
+	ctl, out := newTestCtl(t)
+
+	got, err := run(t, ctl, out, "trips transfer", "1", "new@kagubird.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "TRIP  NAME     FROM  TO\n1     Chicago  1     2\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	trip, err := ctl.models.Trips.Get(ctl.ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trip.CreatedBy != 2 {
+		t.Errorf("got creator %d, want 2", trip.CreatedBy)
+	}
+
+	goers, err := ctl.models.Users.GetAllByTrip(ctl.ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(goers) != 1 || goers[0].ID != 1 {
+		t.Errorf("got tripgoers %v, want the previous creator", goers)
+	}
+
+	_, err = run(t, ctl, out, "trips transfer", "-keep-access=false", "1", "2")
+	if err == nil || err.Error() != "trip 1 already belongs to new@kagubird.dev" {
+		t.Errorf("got %v transferring to the creator", err)
+	}
+
+	_, err = run(t, ctl, out, "trips transfer", "-keep-access=false", "1", "ops@kagubird.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goers, err = ctl.models.Users.GetAllByTrip(ctl.ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(goers) != 1 || goers[0].ID != 1 {
+		t.Errorf("got tripgoers %v, want them left alone", goers)
+	}
+
+	_, err = run(t, ctl, out, "trips transfer", "9", "1")
+	if err == nil || err.Error() != "no trip 9" {
+		t.Errorf("got %v for a trip that doesn't exist", err)
+	}
+
+	_, err = run(t, ctl, out, "trips transfer", "first", "1")
+	if !isUsageError(err) {
+		t.Errorf("got %v for an invalid trip ID, want a usage error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+func permissionsList(ctl *ctl, args []string) error {
+	args, err := parse(flag.NewFlagSet("permissions list", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := ctl.user(args[0])
+	if err != nil {
+		return err
+	}
+
+	return printPermissions(ctl, user)
+}
+
+func permissionsGrant(ctl *ctl, args []string) error {
+	return changePermissions(ctl, "permissions grant", args, data.PermissionRepository.AddForUser)
+}
+
+func permissionsRevoke(ctl *ctl, args []string) error {
+	return changePermissions(ctl, "permissions revoke", args, data.PermissionRepository.RemoveForUser)
+}
+
+type permissionChange func(m data.PermissionRepository, ctx context.Context, userID int64, codes ...string) error
+
+// changePermissions grants or revokes codes. Codes the user already has, or
+// doesn't have, are skipped, so running the same command twice is harmless.
+func changePermissions(ctl *ctl, name string, args []string, change permissionChange) error {
+	args, err := parse(flag.NewFlagSet(name, flag.ContinueOnError), args, 2)
+	if err != nil {
+		return err
+	}
+
+	codes := args[1:]
+	for _, code := range codes {
+		if !slices.Contains(data.PermissionCodes, code) {
+			return usageError(fmt.Sprintf("unknown permission %q, must be one of %s", code, strings.Join(data.PermissionCodes, ", ")))
+		}
+	}
+
+	var user *data.User
+
+	err = ctl.models.Transaction(ctl.ctx, func(tx data.Models) error {
+		user, err = findUser(ctl.ctx, tx, args[0])
+		if err != nil {
+			return err
+		}
+
+		current, err := tx.Permissions.GetAllForUser(ctl.ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// users_permissions has a primary key, so only add what's missing
+		granting := name == "permissions grant"
+		pending := slices.DeleteFunc(slices.Clone(codes), func(code string) bool {
+			return current.Include(code) == granting
+		})
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		return change(tx.Permissions, ctl.ctx, user.ID, pending...)
+	})
+	if err != nil {
+		return err
+	}
+
+	return printPermissions(ctl, user)
+}
+
+func printPermissions(ctl *ctl, user *data.User) error {
+	permissions, err := ctl.models.Permissions.GetAllForUser(ctl.ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	rows := [][]string{}
+	for _, code := range permissions {
+		rows = append(rows, []string{code})
+	}
+
+	return ctl.print(map[string]any{"user": user.ID, "permissions": permissions}, []string{"PERMISSION"}, rows)
+}
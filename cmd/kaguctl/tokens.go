@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+func tokensIssue(ctl *ctl, args []string) error {
+	fs := flag.NewFlagSet("tokens issue", flag.ContinueOnError)
+	scope := fs.String("scope", data.ScopeAuthentication, "Token scope (authentication|activation)")
+	ttl := fs.Duration("ttl", 24*time.Hour, "How long the token is valid for")
+
+	args, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *scope != data.ScopeAuthentication && *scope != data.ScopeActivation {
+		return usageError(fmt.Sprintf("unknown scope %q", *scope))
+	}
+
+	if *ttl <= 0 {
+		return usageError("-ttl must be positive")
+	}
+
+	user, err := ctl.user(args[0])
+	if err != nil {
+		return err
+	}
+
+	token, err := ctl.models.Tokens.New(ctl.ctx, user.ID, *ttl, *scope)
+	if err != nil {
+		return err
+	}
+
+	row := []string{token.Plaintext, *scope, strconv.FormatInt(user.ID, 10), token.Expiry.Format(time.RFC3339)}
+
+	return ctl.print(map[string]any{"token": token, "scope": *scope}, []string{"TOKEN", "SCOPE", "USER", "EXPIRY"}, [][]string{row})
+}
+
+func tokensRevoke(ctl *ctl, args []string) error {
+	fs := flag.NewFlagSet("tokens revoke", flag.ContinueOnError)
+	scope := fs.String("scope", data.ScopeAuthentication, "Token scope (authentication|activation|all)")
+
+	args, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var scopes []string
+	switch *scope {
+	case data.ScopeAuthentication, data.ScopeActivation:
+		scopes = []string{*scope}
+	case "all":
+		scopes = []string{data.ScopeAuthentication, data.ScopeActivation}
+	default:
+		return usageError(fmt.Sprintf("unknown scope %q", *scope))
+	}
+
+	user, err := ctl.user(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = ctl.models.Transaction(ctl.ctx, func(tx data.Models) error {
+		for _, scope := range scopes {
+			err := tx.Tokens.DeleteAllForUser(ctl.ctx, scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctl.done(fmt.Sprintf("revoked %s tokens for %s", *scope, user.Email), map[string]any{"user": user.ID, "revoked": scopes})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+// tripsTransfer makes another user the trip's creator. By default the previous
+// creator stays on the trip as a tripgoer, so they don't lose access to it.
+func tripsTransfer(ctl *ctl, args []string) error {
+	fs := flag.NewFlagSet("trips transfer", flag.ContinueOnError)
+	keepAccess := fs.Bool("keep-access", true, "Add the previous creator as a tripgoer")
+
+	args, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	tripID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || tripID < 1 {
+		return usageError(fmt.Sprintf("invalid trip id %q", args[0]))
+	}
+
+	var trip *data.Trip
+	var previous int64
+
+	err = ctl.models.Transaction(ctl.ctx, func(tx data.Models) error {
+		trip, err = tx.Trips.GetForUpdate(ctl.ctx, tripID)
+		if err != nil {
+			return err
+		}
+
+		user, err := findUser(ctl.ctx, tx, args[1])
+		if err != nil {
+			return err
+		}
+
+		previous = trip.CreatedBy
+		if previous == user.ID {
+			return fmt.Errorf("trip %d already belongs to %s", trip.ID, user.Email)
+		}
+
+		err = tx.Trips.Transfer(ctl.ctx, trip, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if !*keepAccess {
+			return nil
+		}
+
+		goers, err := tx.Users.GetAllByTrip(ctl.ctx, trip.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, goer := range goers {
+			if goer.ID == previous {
+				return nil
+			}
+		}
+
+		return tx.TripGoers.Insert(ctl.ctx, previous, trip.ID)
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("no trip %d", tripID)
+		}
+		return editConflict(err)
+	}
+
+	row := []string{strconv.FormatInt(trip.ID, 10), trip.Name, strconv.FormatInt(previous, 10), strconv.FormatInt(trip.CreatedBy, 10)}
+
+	return ctl.print(map[string]any{"trip": trip, "previous_created_by": previous}, []string{"TRIP", "NAME", "FROM", "TO"}, [][]string{row})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+var userHeader = []string{"ID", "NAME", "EMAIL", "ACTIVATED", "CREATED"}
+
+func userRow(user *data.User) []string {
+	return []string{
+		strconv.FormatInt(user.ID, 10),
+		user.Name,
+		user.Email,
+		strconv.FormatBool(user.Activated),
+		user.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func usersList(ctl *ctl, args []string) error {
+	fs := flag.NewFlagSet("users list", flag.ContinueOnError)
+	email := fs.String("email", "", "Only emails containing this")
+	name := fs.String("name", "", "Only names containing this")
+	activated := fs.String("activated", "", "Only activated (true) or unactivated (false) users")
+	sort := fs.String("sort", data.UserListing.DefaultSort, "Sort order")
+	page := fs.Int("page", 1, "Page number")
+	pageSize := fs.Int("page-size", 50, "Users per page")
+
+	_, err := parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	filters := data.Filters{
+		Page:           *page,
+		PageSize:       *pageSize,
+		Sort:           *sort,
+		SortSafelist:   data.UserListing.SortSafelist(),
+		FilterSafelist: data.UserListing.Filterable,
+		Conditions:     []data.Condition{},
+	}
+
+	if *email != "" {
+		filters.Conditions = append(filters.Conditions, data.Condition{Field: "email", Operator: data.OpContains, Values: []string{*email}})
+	}
+	if *name != "" {
+		filters.Conditions = append(filters.Conditions, data.Condition{Field: "name", Operator: data.OpContains, Values: []string{*name}})
+	}
+	if *activated != "" {
+		filters.Conditions = append(filters.Conditions, data.Condition{Field: "activated", Operator: data.OpEq, Values: []string{*activated}})
+	}
+
+	v := validator.New()
+	data.ValidateFilters(v, filters)
+	if !v.Valid() {
+		return usageError(fmt.Sprint(v.Errors))
+	}
+
+	users, metadata, err := ctl.models.Users.GetAll(ctl.ctx, filters)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, user := range users {
+		rows = append(rows, userRow(user))
+	}
+
+	err = ctl.print(map[string]any{"users": users, "metadata": metadata}, userHeader, rows)
+	if err != nil || ctl.json {
+		return err
+	}
+
+	_, err = fmt.Fprintf(ctl.out, "\npage %d of %d, %d users\n", metadata.CurrentPage, metadata.LastPage, metadata.TotalRecords)
+	return err
+}
+
+func usersFind(ctl *ctl, args []string) error {
+	args, err := parse(flag.NewFlagSet("users find", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := ctl.user(args[0])
+	if err != nil {
+		return err
+	}
+
+	permissions, err := ctl.models.Permissions.GetAllForUser(ctl.ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	header := slices.Concat(userHeader, []string{"PERMISSIONS"})
+	row := slices.Concat(userRow(user), []string{strings.Join(permissions, ", ")})
+
+	return ctl.print(map[string]any{"user": user, "permissions": permissions}, header, [][]string{row})
+}
+
+func usersActivate(ctl *ctl, args []string) error {
+	return setActivated(ctl, "users activate", args, true)
+}
+
+// usersDeactivate also deletes the user's authentication tokens, so existing
+// sessions end now rather than when their tokens expire.
+func usersDeactivate(ctl *ctl, args []string) error {
+	return setActivated(ctl, "users deactivate", args, false)
+}
+
+func setActivated(ctl *ctl, name string, args []string, activated bool) error {
+	args, err := parse(flag.NewFlagSet(name, flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	var user *data.User
+
+	err = ctl.models.Transaction(ctl.ctx, func(tx data.Models) error {
+		user, err = findUser(ctl.ctx, tx, args[0])
+		if err != nil {
+			return err
+		}
+
+		user.Activated = activated
+
+		err = tx.Users.Update(ctl.ctx, user)
+		if err != nil {
+			return err
+		}
+
+		if activated {
+			return nil
+		}
+
+		return tx.Tokens.DeleteAllForUser(ctl.ctx, data.ScopeAuthentication, user.ID)
+	})
+	if err != nil {
+		return editConflict(err)
+	}
+
+	return ctl.done(fmt.Sprintf("%s is now activated: %t", user.Email, activated), map[string]any{"user": user})
+}
+
+func usersResetPassword(ctl *ctl, args []string) error {
+	fs := flag.NewFlagSet("users reset-password", flag.ContinueOnError)
+	password := fs.String("password", "", "The new password (default a random one, which is printed)")
+
+	args, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	generated := *password == ""
+	if generated {
+		*password, err = randomPassword()
+		if err != nil {
+			return err
+		}
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, *password)
+	if !v.Valid() {
+		return usageError(v.Errors["password"])
+	}
+
+	var user *data.User
+
+	err = ctl.models.Transaction(ctl.ctx, func(tx data.Models) error {
+		user, err = findUser(ctl.ctx, tx, args[0])
+		if err != nil {
+			return err
+		}
+
+		err = user.Password.Set(*password)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Users.Update(ctl.ctx, user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(ctl.ctx, data.ScopeAuthentication, user.ID)
+	})
+	if err != nil {
+		return editConflict(err)
+	}
+
+	result := map[string]any{"user": user}
+	message := fmt.Sprintf("password reset for %s", user.Email)
+
+	if generated {
+		result["password"] = *password
+		message += ", new password: " + *password
+	}
+
+	return ctl.done(message, result)
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 15)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// editConflict explains ErrEditConflict, which here means someone changed the
+// record between reading and writing it.
+func editConflict(err error) error {
+	if errors.Is(err, data.ErrEditConflict) {
+		return errors.New("the record was changed by someone else, try again")
+	}
+
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import "github.com/rytwalker/kagubird-api/internal/data"
+
+// The raw material the generator draws from. Everything is picked with the
+// seeded source, so the order of these lists is part of what makes a dataset
+// reproducible: append to them rather than reordering.
+
+type city struct {
+	name      string
+	stateCode string
+	lat       float64
+	lng       float64
+}
+
+var cities = []city{
+	{"Chicago", "IL", 41.878113, -87.629799},
+	{"New York", "NY", 40.712776, -74.005974},
+	{"San Francisco", "CA", 37.774929, -122.419418},
+	{"Austin", "TX", 30.267153, -97.743057},
+	{"Seattle", "WA", 47.606209, -122.332069},
+	{"New Orleans", "LA", 29.951065, -90.071533},
+	{"Nashville", "TN", 36.162663, -86.781601},
+	{"Denver", "CO", 39.739235, -104.990250},
+	{"Portland", "OR", 45.515232, -122.678385},
+	{"Boston", "MA", 42.360081, -71.058884},
+	{"Miami", "FL", 25.761681, -80.191788},
+	{"Asheville", "NC", 35.595058, -82.551487},
+}
+
+var tripNames = []string{
+	"Long weekend in %s",
+	"%s getaway",
+	"Spring break in %s",
+	"%s with the crew",
+	"Birthday trip to %s",
+	"%s food tour",
+	"Conference week in %s",
+	"Family visit to %s",
+	"Road trip stop: %s",
+	"Exploring %s",
+}
+
+var firstNames = []string{
+	"Ava", "Liam", "Maya", "Noah", "Zoe", "Ethan", "Priya", "Mateo", "Hana", "Omar",
+	"Chloe", "Diego", "Amara", "Felix", "Ines", "Jonah", "Keiko", "Luca", "Nia", "Theo",
+}
+
+var lastNames = []string{
+	"Nguyen", "Garcia", "Okafor", "Smith", "Kowalski", "Patel", "Rossi", "Kim", "Haddad", "Johnson",
+	"Silva", "Walker", "Schmidt", "Tanaka", "Murphy", "Lopez", "Ali", "Brown", "Novak", "Reyes",
+}
+
+var streets = []string{
+	"Main St", "Oak Ave", "Market St", "Broadway", "Elm St", "Lake Shore Dr", "2nd Ave",
+	"Washington Blvd", "Maple St", "River Rd", "Union Sq", "Pine St", "Mission St", "Park Ave",
+}
+
+type activityTemplate struct {
+	category string
+	name     string
+	notes    string
+	// venue names the location, or is empty for activities that don't have one
+	venue string
+}
+
+var activityTemplates = []activityTemplate{
+	{data.CategoryFood, "Brunch at %s", "Book a table for the group, they fill up by 10.", "%s Kitchen"},
+	{data.CategoryFood, "Dinner at %s", "Tasting menu or à la carte, decide on the day.", "The %s Room"},
+	{data.CategoryFood, "Street food crawl", "Bring cash, most stalls don't take cards.", "%s Market Hall"},
+	{data.CategoryDrinks, "Drinks at %s", "Rooftop, so check the weather first.", "%s Rooftop Bar"},
+	{data.CategoryDrinks, "Brewery tour", "Tour includes a flight of four.", "%s Brewing Co."},
+	{data.CategorySightseeing, "Walking tour of the old town", "Meets outside the visitor center.", "%s Visitor Center"},
+	{data.CategorySightseeing, "Visit the %s", "Free on the first Thursday of the month.", "%s Museum of Art"},
+	{data.CategorySightseeing, "Sunset at the lookout", "Get there 30 minutes before sunset.", "%s Overlook"},
+	{data.CategoryOutdoors, "Morning hike", "Trailhead parking is limited, go early.", "%s Trailhead"},
+	{data.CategoryOutdoors, "Kayak rental", "Two hour minimum, life jackets included.", "%s Boathouse"},
+	{data.CategoryOutdoors, "Picnic in the park", "Pick up supplies on the way.", "%s Park"},
+	{data.CategoryEntertainment, "Live music at %s", "Doors at 8, no reservations.", "The %s Lounge"},
+	{data.CategoryEntertainment, "Comedy show", "Two drink minimum.", "%s Comedy Club"},
+	{data.CategoryEntertainment, "Ballgame", "Seats are in section 112.", "%s Stadium"},
+	{data.CategoryShopping, "Vintage shopping", "A few stores on the same block.", "%s Vintage"},
+	{data.CategoryShopping, "Farmers market", "Saturday mornings only.", "%s Farmers Market"},
+	{data.CategoryTransport, "Airport transfer", "Shuttle leaves every 30 minutes.", ""},
+	{data.CategoryWork, "Client meeting", "Bring the printed deck.", "%s Tower"},
+	{data.CategoryOther, "Laundry and downtime", "Nothing planned, on purpose.", ""},
+}
+
+var venueWords = []string{
+	"Juniper", "Harbor", "Copper", "Magnolia", "Cedar", "Lantern", "Bluebird", "Granite",
+	"Willow", "Union", "Golden", "Riverside", "Summit", "Anchor", "Ivy", "Foxglove",
+}
+
+var tagPool = []string{"booked", "free", "kid-friendly", "rainy-day", "must-do", "dinner", "nightlife", "outdoors", "splurge"}
+
+type stayTemplate struct {
+	stayType string
+	name     string
+	link     string
+}
+
+var stayTemplates = []stayTemplate{
+	{data.StayTypeHotel, "Hotel %s", "https://hotel%s.example.com"},
+	{data.StayTypeHotel, "The %s Inn", "https://%sinn.example.com"},
+	{data.StayTypeRental, "%s loft", "https://rentals.example.com/%s-loft"},
+	{data.StayTypeHostel, "%s Hostel", "https://%shostel.example.com"},
+	{data.StayTypeCamping, "%s campground", ""},
+	{data.StayTypeFriends, "Staying with %s", ""},
+}
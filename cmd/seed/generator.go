@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// generator draws everything from a single seeded source, in a fixed order,
+// so nothing it produces depends on the IDs the database hands out.
+type generator struct {
+	rng      *rand.Rand
+	seed     uint64
+	start    time.Time
+	password string
+	counts   counts
+	created  struct {
+		users      int
+		trips      int
+		activities int
+		locations  int
+		stays      int
+		tripGoers  int
+	}
+}
+
+func newGenerator(seed uint64, start time.Time, password string, c counts) *generator {
+	return &generator{
+		rng:      rand.New(rand.NewPCG(seed, seed)),
+		seed:     seed,
+		start:    start,
+		password: password,
+		counts:   c,
+	}
+}
+
+func (g *generator) generate(ctx context.Context, models data.Models) error {
+	users := make([]*data.User, 0, g.counts.users)
+
+	for i := range g.counts.users {
+		first, last := pick(g.rng, firstNames), pick(g.rng, lastNames)
+
+		user := &data.User{
+			Name: first + " " + last,
+			// the seed is in the domain so datasets from different seeds can
+			// share a database
+			Email:     fmt.Sprintf("%s.%s.%d@seed-%d.example.com", strings.ToLower(first), strings.ToLower(last), i+1, g.seed),
+			Activated: true,
+		}
+
+		// bcrypt is slow on purpose, so everyone shares the first user's hash
+		if i == 0 {
+			err := user.Password.Set(g.password)
+			if err != nil {
+				return err
+			}
+		} else {
+			user.Password = users[0].Password
+		}
+
+		v := validator.New()
+		data.ValidateUser(v, user)
+		err := validated("user", v)
+		if err != nil {
+			return err
+		}
+
+		err = models.Users.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		err = models.Permissions.AddForUser(ctx, user.ID, "trips:read", "trips:write")
+		if err != nil {
+			return err
+		}
+
+		users = append(users, user)
+		g.created.users++
+	}
+
+	for _, user := range users {
+		for range g.between(1, g.counts.trips) {
+			err := g.trip(ctx, models, user, users)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (g *generator) trip(ctx context.Context, models data.Models, creator *data.User, users []*data.User) error {
+	c := pick(g.rng, cities)
+	nights := g.between(2, 7)
+	arrival := g.start.AddDate(0, 0, g.rng.IntN(180))
+
+	trip := &data.Trip{
+		Name:          fmt.Sprintf(pick(g.rng, tripNames), c.name),
+		City:          c.name,
+		StateCode:     c.stateCode,
+		GooglePlaceID: g.placeID(),
+		Lat:           c.lat,
+		Lng:           c.lng,
+		StartDate:     arrival,
+		EndDate:       arrival.AddDate(0, 0, nights),
+		CreatedBy:     creator.ID,
+	}
+
+	v := validator.New()
+	data.ValidateTrip(v, trip)
+	err := validated("trip", v)
+	if err != nil {
+		return err
+	}
+
+	err = models.Trips.Insert(ctx, trip)
+	if err != nil {
+		return err
+	}
+
+	g.created.trips++
+
+	// tripgoers are other users; the creator isn't one, as with POST /v1/tripgoers
+	goers := g.between(0, g.counts.tripGoers)
+	for _, i := range g.rng.Perm(len(users)) {
+		if goers == 0 {
+			break
+		}
+		if users[i].ID == creator.ID {
+			continue
+		}
+
+		err = models.TripGoers.Insert(ctx, users[i].ID, trip.ID)
+		if err != nil {
+			return err
+		}
+
+		goers--
+		g.created.tripGoers++
+	}
+
+	for range g.between(0, g.counts.activities) {
+		err = g.activity(ctx, models, trip, c, nights)
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.stays(ctx, models, trip, c, nights)
+}
+
+// activity adds an activity and, if it happens somewhere, its location. Timed
+// activities fall on the nights of the trip, between 8am and 11pm, and dated
+// ones anywhere from arrival to departure day.
+func (g *generator) activity(ctx context.Context, models data.Models, trip *data.Trip, c city, nights int) error {
+	tpl := pick(g.rng, activityTemplates)
+
+	venue := ""
+	if tpl.venue != "" {
+		venue = fmt.Sprintf(tpl.venue, pick(g.rng, venueWords))
+	}
+
+	name := tpl.name
+	if strings.Contains(name, "%s") {
+		name = fmt.Sprintf(name, venue)
+	}
+
+	activity := &data.Activity{
+		Name:     name,
+		Notes:    tpl.notes,
+		Category: tpl.category,
+		Tags:     g.tags(),
+		Booking:  data.Booking{Status: data.BookingStatusIdea},
+		TripID:   trip.ID,
+	}
+
+	switch r := g.rng.IntN(20); {
+	case r < 10:
+		day := g.rng.IntN(nights)
+		hour := g.between(8, 20)
+		start := trip.StartDate.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+		end := start.Add(time.Duration(g.between(1, 3)) * time.Hour)
+
+		activity.Schedule = data.ScheduleTimed
+		activity.StartTime = &start
+		activity.EndTime = &end
+	case r < 13:
+		day := g.rng.IntN(nights + 1)
+		span := g.rng.IntN(min(2, nights+1-day))
+
+		activity.Schedule = data.ScheduleAllDay
+		activity.StartDate = &data.Date{Time: trip.StartDate.AddDate(0, 0, day)}
+		activity.EndDate = &data.Date{Time: trip.StartDate.AddDate(0, 0, day+span)}
+	case r < 15:
+		day := data.Date{Time: trip.StartDate.AddDate(0, 0, g.rng.IntN(nights+1))}
+
+		activity.Schedule = data.ScheduleDate
+		activity.StartDate = &day
+		activity.EndDate = &day
+	default:
+		activity.Schedule = data.ScheduleUnscheduled
+	}
+
+	v := validator.New()
+	data.ValidateActivity(v, activity)
+	err := validated("activity", v)
+	if err != nil {
+		return err
+	}
+
+	err = models.Activities.Insert(ctx, activity)
+	if err != nil {
+		return err
+	}
+
+	g.created.activities++
+
+	if venue == "" {
+		return nil
+	}
+
+	lat, lng := g.near(c)
+
+	location := &data.Location{
+		Name:          venue,
+		Address:       g.address(c),
+		Lat:           lat,
+		Lng:           lng,
+		GooglePlaceID: g.placeID(),
+		Phone:         g.phone(),
+		ActivityID:    activity.ID,
+	}
+
+	if g.rng.IntN(2) == 0 {
+		location.Website = fmt.Sprintf("https://%s.example.com", slug(venue))
+	}
+
+	v = validator.New()
+	data.ValidateLocation(v, location)
+	err = validated("location", v)
+	if err != nil {
+		return err
+	}
+
+	err = models.Locations.Insert(ctx, location)
+	if err != nil {
+		return err
+	}
+
+	g.created.locations++
+	return nil
+}
+
+// stays splits the trip's nights between back to back stays, checking in at
+// 3pm and out at 11am, so the last one ends on departure day.
+func (g *generator) stays(ctx context.Context, models data.Models, trip *data.Trip, c city, nights int) error {
+	n := min(g.between(0, g.counts.stays), nights)
+	if n == 0 {
+		return nil
+	}
+
+	cuts := []int{0, nights}
+	for _, cut := range g.rng.Perm(nights - 1)[:n-1] {
+		cuts = append(cuts, cut+1)
+	}
+	slices.Sort(cuts)
+
+	for i := range n {
+		tpl := pick(g.rng, stayTemplates)
+
+		word := pick(g.rng, venueWords)
+		if tpl.stayType == data.StayTypeFriends {
+			word = pick(g.rng, firstNames)
+		}
+
+		lat, lng := g.near(c)
+
+		stay := &data.Stay{
+			Name:      fmt.Sprintf(tpl.name, word),
+			StartTime: trip.StartDate.AddDate(0, 0, cuts[i]).Add(15 * time.Hour),
+			EndTime:   trip.StartDate.AddDate(0, 0, cuts[i+1]).Add(11 * time.Hour),
+			Address:   g.address(c),
+			Lat:       lat,
+			Lng:       lng,
+			Phone:     g.phone(),
+			Type:      tpl.stayType,
+			Tags:      g.tags(),
+			Booking:   data.Booking{Status: data.BookingStatusBooked},
+			TripID:    trip.ID,
+		}
+
+		if tpl.stayType == data.StayTypeFriends {
+			stay.Booking.Status = data.BookingStatusIdea
+		}
+
+		if tpl.link != "" {
+			stay.Link = fmt.Sprintf(tpl.link, slug(word))
+		}
+
+		v := validator.New()
+		data.ValidateStay(v, stay)
+		err := validated("stay", v)
+		if err != nil {
+			return err
+		}
+
+		err = models.Stays.Insert(ctx, stay)
+		if err != nil {
+			return err
+		}
+
+		g.created.stays++
+	}
+
+	return nil
+}
+
+// between returns a number from lo to hi inclusive, or lo if hi is smaller.
+func (g *generator) between(lo, hi int) int {
+	if hi <= lo {
+		return lo
+	}
+	return lo + g.rng.IntN(hi-lo+1)
+}
+
+// near scatters a point around the city center, most within a couple of
+// kilometres.
+func (g *generator) near(c city) (float64, float64) {
+	lat := c.lat + g.rng.NormFloat64()*0.02
+	lng := c.lng + g.rng.NormFloat64()*0.02/math.Cos(c.lat*math.Pi/180)
+
+	return math.Round(lat*1e6) / 1e6, math.Round(lng*1e6) / 1e6
+}
+
+func (g *generator) address(c city) string {
+	return fmt.Sprintf("%d %s, %s, %s", g.between(100, 9999), pick(g.rng, streets), c.name, c.stateCode)
+}
+
+// phone numbers are in the 555-01xx range, which is reserved for fiction.
+func (g *generator) phone() string {
+	return fmt.Sprintf("+1 %d-555-01%02d", g.between(201, 989), g.rng.IntN(100))
+}
+
+// placeID returns something shaped like a Google place ID.
+func (g *generator) placeID() string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+	id := []byte("ChIJ")
+	for range 23 {
+		id = append(id, alphabet[g.rng.IntN(len(alphabet))])
+	}
+
+	return string(id)
+}
+
+func (g *generator) tags() []string {
+	tags := []string{}
+	for _, i := range g.rng.Perm(len(tagPool))[:g.rng.IntN(3)] {
+		tags = append(tags, tagPool[i])
+	}
+
+	return data.NormalizeTags(tags)
+}
+
+func pick[T any](rng *rand.Rand, items []T) T {
+	return items[rng.IntN(len(items))]
+}
+
+func slug(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.Trim(s, "."))), "-")
+}
+
+// pastDateErrors are the API's rules that new trips and activities be in the
+// future, which don't apply here since -start can be in the past.
+var pastDateErrors = []string{"must be in the future", "must not be in the past"}
+
+// validated turns failed validation into an error. Anything the generator
+// makes should pass the same checks the API applies, apart from pastDateErrors,
+// so an error means a bug here.
+func validated(kind string, v *validator.Validator) error {
+	for key, message := range v.Errors {
+		if slices.Contains(pastDateErrors, message) {
+			delete(v.Errors, key)
+		}
+	}
+
+	if v.Valid() {
+		return nil
+	}
+
+	return fmt.Errorf("generated an invalid %s: %v", kind, v.Errors)
+}
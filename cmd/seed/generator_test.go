@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+var testCounts = counts{users: 4, trips: 2, activities: 6, stays: 2, tripGoers: 2}
+
+// generate seeds a memory store and describes what ended up in it, with every
+// time written relative to start so datasets from different starts compare.
+func generate(t *testing.T, seed uint64, start time.Time) string {
+	t.Helper()
+
+	ctx := context.Background()
+	models := data.NewMemoryModels()
+
+	g := newGenerator(seed, start, "pa55word", testCounts)
+
+	err := g.generate(ctx, models)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(tm time.Time) string { return tm.Sub(start).String() }
+
+	var b strings.Builder
+
+	for id := int64(1); id <= int64(g.created.users); id++ {
+		user, err := models.Users.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "user %s <%s>\n", user.Name, user.Email)
+	}
+
+	for id := int64(1); id <= int64(g.created.trips); id++ {
+		trip, err := models.Trips.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "trip %q %s %s %s-%s by %d\n", trip.Name, trip.City, trip.GooglePlaceID, at(trip.StartDate), at(trip.EndDate), trip.CreatedBy)
+
+		goers, err := models.Users.GetAllByTrip(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, goer := range goers {
+			fmt.Fprintf(&b, "  goer %s\n", goer.Email)
+		}
+
+		activities, err := models.Activities.GetAllByTrip(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range activities {
+			when := ""
+			switch {
+			case a.StartTime != nil:
+				when = at(*a.StartTime) + "-" + at(*a.EndTime)
+			case a.StartDate != nil:
+				when = at(a.StartDate.Time) + "-" + at(a.EndDate.Time)
+			}
+			fmt.Fprintf(&b, "  activity %q %s %s %v %s\n", a.Name, a.Category, a.Schedule, a.Tags, when)
+
+			locations, err := models.Locations.GetAllByActivity(ctx, a.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range locations {
+				fmt.Fprintf(&b, "    location %q %s %v,%v %s %s\n", l.Name, l.Address, l.Lat, l.Lng, l.GooglePlaceID, l.Website)
+			}
+		}
+
+		stays, err := models.Stays.GetAllByTrip(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range stays {
+			fmt.Fprintf(&b, "  stay %q %s %s %s-%s %v,%v\n", s.Name, s.Type, s.Link, at(s.StartTime), at(s.EndTime), s.Lat, s.Lng)
+		}
+	}
+
+	return b.String()
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	start := time.Date(2031, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	first := generate(t, 7, start)
+	if !strings.Contains(first, "trip ") || !strings.Contains(first, "activity ") {
+		t.Fatalf("generated too little to compare:\n%s", first)
+	}
+
+	if again := generate(t, 7, start); again != first {
+		t.Errorf("the same seed and start gave different data:\n%s\nthen\n%s", first, again)
+	}
+
+	if other := generate(t, 8, start); other == first {
+		t.Error("different seeds gave the same data")
+	}
+}
+
+// -start only moves the dataset, and it can be moved into the past.
+func TestGenerateFromAnyStart(t *testing.T) {
+	want := generate(t, 7, time.Date(2031, time.March, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, start := range []time.Time{
+		time.Date(2031, time.July, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		if got := generate(t, 7, start); got != want {
+			t.Errorf("starting %s gave different data:\n%s\nwant\n%s", start.Format(time.DateOnly), got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Command seed fills a database with generated users, trips, activities,
+// locations, stays and tripgoers. The same -seed always produces the same
+// dataset, with its dates counted from -start, which makes it useful for demos,
+// load tests and reproducing bugs without hand-written SQL. -start can be in
+// the past, to make trips that have already happened.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/rytwalker/kagubird-api/internal/data"
+)
+
+type config struct {
+	dsn      string
+	seed     uint64
+	start    string
+	password string
+	counts   counts
+}
+
+// counts are how much to generate. Trips are per user and everything else is
+// per trip; the generator varies the actual numbers below these maximums.
+type counts struct {
+	users      int
+	trips      int
+	activities int
+	stays      int
+	tripGoers  int
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.dsn, "db-dsn", "", "PostgreSQL DSN")
+	flag.Uint64Var(&cfg.seed, "seed", 1, "Seed for the generator; the same seed gives the same data, counted from -start")
+	flag.StringVar(&cfg.start, "start", "", "Date trips are generated after, as YYYY-MM-DD (default tomorrow)")
+	flag.StringVar(&cfg.password, "password", "pa55word", "Password for every generated user")
+	flag.IntVar(&cfg.counts.users, "users", 10, "Number of users")
+	flag.IntVar(&cfg.counts.trips, "trips", 3, "Maximum trips per user")
+	flag.IntVar(&cfg.counts.activities, "activities", 8, "Maximum activities per trip")
+	flag.IntVar(&cfg.counts.stays, "stays", 2, "Maximum stays per trip")
+	flag.IntVar(&cfg.counts.tripGoers, "tripgoers", 3, "Maximum tripgoers per trip, besides its creator")
+
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	start := data.Today().AddDate(0, 0, 1)
+	if cfg.start != "" {
+		date, err := data.ParseDate(cfg.start)
+		if err != nil {
+			logger.Error("invalid -start, must be YYYY-MM-DD")
+			os.Exit(1)
+		}
+		start = date.Time
+	}
+
+	db, err := sql.Open("postgres", cfg.dsn)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	defer db.Close()
+
+	models := data.NewModels(db, data.DefaultQueryTimeout)
+	g := newGenerator(cfg.seed, start, cfg.password, cfg.counts)
+
+	ctx := context.Background()
+
+	// one transaction, so a failed run leaves nothing behind
+	err = models.Transaction(ctx, func(tx data.Models) error {
+		return g.generate(ctx, tx)
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	logger.Info("seeded database",
+		"seed", cfg.seed,
+		"start", start.Format(time.DateOnly),
+		"users", g.created.users,
+		"trips", g.created.trips,
+		"activities", g.created.activities,
+		"locations", g.created.locations,
+		"stays", g.created.stays,
+		"tripgoers", g.created.tripGoers,
+	)
+}
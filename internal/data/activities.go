@@ -6,15 +6,35 @@ import (
 	"errors"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/rytwalker/kagubird-api/internal/validator"
 )
 
+// An activity is either an unscheduled idea, a timed event, an all-day (possibly
+// multi-day) block, or something pinned to a date without a time slot yet.
+const (
+	ScheduleUnscheduled = "unscheduled"
+	ScheduleTimed       = "timed"
+	ScheduleAllDay      = "all_day"
+	ScheduleDate        = "date"
+)
+
+var Schedules = []string{ScheduleUnscheduled, ScheduleTimed, ScheduleAllDay, ScheduleDate}
+
 type Activity struct {
 	ID        int64       `json:"id"`
 	Name      string      `json:"name"`
 	Notes     string      `json:"notes"`
-	StartTime time.Time   `json:"start_time"`
-	EndTime   time.Time   `json:"end_time"`
+	Category  string      `json:"category"`
+	Tags      []string    `json:"tags"`
+	Schedule  string      `json:"schedule"`
+	StartTime *time.Time  `json:"start_time"`
+	EndTime   *time.Time  `json:"end_time"`
+	StartDate *Date       `json:"start_date"`
+	EndDate   *Date       `json:"end_date"`
+	Position  int         `json:"position"`
+	Booking   Booking     `json:"booking"`
 	TripID    int64       `json:"trip"`
 	Locations []*Location `json:"locations"`
 	Version   int32       `json:"version"`
@@ -22,37 +42,116 @@ type Activity struct {
 	UpdatedAt time.Time   `json:"-"`
 }
 
+// Unschedule turns the activity back into an idea, clearing any times or dates.
+func (a *Activity) Unschedule() {
+	a.Schedule = ScheduleUnscheduled
+	a.StartTime = nil
+	a.EndTime = nil
+	a.StartDate = nil
+	a.EndDate = nil
+}
+
+// ScheduleAt pins the activity to a time slot.
+func (a *Activity) ScheduleAt(start, end time.Time) {
+	a.Schedule = ScheduleTimed
+	a.StartTime = &start
+	a.EndTime = &end
+	a.StartDate = nil
+	a.EndDate = nil
+	a.Position = 0
+}
+
+// ScheduleOn pins the activity to a range of days. schedule must be either
+// ScheduleAllDay or ScheduleDate. A nil end means the activity is a single day.
+func (a *Activity) ScheduleOn(schedule string, start Date, end *Date) {
+	if end == nil {
+		end = &start
+	}
+
+	a.Schedule = schedule
+	a.StartTime = nil
+	a.EndTime = nil
+	a.StartDate = &start
+	a.EndDate = end
+	a.Position = 0
+}
+
+// ideasEpoch is the start time unscheduled ideas sort at: after anything that
+// could be scheduled, and a second apart in their manual order.
+var ideasEpoch = time.Date(9000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Unscheduled ideas have no time, so by start time they sort after everything
+// else, in the order set with ReorderIdeas. The start_time expression takes
+// ideasEpoch as $2, which GetAll passes after the trip ID when sorting by it.
+var ActivityListing = Listing{
+	ID:          "activities.id",
+	DefaultSort: "start_time",
+	Sortable: map[string]string{
+		"id":         "activities.id",
+		"name":       "activities.name",
+		"start_time": "COALESCE(activities.start_time, activities.start_date::timestamptz, $2::timestamptz + activities.position * interval '1 second')",
+		"position":   "activities.position",
+		"category":   "activities.category",
+	},
+	Filterable: map[string]FilterField{
+		"name":       {Column: "activities.name", Type: FieldText},
+		"category":   {Column: "activities.category", Type: FieldText},
+		"schedule":   {Column: "activities.schedule", Type: FieldText},
+		"start_time": {Column: "activities.start_time", Type: FieldTime},
+		"end_time":   {Column: "activities.end_time", Type: FieldTime},
+		"start_date": {Column: "activities.start_date", Type: FieldDate},
+		"end_date":   {Column: "activities.end_date", Type: FieldDate},
+		"position":   {Column: "activities.position", Type: FieldInt},
+		"tags":       {Column: "activities.tags", Type: FieldTags},
+	},
+	Aliases: map[string]string{
+		"category": OpEq,
+		"schedule": OpEq,
+		"tags":     OpAll,
+	},
+}
+
 type ActivityModel struct {
-	DB *sql.DB
+	DB      Executor
+	Timeout time.Duration
 }
 
-func (m ActivityModel) Get(id int64) (*Activity, error) {
+func (m ActivityModel) Get(ctx context.Context, id int64) (*Activity, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-    SELECT id, created_at, updated_at, name, notes, start_time, end_time trip_id, version
+    SELECT id, created_at, updated_at, name, notes, category, tags, schedule, start_time, end_time, start_date, end_date, position, trip_id, version,
+        ` + bookingColumns("activities") + `
     FROM activities
     WHERE id = $1`
 
 	var activity Activity
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	fields := []any{
 		&activity.ID,
 		&activity.CreatedAt,
 		&activity.UpdatedAt,
 		&activity.Name,
 		&activity.Notes,
+		&activity.Category,
+		pq.Array(&activity.Tags),
+		&activity.Schedule,
 		&activity.StartTime,
 		&activity.EndTime,
+		&activity.StartDate,
+		&activity.EndDate,
+		&activity.Position,
 		&activity.TripID,
 		&activity.Version,
-	)
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(append(fields, bookingFields(&activity.Booking)...)...)
 
 	if err != nil {
 		switch {
@@ -65,27 +164,53 @@ func (m ActivityModel) Get(id int64) (*Activity, error) {
 
 	return &activity, nil
 }
-func (m ActivityModel) Insert(activity *Activity) error {
+
+// Insert adds a new activity. Ideas created without an explicit position are
+// appended to the end of the trip's unscheduled list.
+func (m ActivityModel) Insert(ctx context.Context, activity *Activity) error {
 	query := `
-    INSERT INTO activities (name, notes, start_time, end_time, trip_id)
-    VALUES ($1, $2, $3, $4, $5)
-    RETURNING id, created_at, version`
+    INSERT INTO activities (name, notes, category, tags, schedule, start_time, end_time, start_date, end_date, position, trip_id,
+        booking_status, confirmation_number, provider, cost, currency, cancellation_deadline, payment_due)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9,
+        CASE WHEN $5 = 'unscheduled' AND $10 = 0
+            THEN (SELECT COALESCE(MAX(position), 0) + 1 FROM activities WHERE trip_id = $11 AND schedule = 'unscheduled')
+            ELSE $10
+        END,
+        $11, $12, $13, $14, $15, $16, $17, $18)
+    RETURNING id, created_at, position, version`
 
-	args := []any{activity.Name, activity.Notes, activity.StartTime, activity.EndTime, activity.TripID}
+	args := []any{
+		activity.Name,
+		activity.Notes,
+		activity.Category,
+		pq.Array(activity.Tags),
+		activity.Schedule,
+		activity.StartTime,
+		activity.EndTime,
+		activity.StartDate,
+		activity.EndDate,
+		activity.Position,
+		activity.TripID,
+	}
+	args = append(args, bookingArgs(&activity.Booking)...)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&activity.ID, &activity.CreatedAt, &activity.Version)
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&activity.ID, &activity.CreatedAt, &activity.Position, &activity.Version)
 }
 
-func (m ActivityModel) GetAllByTrip(trip_id int64) ([]*Activity, error) {
+// GetAllByTrip returns scheduled activities in chronological order followed by
+// the trip's ideas in their manual order.
+func (m ActivityModel) GetAllByTrip(ctx context.Context, trip_id int64) ([]*Activity, error) {
 	query := `
-    SELECT  id, created_at, name, notes, start_time, end_time, version 
+    SELECT  id, created_at, name, notes, category, tags, schedule, start_time, end_time, start_date, end_date, position, version,
+        ` + bookingColumns("activities") + `
     FROM activities
-    WHERE trip_id = $1`
+    WHERE trip_id = $1
+    ORDER BY schedule = 'unscheduled', COALESCE(start_time, start_date::timestamptz), position, id`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, trip_id)
@@ -100,15 +225,23 @@ func (m ActivityModel) GetAllByTrip(trip_id int64) ([]*Activity, error) {
 	for rows.Next() {
 		var activity Activity
 
-		err := rows.Scan(
+		fields := []any{
 			&activity.ID,
 			&activity.CreatedAt,
 			&activity.Name,
 			&activity.Notes,
+			&activity.Category,
+			pq.Array(&activity.Tags),
+			&activity.Schedule,
 			&activity.StartTime,
 			&activity.EndTime,
+			&activity.StartDate,
+			&activity.EndDate,
+			&activity.Position,
 			&activity.Version,
-		)
+		}
+
+		err := rows.Scan(append(fields, bookingFields(&activity.Booking)...)...)
 
 		if err != nil {
 			return nil, err
@@ -125,23 +258,86 @@ func (m ActivityModel) GetAllByTrip(trip_id int64) ([]*Activity, error) {
 	return activities, nil
 }
 
-func (m ActivityModel) Update(activity *Activity) error {
+// GetAll returns a page of a trip's activities. The metadata includes category and tag facet counts across the whole
+// trip so clients can offer the other filters.
+func (m ActivityModel) GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Activity, Metadata, error) {
+	q := listQuery{
+		columns: `activities.id, activities.created_at, activities.name, activities.notes, activities.category, activities.tags,
+        activities.schedule, activities.start_time, activities.end_time, activities.start_date, activities.end_date,
+        activities.position, activities.trip_id, activities.version, ` + bookingColumns("activities"),
+		from:  "activities",
+		where: "activities.trip_id = $1",
+		args:  []any{tripID},
+		sortArgs: map[string][]any{
+			"start_time": {ideasEpoch},
+		},
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	fields := func(activity *Activity) []any {
+		return append([]any{
+			&activity.ID,
+			&activity.CreatedAt,
+			&activity.Name,
+			&activity.Notes,
+			&activity.Category,
+			pq.Array(&activity.Tags),
+			&activity.Schedule,
+			&activity.StartTime,
+			&activity.EndTime,
+			&activity.StartDate,
+			&activity.EndDate,
+			&activity.Position,
+			&activity.TripID,
+			&activity.Version,
+		}, bookingFields(&activity.Booking)...)
+	}
+
+	activities, metadata, err := list(ctx, m.DB, ActivityListing, q, filters, fields, func(activity *Activity) int64 { return activity.ID })
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	facetQuery := `
+    SELECT 'category', category, count(*) FROM activities WHERE trip_id = $1 GROUP BY category
+    UNION ALL
+    SELECT 'tag', tag, count(*) FROM activities, unnest(tags) AS tag WHERE trip_id = $1 GROUP BY tag`
+
+	metadata.Facets, err = queryFacets(ctx, m.DB, facetQuery, tripID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return activities, metadata, nil
+}
+
+func (m ActivityModel) Update(ctx context.Context, activity *Activity) error {
 	query := `
     UPDATE activities
-    SET name = $1, notes = $2, start_time = $3, end_time = $4
-    WHERE id = $5 AND version = $6
+    SET name = $1, notes = $2, category = $3, tags = $4, schedule = $5, start_time = $6, end_time = $7, start_date = $8, end_date = $9,
+        position = $10, ` + bookingSet(13) + `, version = version + 1, updated_at = NOW()
+    WHERE id = $11 AND version = $12
     RETURNING version`
 
 	args := []any{
 		activity.Name,
 		activity.Notes,
+		activity.Category,
+		pq.Array(activity.Tags),
+		activity.Schedule,
 		activity.StartTime,
 		activity.EndTime,
+		activity.StartDate,
+		activity.EndDate,
+		activity.Position,
 		activity.ID,
 		activity.Version,
 	}
+	args = append(args, bookingArgs(&activity.Booking)...)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&activity.Version)
@@ -157,7 +353,65 @@ func (m ActivityModel) Update(activity *Activity) error {
 	return nil
 }
 
-func (m ActivityModel) Delete(id int64) error {
+// SaveBatch inserts the activities that don't have an ID yet and updates the
+// rest, all in one transaction. If any statement fails nothing is saved; a
+// stale version on one of the updates returns ErrEditConflict.
+func (m ActivityModel) SaveBatch(ctx context.Context, activities []*Activity) error {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx Executor) error {
+		batch := ActivityModel{DB: tx, Timeout: m.Timeout}
+
+		for _, activity := range activities {
+			var err error
+			if activity.ID == 0 {
+				err = batch.Insert(ctx, activity)
+			} else {
+				err = batch.Update(ctx, activity)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ReorderIdeas sets the manual order of a trip's unscheduled activities to the
+// order of the given IDs. Every ID must belong to an unscheduled activity on the
+// trip, otherwise ErrRecordNotFound is returned and nothing is changed.
+func (m ActivityModel) ReorderIdeas(ctx context.Context, tripID int64, activityIDs []int64) error {
+	query := `
+    UPDATE activities
+    SET position = ordered.position, version = version + 1, updated_at = NOW()
+    FROM unnest($2::bigint[]) WITH ORDINALITY AS ordered(id, position)
+    WHERE activities.id = ordered.id AND activities.trip_id = $1 AND activities.schedule = 'unscheduled'`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx Executor) error {
+		result, err := tx.ExecContext(ctx, query, tripID, pq.Array(activityIDs))
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected != int64(len(activityIDs)) {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+func (m ActivityModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -166,7 +420,7 @@ func (m ActivityModel) Delete(id int64) error {
     DELETE FROM activities
     WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
@@ -186,6 +440,37 @@ func (m ActivityModel) Delete(id int64) error {
 	return nil
 }
 
+// DeleteVersion deletes the activity only if it's still at the given version,
+// returning ErrEditConflict otherwise.
+func (m ActivityModel) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM activities
+    WHERE id = $1 AND version = $2`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
 func ValidateActivity(v *validator.Validator, activity *Activity) {
 	// name validations
 	v.Check(activity.Name != "", "name", "must be provided")
@@ -193,18 +478,64 @@ func ValidateActivity(v *validator.Validator, activity *Activity) {
 
 	// notes validations
 	v.Check(activity.Notes != "", "notes", "must be provided")
-	v.Check(len(activity.Notes) <= 10000, "notes", "must not be more than 500 bytes long")
+	v.Check(len(activity.Notes) <= 10000, "notes", "must not be more than 10000 bytes long")
 
 	// activity validations
 	v.Check(activity.TripID != 0, "activity", "must be provided")
 
-	// start_time validations
-	v.Check(!activity.StartTime.IsZero(), "start_time", "must be provided")
-	v.Check(!activity.StartTime.Before(time.Now()), "start_time", "must be in the future")
-	v.Check(activity.StartTime.Before(activity.EndTime), "start_time", "must be before end time")
+	// category validations
+	v.Check(validator.PermittedValue(activity.Category, ActivityCategories...), "category", "must be a known activity category")
+
+	// tags validations
+	ValidateTags(v, activity.Tags)
+
+	// position validations
+	v.Check(activity.Position >= 0, "position", "must not be negative")
+
+	// booking validations
+	ValidateBooking(v, &activity.Booking)
 
-	// end_time validations
-	v.Check(!activity.EndTime.IsZero(), "end_time", "must be provided")
-	v.Check(!activity.EndTime.Before(time.Now()), "end_time", "must be in the future")
-	v.Check(activity.EndTime.After(activity.StartTime), "end_time", "must be after start time")
+	// schedule validations
+	v.Check(validator.PermittedValue(activity.Schedule, Schedules...), "schedule", "must be one of unscheduled, timed, all_day or date")
+
+	switch activity.Schedule {
+	case ScheduleUnscheduled:
+		v.Check(activity.StartTime == nil, "start_time", "must not be set for an unscheduled activity")
+		v.Check(activity.EndTime == nil, "end_time", "must not be set for an unscheduled activity")
+		v.Check(activity.StartDate == nil, "start_date", "must not be set for an unscheduled activity")
+		v.Check(activity.EndDate == nil, "end_date", "must not be set for an unscheduled activity")
+
+	case ScheduleTimed:
+		v.Check(activity.StartDate == nil, "start_date", "must not be set for a timed activity")
+		v.Check(activity.EndDate == nil, "end_date", "must not be set for a timed activity")
+
+		// start_time validations
+		v.Check(activity.StartTime != nil && !activity.StartTime.IsZero(), "start_time", "must be provided")
+
+		// end_time validations
+		v.Check(activity.EndTime != nil && !activity.EndTime.IsZero(), "end_time", "must be provided")
+
+		if activity.StartTime != nil && activity.EndTime != nil {
+			v.Check(!activity.StartTime.Before(time.Now()), "start_time", "must be in the future")
+			v.Check(activity.StartTime.Before(*activity.EndTime), "start_time", "must be before end time")
+
+			v.Check(!activity.EndTime.Before(time.Now()), "end_time", "must be in the future")
+			v.Check(activity.EndTime.After(*activity.StartTime), "end_time", "must be after start time")
+		}
+
+	case ScheduleAllDay, ScheduleDate:
+		v.Check(activity.StartTime == nil, "start_time", "must not be set for a date-only activity")
+		v.Check(activity.EndTime == nil, "end_time", "must not be set for a date-only activity")
+
+		// start_date validations
+		v.Check(activity.StartDate != nil, "start_date", "must be provided")
+
+		// end_date validations
+		v.Check(activity.EndDate != nil, "end_date", "must be provided")
+
+		if activity.StartDate != nil && activity.EndDate != nil {
+			v.Check(!activity.StartDate.Before(Today().Time), "start_date", "must not be in the past")
+			v.Check(!activity.EndDate.Before(activity.StartDate.Time), "end_date", "must not be before start date")
+		}
+	}
 }
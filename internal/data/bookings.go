@@ -0,0 +1,364 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// Where an activity or stay is in being booked.
+const (
+	BookingStatusIdea         = "idea"
+	BookingStatusNeedsBooking = "needs_booking"
+	BookingStatusBooked       = "booked"
+	BookingStatusCancelled    = "cancelled"
+)
+
+var BookingStatuses = []string{
+	BookingStatusIdea,
+	BookingStatusNeedsBooking,
+	BookingStatusBooked,
+	BookingStatusCancelled,
+}
+
+// The deadlines a booking can have reminders sent for.
+const (
+	BookingDeadlineCancellation = "cancellation"
+	BookingDeadlinePayment      = "payment"
+)
+
+var CurrencyRX = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Booking is the reservation behind an activity or stay. Cost is in the minor
+// unit of Currency, such as cents for USD. The reminded times record when a
+// reminder went out for each deadline; the models clear them whenever the
+// deadline changes.
+type Booking struct {
+	Status                 string     `json:"status"`
+	ConfirmationNumber     string     `json:"confirmation_number"`
+	Provider               string     `json:"provider"`
+	Cost                   *int64     `json:"cost"`
+	Currency               string     `json:"currency"`
+	CancellationDeadline   *time.Time `json:"cancellation_deadline"`
+	PaymentDue             *Date      `json:"payment_due"`
+	CancellationRemindedAt *time.Time `json:"-"`
+	PaymentRemindedAt      *time.Time `json:"-"`
+}
+
+// bookingColumns are the booking columns of activities or stays, in the order
+// bookingFields scans them.
+func bookingColumns(table string) string {
+	return fmt.Sprintf(`%[1]s.booking_status, %[1]s.confirmation_number, %[1]s.provider, %[1]s.cost, %[1]s.currency,
+        %[1]s.cancellation_deadline, %[1]s.payment_due`, table)
+}
+
+func bookingFields(booking *Booking) []any {
+	return []any{
+		&booking.Status,
+		&booking.ConfirmationNumber,
+		&booking.Provider,
+		&booking.Cost,
+		&booking.Currency,
+		&booking.CancellationDeadline,
+		&booking.PaymentDue,
+	}
+}
+
+// bookingArgs are the values for bookingColumns in an INSERT or UPDATE.
+func bookingArgs(booking *Booking) []any {
+	return []any{
+		booking.Status,
+		booking.ConfirmationNumber,
+		booking.Provider,
+		booking.Cost,
+		booking.Currency,
+		booking.CancellationDeadline,
+		booking.PaymentDue,
+	}
+}
+
+// bookingSet assigns the booking columns from the parameters starting at $n
+// in an UPDATE, clearing the reminder for a deadline that changed.
+func bookingSet(n int) string {
+	return fmt.Sprintf(`booking_status = $%d, confirmation_number = $%d, provider = $%d, cost = $%d, currency = $%d,
+        cancellation_reminded_at = CASE WHEN cancellation_deadline IS DISTINCT FROM $%[6]d THEN NULL ELSE cancellation_reminded_at END,
+        payment_reminded_at = CASE WHEN payment_due IS DISTINCT FROM $%[7]d THEN NULL ELSE payment_reminded_at END,
+        cancellation_deadline = $%[6]d, payment_due = $%[7]d`, n, n+1, n+2, n+3, n+4, n+5, n+6)
+}
+
+// NeedsAction reports whether someone still has to do something about a
+// booking at now: book it, or pay or decide on cancelling before a deadline.
+func (b *Booking) NeedsAction(now time.Time) bool {
+	switch b.Status {
+	case BookingStatusNeedsBooking:
+		return true
+	case BookingStatusBooked:
+		return b.NextDeadline(now) != nil
+	default:
+		return false
+	}
+}
+
+// NextDeadline returns the earliest of the booking's deadlines still ahead of
+// now, or nil if there isn't one. A payment is due at the start of its day.
+func (b *Booking) NextDeadline(now time.Time) *time.Time {
+	var next *time.Time
+
+	if b.CancellationDeadline != nil && b.CancellationDeadline.After(now) {
+		next = b.CancellationDeadline
+	}
+
+	if b.PaymentDue != nil && !b.PaymentDue.Before(DateOf(now).Time) {
+		if next == nil || b.PaymentDue.Before(*next) {
+			due := b.PaymentDue.Time
+			next = &due
+		}
+	}
+
+	return next
+}
+
+// BookingItem is an activity or stay on a trip's booking tracker. Kind is
+// "activity" or "stay", and Deadline is the booking's next deadline, if any.
+type BookingItem struct {
+	Kind     string     `json:"kind"`
+	ID       int64      `json:"id"`
+	Name     string     `json:"name"`
+	Deadline *time.Time `json:"deadline"`
+	Booking  Booking    `json:"booking"`
+}
+
+// BookingReminder is a deadline coming up on a booked activity or stay, which
+// travelers should hear about before it passes.
+type BookingReminder struct {
+	Item     BookingItem
+	TripID   int64
+	Deadline string
+	Due      time.Time
+}
+
+type BookingModel struct {
+	DB      Executor
+	Timeout time.Duration
+}
+
+// bookingItems selects the activities and stays of every trip as one relation.
+const bookingItems = `(
+        SELECT 'activity' AS kind, id, trip_id, name, booking_status, confirmation_number, provider, cost, currency,
+            cancellation_deadline, payment_due, cancellation_reminded_at, payment_reminded_at
+        FROM activities
+        UNION ALL
+        SELECT 'stay' AS kind, id, trip_id, name, booking_status, confirmation_number, provider, cost, currency,
+            cancellation_deadline, payment_due, cancellation_reminded_at, payment_reminded_at
+        FROM stays
+    ) AS items`
+
+// GetAllByTrip returns the activities and stays on a trip that still need
+// action, soonest deadline first. Items without a deadline come last.
+func (m BookingModel) GetAllByTrip(ctx context.Context, tripID int64) ([]*BookingItem, error) {
+	query := `
+    SELECT kind, id, name, ` + bookingColumns("items") + `,
+        LEAST(
+            CASE WHEN cancellation_deadline > NOW() THEN cancellation_deadline END,
+            CASE WHEN payment_due >= (NOW() AT TIME ZONE 'UTC')::date THEN payment_due::timestamp AT TIME ZONE 'UTC' END
+        ) AS deadline
+    FROM ` + bookingItems + `
+    WHERE trip_id = $1 AND (
+        booking_status = 'needs_booking'
+        OR (booking_status = 'booked' AND (cancellation_deadline > NOW() OR payment_due >= (NOW() AT TIME ZONE 'UTC')::date))
+    )
+    ORDER BY deadline NULLS LAST, kind, id`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, tripID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	items := []*BookingItem{}
+
+	for rows.Next() {
+		var item BookingItem
+
+		fields := append([]any{&item.Kind, &item.ID, &item.Name}, bookingFields(&item.Booking)...)
+
+		err := rows.Scan(append(fields, &item.Deadline)...)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetDueReminders returns the deadlines of booked activities and stays that
+// fall within the next lead and haven't had a reminder yet.
+func (m BookingModel) GetDueReminders(ctx context.Context, lead time.Duration) ([]*BookingReminder, error) {
+	query := `
+    SELECT kind, id, trip_id, name, ` + bookingColumns("items") + `, 'cancellation' AS deadline, cancellation_deadline AS due
+    FROM ` + bookingItems + `
+    WHERE booking_status = 'booked' AND cancellation_reminded_at IS NULL
+        AND cancellation_deadline > NOW() AND cancellation_deadline <= NOW() + make_interval(secs => $1)
+    UNION ALL
+    SELECT kind, id, trip_id, name, ` + bookingColumns("items") + `, 'payment', payment_due::timestamp AT TIME ZONE 'UTC'
+    FROM ` + bookingItems + `
+    WHERE booking_status = 'booked' AND payment_reminded_at IS NULL
+        AND payment_due >= (NOW() AT TIME ZONE 'UTC')::date
+        AND payment_due::timestamp AT TIME ZONE 'UTC' <= NOW() + make_interval(secs => $1)
+    ORDER BY due, kind, id`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, lead.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reminders := []*BookingReminder{}
+
+	for rows.Next() {
+		var reminder BookingReminder
+
+		fields := append([]any{&reminder.Item.Kind, &reminder.Item.ID, &reminder.TripID, &reminder.Item.Name}, bookingFields(&reminder.Item.Booking)...)
+
+		err := rows.Scan(append(fields, &reminder.Deadline, &reminder.Due)...)
+		if err != nil {
+			return nil, err
+		}
+
+		reminder.Item.Deadline = &reminder.Due
+		reminders = append(reminders, &reminder)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
+
+// reminderItemColumns are the columns of booking_reminder_recipients that
+// reference each kind of booking item.
+var reminderItemColumns = map[string]string{"activity": "activity_id", "stay": "stay_id"}
+
+// MarkReminded records that a reminder went out to everyone, so it isn't sent
+// again unless the deadline changes, and forgets who it reached. It doesn't
+// change the item's version.
+func (m BookingModel) MarkReminded(ctx context.Context, reminder *BookingReminder) error {
+	tables := map[string]string{"activity": "activities", "stay": "stays"}
+	columns := map[string]string{BookingDeadlineCancellation: "cancellation_reminded_at", BookingDeadlinePayment: "payment_reminded_at"}
+
+	table, column, itemColumn := tables[reminder.Item.Kind], columns[reminder.Deadline], reminderItemColumns[reminder.Item.Kind]
+	if table == "" || column == "" {
+		return fmt.Errorf("unknown booking reminder %s for %s", reminder.Deadline, reminder.Item.Kind)
+	}
+
+	query := fmt.Sprintf(`
+    WITH recipients AS (
+        DELETE FROM booking_reminder_recipients
+        WHERE %s = $1 AND deadline = $2
+    )
+    UPDATE %s
+    SET %s = NOW()
+    WHERE id = $1`, itemColumn, table, column)
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, reminder.Item.ID, reminder.Deadline)
+	return err
+}
+
+// GetRemindedRecipients returns the email addresses a reminder has already
+// reached, when sending it to everyone hasn't worked yet.
+func (m BookingModel) GetRemindedRecipients(ctx context.Context, reminder *BookingReminder) ([]string, error) {
+	itemColumn := reminderItemColumns[reminder.Item.Kind]
+	if itemColumn == "" {
+		return nil, fmt.Errorf("unknown booking reminder %s for %s", reminder.Deadline, reminder.Item.Kind)
+	}
+
+	query := fmt.Sprintf(`
+    SELECT email
+    FROM booking_reminder_recipients
+    WHERE %s = $1 AND deadline = $2 AND due = $3
+    ORDER BY email`, itemColumn)
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, reminder.Item.ID, reminder.Deadline, reminder.Due)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	emails := []string{}
+
+	for rows.Next() {
+		var email string
+
+		err := rows.Scan(&email)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkRecipientReminded records that a reminder reached one email address, so
+// it isn't sent there again while others are retried.
+func (m BookingModel) MarkRecipientReminded(ctx context.Context, reminder *BookingReminder, email string) error {
+	itemColumn := reminderItemColumns[reminder.Item.Kind]
+	if itemColumn == "" {
+		return fmt.Errorf("unknown booking reminder %s for %s", reminder.Deadline, reminder.Item.Kind)
+	}
+
+	query := fmt.Sprintf(`
+    INSERT INTO booking_reminder_recipients (%s, deadline, due, email)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT DO NOTHING`, itemColumn)
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, reminder.Item.ID, reminder.Deadline, reminder.Due, email)
+	return err
+}
+
+func ValidateBooking(v *validator.Validator, booking *Booking) {
+	v.Check(validator.PermittedValue(booking.Status, BookingStatuses...), "booking.status", "must be one of idea, needs_booking, booked or cancelled")
+
+	v.Check(len(booking.ConfirmationNumber) <= 100, "booking.confirmation_number", "must not be more than 100 bytes long")
+	v.Check(len(booking.Provider) <= 200, "booking.provider", "must not be more than 200 bytes long")
+
+	if booking.Cost != nil {
+		v.Check(*booking.Cost >= 0, "booking.cost", "must not be negative")
+		v.Check(booking.Currency != "", "booking.currency", "must be provided with a cost")
+	}
+
+	v.Check(booking.Currency == "" || validator.Matches(booking.Currency, CurrencyRX), "booking.currency", "must be a three letter ISO 4217 code, such as USD")
+}
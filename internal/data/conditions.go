@@ -0,0 +1,178 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// Filter operators, as used in filter[field][operator]=value query parameters.
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpBetween  = "between"
+	OpIn       = "in"
+	OpContains = "contains"
+	OpAll      = "all"
+)
+
+// The types a filterable field can have. They decide which operators apply and
+// how values are checked before they're sent to postgres.
+const (
+	FieldText  = "text"
+	FieldInt   = "int"
+	FieldFloat = "float"
+	FieldTime  = "time"
+	FieldDate  = "date"
+	FieldBool  = "bool"
+	FieldTags  = "tags"
+)
+
+var fieldOperators = map[string][]string{
+	FieldText:  {OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpBetween, OpIn, OpContains},
+	FieldInt:   {OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpBetween, OpIn},
+	FieldFloat: {OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpBetween},
+	FieldTime:  {OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpBetween},
+	FieldDate:  {OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpBetween, OpIn},
+	FieldBool:  {OpEq, OpNe},
+	// tags fields are arrays: in matches rows with any of the tags, all rows
+	// with every one of them
+	FieldTags: {OpIn, OpAll},
+}
+
+var comparisons = map[string]string{
+	OpEq:  "=",
+	OpNe:  "<>",
+	OpLt:  "<",
+	OpLte: "<=",
+	OpGt:  ">",
+	OpGte: ">=",
+}
+
+// FilterField declares a filterable field: the SQL expression it maps to and
+// the type of its values.
+type FilterField struct {
+	Column string
+	Type   string
+}
+
+// Condition is a single parsed filter, such as filter[lat][between]=30.1,30.4.
+// between takes exactly two values, in and all one or more; every other
+// operator takes one. Param is set when the condition was read from one of a
+// listing's Aliases rather than a filter[...] parameter.
+type Condition struct {
+	Field    string
+	Operator string
+	Values   []string
+	Param    string
+}
+
+// Key is the query string parameter the condition was read from, used to report
+// validation errors against.
+func (c Condition) Key() string {
+	if c.Param != "" {
+		return c.Param
+	}
+
+	return fmt.Sprintf("filter[%s][%s]", c.Field, c.Operator)
+}
+
+func ValidateCondition(v *validator.Validator, safelist map[string]FilterField, c Condition) {
+	key := c.Key()
+
+	field, ok := safelist[c.Field]
+	if !ok {
+		v.AddError(key, "unknown filter field")
+		return
+	}
+
+	if !validator.PermittedValue(c.Operator, fieldOperators[field.Type]...) {
+		v.AddError(key, fmt.Sprintf("operator is not supported for %s fields", field.Type))
+		return
+	}
+
+	switch c.Operator {
+	case OpBetween:
+		v.Check(len(c.Values) == 2, key, "must contain exactly 2 comma separated values")
+	case OpIn, OpAll:
+		v.Check(len(c.Values) >= 1, key, "must contain at least 1 value")
+		v.Check(len(c.Values) <= 100, key, "must not contain more than 100 values")
+	default:
+		v.Check(len(c.Values) == 1, key, "must contain exactly 1 value")
+	}
+
+	for _, value := range c.Values {
+		v.Check(len(value) <= 500, key, "must not be more than 500 bytes long")
+		v.Check(validFieldValue(field.Type, value), key, "must be a valid "+field.Type+" value")
+	}
+}
+
+func validFieldValue(fieldType, value string) bool {
+	var err error
+
+	switch fieldType {
+	case FieldInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case FieldFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case FieldTime:
+		_, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			_, err = ParseDate(value)
+		}
+	case FieldDate:
+		_, err = ParseDate(value)
+	case FieldBool:
+		_, err = strconv.ParseBool(value)
+	case FieldTags:
+		return value != "" && value == strings.ToLower(strings.TrimSpace(value))
+	}
+
+	return err == nil
+}
+
+// likeEscaper escapes the LIKE wildcards in a value, so it only ever matches
+// literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// compile turns a validated condition into a parameterized SQL condition with
+// placeholders starting at $n, and the arguments for those placeholders. Values
+// are only ever passed as arguments; the only text interpolated into the SQL is
+// the column from the safelist and the operator from a fixed table.
+func (c Condition) compile(field FilterField, n int) (string, []any) {
+	if field.Type == FieldTags {
+		switch c.Operator {
+		case OpIn:
+			return fmt.Sprintf("%s && $%d", field.Column, n), []any{pq.Array(c.Values)}
+		case OpAll:
+			return fmt.Sprintf("%s @> $%d", field.Column, n), []any{pq.Array(c.Values)}
+		default:
+			panic("unsafe filter operator: " + c.Operator)
+		}
+	}
+
+	switch c.Operator {
+	case OpBetween:
+		return fmt.Sprintf("%s BETWEEN $%d AND $%d", field.Column, n, n+1), []any{c.Values[0], c.Values[1]}
+	case OpIn:
+		return fmt.Sprintf("%s = ANY($%d)", field.Column, n), []any{pq.Array(c.Values)}
+	case OpContains:
+		escaped := likeEscaper.Replace(c.Values[0])
+		return fmt.Sprintf("%s ILIKE '%%' || $%d || '%%'", field.Column, n), []any{escaped}
+	default:
+		comparison, ok := comparisons[c.Operator]
+		if !ok {
+			panic("unsafe filter operator: " + c.Operator)
+		}
+		return fmt.Sprintf("%s %s $%d", field.Column, comparison, n), []any{c.Values[0]}
+	}
+}
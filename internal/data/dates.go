@@ -0,0 +1,81 @@
+package data
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidDateFormat = errors.New("invalid date format, expected YYYY-MM-DD")
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day without a time of day. It is encoded as "YYYY-MM-DD" in
+// JSON and stored in postgres date columns.
+type Date struct {
+	time.Time
+}
+
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return Date{}, ErrInvalidDateFormat
+	}
+
+	return Date{t}, nil
+}
+
+// Today returns the current date in UTC.
+func Today() Date {
+	now := time.Now().UTC()
+	return NewDate(now.Year(), now.Month(), now.Day())
+}
+
+// DateOf returns the UTC calendar day t falls on.
+func DateOf(t time.Time) Date {
+	t = t.UTC()
+	return NewDate(t.Year(), t.Month(), t.Day())
+}
+
+func (d Date) String() string {
+	return d.Format(dateLayout)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	unquoted, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	parsed, err := ParseDate(unquoted)
+	if err != nil {
+		return err
+	}
+
+	*d = parsed
+	return nil
+}
+
+func (d *Date) Scan(src any) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Date", src)
+	}
+
+	*d = NewDate(t.Year(), t.Month(), t.Day())
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
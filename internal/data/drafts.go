@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDuplicateMessage is returned when a trip already has the draft made from
+// the same reservation in the same message.
+var ErrDuplicateMessage = errors.New("duplicate message")
+
+// The kinds of item a draft can become.
+const (
+	DraftKindActivity = "activity"
+	DraftKindStay     = "stay"
+)
+
+// Draft is a stay or activity read from a forwarded booking confirmation. It
+// isn't part of the trip until someone confirms it, which turns it into the
+// real thing. Exactly one of Activity and Stay is set, matching Kind. The
+// message fields say which email it came from, and Part which of the
+// message's reservations.
+type Draft struct {
+	ID        int64     `json:"id"`
+	TripID    int64     `json:"trip"`
+	Kind      string    `json:"kind"`
+	Activity  *Activity `json:"activity,omitempty"`
+	Stay      *Stay     `json:"stay,omitempty"`
+	MessageID string    `json:"message_id"`
+	Part      int       `json:"-"`
+	Sender    string    `json:"sender"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// item returns the draft's stay or activity as JSON, for the item column.
+func (d *Draft) item() ([]byte, error) {
+	switch d.Kind {
+	case DraftKindActivity:
+		return json.Marshal(d.Activity)
+	case DraftKindStay:
+		return json.Marshal(d.Stay)
+	default:
+		return nil, fmt.Errorf("unknown draft kind %q", d.Kind)
+	}
+}
+
+// setItem sets the draft's stay or activity from the item column.
+func (d *Draft) setItem(item []byte) error {
+	switch d.Kind {
+	case DraftKindActivity:
+		d.Activity = &Activity{}
+		return json.Unmarshal(item, d.Activity)
+	case DraftKindStay:
+		d.Stay = &Stay{}
+		return json.Unmarshal(item, d.Stay)
+	default:
+		return fmt.Errorf("unknown draft kind %q", d.Kind)
+	}
+}
+
+type DraftModel struct {
+	DB      Executor
+	Timeout time.Duration
+}
+
+func (m DraftModel) Insert(ctx context.Context, draft *Draft) error {
+	item, err := draft.item()
+	if err != nil {
+		return err
+	}
+
+	query := `
+    INSERT INTO drafts (trip_id, kind, item, message_id, part, sender, subject)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING id, created_at`
+
+	args := []any{draft.TripID, draft.Kind, item, draft.MessageID, draft.Part, draft.Sender, draft.Subject}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&draft.ID, &draft.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "drafts_message_part_idx"`:
+			return ErrDuplicateMessage
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m DraftModel) Get(ctx context.Context, id int64) (*Draft, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, trip_id, kind, item, message_id, part, sender, subject, created_at
+    FROM drafts
+    WHERE id = $1`
+
+	drafts, err := m.query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(drafts) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return drafts[0], nil
+}
+
+// GetAllByTrip returns a trip's drafts, oldest first.
+func (m DraftModel) GetAllByTrip(ctx context.Context, tripID int64) ([]*Draft, error) {
+	query := `
+    SELECT id, trip_id, kind, item, message_id, part, sender, subject, created_at
+    FROM drafts
+    WHERE trip_id = $1
+    ORDER BY created_at, id`
+
+	return m.query(ctx, query, tripID)
+}
+
+// GetAllByMessage returns the drafts a trip already has from a message, so
+// one delivered twice doesn't add the same drafts again.
+func (m DraftModel) GetAllByMessage(ctx context.Context, tripID int64, messageID string) ([]*Draft, error) {
+	query := `
+    SELECT id, trip_id, kind, item, message_id, part, sender, subject, created_at
+    FROM drafts
+    WHERE trip_id = $1 AND message_id = $2
+    ORDER BY id`
+
+	return m.query(ctx, query, tripID, messageID)
+}
+
+func (m DraftModel) query(ctx context.Context, query string, args ...any) ([]*Draft, error) {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	drafts := []*Draft{}
+
+	for rows.Next() {
+		var draft Draft
+		var item []byte
+
+		err := rows.Scan(
+			&draft.ID,
+			&draft.TripID,
+			&draft.Kind,
+			&item,
+			&draft.MessageID,
+			&draft.Part,
+			&draft.Sender,
+			&draft.Subject,
+			&draft.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = draft.setItem(item)
+		if err != nil {
+			return nil, err
+		}
+
+		drafts = append(drafts, &draft)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return drafts, nil
+}
+
+func (m DraftModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM drafts
+    WHERE id = $1`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
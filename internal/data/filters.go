@@ -1,24 +1,104 @@
 package data
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/rytwalker/kagubird-api/internal/validator"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filters describes a page of a list. Pages are addressed either by number
+// (Page) or, when After or Before is set, by an opaque cursor token pointing at
+// the row the page starts after or ends before. Cursors are signed with
+// CursorKey so clients can't forge arbitrary keyset values. Conditions narrow
+// the list and may only refer to fields in FilterSafelist.
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	After        string
+	Before       string
+	CursorKey    []byte
+
+	FilterSafelist map[string]FilterField
+	Conditions     []Condition
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+	Facets       Facets `json:"facets,omitempty"`
+}
+
+// Cursor is the decoded form of a cursor token. Value is the row's sort column
+// rendered as text by postgres, and ID breaks ties between equal sort values.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// EncodeCursor serializes and signs a cursor as "<payload>.<signature>", both
+// base64url encoded.
+func EncodeCursor(key []byte, c Cursor) string {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DecodeCursor verifies and decodes a token created by EncodeCursor.
+func DecodeCursor(key []byte, token string) (Cursor, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var c Cursor
+
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -28,6 +108,22 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maxumum of 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	v.Check(f.After == "" || f.Before == "", "after", "must not be used together with before")
+
+	for _, c := range f.Conditions {
+		ValidateCondition(v, f.FilterSafelist, c)
+	}
+
+	for key, token := range map[string]string{"after": f.After, "before": f.Before} {
+		if token == "" {
+			continue
+		}
+
+		c, err := DecodeCursor(f.CursorKey, token)
+		v.Check(err == nil, key, "must be a valid cursor")
+		v.Check(err != nil || c.Sort == f.Sort, key, "must be a cursor for the same sort order")
+	}
 }
 
 func (f Filters) sortColumn() string {
@@ -40,6 +136,26 @@ func (f Filters) sortColumn() string {
 	panic("unsafe sort parameter: " + f.Sort)
 }
 
+// where compiles the conditions into a list of SQL conditions with placeholders
+// starting at $n, and the arguments for them.
+func (f Filters) where(n int) ([]string, []any) {
+	conditions := []string{}
+	args := []any{}
+
+	for _, c := range f.Conditions {
+		field, ok := f.FilterSafelist[c.Field]
+		if !ok {
+			panic("unsafe filter parameter: " + c.Field)
+		}
+
+		condition, conditionArgs := c.compile(field, n+len(args))
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	return conditions, args
+}
+
 func (f Filters) sortDirection() string {
 	if strings.HasPrefix(f.Sort, "-") {
 		return "DESC"
@@ -49,13 +165,137 @@ func (f Filters) sortDirection() string {
 }
 
 func (f Filters) limit() int {
+	// in cursor mode fetch one extra row to find out whether another page
+	// follows
+	if f.usesCursor() {
+		return f.PageSize + 1
+	}
+
 	return f.PageSize
 }
 
 func (f Filters) offset() int {
+	if f.usesCursor() {
+		return 0
+	}
+
 	return (f.Page - 1) * f.PageSize
 }
 
+func (f Filters) usesCursor() bool {
+	return f.After != "" || f.Before != ""
+}
+
+func (f Filters) cursor() Cursor {
+	token := f.After
+	if token == "" {
+		token = f.Before
+	}
+
+	c, err := DecodeCursor(f.CursorKey, token)
+	if err != nil || c.Sort != f.Sort {
+		panic("unsafe cursor parameter: " + token)
+	}
+
+	return c
+}
+
+// backwards reports whether rows have to be read in the opposite of the sort
+// direction, which is the case when fetching the page before a cursor.
+func (f Filters) backwards() bool {
+	return f.Before != ""
+}
+
+// countColumn is the total records expression for a list query. Counting every
+// match is what makes deep OFFSET pages slow, so cursor pages skip it.
+func (f Filters) countColumn() string {
+	if f.usesCursor() {
+		return "0"
+	}
+
+	return "count(*) OVER()"
+}
+
+// keysetOrderBy is the ORDER BY clause for a list query sorted by sortExpr, with
+// the idColumn as the tie breaker. id is ordered in the same direction as the
+// sort so (sort, id) can be compared as a row, and in both pagination modes so
+// a cursor taken from a numbered page continues it without skipping or
+// repeating ties.
+func (f Filters) keysetOrderBy(sortExpr, idColumn string) string {
+	direction := f.sortDirection()
+	if f.backwards() {
+		direction = map[string]string{"ASC": "DESC", "DESC": "ASC"}[direction]
+	}
+
+	return fmt.Sprintf("%s %s, %s %s", sortExpr, direction, idColumn, direction)
+}
+
+// keysetCondition returns the WHERE condition restricting a list query to the
+// rows after (or before) the cursor, using placeholders starting at $n, along
+// with the arguments for those placeholders. Outside cursor mode it's TRUE.
+func (f Filters) keysetCondition(sortExpr, idColumn string, n int) (string, []any) {
+	if !f.usesCursor() {
+		return "TRUE", nil
+	}
+
+	c := f.cursor()
+
+	operator := ">"
+	if (f.sortDirection() == "DESC") != f.backwards() {
+		operator = "<"
+	}
+
+	condition := fmt.Sprintf("(%s, %s) %s ($%d, $%d)", sortExpr, idColumn, operator, n, n+1)
+
+	return condition, []any{c.Value, c.ID}
+}
+
+// paginate finishes a list query: it drops the look-ahead row fetched in cursor
+// mode, puts rows read backwards into sort order and builds the page metadata.
+// keys holds the cursor for each item, in the same order.
+func paginate[T any](f Filters, items []T, keys []Cursor, totalRecords int) ([]T, Metadata) {
+	if !f.usesCursor() {
+		metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+
+		if len(items) > 0 {
+			if f.Page < metadata.LastPage {
+				metadata.NextCursor = EncodeCursor(f.CursorKey, keys[len(keys)-1])
+			}
+			if f.Page > 1 {
+				metadata.PrevCursor = EncodeCursor(f.CursorKey, keys[0])
+			}
+		}
+
+		return items, metadata
+	}
+
+	hasMore := len(items) > f.PageSize
+	if hasMore {
+		items = items[:f.PageSize]
+		keys = keys[:f.PageSize]
+	}
+
+	hasNext, hasPrev := hasMore, true
+	if f.backwards() {
+		slices.Reverse(items)
+		slices.Reverse(keys)
+		hasNext, hasPrev = true, hasMore
+	}
+
+	metadata := Metadata{PageSize: f.PageSize}
+
+	if len(items) > 0 {
+		if hasNext {
+			metadata.NextCursor = EncodeCursor(f.CursorKey, keys[len(keys)-1])
+		}
+		if hasPrev {
+			metadata.PrevCursor = EncodeCursor(f.CursorKey, keys[0])
+		}
+	}
+
+	return items, metadata
+}
+
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	if totalRecords == 0 {
 		return Metadata{}
@@ -70,3 +310,49 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	}
 
 }
+
+// Facets holds, for each facet, the number of records carrying each value. For
+// example {"category": {"food": 12, "work": 3}}.
+type Facets map[string]map[string]int
+
+func (f Facets) add(facet, value string, count int) {
+	if f[facet] == nil {
+		f[facet] = make(map[string]int)
+	}
+
+	f[facet][value] += count
+}
+
+// queryFacets runs a query returning (facet, value, count) rows and collects the
+// results.
+func queryFacets(ctx context.Context, db Executor, query string, args ...any) (Facets, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	facets := Facets{}
+
+	for rows.Next() {
+		var (
+			facet string
+			value string
+			count int
+		)
+
+		err := rows.Scan(&facet, &value, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		facets.add(facet, value, count)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Inbox is the email address of a trip that booking confirmations can be
+// forwarded to. Only the token is stored; the API puts the address together.
+// Anyone with the address can add drafts to the trip, so it's treated as a
+// secret and can be replaced with New.
+type Inbox struct {
+	TripID    int64     `json:"trip"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// generateInboxToken returns a random token for an inbox address. It's
+// lowercase, since mailers don't reliably keep the case of addresses.
+func generateInboxToken() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	token := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	return strings.ToLower(token), nil
+}
+
+type InboxModel struct {
+	DB      Executor
+	Timeout time.Duration
+}
+
+// New gives a trip an inbox with a fresh token, replacing the one it had.
+func (m InboxModel) New(ctx context.Context, tripID int64) (*Inbox, error) {
+	token, err := generateInboxToken()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+    INSERT INTO trip_inboxes (trip_id, token)
+    VALUES ($1, $2)
+    ON CONFLICT (trip_id) DO UPDATE SET token = EXCLUDED.token, created_at = NOW()
+    RETURNING created_at`
+
+	inbox := &Inbox{TripID: tripID, Token: token}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, tripID, token).Scan(&inbox.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return inbox, nil
+}
+
+func (m InboxModel) Get(ctx context.Context, tripID int64) (*Inbox, error) {
+	if tripID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT trip_id, token, created_at
+    FROM trip_inboxes
+    WHERE trip_id = $1`
+
+	return m.get(ctx, query, tripID)
+}
+
+// GetByToken returns the inbox an address's token belongs to.
+func (m InboxModel) GetByToken(ctx context.Context, token string) (*Inbox, error) {
+	query := `
+    SELECT trip_id, token, created_at
+    FROM trip_inboxes
+    WHERE token = $1`
+
+	return m.get(ctx, query, strings.ToLower(token))
+}
+
+func (m InboxModel) get(ctx context.Context, query string, arg any) (*Inbox, error) {
+	var inbox Inbox
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(&inbox.TripID, &inbox.Token, &inbox.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &inbox, nil
+}
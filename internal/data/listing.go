@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A Listing declares how a resource's list endpoint can be sorted and filtered.
+// Sortable and Filterable map the field names clients use to the SQL
+// expressions behind them, so only declared fields ever reach a query.
+type Listing struct {
+	// ID is the column used to break ties between equal sort values.
+	ID          string
+	DefaultSort string
+	Sortable    map[string]string
+	Filterable  map[string]FilterField
+	// Aliases are the plain query parameters clients filtered with before
+	// filter[field][operator], such as ?category=museum. Each is read as the
+	// filterable field of the same name with the operator given here.
+	Aliases map[string]string
+}
+
+// SortSafelist returns every permitted sort value, ascending and descending.
+func (l Listing) SortSafelist() []string {
+	safelist := []string{}
+
+	for field := range l.Sortable {
+		safelist = append(safelist, field, "-"+field)
+	}
+
+	slices.Sort(safelist)
+	return safelist
+}
+
+// listQuery is the resource specific part of a list query. where may refer to
+// args as $1 to $len(args). sortArgs are the arguments a sort expression needs,
+// which follow args when sorting by it; postgres won't prepare a query with an
+// argument it doesn't use, so they can't always be passed.
+type listQuery struct {
+	columns  string
+	from     string
+	where    string
+	args     []any
+	sortArgs map[string][]any
+}
+
+// list runs a filtered, sorted and paginated SELECT for a resource. fields
+// returns the scan destinations for an item in the same order as q.columns, and
+// id returns its tie breaking ID for cursors.
+func list[T any](ctx context.Context, db Executor, l Listing, q listQuery, filters Filters, fields func(*T) []any, id func(*T) int64) ([]*T, Metadata, error) {
+	args := slices.Clone(q.args)
+	args = append(args, q.sortArgs[filters.sortColumn()]...)
+
+	conditions := []string{"TRUE"}
+	if q.where != "" {
+		conditions = append(conditions, q.where)
+	}
+
+	filterConditions, filterArgs := filters.where(len(args) + 1)
+	conditions = append(conditions, filterConditions...)
+	args = append(args, filterArgs...)
+
+	sortExpr, ok := l.Sortable[filters.sortColumn()]
+	if !ok {
+		panic("unsafe sort parameter: " + filters.Sort)
+	}
+
+	keyset, keysetArgs := filters.keysetCondition(sortExpr, l.ID, len(args)+1)
+	args = append(args, keysetArgs...)
+	conditions = append(conditions, keyset)
+
+	args = append(args, filters.limit(), filters.offset())
+
+	query := fmt.Sprintf(`
+    SELECT %s, %s, (%s)::text
+    FROM %s
+    WHERE %s
+    ORDER BY %s
+    LIMIT $%d OFFSET $%d`,
+		filters.countColumn(), q.columns, sortExpr,
+		q.from,
+		strings.Join(conditions, " AND "),
+		filters.keysetOrderBy(sortExpr, l.ID),
+		len(args)-1, len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*T{}
+	keys := []Cursor{}
+
+	for rows.Next() {
+		var item T
+		var sortValue string
+
+		dest := []any{&totalRecords}
+		dest = append(dest, fields(&item)...)
+		dest = append(dest, &sortValue)
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		items = append(items, &item)
+		keys = append(keys, Cursor{Sort: filters.Sort, Value: sortValue, ID: id(&item)})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	items, metadata := paginate(filters, items, keys, totalRecords)
+	return items, metadata, nil
+}
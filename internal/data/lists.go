@@ -0,0 +1,395 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+var (
+	ErrDuplicateListName   = errors.New("duplicate list name")
+	ErrDuplicateSavedPlace = errors.New("duplicate saved place")
+)
+
+// List is one of a user's personal collections of places, like "Best coffee"
+// or "Want to try in Lisbon". Lists aren't tied to a trip.
+type List struct {
+	ID          int64         `json:"id"`
+	UserID      int64         `json:"-"`
+	Name        string        `json:"name"`
+	SavedPlaces []*SavedPlace `json:"places,omitempty"`
+	Version     int32         `json:"version"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"-"`
+}
+
+// SavedPlace is a place on a list, with the user's own notes and rating. A
+// place is on a list at most once, so the pair identifies it.
+type SavedPlace struct {
+	ListID    int64     `json:"list"`
+	Place     *Place    `json:"place"`
+	Notes     string    `json:"notes"`
+	Rating    *int      `json:"rating"`
+	Version   int32     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+type ListModel struct {
+	DB      Executor
+	Timeout time.Duration
+}
+
+func (m ListModel) Insert(ctx context.Context, list *List) error {
+	query := `
+    INSERT INTO lists (user_id, name)
+    VALUES ($1, $2)
+    RETURNING id, created_at, version`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, list.UserID, list.Name).Scan(&list.ID, &list.CreatedAt, &list.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "lists_user_id_name_key"`:
+			return ErrDuplicateListName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ListModel) Get(ctx context.Context, id int64) (*List, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, created_at, updated_at, user_id, name, version
+    FROM lists
+    WHERE id = $1`
+
+	var list List
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+		&list.UserID,
+		&list.Name,
+		&list.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+// GetAllForUser returns a user's lists in name order, without their places.
+func (m ListModel) GetAllForUser(ctx context.Context, userID int64) ([]*List, error) {
+	query := `
+    SELECT id, created_at, user_id, name, version
+    FROM lists
+    WHERE user_id = $1
+    ORDER BY name, id`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	lists := []*List{}
+
+	for rows.Next() {
+		var list List
+
+		err := rows.Scan(
+			&list.ID,
+			&list.CreatedAt,
+			&list.UserID,
+			&list.Name,
+			&list.Version,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+func (m ListModel) Update(ctx context.Context, list *List) error {
+	query := `
+    UPDATE lists
+    SET name = $1, version = version + 1, updated_at = NOW()
+    WHERE id = $2 AND version = $3
+    RETURNING version`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, list.Name, list.ID, list.Version).Scan(&list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "lists_user_id_name_key"`:
+			return ErrDuplicateListName
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteVersion deletes a list and everything saved on it. The places
+// themselves are kept.
+func (m ListModel) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM lists
+    WHERE id = $1 AND version = $2`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+type SavedPlaceModel struct {
+	DB      Executor
+	Timeout time.Duration
+}
+
+// savedPlaceColumns are selected from saved_places joined with places, in the
+// order savedPlaceFields scans them.
+const savedPlaceColumns = `saved_places.list_id, saved_places.notes, saved_places.rating, saved_places.version,
+        saved_places.created_at, saved_places.updated_at, places.id, COALESCE(places.google_place_id, ''), places.name,
+        places.address, places.lat, places.lng, places.website, places.phone, places.version`
+
+func savedPlaceFields(saved *SavedPlace) []any {
+	saved.Place = &Place{}
+
+	return []any{
+		&saved.ListID,
+		&saved.Notes,
+		&saved.Rating,
+		&saved.Version,
+		&saved.CreatedAt,
+		&saved.UpdatedAt,
+		&saved.Place.ID,
+		&saved.Place.GooglePlaceID,
+		&saved.Place.Name,
+		&saved.Place.Address,
+		&saved.Place.Lat,
+		&saved.Place.Lng,
+		&saved.Place.Website,
+		&saved.Place.Phone,
+		&saved.Place.Version,
+	}
+}
+
+// Insert saves saved.Place, which must already be in the catalog, on a list.
+// saved.Place is filled in with the stored place.
+func (m SavedPlaceModel) Insert(ctx context.Context, saved *SavedPlace) error {
+	query := `
+    WITH saved AS (
+        INSERT INTO saved_places (list_id, place_id, notes, rating)
+        VALUES ($1, $2, $3, $4)
+        RETURNING *
+    )
+    SELECT ` + savedPlaceColumns + `
+    FROM saved AS saved_places
+    JOIN places ON places.id = saved_places.place_id`
+
+	args := []any{saved.ListID, saved.Place.ID, saved.Notes, saved.Rating}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(savedPlaceFields(saved)...)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "saved_places_pkey"`:
+			return ErrDuplicateSavedPlace
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m SavedPlaceModel) Get(ctx context.Context, listID, placeID int64) (*SavedPlace, error) {
+	query := `
+    SELECT ` + savedPlaceColumns + `
+    FROM saved_places
+    JOIN places ON places.id = saved_places.place_id
+    WHERE saved_places.list_id = $1 AND saved_places.place_id = $2`
+
+	var saved SavedPlace
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, listID, placeID).Scan(savedPlaceFields(&saved)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &saved, nil
+}
+
+// GetAllByList returns the places on a list, most recently saved first.
+func (m SavedPlaceModel) GetAllByList(ctx context.Context, listID int64) ([]*SavedPlace, error) {
+	query := `
+    SELECT ` + savedPlaceColumns + `
+    FROM saved_places
+    JOIN places ON places.id = saved_places.place_id
+    WHERE saved_places.list_id = $1
+    ORDER BY saved_places.created_at DESC, places.id`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	saved := []*SavedPlace{}
+
+	for rows.Next() {
+		var s SavedPlace
+
+		err := rows.Scan(savedPlaceFields(&s)...)
+		if err != nil {
+			return nil, err
+		}
+
+		saved = append(saved, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// Update saves the notes and rating of a saved place.
+func (m SavedPlaceModel) Update(ctx context.Context, saved *SavedPlace) error {
+	query := `
+    UPDATE saved_places
+    SET notes = $1, rating = $2, version = version + 1, updated_at = NOW()
+    WHERE list_id = $3 AND place_id = $4 AND version = $5
+    RETURNING version`
+
+	args := []any{saved.Notes, saved.Rating, saved.ListID, saved.Place.ID, saved.Version}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&saved.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m SavedPlaceModel) DeleteVersion(ctx context.Context, listID, placeID int64, version int32) error {
+	query := `
+    DELETE FROM saved_places
+    WHERE list_id = $1 AND place_id = $2 AND version = $3`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, listID, placeID, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 200, "name", "must not be more than 200 bytes long")
+}
+
+func ValidateSavedPlace(v *validator.Validator, saved *SavedPlace) {
+	v.Check(len(saved.Notes) <= 10000, "notes", "must not be more than 10000 bytes long")
+
+	if saved.Rating != nil {
+		v.Check(*saved.Rating >= 1 && *saved.Rating <= 5, "rating", "must be between 1 and 5")
+	}
+}
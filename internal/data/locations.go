@@ -3,11 +3,17 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/rytwalker/kagubird-api/internal/validator"
 )
 
+// Location is a place as used by one activity. The details come from the
+// shared place, so Version is the place's version and editing a location edits
+// the place for every activity and stay that uses it.
 type Location struct {
 	ID            int64     `json:"id"`
 	Name          string    `json:"name"`
@@ -18,36 +24,212 @@ type Location struct {
 	Website       string    `json:"website"`
 	Phone         string    `json:"phone"`
 	ActivityID    int64     `json:"activity"`
+	PlaceID       int64     `json:"place"`
 	Version       int32     `json:"version"`
 	CreatedAt     time.Time `json:"-"`
 	UpdatedAt     time.Time `json:"-"`
 }
 
+// place returns the shared place a location's details belong to.
+func (location *Location) place() *Place {
+	return &Place{
+		ID:            location.PlaceID,
+		GooglePlaceID: location.GooglePlaceID,
+		Name:          location.Name,
+		Address:       location.Address,
+		Lat:           location.Lat,
+		Lng:           location.Lng,
+		Website:       location.Website,
+		Phone:         location.Phone,
+		Version:       location.Version,
+	}
+}
+
+// setPlace copies a place's details onto the location.
+func (location *Location) setPlace(place *Place) {
+	location.PlaceID = place.ID
+	location.GooglePlaceID = place.GooglePlaceID
+	location.Name = place.Name
+	location.Address = place.Address
+	location.Lat = place.Lat
+	location.Lng = place.Lng
+	location.Website = place.Website
+	location.Phone = place.Phone
+	location.Version = place.Version
+	location.UpdatedAt = place.UpdatedAt
+}
+
+var LocationListing = Listing{
+	ID:          "locations.id",
+	DefaultSort: "id",
+	Sortable: map[string]string{
+		"id":       "locations.id",
+		"name":     "places.name",
+		"activity": "locations.activity_id",
+	},
+	Filterable: map[string]FilterField{
+		"name":            {Column: "places.name", Type: FieldText},
+		"address":         {Column: "places.address", Type: FieldText},
+		"activity":        {Column: "locations.activity_id", Type: FieldInt},
+		"place":           {Column: "locations.place_id", Type: FieldInt},
+		"google_place_id": {Column: "places.google_place_id", Type: FieldText},
+		"lat":             {Column: "places.lat", Type: FieldFloat},
+		"lng":             {Column: "places.lng", Type: FieldFloat},
+	},
+	Aliases: map[string]string{
+		"activity":        OpEq,
+		"google_place_id": OpEq,
+	},
+}
+
+// locationColumns are selected from locations joined with places, in the order
+// locationFields scans them.
+const locationColumns = `locations.id, places.name, places.address, places.lat, places.lng, COALESCE(places.google_place_id, ''),
+        places.website, places.phone, locations.activity_id, locations.place_id, places.version`
+
+func locationFields(location *Location) []any {
+	return []any{
+		&location.ID,
+		&location.Name,
+		&location.Address,
+		&location.Lat,
+		&location.Lng,
+		&location.GooglePlaceID,
+		&location.Website,
+		&location.Phone,
+		&location.ActivityID,
+		&location.PlaceID,
+		&location.Version,
+	}
+}
+
 type LocationModel struct {
-	DB *sql.DB
+	DB      Executor
+	Timeout time.Duration
 }
 
-func (m LocationModel) Insert(location *Location) error {
+// Insert adds a place to an activity. A location with a PlaceID links that
+// place; otherwise, if the Google place ID is already known, the existing place
+// is used as is. Either way location is filled in with the place, and adding
+// the same place to an activity twice returns the existing location.
+func (m LocationModel) Insert(ctx context.Context, location *Location) error {
+	query := `
+    INSERT INTO locations (activity_id, place_id)
+    VALUES ($1, $2)
+    ON CONFLICT (activity_id, place_id) DO UPDATE SET activity_id = EXCLUDED.activity_id
+    RETURNING id, created_at`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx Executor) error {
+		places := PlaceModel{DB: tx, Timeout: m.Timeout}
+
+		place := location.place()
+
+		var err error
+		if location.PlaceID != 0 {
+			place, err = places.Get(ctx, location.PlaceID)
+		} else {
+			err = places.Ensure(ctx, place)
+		}
+		if err != nil {
+			return err
+		}
+
+		location.setPlace(place)
+
+		return tx.QueryRowContext(ctx, query, location.ActivityID, location.PlaceID).Scan(&location.ID, &location.CreatedAt)
+	})
+}
+
+func (m LocationModel) Get(ctx context.Context, id int64) (*Location, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	query := `
-    INSERT INTO locations (name, address, lat, lng, google_place_id, website, phone, activity_id)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-    RETURNING id, created_at, version`
+    SELECT locations.created_at, places.updated_at, ` + locationColumns + `
+    FROM locations
+    JOIN places ON places.id = locations.place_id
+    WHERE locations.id = $1`
 
-	args := []any{location.Name, location.Address, location.Lat, location.Lng, location.GooglePlaceID, location.Website, location.Phone, location.ActivityID}
+	var location Location
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&location.ID, &location.CreatedAt, &location.Version)
+	fields := append([]any{&location.CreatedAt, &location.UpdatedAt}, locationFields(&location)...)
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(fields...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &location, nil
 }
 
-func (m LocationModel) GetAllByActivity(activity_id int64) ([]*Location, error) {
+// Update saves a location's details to its place, so the change applies
+// everywhere the place is used. A location stays with the activity and place it
+// was created for.
+func (m LocationModel) Update(ctx context.Context, location *Location) error {
+	place := location.place()
+
+	err := PlaceModel{DB: m.DB, Timeout: m.Timeout}.Update(ctx, place)
+	if err != nil {
+		return err
+	}
+
+	location.Version = place.Version
+	return nil
+}
+
+// DeleteVersion removes a place from an activity, as long as the place is still
+// at the given version. The place itself is kept.
+func (m LocationModel) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
 	query := `
-    SELECT  id, name, address, lat, lng, google_place_id, website, phone, version 
+    DELETE FROM locations
+    USING places
+    WHERE locations.id = $1 AND places.id = locations.place_id AND places.version = $2`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+func (m LocationModel) GetAllByActivity(ctx context.Context, activity_id int64) ([]*Location, error) {
+	query := `
+    SELECT ` + locationColumns + `
     FROM locations
-    WHERE activity_id = $1`
+    JOIN places ON places.id = locations.place_id
+    WHERE locations.activity_id = $1
+    ORDER BY locations.id`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, activity_id)
@@ -63,24 +245,11 @@ func (m LocationModel) GetAllByActivity(activity_id int64) ([]*Location, error)
 	for rows.Next() {
 		var location Location
 
-		err := rows.Scan(
-			&location.ID,
-			&location.Name,
-			&location.Address,
-			&location.Lat,
-			&location.Lng,
-			&location.GooglePlaceID,
-			&location.Website,
-			&location.Phone,
-			&location.Version,
-		)
-
+		err := rows.Scan(locationFields(&location)...)
 		if err != nil {
 			return nil, err
 		}
 
-		location.ActivityID = activity_id
-
 		locations = append(locations, &location)
 	}
 
@@ -90,6 +259,93 @@ func (m LocationModel) GetAllByActivity(activity_id int64) ([]*Location, error)
 
 	return locations, nil
 }
+
+// GetAllByActivities fetches the locations of many activities in a single query,
+// keyed by activity ID. Activities without locations have no entry.
+func (m LocationModel) GetAllByActivities(ctx context.Context, activityIDs []int64) (map[int64][]*Location, error) {
+	query := `
+    SELECT ` + locationColumns + `
+    FROM locations
+    JOIN places ON places.id = locations.place_id
+    WHERE locations.activity_id = ANY($1)
+    ORDER BY locations.activity_id, locations.id`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(activityIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	locations := make(map[int64][]*Location)
+
+	for rows.Next() {
+		var location Location
+
+		err := rows.Scan(locationFields(&location)...)
+		if err != nil {
+			return nil, err
+		}
+
+		locations[location.ActivityID] = append(locations[location.ActivityID], &location)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
+
+// LoadForActivities sets the Locations of every given activity using a single
+// query, however many activities there are.
+func (m LocationModel) LoadForActivities(ctx context.Context, activities []*Activity) error {
+	return loadLocations(ctx, m, activities)
+}
+
+func loadLocations(ctx context.Context, locations LocationRepository, activities []*Activity) error {
+	if len(activities) == 0 {
+		return nil
+	}
+
+	activityIDs := make([]int64, len(activities))
+	for i, activity := range activities {
+		activityIDs[i] = activity.ID
+	}
+
+	byActivity, err := locations.GetAllByActivities(ctx, activityIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, activity := range activities {
+		activity.Locations = byActivity[activity.ID]
+		if activity.Locations == nil {
+			activity.Locations = []*Location{}
+		}
+	}
+
+	return nil
+}
+
+// GetAll returns a page of the locations of every activity on a trip.
+func (m LocationModel) GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Location, Metadata, error) {
+	q := listQuery{
+		columns: locationColumns,
+		from:    "locations JOIN places ON places.id = locations.place_id JOIN activities ON activities.id = locations.activity_id",
+		where:   "activities.trip_id = $1",
+		args:    []any{tripID},
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return list(ctx, m.DB, LocationListing, q, filters, locationFields, func(location *Location) int64 { return location.ID })
+}
+
 func ValidateLocation(v *validator.Validator, location *Location) {
 	// name validations
 	v.Check(location.Name != "", "name", "must be provided")
@@ -97,10 +353,11 @@ func ValidateLocation(v *validator.Validator, location *Location) {
 
 	// address validations
 	v.Check(location.Address != "", "address", "must be provided")
-	v.Check(len(location.Address) <= 500, "city", "must not be more than 500 bytes long")
+	v.Check(len(location.Address) <= 500, "address", "must not be more than 500 bytes long")
 
 	// google_place_id validations
-	v.Check(location.GooglePlaceID != "", "google_place_id", "must be provided")
+	// only places from before Google place IDs were required go without one
+	v.Check(location.GooglePlaceID != "" || location.ID != 0, "google_place_id", "must be provided")
 	v.Check(len(location.GooglePlaceID) <= 500, "google_place_id", "must not be more than 500 bytes long")
 
 	// lat validations
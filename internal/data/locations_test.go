@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// countingConnector opens connections that count the queries run on them and
+// answer every query with an empty result set.
+type countingConnector struct {
+	queries atomic.Int64
+}
+
+func (c *countingConnector) Connect(context.Context) (driver.Conn, error) {
+	return countingConn{queries: &c.queries}, nil
+}
+
+func (c *countingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type countingConn struct {
+	queries *atomic.Int64
+}
+
+func (c countingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c countingConn) Close() error {
+	return nil
+}
+
+func (c countingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+func (c countingConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	c.queries.Add(1)
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return nil }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newActivities(n int) []*Activity {
+	activities := make([]*Activity, n)
+	for i := range activities {
+		activities[i] = &Activity{ID: int64(i + 1)}
+	}
+
+	return activities
+}
+
+// BenchmarkLoadLocations compares loading the locations of a trip's activities
+// one activity at a time against LoadForActivities. The queries/op metric grows
+// with the number of activities for the former and stays at 1 for the latter.
+func BenchmarkLoadLocations(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		activities := newActivities(n)
+
+		b.Run(fmt.Sprintf("PerActivity/%d", n), func(b *testing.B) {
+			connector := &countingConnector{}
+			m := LocationModel{DB: sql.OpenDB(connector)}
+
+			for i := 0; i < b.N; i++ {
+				for _, activity := range activities {
+					locations, err := m.GetAllByActivity(context.Background(), activity.ID)
+					if err != nil {
+						b.Fatal(err)
+					}
+					activity.Locations = locations
+				}
+			}
+
+			b.ReportMetric(float64(connector.queries.Load())/float64(b.N), "queries/op")
+		})
+
+		b.Run(fmt.Sprintf("Batched/%d", n), func(b *testing.B) {
+			connector := &countingConnector{}
+			m := LocationModel{DB: sql.OpenDB(connector)}
+
+			for i := 0; i < b.N; i++ {
+				err := m.LoadForActivities(context.Background(), activities)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(connector.queries.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -0,0 +1,857 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errForeignKey and errDuplicateKey stand in for the constraint violations
+// Postgres reports. Handlers only ever see them as server errors, exactly as
+// they would the pq errors.
+var (
+	errForeignKey   = errors.New("memory store: foreign key violation")
+	errDuplicateKey = errors.New("memory store: duplicate key value")
+	errCheck        = errors.New("memory store: check constraint violation")
+)
+
+// errOuterCallInTransaction is returned for a call through the store's own
+// models, rather than the tx models, from inside a transaction. Postgres would
+// run it on another connection, but here it would wait forever for the lock
+// the transaction holds.
+var errOuterCallInTransaction = errors.New("memory store: outer models used inside a transaction, use the tx models passed to fn")
+
+// memoryTables holds the rows of every table. Rows are stored as private
+// copies, and copies are handed out, so callers can't change stored data
+// without going through a repository, just like with a database.
+type memoryTables struct {
+	sequences   map[string]int64
+	users       map[int64]*User
+	tokens      map[string]*Token
+	permissions map[int64][]string
+	trips       map[int64]*Trip
+	tripGoers   map[TripGoer]bool
+	activities  map[int64]*Activity
+	places      map[int64]*Place
+	locations   map[int64]*Location
+	stays       map[int64]*Stay
+	segments    map[int64]*Segment
+	stayPlaces  map[stayPlace]time.Time
+	lists       map[int64]*List
+	savedPlaces map[savedPlaceKey]*SavedPlace
+	inboxes     map[int64]*Inbox
+	drafts      map[int64]*Draft
+	reminded    map[reminderRecipient]bool
+}
+
+// stayPlace is a row of stay_places; the map it keys holds its created_at.
+type stayPlace struct {
+	stayID  int64
+	placeID int64
+}
+
+// reminderRecipient is a row of booking_reminder_recipients. due is in
+// microseconds, the precision Postgres keeps, and email is lowercased since the
+// column is citext.
+type reminderRecipient struct {
+	kind     string
+	itemID   int64
+	deadline string
+	due      int64
+	email    string
+}
+
+// savedPlaceKey is the primary key of saved_places. Stored saved places only
+// carry their place's ID, and get the rest from the place when they're read.
+type savedPlaceKey struct {
+	listID  int64
+	placeID int64
+}
+
+func newMemoryTables() *memoryTables {
+	return &memoryTables{
+		sequences:   make(map[string]int64),
+		users:       make(map[int64]*User),
+		tokens:      make(map[string]*Token),
+		permissions: make(map[int64][]string),
+		trips:       make(map[int64]*Trip),
+		tripGoers:   make(map[TripGoer]bool),
+		activities:  make(map[int64]*Activity),
+		places:      make(map[int64]*Place),
+		locations:   make(map[int64]*Location),
+		stays:       make(map[int64]*Stay),
+		segments:    make(map[int64]*Segment),
+		stayPlaces:  make(map[stayPlace]time.Time),
+		lists:       make(map[int64]*List),
+		savedPlaces: make(map[savedPlaceKey]*SavedPlace),
+		inboxes:     make(map[int64]*Inbox),
+		drafts:      make(map[int64]*Draft),
+		reminded:    make(map[reminderRecipient]bool),
+	}
+}
+
+func (t *memoryTables) nextID(table string) int64 {
+	t.sequences[table]++
+	return t.sequences[table]
+}
+
+func (t *memoryTables) clone() *memoryTables {
+	c := newMemoryTables()
+
+	for table, id := range t.sequences {
+		c.sequences[table] = id
+	}
+	for id, user := range t.users {
+		c.users[id] = copyUser(user)
+	}
+	for hash, token := range t.tokens {
+		c.tokens[hash] = copyToken(token)
+	}
+	for id, codes := range t.permissions {
+		c.permissions[id] = slices.Clone(codes)
+	}
+	for id, trip := range t.trips {
+		c.trips[id] = copyTrip(trip)
+	}
+	for tripGoer := range t.tripGoers {
+		c.tripGoers[tripGoer] = true
+	}
+	for id, activity := range t.activities {
+		c.activities[id] = copyActivity(activity)
+	}
+	for id, place := range t.places {
+		c.places[id] = copyPlace(place)
+	}
+	for id, location := range t.locations {
+		c.locations[id] = copyLocation(location)
+	}
+	for id, stay := range t.stays {
+		c.stays[id] = copyStay(stay)
+	}
+	for id, segment := range t.segments {
+		c.segments[id] = copySegment(segment)
+	}
+	for link, createdAt := range t.stayPlaces {
+		c.stayPlaces[link] = createdAt
+	}
+	for id, list := range t.lists {
+		c.lists[id] = copyList(list)
+	}
+	for key, saved := range t.savedPlaces {
+		c.savedPlaces[key] = copySavedPlace(saved)
+	}
+	for tripID, inbox := range t.inboxes {
+		c.inboxes[tripID] = copyInbox(inbox)
+	}
+	for id, draft := range t.drafts {
+		c.drafts[id] = copyDraft(draft)
+	}
+	for recipient := range t.reminded {
+		c.reminded[recipient] = true
+	}
+
+	return c
+}
+
+// deleteTrip removes a trip along with everything that references it with ON
+// DELETE CASCADE.
+func (t *memoryTables) deleteTrip(id int64) {
+	delete(t.trips, id)
+
+	for activityID, activity := range t.activities {
+		if activity.TripID == id {
+			t.deleteActivity(activityID)
+		}
+	}
+
+	for stayID, stay := range t.stays {
+		if stay.TripID == id {
+			t.deleteStay(stayID)
+		}
+	}
+
+	for segmentID, segment := range t.segments {
+		if segment.TripID == id {
+			delete(t.segments, segmentID)
+		}
+	}
+
+	for tripGoer := range t.tripGoers {
+		if tripGoer.TripID == id {
+			delete(t.tripGoers, tripGoer)
+		}
+	}
+
+	delete(t.inboxes, id)
+
+	for draftID, draft := range t.drafts {
+		if draft.TripID == id {
+			delete(t.drafts, draftID)
+		}
+	}
+}
+
+func (t *memoryTables) deleteStay(id int64) {
+	delete(t.stays, id)
+
+	for link := range t.stayPlaces {
+		if link.stayID == id {
+			delete(t.stayPlaces, link)
+		}
+	}
+
+	t.forgetReminded("stay", id, "")
+}
+
+// visible reports whether a user created or is going on a trip, which is what
+// decides the trips searches and autocomplete draw on.
+func (t *memoryTables) visible(userID, tripID int64) bool {
+	trip, ok := t.trips[tripID]
+	return ok && (trip.CreatedBy == userID || t.tripGoers[TripGoer{UserID: userID, TripID: tripID}])
+}
+
+// location returns a copy of a stored location with its place's details. Like
+// rows of the locations table, stored locations only link an activity and a
+// place.
+func (t *memoryTables) location(stored *Location) *Location {
+	location := copyLocation(stored)
+	location.setPlace(t.places[stored.PlaceID])
+	return location
+}
+
+// savedPlace returns a copy of a stored saved place with its place's details.
+func (t *memoryTables) savedPlace(stored *SavedPlace) *SavedPlace {
+	saved := copySavedPlace(stored)
+	saved.Place = copyPlace(t.places[stored.Place.ID])
+	return saved
+}
+
+func (t *memoryTables) deleteList(id int64) {
+	delete(t.lists, id)
+
+	for key := range t.savedPlaces {
+		if key.listID == id {
+			delete(t.savedPlaces, key)
+		}
+	}
+}
+
+func (t *memoryTables) deleteActivity(id int64) {
+	delete(t.activities, id)
+
+	for locationID, location := range t.locations {
+		if location.ActivityID == id {
+			delete(t.locations, locationID)
+		}
+	}
+
+	t.forgetReminded("activity", id, "")
+}
+
+// forgetReminded deletes who a booking item's reminders reached, for one
+// deadline or, if deadline is empty, all of them.
+func (t *memoryTables) forgetReminded(kind string, itemID int64, deadline string) {
+	for recipient := range t.reminded {
+		if recipient.kind == kind && recipient.itemID == itemID && (deadline == "" || recipient.deadline == deadline) {
+			delete(t.reminded, recipient)
+		}
+	}
+}
+
+// memoryStore is a Models backend that keeps everything in process memory. A
+// single mutex serializes access, and a transaction holds it for its whole
+// duration while working on a copy of the tables, which replaces the original
+// on commit.
+//
+// Because of that lock, code inside Transaction has to go through the tx
+// models it's given. A call through the outer models with the transaction's
+// context returns errOuterCallInTransaction instead of deadlocking; one with an
+// unrelated context still blocks until the transaction ends.
+type memoryStore struct {
+	mu     sync.Mutex
+	tables *memoryTables
+	inTx   bool
+
+	// txCtx is the context of the transaction holding mu, if there is one.
+	txCtx atomic.Pointer[context.Context]
+}
+
+// NewMemoryModels returns models backed by an empty in-memory store. It's meant
+// for development and demos, where running Postgres is a burden; nothing is
+// persisted.
+func NewMemoryModels() Models {
+	return newMemoryModels(&memoryStore{tables: newMemoryTables()})
+}
+
+func newMemoryModels(s *memoryStore) Models {
+	return Models{
+		transaction: s.transaction,
+		Activities:  memoryActivities{s},
+		Bookings:    memoryBookings{s},
+		Drafts:      memoryDrafts{s},
+		Inboxes:     memoryInboxes{s},
+		Lists:       memoryLists{s},
+		Locations:   memoryLocations{s},
+		Permissions: memoryPermissions{s},
+		Places:      memoryPlaces{s},
+		SavedPlaces: memorySavedPlaces{s},
+		Search:      memorySearch{s},
+		Segments:    memorySegments{s},
+		Stays:       memoryStays{s},
+		Tokens:      memoryTokens{s},
+		TripGoers:   memoryTripGoers{s},
+		Trips:       memoryTrips{s},
+		Users:       memoryUsers{s},
+	}
+}
+
+// do runs fn with exclusive access to the tables.
+func (s *memoryStore) do(ctx context.Context, fn func(t *memoryTables) error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	if txCtx := s.txCtx.Load(); txCtx != nil && *txCtx == ctx {
+		return errOuterCallInTransaction
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return fn(s.tables)
+}
+
+// atomically is do for changes spanning several rows: fn works on a copy of the
+// tables, which is only kept if fn succeeds.
+func (s *memoryStore) atomically(ctx context.Context, fn func(t *memoryTables) error) error {
+	return s.do(ctx, func(t *memoryTables) error {
+		c := t.clone()
+
+		err := fn(c)
+		if err != nil {
+			return err
+		}
+
+		s.tables = c
+		return nil
+	})
+}
+
+func (s *memoryStore) transaction(ctx context.Context, fn func(tx Models) error) error {
+	if s.inTx {
+		return fn(newMemoryModels(s))
+	}
+
+	return s.do(ctx, func(t *memoryTables) error {
+		s.txCtx.Store(&ctx)
+		defer s.txCtx.Store(nil)
+
+		tx := &memoryStore{tables: t.clone(), inTx: true}
+
+		err := fn(newMemoryModels(tx))
+		if err != nil {
+			return err
+		}
+
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		s.tables = tx.tables
+		return nil
+	})
+}
+
+func copyUser(user *User) *User {
+	c := *user
+	c.Password = password{hash: slices.Clone(user.Password.hash)}
+	return &c
+}
+
+func copyToken(token *Token) *Token {
+	c := *token
+	c.Hash = slices.Clone(token.Hash)
+	return &c
+}
+
+func copyTrip(trip *Trip) *Trip {
+	c := *trip
+	c.Activities = nil
+	c.Stays = nil
+	c.TripGoers = nil
+	return &c
+}
+
+func copyActivity(activity *Activity) *Activity {
+	c := *activity
+	c.Booking = copyBooking(activity.Booking)
+	c.Tags = slices.Clone(activity.Tags)
+	c.Locations = nil
+
+	if activity.StartTime != nil {
+		startTime := *activity.StartTime
+		c.StartTime = &startTime
+	}
+	if activity.EndTime != nil {
+		endTime := *activity.EndTime
+		c.EndTime = &endTime
+	}
+	if activity.StartDate != nil {
+		startDate := *activity.StartDate
+		c.StartDate = &startDate
+	}
+	if activity.EndDate != nil {
+		endDate := *activity.EndDate
+		c.EndDate = &endDate
+	}
+
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+
+	return &c
+}
+
+func copyList(list *List) *List {
+	c := *list
+	c.SavedPlaces = nil
+	return &c
+}
+
+func copySavedPlace(saved *SavedPlace) *SavedPlace {
+	c := *saved
+	c.Place = &Place{ID: saved.Place.ID}
+
+	if saved.Rating != nil {
+		rating := *saved.Rating
+		c.Rating = &rating
+	}
+
+	return &c
+}
+
+func copyPlace(place *Place) *Place {
+	c := *place
+	return &c
+}
+
+func copyLocation(location *Location) *Location {
+	c := *location
+	return &c
+}
+
+func copySegment(segment *Segment) *Segment {
+	c := *segment
+	c.Travelers = slices.Clone(segment.Travelers)
+	c.Departure.PlaceID = copyInt64(segment.Departure.PlaceID)
+	c.Arrival.PlaceID = copyInt64(segment.Arrival.PlaceID)
+
+	if c.Travelers == nil {
+		c.Travelers = []int64{}
+	}
+
+	return &c
+}
+
+func copyInbox(inbox *Inbox) *Inbox {
+	c := *inbox
+	return &c
+}
+
+func copyDraft(draft *Draft) *Draft {
+	c := *draft
+
+	if draft.Activity != nil {
+		c.Activity = copyActivity(draft.Activity)
+	}
+	if draft.Stay != nil {
+		c.Stay = copyStay(draft.Stay)
+	}
+
+	return &c
+}
+
+func copyBooking(booking Booking) Booking {
+	c := booking
+	c.Cost = copyInt64(booking.Cost)
+	c.CancellationDeadline = copyTime(booking.CancellationDeadline)
+	c.CancellationRemindedAt = copyTime(booking.CancellationRemindedAt)
+	c.PaymentRemindedAt = copyTime(booking.PaymentRemindedAt)
+
+	if booking.PaymentDue != nil {
+		paymentDue := *booking.PaymentDue
+		c.PaymentDue = &paymentDue
+	}
+
+	return c
+}
+
+// keepReminders carries over when reminders went out from the stored booking,
+// except for deadlines that changed, the way the Postgres models' UPDATEs do.
+func (b *Booking) keepReminders(stored Booking) {
+	b.CancellationRemindedAt, b.PaymentRemindedAt = nil, nil
+
+	if equalTimes(b.CancellationDeadline, stored.CancellationDeadline) {
+		b.CancellationRemindedAt = copyTime(stored.CancellationRemindedAt)
+	}
+
+	if (b.PaymentDue == nil && stored.PaymentDue == nil) ||
+		(b.PaymentDue != nil && stored.PaymentDue != nil && b.PaymentDue.Equal(stored.PaymentDue.Time)) {
+		b.PaymentRemindedAt = copyTime(stored.PaymentRemindedAt)
+	}
+}
+
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	c := *t
+	return &c
+}
+
+func copyInt64(id *int64) *int64 {
+	if id == nil {
+		return nil
+	}
+
+	c := *id
+	return &c
+}
+
+func copyStay(stay *Stay) *Stay {
+	c := *stay
+	c.Booking = copyBooking(stay.Booking)
+	c.Tags = slices.Clone(stay.Tags)
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+	return &c
+}
+
+// now is the current time at the precision of a timestamp(0) column.
+func now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// coordinate rounds a latitude or longitude the way a decimal(9, 6) column
+// does.
+func coordinate(f float64) float64 {
+	return math.Round(f*1e6) / 1e6
+}
+
+// sortedRows returns the rows of a table ordered by id.
+func sortedRows[T any](rows map[int64]*T) []*T {
+	ids := make([]int64, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	sorted := make([]*T, len(ids))
+	for i, id := range ids {
+		sorted[i] = rows[id]
+	}
+
+	return sorted
+}
+
+// hasTags reports whether tags contains every one of want, like tags @> want.
+func hasTags(tags, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// memoryListing is the in-memory counterpart of a Listing. For each sortable
+// and filterable field it returns the value the SQL expression would: a
+// string, int64, float64, bool, time.Time or []string of tags, or nil for NULL.
+type memoryListing[T any] struct {
+	listing Listing
+	sort    map[string]func(*T) any
+	filter  map[string]func(*T) any
+	id      func(*T) int64
+}
+
+// list filters, sorts and paginates items the same way list() does in SQL,
+// including keyset pagination with cursors.
+func (l memoryListing[T]) list(items []*T, filters Filters) ([]*T, Metadata) {
+	sortValue, ok := l.sort[filters.sortColumn()]
+	if !ok {
+		panic("unsafe sort parameter: " + filters.Sort)
+	}
+
+	matched := []*T{}
+
+	for _, item := range items {
+		if l.matches(item, filters) {
+			matched = append(matched, item)
+		}
+	}
+
+	if filters.usesCursor() {
+		c := filters.cursor()
+
+		after := (filters.sortDirection() == "DESC") == filters.backwards()
+
+		matched = slices.DeleteFunc(matched, func(item *T) bool {
+			value := sortValue(item)
+
+			order := compareValues(value, parseValueLike(value, c.Value))
+			if order == 0 {
+				order = cmp.Compare(l.id(item), c.ID)
+			}
+
+			if after {
+				return order <= 0
+			}
+			return order >= 0
+		})
+	}
+
+	descending := filters.sortDirection() == "DESC"
+
+	slices.SortStableFunc(matched, func(a, b *T) int {
+		order := compareValues(sortValue(a), sortValue(b))
+		if descending {
+			order = -order
+		}
+
+		idOrder := cmp.Compare(l.id(a), l.id(b))
+		if descending {
+			idOrder = -idOrder
+		}
+
+		if filters.backwards() {
+			order, idOrder = -order, -idOrder
+		}
+
+		if order != 0 {
+			return order
+		}
+		return idOrder
+	})
+
+	totalRecords := 0
+	if !filters.usesCursor() {
+		totalRecords = len(matched)
+	}
+
+	start := min(filters.offset(), len(matched))
+	end := min(start+filters.limit(), len(matched))
+	page := matched[start:end]
+
+	// count(*) OVER() comes back on the rows of the page, so a page past the
+	// end has no total either
+	if len(page) == 0 {
+		totalRecords = 0
+	}
+
+	keys := make([]Cursor, len(page))
+	for i, item := range page {
+		keys[i] = Cursor{Sort: filters.Sort, Value: formatValue(sortValue(item)), ID: l.id(item)}
+	}
+
+	return paginate(filters, page, keys, totalRecords)
+}
+
+func (l memoryListing[T]) matches(item *T, filters Filters) bool {
+	for _, c := range filters.Conditions {
+		field, ok := filters.FilterSafelist[c.Field]
+		if !ok {
+			panic("unsafe filter parameter: " + c.Field)
+		}
+
+		value := l.filter[c.Field](item)
+		if value == nil {
+			// comparisons with NULL are never true
+			return false
+		}
+
+		if !matchCondition(value, field.Type, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchCondition(value any, fieldType string, c Condition) bool {
+	if fieldType == FieldTags {
+		tags := value.([]string)
+
+		switch c.Operator {
+		case OpIn:
+			return slices.ContainsFunc(c.Values, func(tag string) bool { return slices.Contains(tags, tag) })
+		case OpAll:
+			return hasTags(tags, c.Values)
+		default:
+			panic("unsafe filter operator: " + c.Operator)
+		}
+	}
+
+	switch c.Operator {
+	case OpContains:
+		return strings.Contains(strings.ToLower(value.(string)), strings.ToLower(c.Values[0]))
+	case OpIn:
+		for _, v := range c.Values {
+			if compareValues(value, parseFieldValue(fieldType, v)) == 0 {
+				return true
+			}
+		}
+		return false
+	case OpBetween:
+		return compareValues(value, parseFieldValue(fieldType, c.Values[0])) >= 0 &&
+			compareValues(value, parseFieldValue(fieldType, c.Values[1])) <= 0
+	}
+
+	order := compareValues(value, parseFieldValue(fieldType, c.Values[0]))
+
+	switch c.Operator {
+	case OpEq:
+		return order == 0
+	case OpNe:
+		return order != 0
+	case OpLt:
+		return order < 0
+	case OpLte:
+		return order <= 0
+	case OpGt:
+		return order > 0
+	case OpGte:
+		return order >= 0
+	default:
+		panic("unsafe filter operator: " + c.Operator)
+	}
+}
+
+// parseFieldValue converts a validated filter value to the type memory
+// listings compare against.
+func parseFieldValue(fieldType, s string) any {
+	switch fieldType {
+	case FieldInt:
+		i, _ := strconv.ParseInt(s, 10, 64)
+		return i
+	case FieldFloat:
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	case FieldTime:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			d, _ := ParseDate(s)
+			t = d.Time
+		}
+		return t
+	case FieldDate:
+		d, _ := ParseDate(s)
+		return d.Time
+	case FieldBool:
+		b, _ := strconv.ParseBool(s)
+		return b
+	default:
+		return s
+	}
+}
+
+// compareValues orders two values of the same type. NULL sorts after
+// everything else, as it does in ascending Postgres order.
+func compareValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case !a:
+			return -1
+		default:
+			return 1
+		}
+	default:
+		panic(fmt.Sprintf("memory store: can't compare %T", a))
+	}
+}
+
+// formatValue renders a sort value for a cursor, and parseValueLike reads it
+// back as the same type as sample.
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		return ""
+	}
+}
+
+func parseValueLike(sample any, s string) any {
+	switch sample.(type) {
+	case int64:
+		return parseFieldValue(FieldInt, s)
+	case float64:
+		return parseFieldValue(FieldFloat, s)
+	case time.Time:
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t
+	case bool:
+		return parseFieldValue(FieldBool, s)
+	case nil:
+		return nil
+	default:
+		return s
+	}
+}
+
+// words splits text into lower case words, roughly the way the 'simple' text
+// search configuration does.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r > 127)
+	})
+}
@@ -0,0 +1,2055 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var memoryActivityListing = memoryListing[Activity]{
+	listing: ActivityListing,
+	sort: map[string]func(*Activity) any{
+		"id":   func(a *Activity) any { return a.ID },
+		"name": func(a *Activity) any { return a.Name },
+		"start_time": func(a *Activity) any {
+			switch {
+			case a.StartTime != nil:
+				return *a.StartTime
+			case a.StartDate != nil:
+				return a.StartDate.Time
+			default:
+				return ideasEpoch.Add(time.Duration(a.Position) * time.Second)
+			}
+		},
+		"position": func(a *Activity) any { return int64(a.Position) },
+		"category": func(a *Activity) any { return a.Category },
+	},
+	filter: map[string]func(*Activity) any{
+		"name":       func(a *Activity) any { return a.Name },
+		"category":   func(a *Activity) any { return a.Category },
+		"schedule":   func(a *Activity) any { return a.Schedule },
+		"start_time": func(a *Activity) any { return nullableTime(a.StartTime) },
+		"end_time":   func(a *Activity) any { return nullableTime(a.EndTime) },
+		"start_date": func(a *Activity) any { return nullableDate(a.StartDate) },
+		"end_date":   func(a *Activity) any { return nullableDate(a.EndDate) },
+		"position":   func(a *Activity) any { return int64(a.Position) },
+		"tags":       func(a *Activity) any { return a.Tags },
+	},
+	id: func(a *Activity) int64 { return a.ID },
+}
+
+var memoryLocationListing = memoryListing[Location]{
+	listing: LocationListing,
+	sort: map[string]func(*Location) any{
+		"id":       func(l *Location) any { return l.ID },
+		"name":     func(l *Location) any { return l.Name },
+		"activity": func(l *Location) any { return l.ActivityID },
+	},
+	filter: map[string]func(*Location) any{
+		"name":            func(l *Location) any { return l.Name },
+		"address":         func(l *Location) any { return l.Address },
+		"activity":        func(l *Location) any { return l.ActivityID },
+		"place":           func(l *Location) any { return l.PlaceID },
+		"google_place_id": func(l *Location) any { return nullableString(l.GooglePlaceID) },
+		"lat":             func(l *Location) any { return l.Lat },
+		"lng":             func(l *Location) any { return l.Lng },
+	},
+	id: func(l *Location) int64 { return l.ID },
+}
+
+var memoryStayListing = memoryListing[Stay]{
+	listing: StayListing,
+	sort: map[string]func(*Stay) any{
+		"id":         func(s *Stay) any { return s.ID },
+		"name":       func(s *Stay) any { return s.Name },
+		"start_time": func(s *Stay) any { return s.StartTime },
+		"end_time":   func(s *Stay) any { return s.EndTime },
+		"type":       func(s *Stay) any { return s.Type },
+	},
+	filter: map[string]func(*Stay) any{
+		"name":       func(s *Stay) any { return s.Name },
+		"type":       func(s *Stay) any { return s.Type },
+		"lat":        func(s *Stay) any { return s.Lat },
+		"lng":        func(s *Stay) any { return s.Lng },
+		"start_time": func(s *Stay) any { return s.StartTime },
+		"end_time":   func(s *Stay) any { return s.EndTime },
+		"tags":       func(s *Stay) any { return s.Tags },
+	},
+	id: func(s *Stay) int64 { return s.ID },
+}
+
+var memoryTripListing = memoryListing[Trip]{
+	listing: TripListing,
+	sort: map[string]func(*Trip) any{
+		"id":         func(t *Trip) any { return t.ID },
+		"name":       func(t *Trip) any { return t.Name },
+		"city":       func(t *Trip) any { return t.City },
+		"start_date": func(t *Trip) any { return t.StartDate },
+		"end_date":   func(t *Trip) any { return t.EndDate },
+	},
+	filter: map[string]func(*Trip) any{
+		"name":       func(t *Trip) any { return t.Name },
+		"city":       func(t *Trip) any { return t.City },
+		"state_code": func(t *Trip) any { return t.StateCode },
+		"lat":        func(t *Trip) any { return t.Lat },
+		"lng":        func(t *Trip) any { return t.Lng },
+		"start_date": func(t *Trip) any { return t.StartDate },
+		"end_date":   func(t *Trip) any { return t.EndDate },
+		"created_by": func(t *Trip) any { return t.CreatedBy },
+	},
+	id: func(t *Trip) int64 { return t.ID },
+}
+
+var memoryTripGoerListing = memoryListing[User]{
+	listing: TripGoerListing,
+	sort: map[string]func(*User) any{
+		"id":    func(u *User) any { return u.ID },
+		"name":  func(u *User) any { return u.Name },
+		"email": func(u *User) any { return u.Email },
+	},
+	filter: map[string]func(*User) any{
+		"name":      func(u *User) any { return u.Name },
+		"email":     func(u *User) any { return u.Email },
+		"activated": func(u *User) any { return u.Activated },
+	},
+	id: func(u *User) int64 { return u.ID },
+}
+
+var memoryUserListing = memoryListing[User]{
+	listing: UserListing,
+	sort: map[string]func(*User) any{
+		"id":         func(u *User) any { return u.ID },
+		"name":       func(u *User) any { return u.Name },
+		"email":      func(u *User) any { return u.Email },
+		"created_at": func(u *User) any { return u.CreatedAt },
+	},
+	filter: map[string]func(*User) any{
+		"name":       func(u *User) any { return u.Name },
+		"email":      func(u *User) any { return u.Email },
+		"activated":  func(u *User) any { return u.Activated },
+		"created_at": func(u *User) any { return u.CreatedAt },
+	},
+	id: func(u *User) int64 { return u.ID },
+}
+
+func nullableTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+func nullableDate(d *Date) any {
+	if d == nil {
+		return nil
+	}
+	return d.Time
+}
+
+// nullableString is for columns that are NULL where the model has "".
+func nullableString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+type memoryActivities struct {
+	s *memoryStore
+}
+
+func (m memoryActivities) Get(ctx context.Context, id int64) (*Activity, error) {
+	var activity *Activity
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.activities[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		activity = copyActivity(stored)
+		return nil
+	})
+
+	return activity, err
+}
+
+func (m memoryActivities) Insert(ctx context.Context, activity *Activity) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		return insertMemoryActivity(t, activity)
+	})
+}
+
+func insertMemoryActivity(t *memoryTables, activity *Activity) error {
+	if _, ok := t.trips[activity.TripID]; !ok {
+		return errForeignKey
+	}
+
+	if !slices.Contains(Schedules, activity.Schedule) || !slices.Contains(BookingStatuses, activity.Booking.Status) {
+		return errCheck
+	}
+
+	if activity.Schedule == ScheduleUnscheduled && activity.Position == 0 {
+		last := 0
+		for _, other := range t.activities {
+			if other.TripID == activity.TripID && other.Schedule == ScheduleUnscheduled {
+				last = max(last, other.Position)
+			}
+		}
+		activity.Position = last + 1
+	}
+
+	activity.ID = t.nextID("activities")
+	activity.CreatedAt = now()
+	activity.UpdatedAt = activity.CreatedAt
+	activity.Version = 1
+
+	t.activities[activity.ID] = copyActivity(activity)
+	return nil
+}
+
+func (m memoryActivities) GetAllByTrip(ctx context.Context, tripID int64) ([]*Activity, error) {
+	activities := []*Activity{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, activity := range sortedRows(t.activities) {
+			if activity.TripID == tripID {
+				activities = append(activities, copyActivity(activity))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// ideas last, then COALESCE(start_time, start_date) with NULLs last, then
+	// position and id
+	slices.SortStableFunc(activities, func(a, b *Activity) int {
+		return cmp.Or(
+			compareValues(a.Schedule == ScheduleUnscheduled, b.Schedule == ScheduleUnscheduled),
+			compareValues(scheduledAt(a), scheduledAt(b)),
+			cmp.Compare(a.Position, b.Position),
+			cmp.Compare(a.ID, b.ID),
+		)
+	})
+
+	return activities, nil
+}
+
+func scheduledAt(a *Activity) any {
+	if a.StartTime != nil {
+		return *a.StartTime
+	}
+	return nullableDate(a.StartDate)
+}
+
+func (m memoryActivities) GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Activity, Metadata, error) {
+	var activities []*Activity
+	var metadata Metadata
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		rows := []*Activity{}
+		facets := Facets{}
+
+		for _, activity := range sortedRows(t.activities) {
+			if activity.TripID != tripID {
+				continue
+			}
+
+			facets.add("category", activity.Category, 1)
+			for _, tag := range activity.Tags {
+				facets.add("tag", tag, 1)
+			}
+
+			rows = append(rows, copyActivity(activity))
+		}
+
+		activities, metadata = memoryActivityListing.list(rows, filters)
+		metadata.Facets = facets
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return activities, metadata, nil
+}
+
+func (m memoryActivities) Update(ctx context.Context, activity *Activity) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		return updateMemoryActivity(t, activity)
+	})
+}
+
+func updateMemoryActivity(t *memoryTables, activity *Activity) error {
+	stored, ok := t.activities[activity.ID]
+	if !ok || stored.Version != activity.Version {
+		return ErrEditConflict
+	}
+
+	if !slices.Contains(Schedules, activity.Schedule) || !slices.Contains(BookingStatuses, activity.Booking.Status) {
+		return errCheck
+	}
+
+	activity.Version++
+	activity.TripID = stored.TripID
+	activity.Booking.keepReminders(stored.Booking)
+	activity.CreatedAt = stored.CreatedAt
+	activity.UpdatedAt = now()
+
+	t.activities[activity.ID] = copyActivity(activity)
+	return nil
+}
+
+func (m memoryActivities) SaveBatch(ctx context.Context, activities []*Activity) error {
+	// on failure the caller's activities may already have IDs and versions
+	// from the rolled back statements, as they would with Postgres
+	return m.s.atomically(ctx, func(t *memoryTables) error {
+		for _, activity := range activities {
+			var err error
+			if activity.ID == 0 {
+				err = insertMemoryActivity(t, activity)
+			} else {
+				err = updateMemoryActivity(t, activity)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m memoryActivities) ReorderIdeas(ctx context.Context, tripID int64, activityIDs []int64) error {
+	return m.s.atomically(ctx, func(t *memoryTables) error {
+		updated := make(map[int64]bool)
+
+		for i, id := range activityIDs {
+			activity, ok := t.activities[id]
+			if !ok || activity.TripID != tripID || activity.Schedule != ScheduleUnscheduled || updated[id] {
+				continue
+			}
+
+			activity.Position = i + 1
+			activity.Version++
+			activity.UpdatedAt = now()
+			updated[id] = true
+		}
+
+		if len(updated) != len(activityIDs) {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+func (m memoryActivities) Delete(ctx context.Context, id int64) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.activities[id]; !ok {
+			return ErrRecordNotFound
+		}
+
+		t.deleteActivity(id)
+		return nil
+	})
+}
+
+func (m memoryActivities) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	return m.s.do(ctx, func(t *memoryTables) error {
+		activity, ok := t.activities[id]
+		if !ok || activity.Version != version {
+			return ErrEditConflict
+		}
+
+		t.deleteActivity(id)
+		return nil
+	})
+}
+
+type memoryBookings struct {
+	s *memoryStore
+}
+
+// bookingItems returns the activities and stays of a trip, or of every trip if
+// tripID is 0, as booking items with their trip IDs.
+func (t *memoryTables) bookingItems(tripID int64) ([]*BookingItem, []int64) {
+	var items []*BookingItem
+	var tripIDs []int64
+
+	for _, activity := range sortedRows(t.activities) {
+		if tripID == 0 || activity.TripID == tripID {
+			items = append(items, &BookingItem{Kind: "activity", ID: activity.ID, Name: activity.Name, Booking: copyBooking(activity.Booking)})
+			tripIDs = append(tripIDs, activity.TripID)
+		}
+	}
+
+	for _, stay := range sortedRows(t.stays) {
+		if tripID == 0 || stay.TripID == tripID {
+			items = append(items, &BookingItem{Kind: "stay", ID: stay.ID, Name: stay.Name, Booking: copyBooking(stay.Booking)})
+			tripIDs = append(tripIDs, stay.TripID)
+		}
+	}
+
+	return items, tripIDs
+}
+
+func (m memoryBookings) GetAllByTrip(ctx context.Context, tripID int64) ([]*BookingItem, error) {
+	items := []*BookingItem{}
+	now := time.Now()
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		all, _ := t.bookingItems(tripID)
+
+		for _, item := range all {
+			if item.Booking.NeedsAction(now) {
+				item.Deadline = item.Booking.NextDeadline(now)
+				items = append(items, item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// items are already in kind and id order, so a stable sort by deadline
+	// leaves ties that way
+	slices.SortStableFunc(items, func(a, b *BookingItem) int {
+		switch {
+		case a.Deadline == nil && b.Deadline == nil:
+			return 0
+		case a.Deadline == nil:
+			return 1
+		case b.Deadline == nil:
+			return -1
+		default:
+			return a.Deadline.Compare(*b.Deadline)
+		}
+	})
+
+	return items, nil
+}
+
+func (m memoryBookings) GetDueReminders(ctx context.Context, lead time.Duration) ([]*BookingReminder, error) {
+	reminders := []*BookingReminder{}
+	now := time.Now()
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		items, tripIDs := t.bookingItems(0)
+
+		for i, item := range items {
+			booking := item.Booking
+			if booking.Status != BookingStatusBooked {
+				continue
+			}
+
+			due := map[string]*time.Time{}
+
+			if booking.CancellationRemindedAt == nil && booking.CancellationDeadline != nil {
+				due[BookingDeadlineCancellation] = booking.CancellationDeadline
+			}
+
+			if booking.PaymentRemindedAt == nil && booking.PaymentDue != nil && !booking.PaymentDue.Before(DateOf(now).Time) {
+				due[BookingDeadlinePayment] = &booking.PaymentDue.Time
+			}
+
+			for _, deadline := range []string{BookingDeadlineCancellation, BookingDeadlinePayment} {
+				at := due[deadline]
+				if at == nil || (deadline == BookingDeadlineCancellation && !at.After(now)) || at.After(now.Add(lead)) {
+					continue
+				}
+
+				reminder := &BookingReminder{Item: *item, TripID: tripIDs[i], Deadline: deadline, Due: *at}
+				reminder.Item.Deadline = &reminder.Due
+				reminders = append(reminders, reminder)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(reminders, func(a, b *BookingReminder) int {
+		return a.Due.Compare(b.Due)
+	})
+
+	return reminders, nil
+}
+
+func (m memoryBookings) MarkReminded(ctx context.Context, reminder *BookingReminder) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		var booking *Booking
+
+		switch reminder.Item.Kind {
+		case "activity":
+			if activity, ok := t.activities[reminder.Item.ID]; ok {
+				booking = &activity.Booking
+			}
+		case "stay":
+			if stay, ok := t.stays[reminder.Item.ID]; ok {
+				booking = &stay.Booking
+			}
+		default:
+			return fmt.Errorf("unknown booking reminder %s for %s", reminder.Deadline, reminder.Item.Kind)
+		}
+
+		if booking == nil {
+			return nil
+		}
+
+		t.forgetReminded(reminder.Item.Kind, reminder.Item.ID, reminder.Deadline)
+
+		remindedAt := now()
+
+		switch reminder.Deadline {
+		case BookingDeadlineCancellation:
+			booking.CancellationRemindedAt = &remindedAt
+		case BookingDeadlinePayment:
+			booking.PaymentRemindedAt = &remindedAt
+		default:
+			return fmt.Errorf("unknown booking reminder %s for %s", reminder.Deadline, reminder.Item.Kind)
+		}
+
+		return nil
+	})
+}
+
+func (m memoryBookings) GetRemindedRecipients(ctx context.Context, reminder *BookingReminder) ([]string, error) {
+	emails := []string{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for recipient := range t.reminded {
+			if recipient == reminderRecipientOf(reminder, recipient.email) {
+				emails = append(emails, recipient.email)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(emails)
+
+	return emails, nil
+}
+
+func (m memoryBookings) MarkRecipientReminded(ctx context.Context, reminder *BookingReminder, email string) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		switch reminder.Item.Kind {
+		case "activity":
+			if _, ok := t.activities[reminder.Item.ID]; !ok {
+				return errForeignKey
+			}
+		case "stay":
+			if _, ok := t.stays[reminder.Item.ID]; !ok {
+				return errForeignKey
+			}
+		default:
+			return fmt.Errorf("unknown booking reminder %s for %s", reminder.Deadline, reminder.Item.Kind)
+		}
+
+		t.reminded[reminderRecipientOf(reminder, email)] = true
+		return nil
+	})
+}
+
+func reminderRecipientOf(reminder *BookingReminder, email string) reminderRecipient {
+	return reminderRecipient{
+		kind:     reminder.Item.Kind,
+		itemID:   reminder.Item.ID,
+		deadline: reminder.Deadline,
+		due:      reminder.Due.UnixMicro(),
+		email:    strings.ToLower(email),
+	}
+}
+
+type memoryDrafts struct {
+	s *memoryStore
+}
+
+func (m memoryDrafts) Insert(ctx context.Context, draft *Draft) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.trips[draft.TripID]; !ok {
+			return errForeignKey
+		}
+
+		if (draft.Kind == DraftKindActivity) != (draft.Activity != nil) || (draft.Kind == DraftKindStay) != (draft.Stay != nil) {
+			return errCheck
+		}
+
+		if draft.MessageID != "" {
+			for _, other := range t.drafts {
+				if other.TripID == draft.TripID && other.MessageID == draft.MessageID && other.Part == draft.Part {
+					return ErrDuplicateMessage
+				}
+			}
+		}
+
+		draft.ID = t.nextID("drafts")
+		draft.CreatedAt = now()
+
+		t.drafts[draft.ID] = copyDraft(draft)
+		return nil
+	})
+}
+
+func (m memoryDrafts) Get(ctx context.Context, id int64) (*Draft, error) {
+	var draft *Draft
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.drafts[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		draft = copyDraft(stored)
+		return nil
+	})
+
+	return draft, err
+}
+
+func (m memoryDrafts) GetAllByTrip(ctx context.Context, tripID int64) ([]*Draft, error) {
+	return m.find(ctx, func(draft *Draft) bool {
+		return draft.TripID == tripID
+	})
+}
+
+func (m memoryDrafts) GetAllByMessage(ctx context.Context, tripID int64, messageID string) ([]*Draft, error) {
+	return m.find(ctx, func(draft *Draft) bool {
+		return draft.TripID == tripID && draft.MessageID == messageID
+	})
+}
+
+// find returns copies of the drafts that match, in ID order, which is also
+// the order they were created in.
+func (m memoryDrafts) find(ctx context.Context, match func(draft *Draft) bool) ([]*Draft, error) {
+	drafts := []*Draft{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, draft := range sortedRows(t.drafts) {
+			if match(draft) {
+				drafts = append(drafts, copyDraft(draft))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return drafts, nil
+}
+
+func (m memoryDrafts) Delete(ctx context.Context, id int64) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.drafts[id]; !ok {
+			return ErrRecordNotFound
+		}
+
+		delete(t.drafts, id)
+		return nil
+	})
+}
+
+type memoryInboxes struct {
+	s *memoryStore
+}
+
+func (m memoryInboxes) New(ctx context.Context, tripID int64) (*Inbox, error) {
+	token, err := generateInboxToken()
+	if err != nil {
+		return nil, err
+	}
+
+	inbox := &Inbox{TripID: tripID, Token: token}
+
+	err = m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.trips[tripID]; !ok {
+			return errForeignKey
+		}
+
+		for _, other := range t.inboxes {
+			if other.Token == token {
+				return errDuplicateKey
+			}
+		}
+
+		inbox.CreatedAt = now()
+
+		t.inboxes[tripID] = copyInbox(inbox)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return inbox, nil
+}
+
+func (m memoryInboxes) Get(ctx context.Context, tripID int64) (*Inbox, error) {
+	var inbox *Inbox
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.inboxes[tripID]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		inbox = copyInbox(stored)
+		return nil
+	})
+
+	return inbox, err
+}
+
+func (m memoryInboxes) GetByToken(ctx context.Context, token string) (*Inbox, error) {
+	var inbox *Inbox
+
+	token = strings.ToLower(token)
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, stored := range t.inboxes {
+			if stored.Token == token {
+				inbox = copyInbox(stored)
+				return nil
+			}
+		}
+
+		return ErrRecordNotFound
+	})
+
+	return inbox, err
+}
+
+type memoryLists struct {
+	s *memoryStore
+}
+
+func (m memoryLists) Insert(ctx context.Context, list *List) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.users[list.UserID]; !ok {
+			return errForeignKey
+		}
+
+		for _, stored := range t.lists {
+			if stored.UserID == list.UserID && stored.Name == list.Name {
+				return ErrDuplicateListName
+			}
+		}
+
+		list.ID = t.nextID("lists")
+		list.CreatedAt = now()
+		list.UpdatedAt = list.CreatedAt
+		list.Version = 1
+
+		t.lists[list.ID] = copyList(list)
+		return nil
+	})
+}
+
+func (m memoryLists) Get(ctx context.Context, id int64) (*List, error) {
+	var list *List
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.lists[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		list = copyList(stored)
+		return nil
+	})
+
+	return list, err
+}
+
+func (m memoryLists) GetAllForUser(ctx context.Context, userID int64) ([]*List, error) {
+	lists := []*List{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, list := range sortedRows(t.lists) {
+			if list.UserID == userID {
+				lists = append(lists, copyList(list))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(lists, func(a, b *List) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return lists, nil
+}
+
+func (m memoryLists) Update(ctx context.Context, list *List) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.lists[list.ID]
+		if !ok || stored.Version != list.Version {
+			return ErrEditConflict
+		}
+
+		for _, other := range t.lists {
+			if other.ID != list.ID && other.UserID == stored.UserID && other.Name == list.Name {
+				return ErrDuplicateListName
+			}
+		}
+
+		list.Version++
+		list.UserID = stored.UserID
+		list.CreatedAt = stored.CreatedAt
+		list.UpdatedAt = now()
+
+		t.lists[list.ID] = copyList(list)
+		return nil
+	})
+}
+
+func (m memoryLists) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	return m.s.do(ctx, func(t *memoryTables) error {
+		list, ok := t.lists[id]
+		if !ok || list.Version != version {
+			return ErrEditConflict
+		}
+
+		t.deleteList(id)
+		return nil
+	})
+}
+
+type memoryLocations struct {
+	s *memoryStore
+}
+
+func (m memoryLocations) Insert(ctx context.Context, location *Location) error {
+	return m.s.atomically(ctx, func(t *memoryTables) error {
+		if _, ok := t.activities[location.ActivityID]; !ok {
+			return errForeignKey
+		}
+
+		place := location.place()
+		if location.PlaceID != 0 {
+			stored, ok := t.places[location.PlaceID]
+			if !ok {
+				return ErrRecordNotFound
+			}
+			place = copyPlace(stored)
+		} else {
+			ensureMemoryPlace(t, place)
+		}
+
+		for _, stored := range sortedRows(t.locations) {
+			if stored.ActivityID == location.ActivityID && stored.PlaceID == place.ID {
+				*location = *t.location(stored)
+				return nil
+			}
+		}
+
+		location.ID = t.nextID("locations")
+		location.CreatedAt = now()
+
+		t.locations[location.ID] = &Location{
+			ID:         location.ID,
+			ActivityID: location.ActivityID,
+			PlaceID:    place.ID,
+			CreatedAt:  location.CreatedAt,
+		}
+
+		location.setPlace(place)
+		return nil
+	})
+}
+
+func (m memoryLocations) Get(ctx context.Context, id int64) (*Location, error) {
+	var location *Location
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.locations[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		location = t.location(stored)
+		return nil
+	})
+
+	return location, err
+}
+
+func (m memoryLocations) Update(ctx context.Context, location *Location) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		place := location.place()
+
+		err := updateMemoryPlace(t, place)
+		if err != nil {
+			return err
+		}
+
+		location.Version = place.Version
+		return nil
+	})
+}
+
+func (m memoryLocations) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	return m.s.do(ctx, func(t *memoryTables) error {
+		location, ok := t.locations[id]
+		if !ok || t.places[location.PlaceID].Version != version {
+			return ErrEditConflict
+		}
+
+		delete(t.locations, id)
+		return nil
+	})
+}
+
+func (m memoryLocations) GetAllByActivity(ctx context.Context, activityID int64) ([]*Location, error) {
+	locations := []*Location{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, location := range sortedRows(t.locations) {
+			if location.ActivityID == activityID {
+				locations = append(locations, t.location(location))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
+
+func (m memoryLocations) GetAllByActivities(ctx context.Context, activityIDs []int64) (map[int64][]*Location, error) {
+	locations := make(map[int64][]*Location)
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, location := range sortedRows(t.locations) {
+			if slices.Contains(activityIDs, location.ActivityID) {
+				locations[location.ActivityID] = append(locations[location.ActivityID], t.location(location))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
+
+func (m memoryLocations) LoadForActivities(ctx context.Context, activities []*Activity) error {
+	return loadLocations(ctx, m, activities)
+}
+
+func (m memoryLocations) GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Location, Metadata, error) {
+	var locations []*Location
+	var metadata Metadata
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		rows := []*Location{}
+
+		for _, location := range sortedRows(t.locations) {
+			if t.activities[location.ActivityID].TripID == tripID {
+				rows = append(rows, t.location(location))
+			}
+		}
+
+		locations, metadata = memoryLocationListing.list(rows, filters)
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return locations, metadata, nil
+}
+
+type memoryPermissions struct {
+	s *memoryStore
+}
+
+func (m memoryPermissions) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	var permissions Permissions
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, code := range PermissionCodes {
+			if slices.Contains(t.permissions[userID], code) {
+				permissions = append(permissions, code)
+			}
+		}
+		return nil
+	})
+
+	return permissions, err
+}
+
+func (m memoryPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	return m.s.atomically(ctx, func(t *memoryTables) error {
+		if _, ok := t.users[userID]; !ok {
+			return errForeignKey
+		}
+
+		for _, code := range PermissionCodes {
+			if !slices.Contains(codes, code) {
+				continue
+			}
+
+			if slices.Contains(t.permissions[userID], code) {
+				return errDuplicateKey
+			}
+
+			t.permissions[userID] = append(t.permissions[userID], code)
+		}
+
+		return nil
+	})
+}
+
+func (m memoryPermissions) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		t.permissions[userID] = slices.DeleteFunc(t.permissions[userID], func(code string) bool {
+			return slices.Contains(codes, code)
+		})
+		return nil
+	})
+}
+
+type memoryPlaces struct {
+	s *memoryStore
+}
+
+func (m memoryPlaces) Get(ctx context.Context, id int64) (*Place, error) {
+	var place *Place
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.places[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		place = copyPlace(stored)
+		return nil
+	})
+
+	return place, err
+}
+
+func (m memoryPlaces) Ensure(ctx context.Context, place *Place) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		ensureMemoryPlace(t, place)
+		return nil
+	})
+}
+
+func ensureMemoryPlace(t *memoryTables, place *Place) {
+	for _, stored := range sortedRows(t.places) {
+		if place.GooglePlaceID != "" && stored.GooglePlaceID == place.GooglePlaceID {
+			*place = *stored
+			return
+		}
+	}
+
+	place.ID = t.nextID("places")
+	place.Lat = coordinate(place.Lat)
+	place.Lng = coordinate(place.Lng)
+	place.CreatedAt = now()
+	place.UpdatedAt = place.CreatedAt
+	place.Version = 1
+
+	t.places[place.ID] = copyPlace(place)
+}
+
+func (m memoryPlaces) Update(ctx context.Context, place *Place) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		return updateMemoryPlace(t, place)
+	})
+}
+
+func updateMemoryPlace(t *memoryTables, place *Place) error {
+	stored, ok := t.places[place.ID]
+	if !ok || stored.Version != place.Version {
+		return ErrEditConflict
+	}
+
+	place.Version++
+	place.Lat = coordinate(place.Lat)
+	place.Lng = coordinate(place.Lng)
+	place.GooglePlaceID = stored.GooglePlaceID
+	place.CreatedAt = stored.CreatedAt
+	place.UpdatedAt = now()
+
+	t.places[place.ID] = copyPlace(place)
+	return nil
+}
+
+func (m memoryPlaces) Autocomplete(ctx context.Context, userID int64, q string, limit int) ([]*Place, error) {
+	places := []*Place{}
+	q = strings.ToLower(q)
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		uses := make(map[int64]int)
+
+		for _, location := range t.locations {
+			if t.visible(userID, t.activities[location.ActivityID].TripID) {
+				uses[location.PlaceID]++
+			}
+		}
+		for link := range t.stayPlaces {
+			if t.visible(userID, t.stays[link.stayID].TripID) {
+				uses[link.placeID]++
+			}
+		}
+
+		for _, place := range sortedRows(t.places) {
+			name, address := strings.ToLower(place.Name), strings.ToLower(place.Address)
+			if uses[place.ID] > 0 && (strings.Contains(name, q) || strings.Contains(address, q)) {
+				places = append(places, copyPlace(place))
+			}
+		}
+
+		prefixed := func(place *Place) int {
+			if strings.HasPrefix(strings.ToLower(place.Name), q) {
+				return 0
+			}
+			return 1
+		}
+
+		slices.SortStableFunc(places, func(a, b *Place) int {
+			return cmp.Or(cmp.Compare(prefixed(a), prefixed(b)), cmp.Compare(uses[b.ID], uses[a.ID]), cmp.Compare(a.Name, b.Name))
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return places[:min(len(places), limit)], nil
+}
+
+func (m memoryPlaces) UsedByUser(ctx context.Context, placeID, userID int64) (bool, error) {
+	var used bool
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, location := range t.locations {
+			if location.PlaceID == placeID && t.visible(userID, t.activities[location.ActivityID].TripID) {
+				used = true
+				return nil
+			}
+		}
+		for link := range t.stayPlaces {
+			if link.placeID == placeID && t.visible(userID, t.stays[link.stayID].TripID) {
+				used = true
+				return nil
+			}
+		}
+		for _, segment := range t.segments {
+			for _, endpoint := range []*int64{segment.Departure.PlaceID, segment.Arrival.PlaceID} {
+				if endpoint != nil && *endpoint == placeID && t.visible(userID, segment.TripID) {
+					used = true
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+
+	return used, err
+}
+
+func (m memoryPlaces) GetAllByStay(ctx context.Context, stayID int64) ([]*Place, error) {
+	places := []*Place{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for link := range t.stayPlaces {
+			if link.stayID == stayID {
+				places = append(places, copyPlace(t.places[link.placeID]))
+			}
+		}
+
+		slices.SortFunc(places, func(a, b *Place) int {
+			linkA, linkB := stayPlace{stayID, a.ID}, stayPlace{stayID, b.ID}
+			return cmp.Or(t.stayPlaces[linkA].Compare(t.stayPlaces[linkB]), cmp.Compare(a.ID, b.ID))
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return places, nil
+}
+
+func (m memoryPlaces) AddToStay(ctx context.Context, stayID, placeID int64) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.stays[stayID]; !ok {
+			return errForeignKey
+		}
+		if _, ok := t.places[placeID]; !ok {
+			return errForeignKey
+		}
+
+		link := stayPlace{stayID, placeID}
+		if _, ok := t.stayPlaces[link]; !ok {
+			t.stayPlaces[link] = now()
+		}
+
+		return nil
+	})
+}
+
+func (m memoryPlaces) RemoveFromStay(ctx context.Context, stayID, placeID int64) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		link := stayPlace{stayID, placeID}
+		if _, ok := t.stayPlaces[link]; !ok {
+			return ErrRecordNotFound
+		}
+
+		delete(t.stayPlaces, link)
+		return nil
+	})
+}
+
+type memorySavedPlaces struct {
+	s *memoryStore
+}
+
+func (m memorySavedPlaces) Insert(ctx context.Context, saved *SavedPlace) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.lists[saved.ListID]; !ok {
+			return errForeignKey
+		}
+		if _, ok := t.places[saved.Place.ID]; !ok {
+			return errForeignKey
+		}
+
+		if saved.Rating != nil && (*saved.Rating < 1 || *saved.Rating > 5) {
+			return errCheck
+		}
+
+		key := savedPlaceKey{saved.ListID, saved.Place.ID}
+		if _, ok := t.savedPlaces[key]; ok {
+			return ErrDuplicateSavedPlace
+		}
+
+		saved.CreatedAt = now()
+		saved.UpdatedAt = saved.CreatedAt
+		saved.Version = 1
+
+		t.savedPlaces[key] = copySavedPlace(saved)
+		saved.Place = copyPlace(t.places[saved.Place.ID])
+		return nil
+	})
+}
+
+func (m memorySavedPlaces) Get(ctx context.Context, listID, placeID int64) (*SavedPlace, error) {
+	var saved *SavedPlace
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.savedPlaces[savedPlaceKey{listID, placeID}]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		saved = t.savedPlace(stored)
+		return nil
+	})
+
+	return saved, err
+}
+
+func (m memorySavedPlaces) GetAllByList(ctx context.Context, listID int64) ([]*SavedPlace, error) {
+	saved := []*SavedPlace{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for key, stored := range t.savedPlaces {
+			if key.listID == listID {
+				saved = append(saved, t.savedPlace(stored))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(saved, func(a, b *SavedPlace) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.Place.ID, b.Place.ID))
+	})
+
+	return saved, nil
+}
+
+func (m memorySavedPlaces) Update(ctx context.Context, saved *SavedPlace) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		key := savedPlaceKey{saved.ListID, saved.Place.ID}
+
+		stored, ok := t.savedPlaces[key]
+		if !ok || stored.Version != saved.Version {
+			return ErrEditConflict
+		}
+
+		if saved.Rating != nil && (*saved.Rating < 1 || *saved.Rating > 5) {
+			return errCheck
+		}
+
+		saved.Version++
+		saved.CreatedAt = stored.CreatedAt
+		saved.UpdatedAt = now()
+
+		t.savedPlaces[key] = copySavedPlace(saved)
+		return nil
+	})
+}
+
+func (m memorySavedPlaces) DeleteVersion(ctx context.Context, listID, placeID int64, version int32) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		key := savedPlaceKey{listID, placeID}
+
+		saved, ok := t.savedPlaces[key]
+		if !ok || saved.Version != version {
+			return ErrEditConflict
+		}
+
+		delete(t.savedPlaces, key)
+		return nil
+	})
+}
+
+type memorySegments struct {
+	s *memoryStore
+}
+
+// segment returns a copy of a stored segment with its times in each endpoint's
+// time zone, as the Postgres model returns them.
+func (t *memoryTables) segment(stored *Segment) *Segment {
+	segment := copySegment(stored)
+	segment.localize()
+	return segment
+}
+
+// checkSegment enforces the constraints on segments and segment_travelers.
+func (t *memoryTables) checkSegment(segment *Segment) error {
+	if !slices.Contains(SegmentModes, segment.Mode) || !segment.Arrival.Time.After(segment.Departure.Time) {
+		return errCheck
+	}
+
+	for _, placeID := range []*int64{segment.Departure.PlaceID, segment.Arrival.PlaceID} {
+		if placeID == nil {
+			continue
+		}
+		if _, ok := t.places[*placeID]; !ok {
+			return errForeignKey
+		}
+	}
+
+	for i, userID := range segment.Travelers {
+		if _, ok := t.users[userID]; !ok {
+			return errForeignKey
+		}
+		if slices.Contains(segment.Travelers[:i], userID) {
+			return errDuplicateKey
+		}
+	}
+
+	return nil
+}
+
+func (m memorySegments) Get(ctx context.Context, id int64) (*Segment, error) {
+	var segment *Segment
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.segments[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		segment = t.segment(stored)
+		return nil
+	})
+
+	return segment, err
+}
+
+func (m memorySegments) Insert(ctx context.Context, segment *Segment) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.trips[segment.TripID]; !ok {
+			return errForeignKey
+		}
+
+		err := t.checkSegment(segment)
+		if err != nil {
+			return err
+		}
+
+		segment.ID = t.nextID("segments")
+		segment.CreatedAt = now()
+		segment.UpdatedAt = segment.CreatedAt
+		segment.Version = 1
+
+		stored := copySegment(segment)
+		slices.Sort(stored.Travelers)
+
+		t.segments[segment.ID] = stored
+		segment.localize()
+		return nil
+	})
+}
+
+func (m memorySegments) Update(ctx context.Context, segment *Segment) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.segments[segment.ID]
+		if !ok || stored.Version != segment.Version {
+			return ErrEditConflict
+		}
+
+		err := t.checkSegment(segment)
+		if err != nil {
+			return err
+		}
+
+		segment.Version++
+		segment.TripID = stored.TripID
+		segment.CreatedAt = stored.CreatedAt
+		segment.UpdatedAt = now()
+
+		updated := copySegment(segment)
+		slices.Sort(updated.Travelers)
+
+		t.segments[segment.ID] = updated
+		segment.localize()
+		return nil
+	})
+}
+
+func (m memorySegments) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	return m.s.do(ctx, func(t *memoryTables) error {
+		segment, ok := t.segments[id]
+		if !ok || segment.Version != version {
+			return ErrEditConflict
+		}
+
+		delete(t.segments, id)
+		return nil
+	})
+}
+
+func (m memorySegments) GetAllByTrip(ctx context.Context, tripID int64) ([]*Segment, error) {
+	segments := []*Segment{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, segment := range sortedRows(t.segments) {
+			if segment.TripID == tripID {
+				segments = append(segments, t.segment(segment))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(segments, func(a, b *Segment) int {
+		return cmp.Or(a.Departure.Time.Compare(b.Departure.Time), cmp.Compare(a.ID, b.ID))
+	})
+
+	return segments, nil
+}
+
+type memoryStays struct {
+	s *memoryStore
+}
+
+func (m memoryStays) Get(ctx context.Context, id int64) (*Stay, error) {
+	var stay *Stay
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.stays[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		stay = copyStay(stored)
+		return nil
+	})
+
+	return stay, err
+}
+
+func (m memoryStays) Insert(ctx context.Context, stay *Stay) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		return insertMemoryStay(t, stay)
+	})
+}
+
+func insertMemoryStay(t *memoryTables, stay *Stay) error {
+	if _, ok := t.trips[stay.TripID]; !ok {
+		return errForeignKey
+	}
+
+	if !slices.Contains(StayTypes, stay.Type) || !slices.Contains(BookingStatuses, stay.Booking.Status) {
+		return errCheck
+	}
+
+	stay.ID = t.nextID("stays")
+	stay.Lat = coordinate(stay.Lat)
+	stay.Lng = coordinate(stay.Lng)
+	stay.CreatedAt = now()
+	stay.UpdatedAt = stay.CreatedAt
+	stay.Version = 1
+
+	t.stays[stay.ID] = copyStay(stay)
+	return nil
+}
+
+func (m memoryStays) Update(ctx context.Context, stay *Stay) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		return updateMemoryStay(t, stay)
+	})
+}
+
+func updateMemoryStay(t *memoryTables, stay *Stay) error {
+	stored, ok := t.stays[stay.ID]
+	if !ok || stored.Version != stay.Version {
+		return ErrEditConflict
+	}
+
+	if !slices.Contains(StayTypes, stay.Type) || !slices.Contains(BookingStatuses, stay.Booking.Status) {
+		return errCheck
+	}
+
+	stay.Version++
+	stay.Booking.keepReminders(stored.Booking)
+	stay.Lat = coordinate(stay.Lat)
+	stay.Lng = coordinate(stay.Lng)
+	stay.TripID = stored.TripID
+	stay.CreatedAt = stored.CreatedAt
+	stay.UpdatedAt = now()
+
+	t.stays[stay.ID] = copyStay(stay)
+	return nil
+}
+
+func (m memoryStays) SaveBatch(ctx context.Context, stays []*Stay) error {
+	return m.s.atomically(ctx, func(t *memoryTables) error {
+		for _, stay := range stays {
+			var err error
+			if stay.ID == 0 {
+				err = insertMemoryStay(t, stay)
+			} else {
+				err = updateMemoryStay(t, stay)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m memoryStays) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	return m.s.do(ctx, func(t *memoryTables) error {
+		stay, ok := t.stays[id]
+		if !ok || stay.Version != version {
+			return ErrEditConflict
+		}
+
+		t.deleteStay(id)
+		return nil
+	})
+}
+
+func (m memoryStays) GetAllByTrip(ctx context.Context, tripID int64) ([]*Stay, error) {
+	stays := []*Stay{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, stay := range sortedRows(t.stays) {
+			if stay.TripID == tripID {
+				stays = append(stays, copyStay(stay))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(stays, func(a, b *Stay) int {
+		return cmp.Or(a.StartTime.Compare(b.StartTime), cmp.Compare(a.ID, b.ID))
+	})
+
+	return stays, nil
+}
+
+func (m memoryStays) GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Stay, Metadata, error) {
+	var stays []*Stay
+	var metadata Metadata
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		rows := []*Stay{}
+		facets := Facets{}
+
+		for _, stay := range sortedRows(t.stays) {
+			if stay.TripID != tripID {
+				continue
+			}
+
+			facets.add("type", stay.Type, 1)
+			for _, tag := range stay.Tags {
+				facets.add("tag", tag, 1)
+			}
+
+			rows = append(rows, copyStay(stay))
+		}
+
+		stays, metadata = memoryStayListing.list(rows, filters)
+		metadata.Facets = facets
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return stays, metadata, nil
+}
+
+type memoryTokens struct {
+	s *memoryStore
+}
+
+func (m memoryTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) Insert(ctx context.Context, token *Token) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.users[token.UserID]; !ok {
+			return errForeignKey
+		}
+
+		if _, ok := t.tokens[string(token.Hash)]; ok {
+			return errDuplicateKey
+		}
+
+		stored := copyToken(token)
+		stored.Plaintext = ""
+		stored.Expiry = token.Expiry.Truncate(time.Second)
+
+		t.tokens[string(token.Hash)] = stored
+		return nil
+	})
+}
+
+func (m memoryTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		for hash, token := range t.tokens {
+			if token.Scope == scope && token.UserID == userID {
+				delete(t.tokens, hash)
+			}
+		}
+		return nil
+	})
+}
+
+type memoryTripGoers struct {
+	s *memoryStore
+}
+
+func (m memoryTripGoers) Insert(ctx context.Context, userID int64, tripID int64) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.users[userID]; !ok {
+			return errForeignKey
+		}
+		if _, ok := t.trips[tripID]; !ok {
+			return errForeignKey
+		}
+
+		tripGoer := TripGoer{UserID: userID, TripID: tripID}
+		if t.tripGoers[tripGoer] {
+			return errDuplicateKey
+		}
+
+		t.tripGoers[tripGoer] = true
+		return nil
+	})
+}
+
+func (m memoryTripGoers) IsMember(ctx context.Context, userID int64, tripID int64) (bool, error) {
+	var member bool
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		member = t.visible(userID, tripID)
+		return nil
+	})
+
+	return member, err
+}
+
+func (m memoryTripGoers) GetAll(ctx context.Context, tripID int64, filters Filters) ([]*User, Metadata, error) {
+	var users []*User
+	var metadata Metadata
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		users, metadata = memoryTripGoerListing.list(tripGoersOf(t, tripID), filters)
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, metadata, nil
+}
+
+func tripGoersOf(t *memoryTables, tripID int64) []*User {
+	users := []*User{}
+
+	for _, user := range sortedRows(t.users) {
+		if t.tripGoers[TripGoer{UserID: user.ID, TripID: tripID}] {
+			users = append(users, copyUser(user))
+		}
+	}
+
+	return users
+}
+
+type memoryTrips struct {
+	s *memoryStore
+}
+
+func (m memoryTrips) Insert(ctx context.Context, trip *Trip) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.users[trip.CreatedBy]; !ok {
+			return errForeignKey
+		}
+
+		trip.ID = t.nextID("trips")
+		trip.Lat = coordinate(trip.Lat)
+		trip.Lng = coordinate(trip.Lng)
+		trip.StartDate = trip.StartDate.UTC().Truncate(time.Second)
+		trip.EndDate = trip.EndDate.UTC().Truncate(time.Second)
+		trip.CreatedAt = now()
+		trip.UpdatedAt = trip.CreatedAt
+		trip.Version = 1
+
+		t.trips[trip.ID] = copyTrip(trip)
+		return nil
+	})
+}
+
+func (m memoryTrips) Get(ctx context.Context, id int64) (*Trip, error) {
+	var trip *Trip
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.trips[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		trip = copyTrip(stored)
+		return nil
+	})
+
+	return trip, err
+}
+
+// GetForUpdate is the same as Get, since a memory transaction already has the
+// whole store to itself.
+func (m memoryTrips) GetForUpdate(ctx context.Context, id int64) (*Trip, error) {
+	return m.Get(ctx, id)
+}
+
+func (m memoryTrips) Update(ctx context.Context, trip *Trip) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.trips[trip.ID]
+		if !ok || stored.Version != trip.Version {
+			return ErrEditConflict
+		}
+
+		trip.Version++
+		trip.Lat = coordinate(trip.Lat)
+		trip.Lng = coordinate(trip.Lng)
+		trip.StartDate = trip.StartDate.Truncate(time.Second)
+		trip.EndDate = trip.EndDate.Truncate(time.Second)
+		trip.CreatedBy = stored.CreatedBy
+		trip.CreatedAt = stored.CreatedAt
+		trip.UpdatedAt = stored.UpdatedAt
+
+		t.trips[trip.ID] = copyTrip(trip)
+		return nil
+	})
+}
+
+func (m memoryTrips) Transfer(ctx context.Context, trip *Trip, userID int64) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.trips[trip.ID]
+		if !ok || stored.Version != trip.Version {
+			return ErrEditConflict
+		}
+
+		if _, ok := t.users[userID]; !ok {
+			return errForeignKey
+		}
+
+		stored.CreatedBy = userID
+		stored.Version++
+
+		trip.CreatedBy = stored.CreatedBy
+		trip.Version = stored.Version
+		return nil
+	})
+}
+
+func (m memoryTrips) Delete(ctx context.Context, id int64) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		if _, ok := t.trips[id]; !ok {
+			return ErrRecordNotFound
+		}
+
+		t.deleteTrip(id)
+		return nil
+	})
+}
+
+func (m memoryTrips) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	return m.s.do(ctx, func(t *memoryTables) error {
+		trip, ok := t.trips[id]
+		if !ok || trip.Version != version {
+			return ErrEditConflict
+		}
+
+		t.deleteTrip(id)
+		return nil
+	})
+}
+
+func (m memoryTrips) GetAll(ctx context.Context, name string, startDate string, endDate string, filters Filters) ([]*Trip, Metadata, error) {
+	var trips []*Trip
+	var metadata Metadata
+
+	terms := words(name)
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		rows := []*Trip{}
+
+		for _, trip := range sortedRows(t.trips) {
+			if name != "" && !containsAll(words(trip.Name), terms) {
+				continue
+			}
+
+			rows = append(rows, copyTrip(trip))
+		}
+
+		trips, metadata = memoryTripListing.list(rows, filters)
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return trips, metadata, nil
+}
+
+// containsAll is to_tsvector @@ plainto_tsquery: every term has to appear, and
+// a query without any terms matches nothing.
+func containsAll(words, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+
+	for _, term := range terms {
+		if !slices.Contains(words, term) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type memoryUsers struct {
+	s *memoryStore
+}
+
+func (m memoryUsers) Insert(ctx context.Context, user *User) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		for _, other := range t.users {
+			if strings.EqualFold(other.Email, user.Email) {
+				return ErrDuplicateEmail
+			}
+		}
+
+		user.ID = t.nextID("users")
+		user.CreatedAt = now()
+		user.Version = 1
+
+		t.users[user.ID] = copyUser(user)
+		return nil
+	})
+}
+
+func (m memoryUsers) Get(ctx context.Context, id int64) (*User, error) {
+	var user *User
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		stored, ok := t.users[id]
+		if !ok {
+			return ErrRecordNotFound
+		}
+
+		user = copyUser(stored)
+		return nil
+	})
+
+	return user, err
+}
+
+func (m memoryUsers) GetAll(ctx context.Context, filters Filters) ([]*User, Metadata, error) {
+	var users []*User
+	var metadata Metadata
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		rows := []*User{}
+		for _, user := range sortedRows(t.users) {
+			rows = append(rows, copyUser(user))
+		}
+
+		users, metadata = memoryUserListing.list(rows, filters)
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, metadata, nil
+}
+
+func (m memoryUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	var user *User
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		for _, stored := range t.users {
+			if strings.EqualFold(stored.Email, email) {
+				user = copyUser(stored)
+				return nil
+			}
+		}
+
+		return ErrRecordNotFound
+	})
+
+	return user, err
+}
+
+func (m memoryUsers) GetAllByTrip(ctx context.Context, tripID int64) ([]*User, error) {
+	var users []*User
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		users = tripGoersOf(t, tripID)
+		return nil
+	})
+
+	return users, err
+}
+
+func (m memoryUsers) Update(ctx context.Context, user *User) error {
+	return m.s.do(ctx, func(t *memoryTables) error {
+		for _, other := range t.users {
+			if other.ID != user.ID && strings.EqualFold(other.Email, user.Email) {
+				return ErrDuplicateEmail
+			}
+		}
+
+		stored, ok := t.users[user.ID]
+		if !ok || stored.Version != user.Version {
+			return ErrEditConflict
+		}
+
+		user.Version++
+		user.CreatedAt = stored.CreatedAt
+
+		t.users[user.ID] = copyUser(user)
+		return nil
+	})
+}
+
+func (m memoryUsers) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	var user *User
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		token, ok := t.tokens[string(tokenHash[:])]
+		if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+			return ErrRecordNotFound
+		}
+
+		user = copyUser(t.users[token.UserID])
+		return nil
+	})
+
+	return user, err
+}
+
+var (
+	_ ActivityRepository   = memoryActivities{}
+	_ BookingRepository    = memoryBookings{}
+	_ DraftRepository      = memoryDrafts{}
+	_ InboxRepository      = memoryInboxes{}
+	_ ListRepository       = memoryLists{}
+	_ LocationRepository   = memoryLocations{}
+	_ PermissionRepository = memoryPermissions{}
+	_ PlaceRepository      = memoryPlaces{}
+	_ SavedPlaceRepository = memorySavedPlaces{}
+	_ SearchRepository     = memorySearch{}
+	_ SegmentRepository    = memorySegments{}
+	_ StayRepository       = memoryStays{}
+	_ TokenRepository      = memoryTokens{}
+	_ TripGoerRepository   = memoryTripGoers{}
+	_ TripRepository       = memoryTrips{}
+	_ UserRepository       = memoryUsers{}
+)
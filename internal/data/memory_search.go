@@ -0,0 +1,240 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+)
+
+// Weights given to the A, B and C parts of a search vector by ts_rank.
+const (
+	weightA = 1.0
+	weightB = 0.4
+	weightC = 0.2
+)
+
+type memorySearch struct {
+	s *memoryStore
+}
+
+// searchField is one weighted part of a record's search vector.
+type searchField struct {
+	text   string
+	weight float64
+}
+
+// searchClause is a single websearch_to_tsquery term: one or more phrases
+// joined by "or", possibly negated.
+type searchClause struct {
+	phrases [][]string
+	negated bool
+}
+
+// parseWebSearch understands the same syntax as websearch_to_tsquery: quoted
+// phrases, "or" between alternatives and a leading "-" to exclude a term.
+// Phrases match when all their words appear, in any order.
+func parseWebSearch(q string) []searchClause {
+	var tokens []string
+	var negations []bool
+
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		negated := strings.HasPrefix(q, "-")
+		if negated {
+			q = q[1:]
+		}
+
+		var token string
+		if strings.HasPrefix(q, `"`) {
+			end := strings.Index(q[1:], `"`)
+			if end < 0 {
+				token, q = q[1:], ""
+			} else {
+				token, q = q[1:end+1], q[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(q, " \t\n")
+			if end < 0 {
+				end = len(q)
+			}
+			token, q = q[:end], q[end:]
+		}
+
+		tokens = append(tokens, token)
+		negations = append(negations, negated)
+	}
+
+	var clauses []searchClause
+	joinNext := false
+
+	for i, token := range tokens {
+		if strings.EqualFold(token, "or") && !negations[i] {
+			joinNext = len(clauses) > 0
+			continue
+		}
+
+		phrase := words(token)
+		if len(phrase) == 0 {
+			continue
+		}
+
+		if joinNext && !negations[i] && !clauses[len(clauses)-1].negated {
+			last := &clauses[len(clauses)-1]
+			last.phrases = append(last.phrases, phrase)
+		} else {
+			clauses = append(clauses, searchClause{phrases: [][]string{phrase}, negated: negations[i]})
+		}
+
+		joinNext = false
+	}
+
+	return clauses
+}
+
+// rank returns an approximation of ts_rank for the fields, and false when they
+// don't match the query.
+func rank(clauses []searchClause, fields []searchField) (float64, bool) {
+	score := 0.0
+	positive := 0
+
+	for _, clause := range clauses {
+		best := 0.0
+
+		for _, phrase := range clause.phrases {
+			for _, field := range fields {
+				if containsAll(words(field.text), phrase) {
+					best = max(best, field.weight)
+				}
+			}
+		}
+
+		if clause.negated {
+			if best > 0 {
+				return 0, false
+			}
+			continue
+		}
+
+		if best == 0 {
+			return 0, false
+		}
+
+		score += best
+		positive++
+	}
+
+	if positive == 0 {
+		return 0, false
+	}
+
+	return score / float64(positive) * 0.0607927, true
+}
+
+// headline wraps the words of body that match the query in <mark> tags and
+// trims it to 20 words around the first match, like ts_headline. The rest of
+// body is HTML escaped.
+func headline(clauses []searchClause, body string) string {
+	terms := map[string]bool{}
+	for _, clause := range clauses {
+		if clause.negated {
+			continue
+		}
+		for _, phrase := range clause.phrases {
+			for _, word := range phrase {
+				terms[word] = true
+			}
+		}
+	}
+
+	body = strings.NewReplacer(headlineStart, "", headlineStop, "").Replace(body)
+	tokens := strings.Fields(body)
+	first := -1
+
+	for i, token := range tokens {
+		for _, word := range words(token) {
+			if terms[word] {
+				tokens[i] = headlineStart + token + headlineStop
+				if first < 0 {
+					first = i
+				}
+				break
+			}
+		}
+	}
+
+	start := max(0, min(first, len(tokens)-20))
+	end := min(len(tokens), start+20)
+
+	return highlight(strings.Join(tokens[start:end], " "))
+}
+
+func (m memorySearch) Search(ctx context.Context, userID int64, q string, filters Filters) ([]*SearchResult, Metadata, error) {
+	clauses := parseWebSearch(q)
+	results := []*SearchResult{}
+
+	err := m.s.do(ctx, func(t *memoryTables) error {
+		hit := func(kind string, id, tripID int64, title, body string, fields ...searchField) {
+			if !t.visible(userID, tripID) {
+				return
+			}
+
+			r, ok := rank(clauses, fields)
+			if !ok {
+				return
+			}
+
+			results = append(results, &SearchResult{
+				Type:     kind,
+				ID:       id,
+				TripID:   tripID,
+				TripName: t.trips[tripID].Name,
+				Title:    title,
+				Snippet:  headline(clauses, body),
+				Rank:     r,
+			})
+		}
+
+		for _, trip := range sortedRows(t.trips) {
+			hit(SearchTypeTrip, trip.ID, trip.ID, trip.Name, trip.Name+" "+trip.City,
+				searchField{trip.Name, weightA}, searchField{trip.City, weightB})
+		}
+
+		for _, activity := range sortedRows(t.activities) {
+			hit(SearchTypeActivity, activity.ID, activity.TripID, activity.Name, activity.Name+" "+activity.Notes,
+				searchField{activity.Name, weightA}, searchField{activity.Notes, weightC})
+		}
+
+		for _, stored := range sortedRows(t.locations) {
+			location := t.location(stored)
+			tripID := t.activities[location.ActivityID].TripID
+			hit(SearchTypeLocation, location.ID, tripID, location.Name, location.Name+" "+location.Address,
+				searchField{location.Name, weightA}, searchField{location.Address, weightB})
+		}
+
+		for _, stay := range sortedRows(t.stays) {
+			hit(SearchTypeStay, stay.ID, stay.TripID, stay.Name, stay.Name,
+				searchField{stay.Name, weightA})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	slices.SortFunc(results, func(a, b *SearchResult) int {
+		return cmp.Or(cmp.Compare(b.Rank, a.Rank), cmp.Compare(a.Type, b.Type), cmp.Compare(a.ID, b.ID))
+	})
+
+	totalRecords := len(results)
+	offset := min(filters.offset(), totalRecords)
+	results = results[offset:min(totalRecords, offset+filters.limit())]
+
+	// count(*) OVER() is only seen on rows that made it into the page
+	if len(results) == 0 {
+		totalRecords = 0
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return results, metadata, nil
+}
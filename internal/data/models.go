@@ -1,8 +1,13 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/validator"
 )
 
 var (
@@ -10,26 +15,118 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DefaultQueryTimeout bounds each query when a model isn't given a timeout of
+// its own.
+const DefaultQueryTimeout = 3 * time.Second
+
+// queryContext derives the context a single query runs under. Cancelling the
+// parent, for example when the client disconnects, cancels the query too.
+func queryContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// MaxBatchSize caps the number of items a single batch request can save.
+const MaxBatchSize = 100
+
+func ValidateBatchSize(v *validator.Validator, key string, n int) {
+	v.Check(n > 0, key, "must contain at least one item")
+	v.Check(n <= MaxBatchSize, key, fmt.Sprintf("must not contain more than %d items", MaxBatchSize))
+}
+
+// Executor is the part of *sql.DB and *sql.Tx that the models run their
+// queries through, so every model method works the same inside or outside a
+// transaction.
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTx runs fn in a new transaction on exec, committing if fn returns nil. If
+// exec is already a transaction fn joins it, and committing or rolling back is
+// left to whoever started it.
+func inTx(ctx context.Context, exec Executor, fn func(tx Executor) error) error {
+	db, ok := exec.(*sql.DB)
+	if !ok {
+		return fn(exec)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 type Models struct {
-	Activities  ActivityModel
-	Locations   LocationModel
-	Permissions PermissionModel
-	Stays       StayModel
-	Tokens      TokenModel
-	TripGoers   TripGoerModel
-	Trips       TripModel
-	Users       UserModel
+	transaction func(ctx context.Context, fn func(tx Models) error) error
+
+	Activities  ActivityRepository
+	Bookings    BookingRepository
+	Drafts      DraftRepository
+	Inboxes     InboxRepository
+	Lists       ListRepository
+	Locations   LocationRepository
+	Permissions PermissionRepository
+	Places      PlaceRepository
+	SavedPlaces SavedPlaceRepository
+	Search      SearchRepository
+	Segments    SegmentRepository
+	Stays       StayRepository
+	Tokens      TokenRepository
+	TripGoers   TripGoerRepository
+	Trips       TripRepository
+	Users       UserRepository
+}
+
+// NewModels returns models that run their queries on db, each bounded by
+// timeout.
+func NewModels(db *sql.DB, timeout time.Duration) Models {
+	return newModels(db, timeout)
 }
 
-func NewModels(db *sql.DB) Models {
+func newModels(exec Executor, timeout time.Duration) Models {
 	return Models{
-		Activities:  ActivityModel{DB: db},
-		Locations:   LocationModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Stays:       StayModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		TripGoers:   TripGoerModel{DB: db},
-		Trips:       TripModel{DB: db},
-		Users:       UserModel{DB: db},
+		transaction: func(ctx context.Context, fn func(tx Models) error) error {
+			return inTx(ctx, exec, func(tx Executor) error {
+				return fn(newModels(tx, timeout))
+			})
+		},
+		Activities:  ActivityModel{DB: exec, Timeout: timeout},
+		Bookings:    BookingModel{DB: exec, Timeout: timeout},
+		Drafts:      DraftModel{DB: exec, Timeout: timeout},
+		Inboxes:     InboxModel{DB: exec, Timeout: timeout},
+		Lists:       ListModel{DB: exec, Timeout: timeout},
+		Locations:   LocationModel{DB: exec, Timeout: timeout},
+		Permissions: PermissionModel{DB: exec, Timeout: timeout},
+		Places:      PlaceModel{DB: exec, Timeout: timeout},
+		SavedPlaces: SavedPlaceModel{DB: exec, Timeout: timeout},
+		Search:      SearchModel{DB: exec, Timeout: timeout},
+		Segments:    SegmentModel{DB: exec, Timeout: timeout},
+		Stays:       StayModel{DB: exec, Timeout: timeout},
+		Tokens:      TokenModel{DB: exec, Timeout: timeout},
+		TripGoers:   TripGoerModel{DB: exec, Timeout: timeout},
+		Trips:       TripModel{DB: exec, Timeout: timeout},
+		Users:       UserModel{DB: exec, Timeout: timeout},
 	}
 }
+
+// Transaction runs fn as a unit of work. Every model call made through the
+// Models passed to fn shares one transaction, which is committed if fn returns
+// nil and rolled back otherwise. Calling Transaction on Models that are already
+// inside a transaction runs fn as part of it. fn must not use the outer Models:
+// the memory store would deadlock on them, so it returns an error instead.
+func (m Models) Transaction(ctx context.Context, fn func(tx Models) error) error {
+	return m.transaction(ctx, fn)
+}
@@ -2,12 +2,14 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// PermissionCodes are the rows of the permissions table.
+var PermissionCodes = []string{"trips:read", "trips:write"}
+
 type Permissions []string
 
 func (p Permissions) Include(code string) bool {
@@ -21,10 +23,11 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB      Executor
+	Timeout time.Duration
 }
 
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
           SELECT permissions.code
           FROM permissions
@@ -32,7 +35,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
           INNER JOIN users ON users_permissions.user_id = users.id
           WHERE users.id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -60,12 +63,27 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
           INSERT INTO users_permissions
           SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+          DELETE FROM users_permissions
+          USING permissions
+          WHERE users_permissions.permission_id = permissions.id
+          AND users_permissions.user_id = $1
+          AND permissions.code = ANY($2)`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
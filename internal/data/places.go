@@ -0,0 +1,332 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// Place is the one shared record of somewhere, keyed by its Google place ID.
+// Activities reach places through their locations and stays link to them
+// directly, so a change to a place shows up everywhere it's used. Places made
+// from locations saved before Google place IDs were required have none, and
+// GooglePlaceID is empty.
+type Place struct {
+	ID            int64     `json:"id"`
+	GooglePlaceID string    `json:"google_place_id"`
+	Name          string    `json:"name"`
+	Address       string    `json:"address"`
+	Lat           float64   `json:"lat"`
+	Lng           float64   `json:"lng"`
+	Website       string    `json:"website"`
+	Phone         string    `json:"phone"`
+	Version       int32     `json:"version"`
+	CreatedAt     time.Time `json:"-"`
+	UpdatedAt     time.Time `json:"-"`
+}
+
+// MaxPlaceSuggestions caps how many places an autocomplete returns.
+const MaxPlaceSuggestions = 20
+
+type PlaceModel struct {
+	DB      Executor
+	Timeout time.Duration
+}
+
+func (m PlaceModel) Get(ctx context.Context, id int64) (*Place, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, created_at, updated_at, COALESCE(google_place_id, ''), name, address, lat, lng, website, phone, version
+    FROM places
+    WHERE id = $1`
+
+	var place Place
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&place.ID,
+		&place.CreatedAt,
+		&place.UpdatedAt,
+		&place.GooglePlaceID,
+		&place.Name,
+		&place.Address,
+		&place.Lat,
+		&place.Lng,
+		&place.Website,
+		&place.Phone,
+		&place.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &place, nil
+}
+
+// Ensure inserts place unless one with the same Google place ID exists, and
+// either way fills place in with what's stored. An existing place is never
+// overwritten here; that's what Update is for. A place without a Google place
+// ID can't be matched, so it's always inserted, with a NULL ID.
+func (m PlaceModel) Ensure(ctx context.Context, place *Place) error {
+	query := `
+    INSERT INTO places (google_place_id, name, address, lat, lng, website, phone)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    ON CONFLICT (google_place_id) WHERE google_place_id IS NOT NULL DO UPDATE SET google_place_id = EXCLUDED.google_place_id
+    RETURNING id, created_at, updated_at, name, address, lat, lng, website, phone, version`
+
+	googlePlaceID := sql.NullString{String: place.GooglePlaceID, Valid: place.GooglePlaceID != ""}
+
+	args := []any{googlePlaceID, place.Name, place.Address, place.Lat, place.Lng, place.Website, place.Phone}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&place.ID,
+		&place.CreatedAt,
+		&place.UpdatedAt,
+		&place.Name,
+		&place.Address,
+		&place.Lat,
+		&place.Lng,
+		&place.Website,
+		&place.Phone,
+		&place.Version,
+	)
+}
+
+func (m PlaceModel) Update(ctx context.Context, place *Place) error {
+	query := `
+    UPDATE places
+    SET name = $1, address = $2, lat = $3, lng = $4, website = $5, phone = $6,
+        version = version + 1, updated_at = NOW()
+    WHERE id = $7 AND version = $8
+    RETURNING version`
+
+	args := []any{place.Name, place.Address, place.Lat, place.Lng, place.Website, place.Phone, place.ID, place.Version}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&place.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Autocomplete suggests places the user has used before, on any trip they
+// created or are going on, whose name or address contains q. Names starting
+// with q come first, then the places used most.
+func (m PlaceModel) Autocomplete(ctx context.Context, userID int64, q string, limit int) ([]*Place, error) {
+	query := `
+    WITH visible AS (
+        SELECT id FROM trips WHERE created_by = $1
+        UNION
+        SELECT trip_id FROM trip_goers WHERE user_id = $1
+    ),
+    used AS (
+        SELECT l.place_id
+        FROM locations l
+        JOIN activities a ON a.id = l.activity_id
+        WHERE a.trip_id IN (SELECT id FROM visible)
+        UNION ALL
+        SELECT sp.place_id
+        FROM stay_places sp
+        JOIN stays s ON s.id = sp.stay_id
+        WHERE s.trip_id IN (SELECT id FROM visible)
+    ),
+    uses AS (
+        SELECT place_id, count(*) AS n FROM used GROUP BY place_id
+    )
+    SELECT p.id, COALESCE(p.google_place_id, ''), p.name, p.address, p.lat, p.lng, p.website, p.phone, p.version
+    FROM places p
+    JOIN uses ON uses.place_id = p.id
+    WHERE p.name ILIKE '%' || $2 || '%' OR p.address ILIKE '%' || $2 || '%'
+    ORDER BY p.name ILIKE $2 || '%' DESC, uses.n DESC, p.name, p.id
+    LIMIT $3`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, likeEscaper.Replace(q), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPlaces(rows)
+}
+
+// UsedByUser reports whether a place is on a trip the user created or is going
+// on, through an activity, a stay or a segment. Only those users may edit it,
+// since an edit shows up everywhere the place is used.
+func (m PlaceModel) UsedByUser(ctx context.Context, placeID, userID int64) (bool, error) {
+	query := `
+    WITH visible AS (
+        SELECT id FROM trips WHERE created_by = $1
+        UNION
+        SELECT trip_id FROM trip_goers WHERE user_id = $1
+    )
+    SELECT EXISTS (
+        SELECT 1
+        FROM locations l
+        JOIN activities a ON a.id = l.activity_id
+        WHERE l.place_id = $2 AND a.trip_id IN (SELECT id FROM visible)
+        UNION ALL
+        SELECT 1
+        FROM stay_places sp
+        JOIN stays s ON s.id = sp.stay_id
+        WHERE sp.place_id = $2 AND s.trip_id IN (SELECT id FROM visible)
+        UNION ALL
+        SELECT 1
+        FROM segments
+        WHERE $2 IN (departure_place_id, arrival_place_id) AND trip_id IN (SELECT id FROM visible)
+    )`
+
+	var used bool
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, placeID).Scan(&used)
+	return used, err
+}
+
+// GetAllByStay returns the places linked to a stay.
+func (m PlaceModel) GetAllByStay(ctx context.Context, stayID int64) ([]*Place, error) {
+	query := `
+    SELECT p.id, COALESCE(p.google_place_id, ''), p.name, p.address, p.lat, p.lng, p.website, p.phone, p.version
+    FROM places p
+    JOIN stay_places sp ON sp.place_id = p.id
+    WHERE sp.stay_id = $1
+    ORDER BY sp.created_at, p.id`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, stayID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanPlaces(rows)
+}
+
+func scanPlaces(rows *sql.Rows) ([]*Place, error) {
+	defer rows.Close()
+
+	places := []*Place{}
+
+	for rows.Next() {
+		var place Place
+
+		err := rows.Scan(
+			&place.ID,
+			&place.GooglePlaceID,
+			&place.Name,
+			&place.Address,
+			&place.Lat,
+			&place.Lng,
+			&place.Website,
+			&place.Phone,
+			&place.Version,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		places = append(places, &place)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return places, nil
+}
+
+// AddToStay links a place to a stay. Linking it again does nothing.
+func (m PlaceModel) AddToStay(ctx context.Context, stayID, placeID int64) error {
+	query := `
+    INSERT INTO stay_places (stay_id, place_id)
+    VALUES ($1, $2)
+    ON CONFLICT DO NOTHING`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, stayID, placeID)
+	return err
+}
+
+// RemoveFromStay unlinks a place from a stay. The place itself is kept, since
+// other activities and stays may use it.
+func (m PlaceModel) RemoveFromStay(ctx context.Context, stayID, placeID int64) error {
+	query := `
+    DELETE FROM stay_places
+    WHERE stay_id = $1 AND place_id = $2`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, stayID, placeID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func ValidatePlace(v *validator.Validator, place *Place) {
+	v.Check(place.Name != "", "name", "must be provided")
+	v.Check(len(place.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(place.Address != "", "address", "must be provided")
+	v.Check(len(place.Address) <= 500, "address", "must not be more than 500 bytes long")
+
+	// only places from before Google place IDs were required go without one
+	v.Check(place.GooglePlaceID != "" || place.ID != 0, "google_place_id", "must be provided")
+	v.Check(len(place.GooglePlaceID) <= 500, "google_place_id", "must not be more than 500 bytes long")
+
+	v.Check(place.Lat != 0, "lat", "must be provided")
+	v.Check(place.Lng != 0, "lng", "must be provided")
+}
+
+func ValidatePlaceQuery(v *validator.Validator, q string, limit int) {
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 500, "q", "must not be more than 500 bytes long")
+
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= MaxPlaceSuggestions, "limit", fmt.Sprintf("must be a maximum of %d", MaxPlaceSuggestions))
+}
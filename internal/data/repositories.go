@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// The repositories below are what handlers program against. The Postgres
+// models implement them, and so does the in-memory store used by -store=memory,
+// which has to keep the same semantics: versions, ErrEditConflict,
+// ErrRecordNotFound, ErrDuplicateEmail and cascading deletes.
+
+type ActivityRepository interface {
+	Get(ctx context.Context, id int64) (*Activity, error)
+	Insert(ctx context.Context, activity *Activity) error
+	GetAllByTrip(ctx context.Context, tripID int64) ([]*Activity, error)
+	GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Activity, Metadata, error)
+	Update(ctx context.Context, activity *Activity) error
+	SaveBatch(ctx context.Context, activities []*Activity) error
+	ReorderIdeas(ctx context.Context, tripID int64, activityIDs []int64) error
+	Delete(ctx context.Context, id int64) error
+	DeleteVersion(ctx context.Context, id int64, version int32) error
+}
+
+type BookingRepository interface {
+	GetAllByTrip(ctx context.Context, tripID int64) ([]*BookingItem, error)
+	GetDueReminders(ctx context.Context, lead time.Duration) ([]*BookingReminder, error)
+	MarkReminded(ctx context.Context, reminder *BookingReminder) error
+	GetRemindedRecipients(ctx context.Context, reminder *BookingReminder) ([]string, error)
+	MarkRecipientReminded(ctx context.Context, reminder *BookingReminder, email string) error
+}
+
+type DraftRepository interface {
+	Insert(ctx context.Context, draft *Draft) error
+	Get(ctx context.Context, id int64) (*Draft, error)
+	GetAllByTrip(ctx context.Context, tripID int64) ([]*Draft, error)
+	GetAllByMessage(ctx context.Context, tripID int64, messageID string) ([]*Draft, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type InboxRepository interface {
+	New(ctx context.Context, tripID int64) (*Inbox, error)
+	Get(ctx context.Context, tripID int64) (*Inbox, error)
+	GetByToken(ctx context.Context, token string) (*Inbox, error)
+}
+
+type ListRepository interface {
+	Insert(ctx context.Context, list *List) error
+	Get(ctx context.Context, id int64) (*List, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*List, error)
+	Update(ctx context.Context, list *List) error
+	DeleteVersion(ctx context.Context, id int64, version int32) error
+}
+
+type LocationRepository interface {
+	Get(ctx context.Context, id int64) (*Location, error)
+	Insert(ctx context.Context, location *Location) error
+	Update(ctx context.Context, location *Location) error
+	DeleteVersion(ctx context.Context, id int64, version int32) error
+	GetAllByActivity(ctx context.Context, activityID int64) ([]*Location, error)
+	GetAllByActivities(ctx context.Context, activityIDs []int64) (map[int64][]*Location, error)
+	LoadForActivities(ctx context.Context, activities []*Activity) error
+	GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Location, Metadata, error)
+}
+
+type PermissionRepository interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+type PlaceRepository interface {
+	Get(ctx context.Context, id int64) (*Place, error)
+	Ensure(ctx context.Context, place *Place) error
+	Update(ctx context.Context, place *Place) error
+	Autocomplete(ctx context.Context, userID int64, q string, limit int) ([]*Place, error)
+	UsedByUser(ctx context.Context, placeID, userID int64) (bool, error)
+	GetAllByStay(ctx context.Context, stayID int64) ([]*Place, error)
+	AddToStay(ctx context.Context, stayID, placeID int64) error
+	RemoveFromStay(ctx context.Context, stayID, placeID int64) error
+}
+
+type SavedPlaceRepository interface {
+	Insert(ctx context.Context, saved *SavedPlace) error
+	Get(ctx context.Context, listID, placeID int64) (*SavedPlace, error)
+	GetAllByList(ctx context.Context, listID int64) ([]*SavedPlace, error)
+	Update(ctx context.Context, saved *SavedPlace) error
+	DeleteVersion(ctx context.Context, listID, placeID int64, version int32) error
+}
+
+type SearchRepository interface {
+	Search(ctx context.Context, userID int64, q string, filters Filters) ([]*SearchResult, Metadata, error)
+}
+
+type SegmentRepository interface {
+	Get(ctx context.Context, id int64) (*Segment, error)
+	Insert(ctx context.Context, segment *Segment) error
+	Update(ctx context.Context, segment *Segment) error
+	DeleteVersion(ctx context.Context, id int64, version int32) error
+	GetAllByTrip(ctx context.Context, tripID int64) ([]*Segment, error)
+}
+
+type StayRepository interface {
+	Get(ctx context.Context, id int64) (*Stay, error)
+	Insert(ctx context.Context, stay *Stay) error
+	Update(ctx context.Context, stay *Stay) error
+	SaveBatch(ctx context.Context, stays []*Stay) error
+	DeleteVersion(ctx context.Context, id int64, version int32) error
+	GetAllByTrip(ctx context.Context, tripID int64) ([]*Stay, error)
+	GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Stay, Metadata, error)
+}
+
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+}
+
+type TripGoerRepository interface {
+	Insert(ctx context.Context, userID int64, tripID int64) error
+	IsMember(ctx context.Context, userID int64, tripID int64) (bool, error)
+	GetAll(ctx context.Context, tripID int64, filters Filters) ([]*User, Metadata, error)
+}
+
+type TripRepository interface {
+	Insert(ctx context.Context, trip *Trip) error
+	Get(ctx context.Context, id int64) (*Trip, error)
+	GetForUpdate(ctx context.Context, id int64) (*Trip, error)
+	Update(ctx context.Context, trip *Trip) error
+	Transfer(ctx context.Context, trip *Trip, userID int64) error
+	Delete(ctx context.Context, id int64) error
+	DeleteVersion(ctx context.Context, id int64, version int32) error
+	GetAll(ctx context.Context, name string, startDate string, endDate string, filters Filters) ([]*Trip, Metadata, error)
+}
+
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetAll(ctx context.Context, filters Filters) ([]*User, Metadata, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetAllByTrip(ctx context.Context, tripID int64) ([]*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+}
+
+var (
+	_ ActivityRepository   = ActivityModel{}
+	_ BookingRepository    = BookingModel{}
+	_ DraftRepository      = DraftModel{}
+	_ InboxRepository      = InboxModel{}
+	_ ListRepository       = ListModel{}
+	_ LocationRepository   = LocationModel{}
+	_ PermissionRepository = PermissionModel{}
+	_ PlaceRepository      = PlaceModel{}
+	_ SavedPlaceRepository = SavedPlaceModel{}
+	_ SearchRepository     = SearchModel{}
+	_ SegmentRepository    = SegmentModel{}
+	_ StayRepository       = StayModel{}
+	_ TokenRepository      = TokenModel{}
+	_ TripGoerRepository   = TripGoerModel{}
+	_ TripRepository       = TripModel{}
+	_ UserRepository       = UserModel{}
+)
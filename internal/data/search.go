@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// The kinds of record a search can match.
+const (
+	SearchTypeTrip     = "trip"
+	SearchTypeActivity = "activity"
+	SearchTypeLocation = "location"
+	SearchTypeStay     = "stay"
+)
+
+// SearchResult is a single ranked hit. Snippet is the matching text, HTML
+// escaped, with the matched terms wrapped in <mark> tags.
+type SearchResult struct {
+	Type     string  `json:"type"`
+	ID       int64   `json:"id"`
+	TripID   int64   `json:"trip"`
+	TripName string  `json:"trip_name"`
+	Title    string  `json:"title"`
+	Snippet  string  `json:"snippet"`
+	Rank     float64 `json:"rank"`
+}
+
+// Headlines mark matches with control characters that are stripped from the
+// text beforehand, so that highlight can tell them apart from anything the
+// user wrote.
+const (
+	headlineStart = "\x01"
+	headlineStop  = "\x02"
+)
+
+// highlight HTML escapes a headline and turns its match markers into <mark>
+// tags, which are then the only markup in it.
+func highlight(headline string) string {
+	return strings.NewReplacer(headlineStart, "<mark>", headlineStop, "</mark>").Replace(html.EscapeString(headline))
+}
+
+type SearchModel struct {
+	DB      Executor
+	Timeout time.Duration
+}
+
+// Search looks for q across trip names and cities, activity names and notes,
+// location names and addresses, and stay names. Only trips the user created or
+// is a tripgoer on are searched.
+func (m SearchModel) Search(ctx context.Context, userID int64, q string, filters Filters) ([]*SearchResult, Metadata, error) {
+	query := `
+    WITH query AS (
+        SELECT websearch_to_tsquery('simple', $1) AS q
+    ),
+    visible AS (
+        SELECT id FROM trips WHERE created_by = $2
+        UNION
+        SELECT trip_id FROM trip_goers WHERE user_id = $2
+    ),
+    hits AS (
+        SELECT 'trip' AS type, t.id, t.id AS trip_id, t.name AS title, t.name || ' ' || t.city AS body,
+            ts_rank(t.search_vector, query.q) AS rank
+        FROM trips t, query
+        WHERE t.id IN (SELECT id FROM visible) AND t.search_vector @@ query.q
+        UNION ALL
+        SELECT 'activity', a.id, a.trip_id, a.name, a.name || ' ' || a.notes,
+            ts_rank(a.search_vector, query.q)
+        FROM activities a, query
+        WHERE a.trip_id IN (SELECT id FROM visible) AND a.search_vector @@ query.q
+        UNION ALL
+        SELECT 'location', l.id, a.trip_id, p.name, p.name || ' ' || p.address,
+            ts_rank(p.search_vector, query.q)
+        FROM locations l
+        JOIN places p ON p.id = l.place_id
+        JOIN activities a ON a.id = l.activity_id, query
+        WHERE a.trip_id IN (SELECT id FROM visible) AND p.search_vector @@ query.q
+        UNION ALL
+        SELECT 'stay', s.id, s.trip_id, s.name, s.name,
+            ts_rank(s.search_vector, query.q)
+        FROM stays s, query
+        WHERE s.trip_id IN (SELECT id FROM visible) AND s.search_vector @@ query.q
+    )
+    SELECT count(*) OVER(), hits.type, hits.id, hits.trip_id, trips.name, hits.title,
+        ts_headline('simple', translate(hits.body, E'\x01\x02', ''), query.q,
+            E'StartSel=\x01, StopSel=\x02, MaxFragments=2, MaxWords=20, MinWords=5'),
+        hits.rank
+    FROM hits
+    JOIN trips ON trips.id = hits.trip_id, query
+    ORDER BY hits.rank DESC, hits.type, hits.id
+    LIMIT $3 OFFSET $4`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	args := []any{q, userID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*SearchResult{}
+
+	for rows.Next() {
+		var result SearchResult
+
+		err := rows.Scan(
+			&totalRecords,
+			&result.Type,
+			&result.ID,
+			&result.TripID,
+			&result.TripName,
+			&result.Title,
+			&result.Snippet,
+			&result.Rank,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		result.Snippet = highlight(result.Snippet)
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return results, metadata, nil
+}
+
+func ValidateSearchQuery(v *validator.Validator, q string) {
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 500, "q", "must not be more than 500 bytes long")
+}
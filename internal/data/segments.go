@@ -0,0 +1,430 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// The ways a segment gets travelers from one place to another.
+const (
+	SegmentModeFlight    = "flight"
+	SegmentModeTrain     = "train"
+	SegmentModeBus       = "bus"
+	SegmentModeCarRental = "car_rental"
+)
+
+var SegmentModes = []string{
+	SegmentModeFlight,
+	SegmentModeTrain,
+	SegmentModeBus,
+	SegmentModeCarRental,
+}
+
+// Segment is one leg of getting around on a trip, like a flight or a car
+// rental. Carrier and Number are the airline and flight number for flights,
+// the operator and train or route number for trains and buses, and the rental
+// company for car rentals. Travelers are the IDs of the tripgoers booked on it.
+type Segment struct {
+	ID               int64     `json:"id"`
+	TripID           int64     `json:"trip"`
+	Mode             string    `json:"mode"`
+	Carrier          string    `json:"carrier"`
+	Number           string    `json:"number"`
+	Departure        Endpoint  `json:"departure"`
+	Arrival          Endpoint  `json:"arrival"`
+	ConfirmationCode string    `json:"confirmation_code"`
+	Seat             string    `json:"seat"`
+	Travelers        []int64   `json:"travelers"`
+	Version          int32     `json:"version"`
+	CreatedAt        time.Time `json:"-"`
+	UpdatedAt        time.Time `json:"-"`
+}
+
+// Endpoint is where and when a segment departs or arrives. Name is what the
+// ticket calls the place, such as an airport code or a station, and PlaceID
+// optionally links it to the places catalog. Time is shown in TimeZone, the
+// IANA zone of the place, so it reads like the local time on the ticket.
+type Endpoint struct {
+	Name     string    `json:"name"`
+	PlaceID  *int64    `json:"place"`
+	Time     time.Time `json:"time"`
+	TimeZone string    `json:"time_zone"`
+}
+
+// localize puts the endpoint times in their own time zones. Times come back
+// from the database in the session's zone, which means nothing to travelers.
+func (s *Segment) localize() {
+	for _, e := range []*Endpoint{&s.Departure, &s.Arrival} {
+		loc, err := time.LoadLocation(e.TimeZone)
+		if err == nil {
+			e.Time = e.Time.In(loc)
+		}
+	}
+}
+
+// Overlaps reports whether two segments are underway at the same time.
+func (s *Segment) Overlaps(other *Segment) bool {
+	return s.Departure.Time.Before(other.Arrival.Time) && other.Departure.Time.Before(s.Arrival.Time)
+}
+
+type SegmentModel struct {
+	DB      Executor
+	Timeout time.Duration
+}
+
+// segmentColumns are selected from segments, in the order segmentFields scans
+// them. Travelers come back as an array.
+const segmentColumns = `segments.id, segments.created_at, segments.updated_at, segments.trip_id, segments.mode,
+        segments.carrier, segments.number, segments.departure_name, segments.departure_place_id,
+        segments.departure_time, segments.departure_time_zone, segments.arrival_name, segments.arrival_place_id,
+        segments.arrival_time, segments.arrival_time_zone, segments.confirmation_code, segments.seat,
+        ARRAY(SELECT user_id FROM segment_travelers WHERE segment_id = segments.id ORDER BY user_id),
+        segments.version`
+
+func segmentFields(segment *Segment) []any {
+	return []any{
+		&segment.ID,
+		&segment.CreatedAt,
+		&segment.UpdatedAt,
+		&segment.TripID,
+		&segment.Mode,
+		&segment.Carrier,
+		&segment.Number,
+		&segment.Departure.Name,
+		&segment.Departure.PlaceID,
+		&segment.Departure.Time,
+		&segment.Departure.TimeZone,
+		&segment.Arrival.Name,
+		&segment.Arrival.PlaceID,
+		&segment.Arrival.Time,
+		&segment.Arrival.TimeZone,
+		&segment.ConfirmationCode,
+		&segment.Seat,
+		pq.Array(&segment.Travelers),
+		&segment.Version,
+	}
+}
+
+func (m SegmentModel) Get(ctx context.Context, id int64) (*Segment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT ` + segmentColumns + `
+    FROM segments
+    WHERE id = $1`
+
+	var segment Segment
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(segmentFields(&segment)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	segment.localize()
+
+	return &segment, nil
+}
+
+// Insert saves a new segment along with its travelers, and puts its times in
+// their endpoints' time zones.
+func (m SegmentModel) Insert(ctx context.Context, segment *Segment) error {
+	query := `
+    INSERT INTO segments (trip_id, mode, carrier, number, departure_name, departure_place_id, departure_time,
+        departure_time_zone, arrival_name, arrival_place_id, arrival_time, arrival_time_zone, confirmation_code, seat)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+    RETURNING id, created_at, version`
+
+	args := []any{
+		segment.TripID, segment.Mode, segment.Carrier, segment.Number,
+		segment.Departure.Name, segment.Departure.PlaceID, segment.Departure.Time, segment.Departure.TimeZone,
+		segment.Arrival.Name, segment.Arrival.PlaceID, segment.Arrival.Time, segment.Arrival.TimeZone,
+		segment.ConfirmationCode, segment.Seat,
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx Executor) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&segment.ID, &segment.CreatedAt, &segment.Version)
+		if err != nil {
+			return err
+		}
+
+		segment.localize()
+
+		return setSegmentTravelers(ctx, tx, segment)
+	})
+}
+
+// Update saves a segment and replaces its travelers. Like Insert, it puts the
+// segment's times in their endpoints' time zones.
+func (m SegmentModel) Update(ctx context.Context, segment *Segment) error {
+	query := `
+    UPDATE segments
+    SET mode = $1, carrier = $2, number = $3, departure_name = $4, departure_place_id = $5, departure_time = $6,
+        departure_time_zone = $7, arrival_name = $8, arrival_place_id = $9, arrival_time = $10, arrival_time_zone = $11,
+        confirmation_code = $12, seat = $13, version = version + 1, updated_at = NOW()
+    WHERE id = $14 AND version = $15
+    RETURNING version`
+
+	args := []any{
+		segment.Mode, segment.Carrier, segment.Number,
+		segment.Departure.Name, segment.Departure.PlaceID, segment.Departure.Time, segment.Departure.TimeZone,
+		segment.Arrival.Name, segment.Arrival.PlaceID, segment.Arrival.Time, segment.Arrival.TimeZone,
+		segment.ConfirmationCode, segment.Seat, segment.ID, segment.Version,
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx Executor) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&segment.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		segment.localize()
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM segment_travelers WHERE segment_id = $1`, segment.ID)
+		if err != nil {
+			return err
+		}
+
+		return setSegmentTravelers(ctx, tx, segment)
+	})
+}
+
+func setSegmentTravelers(ctx context.Context, tx Executor, segment *Segment) error {
+	query := `
+    INSERT INTO segment_travelers (segment_id, user_id)
+    SELECT $1, unnest($2::bigint[])`
+
+	_, err := tx.ExecContext(ctx, query, segment.ID, pq.Array(segment.Travelers))
+	return err
+}
+
+func (m SegmentModel) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM segments
+    WHERE id = $1 AND version = $2`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// GetAllByTrip returns a trip's segments in departure order.
+func (m SegmentModel) GetAllByTrip(ctx context.Context, tripID int64) ([]*Segment, error) {
+	query := `
+    SELECT ` + segmentColumns + `
+    FROM segments
+    WHERE trip_id = $1
+    ORDER BY departure_time, id`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, tripID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	segments := []*Segment{}
+
+	for rows.Next() {
+		var segment Segment
+
+		err := rows.Scan(segmentFields(&segment)...)
+		if err != nil {
+			return nil, err
+		}
+
+		segment.localize()
+
+		segments = append(segments, &segment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+func ValidateSegment(v *validator.Validator, segment *Segment) {
+	v.Check(validator.PermittedValue(segment.Mode, SegmentModes...), "mode", "must be one of flight, train, bus or car_rental")
+
+	if segment.Mode == SegmentModeFlight {
+		v.Check(segment.Carrier != "", "carrier", "must be provided for flights")
+		v.Check(segment.Number != "", "number", "must be provided for flights")
+	}
+
+	v.Check(len(segment.Carrier) <= 200, "carrier", "must not be more than 200 bytes long")
+	v.Check(len(segment.Number) <= 50, "number", "must not be more than 50 bytes long")
+
+	validateEndpoint(v, "departure", &segment.Departure)
+	validateEndpoint(v, "arrival", &segment.Arrival)
+
+	if !segment.Departure.Time.IsZero() && !segment.Arrival.Time.IsZero() {
+		v.Check(segment.Arrival.Time.After(segment.Departure.Time), "arrival.time", "must be after departure")
+	}
+
+	v.Check(len(segment.ConfirmationCode) <= 100, "confirmation_code", "must not be more than 100 bytes long")
+	v.Check(len(segment.Seat) <= 50, "seat", "must not be more than 50 bytes long")
+
+	v.Check(validator.Unique(segment.Travelers), "travelers", "must not contain duplicate values")
+}
+
+func validateEndpoint(v *validator.Validator, key string, endpoint *Endpoint) {
+	v.Check(endpoint.Name != "", key+".name", "must be provided")
+	v.Check(len(endpoint.Name) <= 500, key+".name", "must not be more than 500 bytes long")
+
+	v.Check(!endpoint.Time.IsZero(), key+".time", "must be provided")
+
+	v.Check(endpoint.TimeZone != "", key+".time_zone", "must be provided")
+	if endpoint.TimeZone != "" {
+		_, err := time.LoadLocation(endpoint.TimeZone)
+		v.Check(err == nil && endpoint.TimeZone != "Local", key+".time_zone", "must be an IANA time zone, such as America/Chicago")
+	}
+
+	v.Check(endpointOffsetMatches(endpoint), key+".time", "must have the UTC offset of time_zone at that time")
+}
+
+// endpointOffsetMatches reports whether an endpoint's time was given with the
+// UTC offset its time zone has at that instant, so the wall-clock time reads
+// the same as on the ticket. It's true when either one is missing or the zone
+// is unknown, since those are reported on their own.
+func endpointOffsetMatches(endpoint *Endpoint) bool {
+	if endpoint.Time.IsZero() || endpoint.TimeZone == "" {
+		return true
+	}
+
+	loc, err := time.LoadLocation(endpoint.TimeZone)
+	if err != nil {
+		return true
+	}
+
+	_, offset := endpoint.Time.Zone()
+	_, want := endpoint.Time.In(loc).Zone()
+
+	return offset == want
+}
+
+// ValidateSegmentInTrip checks that a segment departs and arrives inside the
+// trip. Unlike stays, the days compared are the local ones at each endpoint,
+// so a late flight home on the last day of the trip still fits.
+func ValidateSegmentInTrip(v *validator.Validator, segment *Segment, trip *Trip) {
+	if !segment.Departure.Time.IsZero() {
+		v.Check(!localDate(segment.Departure.Time).Before(DateOf(trip.StartDate).Time), "departure.time", "must not be before the trip starts")
+	}
+	if !segment.Arrival.Time.IsZero() {
+		v.Check(!localDate(segment.Arrival.Time).After(DateOf(trip.EndDate).Time), "arrival.time", "must not be after the trip ends")
+	}
+}
+
+// localDate returns the calendar day t falls on in its own location.
+func localDate(t time.Time) Date {
+	return NewDate(t.Year(), t.Month(), t.Day())
+}
+
+// ValidateSegmentTravelers checks that everyone booked on a segment is going
+// on the trip, either as one of its tripgoers or as the person who created it.
+func ValidateSegmentTravelers(v *validator.Validator, segment *Segment, trip *Trip, tripgoers []*User) {
+	going := map[int64]bool{trip.CreatedBy: true}
+	for _, user := range tripgoers {
+		going[user.ID] = true
+	}
+
+	for _, id := range segment.Travelers {
+		if !going[id] {
+			v.AddError("travelers", fmt.Sprintf("user %d is not going on this trip", id))
+			return
+		}
+	}
+}
+
+// ValidateSegmentConflicts checks that nobody on a segment is booked on
+// another of the trip's segments at the same time. Car rentals run alongside
+// whatever else their travelers do, so they never conflict.
+func ValidateSegmentConflicts(v *validator.Validator, segment *Segment, others []*Segment) {
+	if segment.Mode == SegmentModeCarRental {
+		return
+	}
+
+	for _, other := range others {
+		if other.ID == segment.ID || other.Mode == SegmentModeCarRental || !segment.Overlaps(other) {
+			continue
+		}
+
+		for _, id := range segment.Travelers {
+			if slices.Contains(other.Travelers, id) {
+				v.AddError("travelers", fmt.Sprintf("user %d is already booked on segment %d at that time", id, other.ID))
+				return
+			}
+		}
+	}
+}
+
+// ValidateSegmentActivityConflicts checks that a segment with travelers on it
+// isn't underway during one of the trip's timed activities, which everyone on
+// the trip is expected at. Car rentals don't conflict here either.
+func ValidateSegmentActivityConflicts(v *validator.Validator, segment *Segment, activities []*Activity) {
+	if segment.Mode == SegmentModeCarRental || len(segment.Travelers) == 0 {
+		return
+	}
+
+	for _, activity := range activities {
+		if activity.Schedule != ScheduleTimed || activity.StartTime == nil || activity.EndTime == nil {
+			continue
+		}
+
+		if segment.Departure.Time.Before(*activity.EndTime) && activity.StartTime.Before(segment.Arrival.Time) {
+			v.AddError("travelers", fmt.Sprintf("the segment overlaps activity %d", activity.ID))
+			return
+		}
+	}
+}
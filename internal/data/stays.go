@@ -3,8 +3,11 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/rytwalker/kagubird-api/internal/validator"
 )
 
@@ -19,37 +22,199 @@ type Stay struct {
 	Link      string    `json:"link"`
 	Phone     string    `json:"phone"`
 	Type      string    `json:"type"`
+	Tags      []string  `json:"tags"`
+	Booking   Booking   `json:"booking"`
 	TripID    int64     `json:"trip"`
 	Version   int32     `json:"version"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
 
+var StayListing = Listing{
+	ID:          "stays.id",
+	DefaultSort: "start_time",
+	Sortable: map[string]string{
+		"id":         "stays.id",
+		"name":       "stays.name",
+		"start_time": "stays.start_time",
+		"end_time":   "stays.end_time",
+		"type":       "stays.type",
+	},
+	Filterable: map[string]FilterField{
+		"name":       {Column: "stays.name", Type: FieldText},
+		"type":       {Column: "stays.type", Type: FieldText},
+		"lat":        {Column: "stays.lat", Type: FieldFloat},
+		"lng":        {Column: "stays.lng", Type: FieldFloat},
+		"start_time": {Column: "stays.start_time", Type: FieldTime},
+		"end_time":   {Column: "stays.end_time", Type: FieldTime},
+		"tags":       {Column: "stays.tags", Type: FieldTags},
+	},
+	Aliases: map[string]string{
+		"type": OpEq,
+		"tags": OpAll,
+	},
+}
+
 type StayModel struct {
-	DB *sql.DB
+	DB      Executor
+	Timeout time.Duration
 }
 
-func (m StayModel) Insert(stay *Stay) error {
+func (m StayModel) Get(ctx context.Context, id int64) (*Stay, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	query := `
-    INSERT INTO stays (name, address, start_time, end_time, lat, lng, link, phone, type, trip_id)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+    SELECT id, created_at, updated_at, name, address, lat, lng, start_time, end_time, link, phone, type, tags, trip_id, version,
+        ` + bookingColumns("stays") + `
+    FROM stays
+    WHERE id = $1`
+
+	var stay Stay
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	fields := []any{
+		&stay.ID,
+		&stay.CreatedAt,
+		&stay.UpdatedAt,
+		&stay.Name,
+		&stay.Address,
+		&stay.Lat,
+		&stay.Lng,
+		&stay.StartTime,
+		&stay.EndTime,
+		&stay.Link,
+		&stay.Phone,
+		&stay.Type,
+		pq.Array(&stay.Tags),
+		&stay.TripID,
+		&stay.Version,
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(append(fields, bookingFields(&stay.Booking)...)...)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &stay, nil
+}
+
+func (m StayModel) Insert(ctx context.Context, stay *Stay) error {
+	query := `
+    INSERT INTO stays (name, address, start_time, end_time, lat, lng, link, phone, type, tags, trip_id,
+        booking_status, confirmation_number, provider, cost, currency, cancellation_deadline, payment_due)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
     RETURNING id, created_at, version`
 
-	args := []any{stay.Name, stay.Address, stay.StartTime, stay.EndTime, stay.Lat, stay.Lng, stay.Link, stay.Phone, stay.Type, stay.TripID}
+	args := []any{stay.Name, stay.Address, stay.StartTime, stay.EndTime, stay.Lat, stay.Lng, stay.Link, stay.Phone, stay.Type, pq.Array(stay.Tags), stay.TripID}
+	args = append(args, bookingArgs(&stay.Booking)...)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&stay.ID, &stay.CreatedAt, &stay.Version)
 }
 
-func (m StayModel) GetAllByTrip(trip_id int64) ([]*Stay, error) {
+func (m StayModel) Update(ctx context.Context, stay *Stay) error {
+	query := `
+    UPDATE stays
+    SET name = $1, address = $2, start_time = $3, end_time = $4, lat = $5, lng = $6, link = $7, phone = $8, type = $9, tags = $10,
+        ` + bookingSet(13) + `, version = version + 1, updated_at = NOW()
+    WHERE id = $11 AND version = $12
+    RETURNING version`
+
+	args := []any{stay.Name, stay.Address, stay.StartTime, stay.EndTime, stay.Lat, stay.Lng, stay.Link, stay.Phone, stay.Type, pq.Array(stay.Tags), stay.ID, stay.Version}
+	args = append(args, bookingArgs(&stay.Booking)...)
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&stay.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveBatch inserts the stays that don't have an ID yet and updates the rest,
+// all in one transaction. If any statement fails nothing is saved; a stale
+// version on one of the updates returns ErrEditConflict.
+func (m StayModel) SaveBatch(ctx context.Context, stays []*Stay) error {
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	return inTx(ctx, m.DB, func(tx Executor) error {
+		batch := StayModel{DB: tx, Timeout: m.Timeout}
+
+		for _, stay := range stays {
+			var err error
+			if stay.ID == 0 {
+				err = batch.Insert(ctx, stay)
+			} else {
+				err = batch.Update(ctx, stay)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m StayModel) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM stays
+    WHERE id = $1 AND version = $2`
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+func (m StayModel) GetAllByTrip(ctx context.Context, trip_id int64) ([]*Stay, error) {
 	query := `
-    SELECT  id, name, address, lat, lng,  start_time, end_time, link, phone, type, version 
+    SELECT  id, name, address, lat, lng,  start_time, end_time, link, phone, type, tags, version,
+        ` + bookingColumns("stays") + `
     FROM stays
-    WHERE trip_id = $1`
+    WHERE trip_id = $1
+    ORDER BY start_time, id`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, trip_id)
@@ -65,7 +230,7 @@ func (m StayModel) GetAllByTrip(trip_id int64) ([]*Stay, error) {
 	for rows.Next() {
 		var stay Stay
 
-		err := rows.Scan(
+		fields := []any{
 			&stay.ID,
 			&stay.Name,
 			&stay.Address,
@@ -76,8 +241,11 @@ func (m StayModel) GetAllByTrip(trip_id int64) ([]*Stay, error) {
 			&stay.Link,
 			&stay.Phone,
 			&stay.Type,
+			pq.Array(&stay.Tags),
 			&stay.Version,
-		)
+		}
+
+		err := rows.Scan(append(fields, bookingFields(&stay.Booking)...)...)
 
 		if err != nil {
 			return nil, err
@@ -94,6 +262,55 @@ func (m StayModel) GetAllByTrip(trip_id int64) ([]*Stay, error) {
 	return stays, nil
 }
 
+// GetAll returns a page of a trip's stays. The metadata includes type and tag facet counts across the whole trip.
+func (m StayModel) GetAll(ctx context.Context, tripID int64, filters Filters) ([]*Stay, Metadata, error) {
+	q := listQuery{
+		columns: `stays.id, stays.name, stays.address, stays.lat, stays.lng, stays.start_time, stays.end_time, stays.link,
+        stays.phone, stays.type, stays.tags, stays.trip_id, stays.version, ` + bookingColumns("stays"),
+		from:  "stays",
+		where: "stays.trip_id = $1",
+		args:  []any{tripID},
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	fields := func(stay *Stay) []any {
+		return append([]any{
+			&stay.ID,
+			&stay.Name,
+			&stay.Address,
+			&stay.Lat,
+			&stay.Lng,
+			&stay.StartTime,
+			&stay.EndTime,
+			&stay.Link,
+			&stay.Phone,
+			&stay.Type,
+			pq.Array(&stay.Tags),
+			&stay.TripID,
+			&stay.Version,
+		}, bookingFields(&stay.Booking)...)
+	}
+
+	stays, metadata, err := list(ctx, m.DB, StayListing, q, filters, fields, func(stay *Stay) int64 { return stay.ID })
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	facetQuery := `
+    SELECT 'type', type, count(*) FROM stays WHERE trip_id = $1 GROUP BY type
+    UNION ALL
+    SELECT 'tag', tag, count(*) FROM stays, unnest(tags) AS tag WHERE trip_id = $1 GROUP BY tag`
+
+	metadata.Facets, err = queryFacets(ctx, m.DB, facetQuery, tripID)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return stays, metadata, nil
+}
+
 func ValidateStay(v *validator.Validator, stay *Stay) {
 	// name validations
 	v.Check(stay.Name != "", "name", "must be provided")
@@ -107,7 +324,33 @@ func ValidateStay(v *validator.Validator, stay *Stay) {
 	// lng validations
 	v.Check(stay.Lng != 0, "lng", "must be provided")
 
+	// start_time and end_time validations
+	v.Check(!stay.StartTime.IsZero(), "start_time", "must be provided")
+	v.Check(!stay.EndTime.IsZero(), "end_time", "must be provided")
+	v.Check(stay.EndTime.After(stay.StartTime), "end_time", "must be after start time")
+
+	// link validations
+	v.Check(stay.Link == "" || validator.URL(stay.Link), "link", "must be an http or https URL")
+	v.Check(len(stay.Link) <= 2000, "link", "must not be more than 2000 bytes long")
+
+	// type validations
+	v.Check(validator.PermittedValue(stay.Type, StayTypes...), "type", "must be one of hotel, rental, hostel, camping or friends")
+
+	// tags validations
+	ValidateTags(v, stay.Tags)
+
+	// booking validations
+	ValidateBooking(v, &stay.Booking)
+
 	// activity_id validations
 	v.Check(stay.TripID != 0, "trip_id", "must be provided")
 
 }
+
+// ValidateStayInTrip checks that check-in and check-out fall inside the trip.
+// Whole UTC days are compared, so checking out on the trip's last day is fine
+// whatever time the trip's end_date is stored with.
+func ValidateStayInTrip(v *validator.Validator, stay *Stay, trip *Trip) {
+	v.Check(!DateOf(stay.StartTime).Before(DateOf(trip.StartDate).Time), "start_time", "must not be before the trip starts")
+	v.Check(!DateOf(stay.EndTime).After(DateOf(trip.EndDate).Time), "end_time", "must not be after the trip ends")
+}
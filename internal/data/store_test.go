@@ -0,0 +1,705 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/rytwalker/kagubird-api/internal/migrate"
+	"github.com/rytwalker/kagubird-api/migrations"
+)
+
+// testDB is the Postgres database the store tests run against, besides the
+// memory store. It's named by KAGUBIRD_TEST_DB_DSN and gets migrated and
+// emptied by the tests, so it must not hold anything worth keeping.
+var testDB struct {
+	once sync.Once
+	db   *sql.DB
+	err  error
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("KAGUBIRD_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("KAGUBIRD_TEST_DB_DSN isn't set")
+	}
+
+	testDB.once.Do(func() {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			testDB.err = err
+			return
+		}
+
+		migrator, err := migrate.New(db, migrations.FS, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		if err != nil {
+			testDB.err = err
+			return
+		}
+
+		testDB.db, testDB.err = db, migrator.Up(context.Background())
+	})
+
+	if testDB.err != nil {
+		t.Fatal(testDB.err)
+	}
+
+	return testDB.db
+}
+
+// truncate empties every table but the ones the migrations fill in.
+func truncate(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	rows, err := db.Query(`
+    SELECT tablename FROM pg_tables
+    WHERE schemaname = current_schema() AND tablename NOT IN ('schema_migrations', 'permissions')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	tables := []string{}
+
+	for rows.Next() {
+		var table string
+
+		err := rows.Scan(&table)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tables = append(tables, table)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`TRUNCATE ` + strings.Join(tables, ", ") + ` RESTART IDENTITY CASCADE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// forEachStore runs test against a fresh memory store, and against an emptied
+// Postgres database when one is configured, so both keep the same semantics.
+func forEachStore(t *testing.T, test func(t *testing.T, models Models)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryModels())
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		db := openTestDB(t)
+		truncate(t, db)
+		test(t, NewModels(db, 5*time.Second))
+	})
+}
+
+func insertTestUser(t *testing.T, models Models, email string) *User {
+	t.Helper()
+
+	user := &User{Name: "Test", Email: email, Password: password{hash: []byte("hash")}, Activated: true}
+
+	err := models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func insertTestTrip(t *testing.T, models Models, userID int64) *Trip {
+	t.Helper()
+
+	start := time.Date(2026, time.November, 9, 0, 0, 0, 0, time.UTC)
+
+	trip := &Trip{
+		Name:          "Chicago",
+		City:          "Chicago",
+		StateCode:     "IL",
+		GooglePlaceID: "ChIJ7cv00DwsDogRAMDACa2m4K8",
+		Lat:           41.878114,
+		Lng:           -87.629798,
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 3),
+		CreatedBy:     userID,
+	}
+
+	err := models.Trips.Insert(context.Background(), trip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return trip
+}
+
+func insertTestActivity(t *testing.T, models Models, tripID int64) *Activity {
+	t.Helper()
+
+	activity := &Activity{
+		Name:     "Jazz at the Green Mill",
+		Category: CategoryEntertainment,
+		Tags:     []string{},
+		Schedule: ScheduleUnscheduled,
+		Booking:  Booking{Status: BookingStatusIdea},
+		TripID:   tripID,
+	}
+
+	err := models.Activities.Insert(context.Background(), activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return activity
+}
+
+func insertTestStay(t *testing.T, models Models, trip *Trip) *Stay {
+	t.Helper()
+
+	stay := &Stay{
+		Name:      "The Hoxton",
+		StartTime: trip.StartDate.Add(15 * time.Hour),
+		EndTime:   trip.EndDate.Add(11 * time.Hour),
+		Address:   "200 N Green St, Chicago, IL 60607",
+		Lat:       41.885,
+		Lng:       -87.649,
+		Type:      StayTypeHotel,
+		Tags:      []string{},
+		Booking:   Booking{Status: BookingStatusBooked},
+		TripID:    trip.ID,
+	}
+
+	err := models.Stays.Insert(context.Background(), stay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stay
+}
+
+func assertError(t *testing.T, err, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, models Models)
+	}{
+		{
+			name: "duplicate email",
+			test: func(t *testing.T, models Models) {
+				insertTestUser(t, models, "alice@example.com")
+				bob := insertTestUser(t, models, "bob@example.com")
+
+				err := models.Users.Insert(ctx, &User{Name: "Alice", Email: "alice@example.com", Password: password{hash: []byte("hash")}})
+				assertError(t, err, ErrDuplicateEmail)
+
+				bob.Email = "alice@example.com"
+				assertError(t, models.Users.Update(ctx, bob), ErrDuplicateEmail)
+			},
+		},
+		{
+			name: "missing records",
+			test: func(t *testing.T, models Models) {
+				_, err := models.Users.Get(ctx, 1)
+				assertError(t, err, ErrRecordNotFound)
+
+				_, err = models.Trips.Get(ctx, 1)
+				assertError(t, err, ErrRecordNotFound)
+
+				_, err = models.Activities.Get(ctx, 1)
+				assertError(t, err, ErrRecordNotFound)
+
+				_, err = models.Stays.Get(ctx, 1)
+				assertError(t, err, ErrRecordNotFound)
+
+				assertError(t, models.Trips.Delete(ctx, 1), ErrRecordNotFound)
+				assertError(t, models.Activities.Delete(ctx, 1), ErrRecordNotFound)
+			},
+		},
+		{
+			name: "trip versions",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+				trip := insertTestTrip(t, models, user.ID)
+
+				stale := *trip
+
+				trip.Name = "Chicago in the fall"
+				err := models.Trips.Update(ctx, trip)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if trip.Version != stale.Version+1 {
+					t.Errorf("got version %d after an update, want %d", trip.Version, stale.Version+1)
+				}
+
+				stale.Name = "Chicago in the spring"
+				assertError(t, models.Trips.Update(ctx, &stale), ErrEditConflict)
+				assertError(t, models.Trips.DeleteVersion(ctx, trip.ID, stale.Version), ErrEditConflict)
+
+				got, err := models.Trips.Get(ctx, trip.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got.Name != "Chicago in the fall" {
+					t.Errorf("got name %q, want the first update's", got.Name)
+				}
+
+				err = models.Trips.DeleteVersion(ctx, trip.ID, trip.Version)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "activity versions",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+				trip := insertTestTrip(t, models, user.ID)
+				activity := insertTestActivity(t, models, trip.ID)
+
+				stale := *activity
+
+				activity.Notes = "Get there before 9."
+				err := models.Activities.Update(ctx, activity)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				assertError(t, models.Activities.Update(ctx, &stale), ErrEditConflict)
+				assertError(t, models.Activities.DeleteVersion(ctx, activity.ID, stale.Version), ErrEditConflict)
+
+				err = models.Activities.DeleteVersion(ctx, activity.ID, activity.Version)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_, err = models.Activities.Get(ctx, activity.ID)
+				assertError(t, err, ErrRecordNotFound)
+			},
+		},
+		{
+			name: "deleting a trip cascades",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+				trip := insertTestTrip(t, models, user.ID)
+				activity := insertTestActivity(t, models, trip.ID)
+				stay := insertTestStay(t, models, trip)
+
+				err := models.TripGoers.Insert(ctx, user.ID, trip.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = models.Trips.Delete(ctx, trip.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_, err = models.Activities.Get(ctx, activity.ID)
+				assertError(t, err, ErrRecordNotFound)
+
+				_, err = models.Stays.Get(ctx, stay.ID)
+				assertError(t, err, ErrRecordNotFound)
+
+				users, err := models.Users.GetAllByTrip(ctx, trip.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(users) != 0 {
+					t.Errorf("got %d tripgoers of a deleted trip, want none", len(users))
+				}
+
+				_, err = models.Users.Get(ctx, user.ID)
+				if err != nil {
+					t.Errorf("deleting a trip deleted its creator: %v", err)
+				}
+			},
+		},
+		{
+			name: "places without a Google place ID",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+				trip := insertTestTrip(t, models, user.ID)
+				activity := insertTestActivity(t, models, trip.ID)
+
+				var places []*Place
+
+				for _, name := range []string{"Green Mill", "Aragon Ballroom"} {
+					place := &Place{Name: name, Address: "Uptown, Chicago", Lat: 41.969, Lng: -87.659}
+
+					err := models.Places.Ensure(ctx, place)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					places = append(places, place)
+				}
+
+				// nothing to match them by, so they're two places
+				if places[0].ID == places[1].ID {
+					t.Fatalf("got one place for two without Google place IDs")
+				}
+
+				location := &Location{ActivityID: activity.ID, PlaceID: places[1].ID}
+
+				err := models.Locations.Insert(ctx, location)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if location.PlaceID != places[1].ID || location.Name != "Aragon Ballroom" || location.GooglePlaceID != "" {
+					t.Errorf("got location of place %d %q, want the linked place %d", location.PlaceID, location.Name, places[1].ID)
+				}
+
+				err = models.Locations.Insert(ctx, &Location{ActivityID: activity.ID, PlaceID: 1000})
+				assertError(t, err, ErrRecordNotFound)
+			},
+		},
+		{
+			name: "trip members",
+			test: func(t *testing.T, models Models) {
+				alice := insertTestUser(t, models, "alice@example.com")
+				bob := insertTestUser(t, models, "bob@example.com")
+				carol := insertTestUser(t, models, "carol@example.com")
+				trip := insertTestTrip(t, models, alice.ID)
+
+				err := models.TripGoers.Insert(ctx, bob.ID, trip.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, user := range []*User{alice, bob, carol} {
+					member, err := models.TripGoers.IsMember(ctx, user.ID, trip.ID)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if want := user != carol; member != want {
+						t.Errorf("%s: got member %t, want %t", user.Email, member, want)
+					}
+				}
+			},
+		},
+		{
+			name: "drafts from one message",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+				trip := insertTestTrip(t, models, user.ID)
+
+				draft := func(messageID string, part int) *Draft {
+					return &Draft{
+						TripID:    trip.ID,
+						Kind:      DraftKindActivity,
+						Activity:  &Activity{Name: "Show", Tags: []string{}},
+						MessageID: messageID,
+						Part:      part,
+					}
+				}
+
+				for _, d := range []*Draft{draft("m1", 0), draft("m1", 1), draft("", 0), draft("", 0)} {
+					err := models.Drafts.Insert(ctx, d)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				err := models.Drafts.Insert(ctx, draft("m1", 1))
+				assertError(t, err, ErrDuplicateMessage)
+
+				drafts, err := models.Drafts.GetAllByMessage(ctx, trip.ID, "m1")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(drafts) != 2 || drafts[0].Part != 0 || drafts[1].Part != 1 {
+					t.Errorf("got drafts %+v, want parts 0 and 1", drafts)
+				}
+			},
+		},
+		{
+			name: "reminder recipients",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+				trip := insertTestTrip(t, models, user.ID)
+				stay := insertTestStay(t, models, trip)
+
+				reminder := &BookingReminder{
+					Item:     BookingItem{Kind: "stay", ID: stay.ID},
+					TripID:   trip.ID,
+					Deadline: BookingDeadlineCancellation,
+					Due:      trip.StartDate.Add(-48 * time.Hour),
+				}
+
+				assertReminded := func(reminder *BookingReminder, want ...string) {
+					t.Helper()
+
+					got, err := models.Bookings.GetRemindedRecipients(ctx, reminder)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if !slices.Equal(got, want) {
+						t.Errorf("got reminded recipients %q, want %q", got, want)
+					}
+				}
+
+				for _, email := range []string{"bob@example.com", "alice@example.com", "bob@example.com"} {
+					err := models.Bookings.MarkRecipientReminded(ctx, reminder, email)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				assertReminded(reminder, "alice@example.com", "bob@example.com")
+
+				// a changed deadline reminds everyone again
+				moved := *reminder
+				moved.Due = moved.Due.Add(time.Hour)
+				assertReminded(&moved)
+
+				err := models.Bookings.MarkReminded(ctx, reminder)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				assertReminded(reminder)
+
+				err = models.Bookings.MarkRecipientReminded(ctx, reminder, "alice@example.com")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = models.Trips.Delete(ctx, trip.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				assertReminded(reminder)
+			},
+		},
+		{
+			name: "transactions",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+
+				var rolledBack, committed *Trip
+				errRollback := errors.New("roll back")
+
+				err := models.Transaction(ctx, func(tx Models) error {
+					rolledBack = insertTestTrip(t, tx, user.ID)
+					return errRollback
+				})
+				assertError(t, err, errRollback)
+
+				_, err = models.Trips.Get(ctx, rolledBack.ID)
+				assertError(t, err, ErrRecordNotFound)
+
+				err = models.Transaction(ctx, func(tx Models) error {
+					committed = insertTestTrip(t, tx, user.ID)
+
+					// nested transactions join the outer one
+					return tx.Transaction(ctx, func(tx Models) error {
+						insertTestActivity(t, tx, committed.ID)
+						return nil
+					})
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				activities, err := models.Activities.GetAllByTrip(ctx, committed.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(activities) != 1 {
+					t.Errorf("got %d activities after commit, want 1", len(activities))
+				}
+			},
+		},
+		{
+			name: "search snippets",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+				trip := insertTestTrip(t, models, user.ID)
+				activity := insertTestActivity(t, models, trip.ID)
+
+				activity.Notes = `Jazz & blues <script>alert("hi")</script> \x01until close\x02`
+				err := models.Activities.Update(ctx, activity)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				results, _, err := models.Search.Search(ctx, user.ID, "blues", Filters{Page: 1, PageSize: 20})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(results) != 1 {
+					t.Fatalf("got %d results, want 1", len(results))
+				}
+
+				snippet := results[0].Snippet
+				if !strings.Contains(snippet, "<mark>blues</mark>") {
+					t.Errorf("snippet %q doesn't mark the match", snippet)
+				}
+				if strings.Contains(snippet, "<script>") || !strings.Contains(snippet, "&lt;script&gt;") {
+					t.Errorf("snippet %q doesn't escape the notes", snippet)
+				}
+				if strings.Count(snippet, "<mark>") != 1 || strings.Count(snippet, "</mark>") != 1 {
+					t.Errorf("snippet %q has marks that aren't for the match", snippet)
+				}
+			},
+		},
+		{
+			name: "cancelled requests",
+			test: func(t *testing.T, models Models) {
+				user := insertTestUser(t, models, "alice@example.com")
+				trip := insertTestTrip(t, models, user.ID)
+
+				cancelled, cancel := context.WithCancel(ctx)
+				cancel()
+
+				_, err := models.Trips.Get(cancelled, trip.ID)
+				assertError(t, err, context.Canceled)
+
+				_, _, err = models.Activities.GetAll(cancelled, trip.ID, Filters{Page: 1, PageSize: 20, Sort: "start_time", SortSafelist: []string{"start_time"}})
+				assertError(t, err, context.Canceled)
+
+				trip.Name = "Never saved"
+				assertError(t, models.Trips.Update(cancelled, trip), context.Canceled)
+
+				err = models.Transaction(cancelled, func(tx Models) error {
+					return tx.Trips.Delete(cancelled, trip.ID)
+				})
+				assertError(t, err, context.Canceled)
+
+				saved, err := models.Trips.Get(ctx, trip.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if saved.Name == "Never saved" {
+					t.Error("an update with a cancelled context was saved")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachStore(t, tt.test)
+		})
+	}
+}
+
+// A transaction in the memory store holds its lock until fn returns, so using
+// the outer models from fn returns an error rather than hanging.
+func TestMemoryTransactionOuterCall(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- models.Transaction(ctx, func(tx Models) error {
+			_, err := models.Trips.Get(ctx, 1)
+			return err
+		})
+	}()
+
+	select {
+	case err := <-done:
+		assertError(t, err, errOuterCallInTransaction)
+	case <-time.After(5 * time.Second):
+		t.Fatal("outer call inside a transaction deadlocked")
+	}
+
+	// the store is usable again afterwards
+	_, err := models.Trips.Get(ctx, 1)
+	assertError(t, err, ErrRecordNotFound)
+}
+
+func TestQueryContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		parent  time.Duration
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{"default timeout", 0, 0, DefaultQueryTimeout},
+		{"model timeout", 0, 10 * time.Second, 10 * time.Second},
+		{"sooner request deadline", time.Second, 10 * time.Second, time.Second},
+		{"later request deadline", time.Minute, 10 * time.Second, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := context.Background()
+			if tt.parent > 0 {
+				var cancel context.CancelFunc
+				parent, cancel = context.WithTimeout(parent, tt.parent)
+				defer cancel()
+			}
+
+			ctx, cancel := queryContext(parent, tt.timeout)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("query context has no deadline")
+			}
+
+			if left := time.Until(deadline); left > tt.want || left < tt.want-time.Second {
+				t.Errorf("got a deadline %s away, want %s", left, tt.want)
+			}
+		})
+	}
+
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, stop := queryContext(parent, 0)
+	defer stop()
+
+	cancel()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("got %v from the query context after cancelling the request, want %v", ctx.Err(), context.Canceled)
+	}
+}
+
+// A model's timeout cancels the statement in postgres, not just the wait for it.
+func TestQueryTimeout(t *testing.T) {
+	db := openTestDB(t)
+
+	ctx, cancel := queryContext(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `SELECT pg_sleep(5)`)
+	if !errors.Is(err, context.DeadlineExceeded) && (err == nil || !strings.Contains(err.Error(), "canceling statement")) {
+		t.Errorf("got error %v from a statement outliving its timeout, want it cancelled", err)
+	}
+}
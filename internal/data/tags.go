@@ -0,0 +1,80 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/rytwalker/kagubird-api/internal/validator"
+)
+
+// The fixed taxonomy activities are filed under.
+const (
+	CategoryFood          = "food"
+	CategoryDrinks        = "drinks"
+	CategorySightseeing   = "sightseeing"
+	CategoryOutdoors      = "outdoors"
+	CategoryEntertainment = "entertainment"
+	CategoryShopping      = "shopping"
+	CategoryTransport     = "transport"
+	CategoryWork          = "work"
+	CategoryOther         = "other"
+)
+
+var ActivityCategories = []string{
+	CategoryFood,
+	CategoryDrinks,
+	CategorySightseeing,
+	CategoryOutdoors,
+	CategoryEntertainment,
+	CategoryShopping,
+	CategoryTransport,
+	CategoryWork,
+	CategoryOther,
+}
+
+// The kinds of places people stay at.
+const (
+	StayTypeHotel   = "hotel"
+	StayTypeRental  = "rental"
+	StayTypeHostel  = "hostel"
+	StayTypeCamping = "camping"
+	StayTypeFriends = "friends"
+)
+
+var StayTypes = []string{
+	StayTypeHotel,
+	StayTypeRental,
+	StayTypeHostel,
+	StayTypeCamping,
+	StayTypeFriends,
+}
+
+// NormalizeTags lowercases and trims user supplied tags, dropping blanks and
+// duplicates while keeping the original order. It never returns nil so that an
+// untagged record encodes as an empty JSON array.
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(len(tags) <= 20, "tags", "must not contain more than 20 tags")
+
+	for _, tag := range tags {
+		v.Check(tag != "", "tags", "must not contain blank tags")
+		v.Check(len(tag) <= 50, "tags", "must not contain tags more than 50 bytes long")
+	}
+
+	v.Check(validator.Unique(tags), "tags", "must not contain duplicate values")
+}
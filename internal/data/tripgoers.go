@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"time"
 )
 
@@ -11,18 +10,82 @@ type TripGoer struct {
 	TripID int64 `json:"trip_id"`
 }
 
+var TripGoerListing = Listing{
+	ID:          "users.id",
+	DefaultSort: "name",
+	Sortable: map[string]string{
+		"id":    "users.id",
+		"name":  "users.name",
+		"email": "users.email",
+	},
+	Filterable: map[string]FilterField{
+		"name":      {Column: "users.name", Type: FieldText},
+		"email":     {Column: "users.email", Type: FieldText},
+		"activated": {Column: "users.activated", Type: FieldBool},
+	},
+	Aliases: map[string]string{
+		"email": OpEq,
+	},
+}
+
 type TripGoerModel struct {
-	DB *sql.DB
+	DB      Executor
+	Timeout time.Duration
 }
 
-func (m TripGoerModel) Insert(userID int64, tripID int64) error {
+func (m TripGoerModel) Insert(ctx context.Context, userID int64, tripID int64) error {
 	query := `
     INSERT INTO trip_goers (user_id, trip_id) 
     VALUES ($1, $2)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, tripID)
 	return err
 }
+
+// IsMember reports whether a user created or is going on a trip, which is who
+// may see and change what's on it.
+func (m TripGoerModel) IsMember(ctx context.Context, userID int64, tripID int64) (bool, error) {
+	query := `
+    SELECT EXISTS (
+        SELECT 1 FROM trips WHERE id = $2 AND created_by = $1
+        UNION ALL
+        SELECT 1 FROM trip_goers WHERE trip_id = $2 AND user_id = $1
+    )`
+
+	var member bool
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, tripID).Scan(&member)
+	return member, err
+}
+
+// GetAll returns a page of the users going on a trip.
+func (m TripGoerModel) GetAll(ctx context.Context, tripID int64, filters Filters) ([]*User, Metadata, error) {
+	q := listQuery{
+		columns: "users.id, users.created_at, users.name, users.email, users.activated, users.version",
+		from:    "trip_goers JOIN users ON users.id = trip_goers.user_id",
+		where:   "trip_goers.trip_id = $1",
+		args:    []any{tripID},
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	fields := func(user *User) []any {
+		return []any{
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+		}
+	}
+
+	return list(ctx, m.DB, TripGoerListing, q, filters, fields, func(user *User) int64 { return user.ID })
+}
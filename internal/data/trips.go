@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/rytwalker/kagubird-api/internal/validator"
@@ -23,17 +22,45 @@ type Trip struct {
 	CreatedBy     int64       `json:"created_by"`
 	Activities    []*Activity `json:"activities"`
 	Stays         []*Stay     `json:"stays"`
+	Segments      []*Segment  `json:"segments"`
 	TripGoers     []*User     `json:"tripgoers"`
 	Version       int32       `json:"version"`
 	CreatedAt     time.Time   `json:"-"`
 	UpdatedAt     time.Time   `json:"-"`
 }
 
+var TripListing = Listing{
+	ID:          "trips.id",
+	DefaultSort: "id",
+	Sortable: map[string]string{
+		"id":         "trips.id",
+		"name":       "trips.name",
+		"city":       "trips.city",
+		"start_date": "trips.start_date",
+		"end_date":   "trips.end_date",
+	},
+	Filterable: map[string]FilterField{
+		"name":       {Column: "trips.name", Type: FieldText},
+		"city":       {Column: "trips.city", Type: FieldText},
+		"state_code": {Column: "trips.state_code", Type: FieldText},
+		"lat":        {Column: "trips.lat", Type: FieldFloat},
+		"lng":        {Column: "trips.lng", Type: FieldFloat},
+		"start_date": {Column: "trips.start_date", Type: FieldTime},
+		"end_date":   {Column: "trips.end_date", Type: FieldTime},
+		"created_by": {Column: "trips.created_by", Type: FieldInt},
+	},
+	Aliases: map[string]string{
+		"city":       OpEq,
+		"state_code": OpEq,
+	},
+}
+
 type TripModel struct {
-	DB *sql.DB
+	DB      Executor
+	Timeout time.Duration
 }
 
-func (t TripModel) Insert(trip *Trip) error {
+func (t TripModel) Insert(ctx context.Context, trip *Trip) error {
 	query := `
     INSERT INTO trips (name, city, state_code, google_place_id, lat, lng, start_date, end_date, created_by)
     VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
@@ -41,13 +68,23 @@ func (t TripModel) Insert(trip *Trip) error {
 
 	args := []any{trip.Name, trip.City, trip.StateCode, trip.GooglePlaceID, trip.Lat, trip.Lng, trip.StartDate.UTC(), trip.EndDate.UTC(), trip.CreatedBy}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, t.Timeout)
 	defer cancel()
 
 	return t.DB.QueryRowContext(ctx, query, args...).Scan(&trip.ID, &trip.CreatedAt, &trip.Version)
 }
 
-func (t TripModel) Get(id int64) (*Trip, error) {
+func (t TripModel) Get(ctx context.Context, id int64) (*Trip, error) {
+	return t.get(ctx, id, "")
+}
+
+// GetForUpdate is Get with a row lock, so that inside a transaction nobody else
+// can change or delete the trip until it commits.
+func (t TripModel) GetForUpdate(ctx context.Context, id int64) (*Trip, error) {
+	return t.get(ctx, id, "FOR UPDATE")
+}
+
+func (t TripModel) get(ctx context.Context, id int64, lock string) (*Trip, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -55,11 +92,11 @@ func (t TripModel) Get(id int64) (*Trip, error) {
 	query := `
     SELECT id, created_at, name, city, state_code, google_place_id, lat, lng, start_date, end_date, created_by, version
     FROM trips
-    WHERE id = $1`
+    WHERE id = $1 ` + lock
 
 	var trip Trip
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, t.Timeout)
 
 	defer cancel()
 
@@ -96,7 +133,7 @@ func (t TripModel) Get(id int64) (*Trip, error) {
 	return &trip, nil
 }
 
-func (t TripModel) Update(trip *Trip) error {
+func (t TripModel) Update(ctx context.Context, trip *Trip) error {
 	query := `
     UPDATE trips
     SET name = $1, city = $2, state_code = $3, google_place_id = $4, lat = $5, lng = $6, start_date = $7, end_date = $8, version = version + 1 
@@ -116,7 +153,7 @@ func (t TripModel) Update(trip *Trip) error {
 		trip.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, t.Timeout)
 	defer cancel()
 
 	err := t.DB.QueryRowContext(ctx, query, args...).Scan(&trip.Version)
@@ -132,7 +169,32 @@ func (t TripModel) Update(trip *Trip) error {
 	return nil
 }
 
-func (t TripModel) Delete(id int64) error {
+// Transfer hands the trip over to another user. It is version checked like
+// Update, which doesn't touch created_by.
+func (t TripModel) Transfer(ctx context.Context, trip *Trip, userID int64) error {
+	query := `
+    UPDATE trips
+    SET created_by = $1, version = version + 1
+    WHERE id = $2 AND version = $3
+    RETURNING created_by, version`
+
+	ctx, cancel := queryContext(ctx, t.Timeout)
+	defer cancel()
+
+	err := t.DB.QueryRowContext(ctx, query, userID, trip.ID, trip.Version).Scan(&trip.CreatedBy, &trip.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t TripModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -141,7 +203,7 @@ func (t TripModel) Delete(id int64) error {
     DELETE FROM trips
     WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, t.Timeout)
 	defer cancel()
 
 	result, err := t.DB.ExecContext(ctx, query, id)
@@ -161,35 +223,50 @@ func (t TripModel) Delete(id int64) error {
 	return nil
 }
 
-func (t TripModel) GetAll(name string, start_date string, end_date string, filters Filters) ([]*Trip, Metadata, error) {
-	query := fmt.Sprintf(`
-    SELECT count(*) OVER(), id, created_at, name, city, state_code, google_place_id, lat, lng, start_date, end_date, version
-    FROM trips
-    WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
-    ORDER BY %s %s, id ASC
-    LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+// DeleteVersion deletes the trip only if it's still at the given version,
+// returning ErrEditConflict otherwise.
+func (t TripModel) DeleteVersion(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+    DELETE FROM trips
+    WHERE id = $1 AND version = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, t.Timeout)
 	defer cancel()
 
-	args := []any{name, filters.limit(), filters.offset()}
+	result, err := t.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
 
-	rows, err := t.DB.QueryContext(ctx, query, args...)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return nil, Metadata{}, err
+		return err
 	}
 
-	defer rows.Close()
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
 
-	totalRecords := 0
-	trips := []*Trip{}
+	return nil
+}
 
-	// rows.Next iterates
-	for rows.Next() {
-		var trip Trip
+func (t TripModel) GetAll(ctx context.Context, name string, start_date string, end_date string, filters Filters) ([]*Trip, Metadata, error) {
+	q := listQuery{
+		columns: "trips.id, trips.created_at, trips.name, trips.city, trips.state_code, trips.google_place_id, trips.lat, trips.lng, trips.start_date, trips.end_date, trips.version",
+		from:    "trips",
+		where:   "(to_tsvector('simple', trips.name) @@ plainto_tsquery('simple', $1) OR $1 = '')",
+		args:    []any{name},
+	}
 
-		err := rows.Scan(
-			&totalRecords,
+	ctx, cancel := queryContext(ctx, t.Timeout)
+	defer cancel()
+
+	fields := func(trip *Trip) []any {
+		return []any{
 			&trip.ID,
 			&trip.CreatedAt,
 			&trip.Name,
@@ -201,21 +278,10 @@ func (t TripModel) GetAll(name string, start_date string, end_date string, filte
 			&trip.StartDate,
 			&trip.EndDate,
 			&trip.Version,
-		)
-
-		if err != nil {
-			return nil, Metadata{}, err
 		}
-
-		trips = append(trips, &trip)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	return trips, metadata, nil
+	return list(ctx, t.DB, TripListing, q, filters, fields, func(trip *Trip) int64 { return trip.ID })
 }
 
 func ValidateTrip(v *validator.Validator, trip *Trip) {
@@ -38,11 +38,29 @@ type password struct {
 	hash      []byte
 }
 
+var UserListing = Listing{
+	ID:          "users.id",
+	DefaultSort: "id",
+	Sortable: map[string]string{
+		"id":         "users.id",
+		"name":       "users.name",
+		"email":      "users.email",
+		"created_at": "users.created_at",
+	},
+	Filterable: map[string]FilterField{
+		"name":       {Column: "users.name", Type: FieldText},
+		"email":      {Column: "users.email", Type: FieldText},
+		"activated":  {Column: "users.activated", Type: FieldBool},
+		"created_at": {Column: "users.created_at", Type: FieldTime},
+	},
+}
+
 type UserModel struct {
-	DB *sql.DB
+	DB      Executor
+	Timeout time.Duration
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
     INSERT INTO users (name, email, password_hash, activated) 
     VALUES ($1, $2, $3, $4)
@@ -50,7 +68,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -66,7 +84,69 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, created_at, name, email, password_hash, activated, version
+    FROM users
+    WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAll returns a page of every user, for admin tooling.
+func (m UserModel) GetAll(ctx context.Context, filters Filters) ([]*User, Metadata, error) {
+	q := listQuery{
+		columns: "users.id, users.created_at, users.name, users.email, users.activated, users.version",
+		from:    "users",
+		where:   "true",
+	}
+
+	ctx, cancel := queryContext(ctx, m.Timeout)
+	defer cancel()
+
+	fields := func(user *User) []any {
+		return []any{
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+		}
+	}
+
+	return list(ctx, m.DB, UserListing, q, filters, fields, func(user *User) int64 { return user.ID })
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
     SELECT id, created_at, name, email, password_hash, activated, version
     FROM users
@@ -74,7 +154,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -99,7 +179,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) GetAllByTrip(tripID int64) ([]*User, error) {
+func (m UserModel) GetAllByTrip(ctx context.Context, tripID int64) ([]*User, error) {
 	query := `
     SELECT  u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.version
     FROM trips t
@@ -107,7 +187,7 @@ func (m UserModel) GetAllByTrip(tripID int64) ([]*User, error) {
     JOIN users u ON tg.user_id = u.id
     WHERE t.id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, tripID)
@@ -147,7 +227,7 @@ func (m UserModel) GetAllByTrip(tripID int64) ([]*User, error) {
 	return users, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
     UPDATE users 
     SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -163,7 +243,7 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -233,7 +313,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 	}
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
@@ -249,7 +329,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -0,0 +1,56 @@
+package geocode
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is a Geocoder that answers from a fixed table, for tests and demos.
+// Addresses are looked up the way the gazetteer compares them, ignoring case
+// and punctuation. If Err is set every call fails with it.
+type Fake struct {
+	Results map[string]Result
+	Err     error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+// NewFake returns a Fake that answers with results, keyed by address.
+func NewFake(results map[string]Result) *Fake {
+	normalized := make(map[string]Result, len(results))
+	for address, result := range results {
+		normalized[normalize(address)] = result
+	}
+
+	return &Fake{Results: normalized}
+}
+
+func (f *Fake) Geocode(ctx context.Context, address string) (Result, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, address)
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	if f.Err != nil {
+		return Result{}, f.Err
+	}
+
+	result, ok := f.Results[normalize(address)]
+	if !ok {
+		return Result{}, ErrNoMatch
+	}
+
+	return result, nil
+}
+
+// Calls returns the addresses Geocode was called with, in order.
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.calls...)
+}
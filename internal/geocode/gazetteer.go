@@ -0,0 +1,289 @@
+package geocode
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Gazetteer is an offline Geocoder backed by a GeoNames dump, such as
+// cities15000.txt from https://download.geonames.org/export/dump/. It places
+// addresses at the level of the places in the dump, so a street address gets
+// the coordinates of its city, with ConfidenceMedium to say so.
+//
+// Names are matched with the most specific part of the address first. Later
+// parts, like a state or country code, qualify the match against each place's
+// admin1 and country codes, and against admin1 names if the dump has ADM1
+// rows. When nothing qualifies a match the most populous place wins.
+type Gazetteer struct {
+	places      []gazetteerPlace
+	names       map[string][]int
+	admin1Names map[string]string
+}
+
+type gazetteerPlace struct {
+	name       string
+	lat        float64
+	lng        float64
+	country    string
+	admin1     string
+	population int64
+}
+
+// GeoNames dump columns, tab separated.
+const (
+	colName           = 1
+	colASCIIName      = 2
+	colAlternateNames = 3
+	colLat            = 4
+	colLng            = 5
+	colFeatureCode    = 7
+	colCountry        = 8
+	colAdmin1         = 10
+	colPopulation     = 14
+	minColumns        = 15
+)
+
+// OpenGazetteer loads a GeoNames dump from a file.
+func OpenGazetteer(path string) (*Gazetteer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return LoadGazetteer(f)
+}
+
+// LoadGazetteer reads a GeoNames dump. Every row is indexed under its name,
+// ASCII name and alternate names.
+func LoadGazetteer(r io.Reader) (*Gazetteer, error) {
+	g := &Gazetteer{
+		names:       make(map[string][]int),
+		admin1Names: make(map[string]string),
+	}
+
+	scanner := bufio.NewScanner(r)
+	// alternate names make some rows long
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		cols := strings.Split(text, "\t")
+		if len(cols) < minColumns {
+			return nil, fmt.Errorf("geocode: line %d: expected at least %d columns, got %d", line, minColumns, len(cols))
+		}
+
+		lat, err := strconv.ParseFloat(cols[colLat], 64)
+		if err != nil {
+			return nil, fmt.Errorf("geocode: line %d: invalid latitude %q", line, cols[colLat])
+		}
+
+		lng, err := strconv.ParseFloat(cols[colLng], 64)
+		if err != nil {
+			return nil, fmt.Errorf("geocode: line %d: invalid longitude %q", line, cols[colLng])
+		}
+
+		var population int64
+		if cols[colPopulation] != "" {
+			population, err = strconv.ParseInt(cols[colPopulation], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("geocode: line %d: invalid population %q", line, cols[colPopulation])
+			}
+		}
+
+		p := gazetteerPlace{
+			name:       cols[colName],
+			lat:        lat,
+			lng:        lng,
+			country:    cols[colCountry],
+			admin1:     cols[colAdmin1],
+			population: population,
+		}
+
+		if cols[colFeatureCode] == "ADM1" {
+			g.admin1Names[p.country+"."+p.admin1] = normalize(p.name)
+		}
+
+		g.add(p, cols[colName], cols[colASCIIName], cols[colAlternateNames])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (g *Gazetteer) add(p gazetteerPlace, name, asciiName, alternateNames string) {
+	i := len(g.places)
+	g.places = append(g.places, p)
+
+	keys := []string{normalize(name), normalize(asciiName)}
+	for _, alternate := range strings.Split(alternateNames, ",") {
+		keys = append(keys, normalize(alternate))
+	}
+
+	for _, key := range keys {
+		ids := g.names[key]
+		if key == "" || slices.Contains(ids, i) {
+			continue
+		}
+		g.names[key] = append(ids, i)
+	}
+}
+
+// Len returns the number of places loaded.
+func (g *Gazetteer) Len() int {
+	return len(g.places)
+}
+
+// match is a name found in an address, with the places it could be. It's
+// qualified if something later in the address agreed with those places, and
+// specific if the address had parts before the name, like a street.
+type match struct {
+	places    []int
+	qualified bool
+	specific  bool
+}
+
+// Geocode places an address at the best matching place in the gazetteer.
+func (g *Gazetteer) Geocode(ctx context.Context, address string) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	var parts []string
+	for _, part := range strings.Split(address, ",") {
+		if part = normalize(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	var fallback *match
+
+	for i, part := range parts {
+		words := strings.Fields(part)
+
+		// try the whole part, then without its trailing words, which become
+		// qualifiers: "chicago il 60607" is looked up as "chicago"
+		for n := len(words); n > 0; n-- {
+			ids := g.names[strings.Join(words[:n], " ")]
+			if len(ids) == 0 {
+				continue
+			}
+
+			qualifiers := qualifiersOf(words[n:], parts[i+1:])
+
+			m := &match{places: ids, specific: i > 0}
+			if qualified := g.qualify(ids, qualifiers); len(qualified) > 0 {
+				m.places = qualified
+				m.qualified = true
+				return g.result(m), nil
+			}
+
+			// nothing after the name backs it up, so a later part may be a
+			// better match; "green st, chicago" shouldn't land in Green, Ohio
+			if fallback == nil || g.population(m) > g.population(fallback) {
+				fallback = m
+			}
+			break
+		}
+	}
+
+	if fallback == nil {
+		return Result{}, ErrNoMatch
+	}
+
+	return g.result(fallback), nil
+}
+
+// qualifiersOf returns the words and whole parts that follow a name in an
+// address, which may be admin1 or country codes or names.
+func qualifiersOf(rest []string, later []string) []string {
+	qualifiers := slices.Clone(rest)
+	if len(rest) > 1 {
+		qualifiers = append(qualifiers, strings.Join(rest, " "))
+	}
+
+	for _, part := range later {
+		qualifiers = append(qualifiers, part)
+		qualifiers = append(qualifiers, strings.Fields(part)...)
+	}
+
+	return qualifiers
+}
+
+// qualify returns the places that one of the qualifiers agrees with.
+func (g *Gazetteer) qualify(ids []int, qualifiers []string) []int {
+	var qualified []int
+
+	for _, id := range ids {
+		p := g.places[id]
+		admin1Name := g.admin1Names[p.country+"."+p.admin1]
+
+		for _, q := range qualifiers {
+			if q == strings.ToLower(p.country) || (p.admin1 != "" && q == strings.ToLower(p.admin1)) || (admin1Name != "" && q == admin1Name) {
+				qualified = append(qualified, id)
+				break
+			}
+		}
+	}
+
+	return qualified
+}
+
+// result picks the most populous of a match's places. A match is ambiguous
+// unless that place has at least ten times the population of the next.
+func (g *Gazetteer) result(m *match) Result {
+	ids := slices.Clone(m.places)
+	slices.SortStableFunc(ids, func(a, b int) int {
+		return cmp.Compare(g.places[b].population, g.places[a].population)
+	})
+
+	best := g.places[ids[0]]
+	ambiguous := len(ids) > 1 && best.population < 10*g.places[ids[1]].population
+
+	confidence := ConfidenceHigh
+	switch {
+	case ambiguous:
+		confidence = ConfidenceLow
+	case m.specific:
+		confidence = ConfidenceMedium
+	}
+
+	match := []string{best.name}
+	if best.admin1 != "" && best.admin1 != "00" {
+		match = append(match, best.admin1)
+	}
+	match = append(match, best.country)
+
+	return Result{
+		Lat:        best.lat,
+		Lng:        best.lng,
+		Confidence: confidence,
+		Match:      strings.Join(match, ", "),
+	}
+}
+
+func (g *Gazetteer) population(m *match) int64 {
+	var population int64
+	for _, id := range m.places {
+		population = max(population, g.places[id].population)
+	}
+	return population
+}
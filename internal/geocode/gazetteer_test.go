@@ -0,0 +1,212 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fixtureRow is a row of a GeoNames dump, with the columns the gazetteer
+// doesn't read left empty.
+func fixtureRow(name, alternateNames, lat, lng, featureCode, country, admin1, population string) string {
+	cols := make([]string, 19)
+	cols[0] = "1"
+	cols[colName] = name
+	cols[colASCIIName] = name
+	cols[colAlternateNames] = alternateNames
+	cols[colLat] = lat
+	cols[colLng] = lng
+	cols[6] = "P"
+	cols[colFeatureCode] = featureCode
+	cols[colCountry] = country
+	cols[colAdmin1] = admin1
+	cols[colPopulation] = population
+
+	return strings.Join(cols, "\t")
+}
+
+var fixture = strings.Join([]string{
+	"# a few places from cities15000.txt, and two admin1 rows",
+	fixtureRow("Chicago", "Chi-Town,Chicagua", "41.85003", "-87.65005", "PPLA2", "US", "IL", "2720546"),
+	fixtureRow("Green", "", "40.94589", "-81.48317", "PPL", "US", "OH", "25699"),
+	fixtureRow("Springfield", "", "39.80172", "-89.64371", "PPLA", "US", "IL", "116565"),
+	fixtureRow("Springfield", "", "37.21533", "-93.29824", "PPLA2", "US", "MO", "169176"),
+	fixtureRow("Springfield", "", "42.10148", "-72.58981", "PPLA2", "US", "MA", "153703"),
+	fixtureRow("Portland", "", "45.52345", "-122.67621", "PPLA2", "US", "OR", "652503"),
+	fixtureRow("Portland", "", "43.66147", "-70.25533", "PPLA2", "US", "ME", "66318"),
+	fixtureRow("Paris", "", "48.85341", "2.3488", "PPLC", "FR", "11", "2138551"),
+	fixtureRow("Paris", "", "33.66094", "-95.55551", "PPLA2", "US", "TX", "24782"),
+	"",
+	fixtureRow("Oregon", "", "44.00013", "-120.50139", "ADM1", "US", "OR", "4237256"),
+	fixtureRow("Maine", "", "45.50032", "-69.24977", "ADM1", "US", "ME", "1362359"),
+}, "\n")
+
+func loadFixture(t *testing.T) *Gazetteer {
+	t.Helper()
+
+	g, err := LoadGazetteer(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+func TestLoadGazetteer(t *testing.T) {
+	g := loadFixture(t)
+
+	if g.Len() != 11 {
+		t.Errorf("got %d places, want 11", g.Len())
+	}
+
+	tests := []struct {
+		name string
+		dump string
+	}{
+		{"too few columns", "1\tChicago\tChicago"},
+		{"invalid latitude", fixtureRow("Chicago", "", "north", "-87.65005", "PPL", "US", "IL", "0")},
+		{"invalid longitude", fixtureRow("Chicago", "", "41.85003", "west", "PPL", "US", "IL", "0")},
+		{"invalid population", fixtureRow("Chicago", "", "41.85003", "-87.65005", "PPL", "US", "IL", "lots")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadGazetteer(strings.NewReader(tt.dump))
+			if err == nil {
+				t.Fatal("got no error")
+			}
+		})
+	}
+}
+
+func TestGazetteerGeocode(t *testing.T) {
+	g := loadFixture(t)
+
+	tests := []struct {
+		name       string
+		address    string
+		match      string
+		confidence Confidence
+	}{
+		{
+			name:       "city",
+			address:    "Chicago",
+			match:      "Chicago, IL, US",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "alternate name",
+			address:    "chi-town",
+			match:      "Chicago, IL, US",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "city with a state and zip code",
+			address:    "Chicago, IL 60607",
+			match:      "Chicago, IL, US",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "street address",
+			address:    "200 N Green St, Chicago, IL 60607",
+			match:      "Chicago, IL, US",
+			confidence: ConfidenceMedium,
+		},
+		{
+			name:       "street named like a smaller city",
+			address:    "green st, chicago",
+			match:      "Chicago, IL, US",
+			confidence: ConfidenceMedium,
+		},
+		{
+			name:       "ambiguous city falls back to the most populous",
+			address:    "Springfield",
+			match:      "Springfield, MO, US",
+			confidence: ConfidenceLow,
+		},
+		{
+			name:       "ambiguous city qualified by a state code",
+			address:    "Springfield, IL",
+			match:      "Springfield, IL, US",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "country code doesn't settle it",
+			address:    "Springfield, US",
+			match:      "Springfield, MO, US",
+			confidence: ConfidenceLow,
+		},
+		{
+			name:       "qualified by an admin1 name",
+			address:    "Portland, Maine",
+			match:      "Portland, ME, US",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "less than ten times as populous is ambiguous",
+			address:    "Portland",
+			match:      "Portland, OR, US",
+			confidence: ConfidenceLow,
+		},
+		{
+			name:       "ten times as populous isn't",
+			address:    "Paris",
+			match:      "Paris, 11, FR",
+			confidence: ConfidenceHigh,
+		},
+		{
+			name:       "qualifier picks the smaller place",
+			address:    "Paris, TX",
+			match:      "Paris, TX, US",
+			confidence: ConfidenceHigh,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := g.Geocode(context.Background(), tt.address)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result.Match != tt.match {
+				t.Errorf("got match %q, want %q", result.Match, tt.match)
+			}
+
+			if result.Confidence != tt.confidence {
+				t.Errorf("got confidence %q, want %q", result.Confidence, tt.confidence)
+			}
+		})
+	}
+
+	t.Run("coordinates", func(t *testing.T) {
+		result, err := g.Geocode(context.Background(), "Chicago")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if result.Lat != 41.85003 || result.Lng != -87.65005 {
+			t.Errorf("got %v, %v, want 41.85003, -87.65005", result.Lat, result.Lng)
+		}
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		for _, address := range []string{"Atlantis", "", " , ,"} {
+			_, err := g.Geocode(context.Background(), address)
+			if !errors.Is(err, ErrNoMatch) {
+				t.Errorf("%q: got error %v, want %v", address, err, ErrNoMatch)
+			}
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := g.Geocode(ctx, "Chicago")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	})
+}
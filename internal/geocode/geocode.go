@@ -0,0 +1,56 @@
+// Package geocode turns addresses into coordinates. Handlers only see the
+// Geocoder interface, so the offline Gazetteer can be swapped for a
+// network-backed provider without changing them.
+package geocode
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrNoMatch is returned when a geocoder can't place an address at all.
+var ErrNoMatch = errors.New("geocode: no match")
+
+// Confidence says how far coordinates can be trusted for the address they
+// came from.
+type Confidence string
+
+const (
+	// ConfidenceHigh means the address named the matched place and nothing
+	// more specific, and the match is unambiguous.
+	ConfidenceHigh Confidence = "high"
+	// ConfidenceMedium means the place is right but the address is more
+	// specific than it, such as a street in a city, so the coordinates are
+	// only approximate.
+	ConfidenceMedium Confidence = "medium"
+	// ConfidenceLow means the address matched more than one place and nothing
+	// in it said which, so the likeliest one was picked.
+	ConfidenceLow Confidence = "low"
+)
+
+// Result is where a geocoder placed an address. Match describes what the
+// address was matched to, such as "Chicago, IL, US".
+type Result struct {
+	Lat        float64    `json:"lat"`
+	Lng        float64    `json:"lng"`
+	Confidence Confidence `json:"confidence"`
+	Match      string     `json:"match"`
+}
+
+// Geocoder places an address. Implementations return ErrNoMatch when they
+// can't, and other errors when they couldn't look the address up at all.
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (Result, error)
+}
+
+// normalize lowercases s and reduces it to words separated by single spaces,
+// so addresses compare the same however they're punctuated.
+func normalize(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,223 @@
+package inbound
+
+import (
+	"html"
+	"strings"
+)
+
+// node is an element or a run of text in a parsed HTML document. Text nodes
+// have an empty tag.
+type node struct {
+	tag      string
+	attrs    map[string]string
+	text     string
+	children []*node
+}
+
+// voidElements never have content or an end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// rawTextElements hold text that isn't parsed for tags or entities.
+var rawTextElements = map[string]bool{"script": true, "style": true}
+
+// parseHTML builds a tree from an HTML document. It only understands as much
+// HTML as reservation markup needs: elements, attributes, text and comments.
+// Like a browser, it never fails, so broken markup still gives a tree; end
+// tags that don't match an open element are ignored, and an end tag closes
+// any elements left open inside it.
+func parseHTML(src string) *node {
+	root := &node{tag: "#document"}
+	stack := []*node{root}
+
+	appendText := func(text string) {
+		if text == "" {
+			return
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, &node{text: text})
+	}
+
+	for len(src) > 0 {
+		lt := strings.IndexByte(src, '<')
+		if lt < 0 {
+			appendText(html.UnescapeString(src))
+			break
+		}
+
+		appendText(html.UnescapeString(src[:lt]))
+		src = src[lt:]
+
+		switch {
+		case strings.HasPrefix(src, "<!--"):
+			end := strings.Index(src[4:], "-->")
+			if end < 0 {
+				return root
+			}
+			src = src[4+end+3:]
+
+		case strings.HasPrefix(src, "<!") || strings.HasPrefix(src, "<?"):
+			end := strings.IndexByte(src, '>')
+			if end < 0 {
+				return root
+			}
+			src = src[end+1:]
+
+		case strings.HasPrefix(src, "</"):
+			end := strings.IndexByte(src, '>')
+			if end < 0 {
+				return root
+			}
+
+			tag := strings.ToLower(strings.TrimSpace(src[2:end]))
+			src = src[end+1:]
+
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].tag == tag {
+					stack = stack[:i]
+					break
+				}
+			}
+
+		case len(src) > 1 && isLetter(src[1]):
+			element, rest, selfClosing := parseStartTag(src)
+			src = rest
+
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, element)
+
+			switch {
+			case rawTextElements[element.tag]:
+				end := indexFold(src, "</"+element.tag)
+				if end < 0 {
+					end = len(src)
+				}
+
+				if end > 0 {
+					element.children = append(element.children, &node{text: src[:end]})
+				}
+				src = src[end:]
+			case !selfClosing && !voidElements[element.tag]:
+				stack = append(stack, element)
+			}
+
+		default:
+			// a lone < in text
+			appendText("<")
+			src = src[1:]
+		}
+	}
+
+	return root
+}
+
+// parseStartTag reads the start tag at the beginning of src, returning the
+// element and the input after it.
+func parseStartTag(src string) (element *node, rest string, selfClosing bool) {
+	i := 1
+	for i < len(src) && !isSpace(src[i]) && src[i] != '>' && src[i] != '/' {
+		i++
+	}
+
+	element = &node{tag: strings.ToLower(src[1:i]), attrs: make(map[string]string)}
+
+	for i < len(src) {
+		for i < len(src) && (isSpace(src[i]) || src[i] == '/') {
+			selfClosing = src[i] == '/'
+			i++
+		}
+
+		if i >= len(src) {
+			break
+		}
+		if src[i] == '>' {
+			return element, src[i+1:], selfClosing
+		}
+
+		selfClosing = false
+
+		start := i
+		for i < len(src) && !isSpace(src[i]) && src[i] != '>' && src[i] != '=' && src[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(src[start:i])
+
+		for i < len(src) && isSpace(src[i]) {
+			i++
+		}
+
+		value := ""
+		if i < len(src) && src[i] == '=' {
+			i++
+			for i < len(src) && isSpace(src[i]) {
+				i++
+			}
+
+			if i < len(src) && (src[i] == '"' || src[i] == '\'') {
+				quote := src[i]
+				end := strings.IndexByte(src[i+1:], quote)
+				if end < 0 {
+					end = len(src) - i - 1
+				}
+				value = src[i+1 : i+1+end]
+				i = min(i+1+end+1, len(src))
+			} else {
+				start := i
+				for i < len(src) && !isSpace(src[i]) && src[i] != '>' {
+					i++
+				}
+				value = src[start:i]
+			}
+		}
+
+		if _, ok := element.attrs[name]; !ok && name != "" {
+			element.attrs[name] = html.UnescapeString(value)
+		}
+	}
+
+	return element, "", selfClosing
+}
+
+// textContent returns the text inside n with runs of whitespace collapsed.
+// Line breaks separate the text either side of them.
+func (n *node) textContent() string {
+	var b strings.Builder
+
+	var walk func(n *node)
+	walk = func(n *node) {
+		switch n.tag {
+		case "":
+			b.WriteString(n.text)
+			return
+		case "br":
+			b.WriteByte(' ')
+			return
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(n)
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// indexFold is strings.Index for an ASCII substr, ignoring case.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
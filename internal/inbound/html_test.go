@@ -0,0 +1,121 @@
+package inbound
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// render writes a parsed tree compactly, as tag[attr=value](children) with
+// text quoted, so tests can say what shape broken markup ends up in.
+func render(n *node) string {
+	if n.tag == "" {
+		return "'" + n.text + "'"
+	}
+
+	var b strings.Builder
+	b.WriteString(n.tag)
+
+	if len(n.attrs) > 0 {
+		names := make([]string, 0, len(n.attrs))
+		for name := range n.attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			b.WriteString("[" + name + "=" + n.attrs[name] + "]")
+		}
+	}
+
+	if len(n.children) > 0 {
+		children := make([]string, len(n.children))
+		for i, child := range n.children {
+			children[i] = render(child)
+		}
+		b.WriteString("(" + strings.Join(children, " ") + ")")
+	}
+
+	return b.String()
+}
+
+func TestParseHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"elements and text", `<div><p>Hi <b>there</b></p></div>`, `#document(div(p('Hi ' b('there'))))`},
+		{"upper case tags", `<DIV><P>hi</p></div>`, `#document(div(p('hi')))`},
+		{"entities", `<p title="AT&amp;T">Tom &amp; Jerry&nbsp;&#33;</p>`, "#document(p[title=AT&T]('Tom & Jerry !'))"},
+		{"attribute quoting", `<meta a=1 b='two' c="three" d>`, `#document(meta[a=1][b=two][c=three][d=])`},
+		{"repeated attributes keep the first", `<meta content=a content=b>`, `#document(meta[content=a])`},
+		{"void and self-closing elements", `<p>a<br>b<img src=x/><span/>c</p>`, `#document(p('a' br 'b' img[src=x/] span 'c'))`},
+		{"unclosed elements", `<div><p>hello`, `#document(div(p('hello')))`},
+		{"end tags without a start", `</span>hello</div>`, `#document('hello')`},
+		{"end tag closes what's open inside it", `<div><span>a</div>b`, `#document(div(span('a')) 'b')`},
+		{"lone less-than signs", `1 < 2 <3`, `#document('1 ' '<' ' 2 ' '<' '3')`},
+		{"comments and doctypes", `<!DOCTYPE html><!-- a <b> comment -->hi`, `#document('hi')`},
+		{"comment that never ends", `hi<!-- never closed <p>x</p>`, `#document('hi')`},
+		{"end tag that never ends", `<p>hi</p`, `#document(p('hi'))`},
+		{"start tag that never ends", `<p class="x`, `#document(p[class=x])`},
+		{"script text isn't markup", `<script>if (a < b) { x = "</p>" }</script><p>y</p>`, `#document(script('if (a < b) { x = "</p>" }') p('y'))`},
+		{"script that never ends", `<script type="application/ld+json">{"a": 1}`, `#document(script[type=application/ld+json]('{"a": 1}'))`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := render(parseHTML(tt.html)); got != tt.want {
+				t.Errorf("\ngot  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Markup that's broken in one place doesn't stop the rest being read.
+func TestMalformedMarkup(t *testing.T) {
+	tests := []struct {
+		name  string
+		html  string
+		names []string
+	}{
+		{
+			name:  "invalid JSON-LD next to microdata",
+			html:  `<script type="application/ld+json">{"@type": "EventReservation",</script>` + eventMicrodata,
+			names: []string{"Foo Fighters Concert"},
+		},
+		{
+			name:  "JSON-LD with trailing garbage",
+			html:  `<script type="application/ld+json">{"@type": "EventReservation", "reservationFor": {"name": "Show"}} }}</script>`,
+			names: []string{"Show"},
+		},
+		{
+			name:  "microdata left unclosed",
+			html:  `<div itemscope itemtype="http://schema.org/EventReservation"><div itemprop="reservationFor" itemscope><span itemprop="name">Show`,
+			names: []string{"Show"},
+		},
+		{
+			name:  "properties of the wrong shape",
+			html:  `<script type="application/ld+json">{"@type": ["EventReservation"], "reservationFor": [[], "Show"], "reservationNumber": {"a": 1}}</script>`,
+			names: nil,
+		},
+		{
+			name:  "JSON-LD that isn't an object",
+			html:  `<script type="application/ld+json">"EventReservation"</script><script type="application/ld+json">[1, null, true]</script>`,
+			names: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, r := range reservations(parseHTML(tt.html)) {
+				names = append(names, r.Name)
+			}
+
+			if strings.Join(names, "|") != strings.Join(tt.names, "|") {
+				t.Errorf("got reservations %q, want %q", names, tt.names)
+			}
+		})
+	}
+}
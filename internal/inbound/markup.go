@@ -0,0 +1,300 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// A thing is a schema.org item from either JSON-LD or microdata, in the shape
+// JSON-LD decodes to: "@type" names its type and every other key is a
+// property. Values are strings, json.Numbers, nested things or slices of
+// them.
+type thing map[string]any
+
+// things returns every top-level schema.org item marked up in an HTML
+// document, JSON-LD first.
+func things(doc *node) []thing {
+	var found []thing
+
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.tag == "script" && strings.Contains(strings.ToLower(n.attrs["type"]), "ld+json") {
+			found = append(found, jsonLD(n.textContent())...)
+			return
+		}
+
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	return append(found, microdata(doc)...)
+}
+
+// jsonLD decodes a JSON-LD script. A script can hold one item, an array of
+// them, or a graph; anything that isn't valid JSON is skipped.
+func jsonLD(src string) []thing {
+	// some senders wrap the JSON in an HTML comment to hide it from old clients
+	src = strings.TrimSpace(src)
+	src = strings.TrimSuffix(strings.TrimPrefix(src, "<!--"), "-->")
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(src)))
+	dec.UseNumber()
+
+	var value any
+
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil
+	}
+
+	var found []thing
+
+	var collect func(value any)
+	collect = func(value any) {
+		switch value := value.(type) {
+		case []any:
+			for _, item := range value {
+				collect(item)
+			}
+		case map[string]any:
+			if graph, ok := value["@graph"]; ok {
+				collect(graph)
+				return
+			}
+			found = append(found, thing(value))
+		}
+	}
+	collect(value)
+
+	return found
+}
+
+// microdata returns the top-level items marked up with itemscope, that is the
+// ones that aren't the value of another item's property.
+func microdata(doc *node) []thing {
+	var found []thing
+
+	var walk func(n *node)
+	walk = func(n *node) {
+		_, scoped := n.attrs["itemscope"]
+		_, property := n.attrs["itemprop"]
+
+		if scoped && !property {
+			found = append(found, microdataItem(n))
+			return
+		}
+
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	return found
+}
+
+// microdataItem reads the properties of the item rooted at n.
+func microdataItem(n *node) thing {
+	item := thing{}
+
+	if itemtype := strings.Fields(n.attrs["itemtype"]); len(itemtype) > 0 {
+		item["@type"] = itemtype[0]
+	}
+
+	var walk func(n *node)
+	walk = func(n *node) {
+		for _, child := range n.children {
+			if child.tag == "" {
+				continue
+			}
+
+			_, scoped := child.attrs["itemscope"]
+
+			if names, ok := child.attrs["itemprop"]; ok {
+				var value any
+				if scoped {
+					value = microdataItem(child)
+				} else {
+					value = propertyValue(child)
+				}
+
+				for _, name := range strings.Fields(names) {
+					item.add(name, value)
+				}
+			}
+
+			// a nested item's descendants are its own properties
+			if !scoped {
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+
+	return item
+}
+
+// propertyValue is the value of a microdata property that isn't an item,
+// following the rules for which attribute holds it.
+func propertyValue(n *node) string {
+	attr := ""
+
+	switch n.tag {
+	case "meta":
+		attr = "content"
+	case "a", "area", "link":
+		attr = "href"
+	case "audio", "embed", "iframe", "img", "source", "track", "video":
+		attr = "src"
+	case "object":
+		attr = "data"
+	case "data", "meter":
+		attr = "value"
+	case "time":
+		if _, ok := n.attrs["datetime"]; ok {
+			attr = "datetime"
+		}
+	}
+
+	if attr == "" {
+		// not HTML5, but common in email markup
+		if _, ok := n.attrs["content"]; ok {
+			attr = "content"
+		}
+	}
+
+	if attr != "" {
+		return strings.TrimSpace(n.attrs[attr])
+	}
+
+	return n.textContent()
+}
+
+// add appends value to the property name, turning it into a slice when it
+// has more than one value.
+func (t thing) add(name string, value any) {
+	existing, ok := t[name]
+	if !ok {
+		t[name] = value
+		return
+	}
+
+	if values, ok := existing.([]any); ok {
+		t[name] = append(values, value)
+		return
+	}
+
+	t[name] = []any{existing, value}
+}
+
+// is reports whether the thing has one of the given schema.org types. Types
+// may be written as a bare name, a prefixed name or a full URL.
+func (t thing) is(types ...string) bool {
+	for _, typ := range t.types() {
+		for _, want := range types {
+			if typ == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// types returns the thing's types without any vocabulary prefix.
+func (t thing) types() []string {
+	var names []string
+
+	add := func(value any) {
+		if s, ok := value.(string); ok {
+			names = append(names, typeName(s))
+		}
+	}
+
+	switch value := t["@type"].(type) {
+	case []any:
+		for _, v := range value {
+			add(v)
+		}
+	default:
+		add(value)
+	}
+
+	return names
+}
+
+// typeName strips the vocabulary from a type, such as http://schema.org/Hotel
+// or schema:Hotel.
+func typeName(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(s, "/")
+
+	if i := strings.LastIndexAny(s, "/:#"); i >= 0 {
+		s = s[i+1:]
+	}
+
+	return s
+}
+
+// get returns the first value of a property, following a path of properties
+// through nested things.
+func (t thing) get(path ...string) any {
+	var value any = t
+
+	for _, name := range path {
+		item, ok := first(value).(thing)
+		if !ok {
+			if m, isMap := first(value).(map[string]any); isMap {
+				item, ok = thing(m), true
+			}
+		}
+		if !ok {
+			return nil
+		}
+
+		value = item[name]
+	}
+
+	return first(value)
+}
+
+// thing returns the nested thing at path, or nil if there isn't one.
+func (t thing) thing(path ...string) thing {
+	switch value := t.get(path...).(type) {
+	case thing:
+		return value
+	case map[string]any:
+		return thing(value)
+	default:
+		return nil
+	}
+}
+
+// text returns the value at path as a string. A nested thing stands for its
+// name, as a string does for an item in schema.org.
+func (t thing) text(path ...string) string {
+	switch value := t.get(path...).(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case json.Number:
+		return value.String()
+	case thing:
+		return value.text("name")
+	case map[string]any:
+		return thing(value).text("name")
+	default:
+		return ""
+	}
+}
+
+func first(value any) any {
+	if values, ok := value.([]any); ok {
+		if len(values) == 0 {
+			return nil
+		}
+		return values[0]
+	}
+	return value
+}
@@ -0,0 +1,202 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxDepth bounds how deeply multipart bodies and attached messages are
+// followed, so a message can't nest itself into a stack overflow.
+const maxDepth = 10
+
+// recipientHeaders are the headers an address can reach a message through.
+// Delivered-To and X-Original-To are added by the receiving MTA and are the
+// only place a Bcc recipient shows up.
+var recipientHeaders = []string{"Delivered-To", "X-Original-To", "Envelope-To", "To", "Cc", "Resent-To"}
+
+var addressRX = regexp.MustCompile(`[^\s<>,;:"()\[\]]+@[^\s<>,;:"()\[\]]+`)
+
+// Message is an email as far as importing bookings is concerned: who sent it
+// and to where, and the HTML it carries.
+type Message struct {
+	ID         string
+	From       string
+	Subject    string
+	Date       time.Time
+	Recipients []string
+	HTML       []string
+}
+
+// ParseMessage reads an RFC 822 message, including its MIME parts. The HTML
+// of every text/html part is kept, including those of messages attached with
+// message/rfc822, which is how many clients forward mail.
+func ParseMessage(r io.Reader) (*Message, error) {
+	raw, err := mail.ReadMessage(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("reading message: %w", err)
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+
+	msg := &Message{
+		ID:   strings.Trim(strings.TrimSpace(raw.Header.Get("Message-Id")), "<>"),
+		From: raw.Header.Get("From"),
+	}
+
+	if from, err := (&mail.AddressParser{WordDecoder: decoder}).Parse(msg.From); err == nil {
+		msg.From = from.Address
+	}
+
+	msg.Subject, err = decoder.DecodeHeader(raw.Header.Get("Subject"))
+	if err != nil {
+		msg.Subject = raw.Header.Get("Subject")
+	}
+
+	msg.Date, _ = raw.Header.Date()
+
+	seen := make(map[string]bool)
+	for _, name := range recipientHeaders {
+		for _, value := range raw.Header[name] {
+			for _, address := range addressRX.FindAllString(value, -1) {
+				address = strings.ToLower(address)
+				if !seen[address] {
+					seen[address] = true
+					msg.Recipients = append(msg.Recipients, address)
+				}
+			}
+		}
+	}
+
+	err = msg.readPart(mailHeader(raw.Header), raw.Body, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Reservations returns the reservations marked up in the message's HTML.
+func (m *Message) Reservations() []Reservation {
+	var found []Reservation
+
+	for _, html := range m.HTML {
+		found = append(found, reservations(parseHTML(html))...)
+	}
+
+	return found
+}
+
+// header is the part of textproto.MIMEHeader and mail.Header readPart needs.
+type header interface {
+	Get(key string) string
+}
+
+type mailHeader mail.Header
+
+func (h mailHeader) Get(key string) string {
+	return mail.Header(h).Get(key)
+}
+
+func (m *Message) readPart(h header, body io.Reader, depth int) error {
+	if depth > maxDepth {
+		return errors.New("message is nested too deeply")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045 says a part without a usable type is plain text
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	body = transferDecoder(h.Get("Content-Transfer-Encoding"), body)
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if params["boundary"] == "" {
+			return errors.New("multipart message has no boundary")
+		}
+
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading message part: %w", err)
+			}
+
+			err = m.readPart(part.Header, part, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+
+	case mediaType == "message/rfc822":
+		attached, err := mail.ReadMessage(bufio.NewReader(body))
+		if err != nil {
+			// an attachment we can't read doesn't spoil the rest of the message
+			return nil
+		}
+
+		return m.readPart(mailHeader(attached.Header), attached.Body, depth+1)
+
+	case mediaType == "text/html":
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("reading message part: %w", err)
+		}
+
+		m.HTML = append(m.HTML, decodeCharset(content, params["charset"]))
+	}
+
+	return nil
+}
+
+func transferDecoder(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// decodeCharset turns text in charset into UTF-8. Besides UTF-8, it knows the
+// Latin-1 family most older mailers use. Text in any other charset only keeps
+// what's valid UTF-8, which is at least its ASCII.
+func decodeCharset(content []byte, charset string) string {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "iso-8859-1", "latin1", "latin-1", "windows-1252", "cp1252", "iso-8859-15":
+		runes := make([]rune, len(content))
+		for i, c := range content {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	default:
+		return string(bytes.ToValidUTF8(content, []byte(string(utf8.RuneError))))
+	}
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.NewReader(decodeCharset(content, charset)), nil
+}
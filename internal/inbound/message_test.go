@@ -0,0 +1,215 @@
+package inbound
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// crlf turns a message written with \n line endings into the \r\n ones on
+// the wire.
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+func TestParseMessage(t *testing.T) {
+	forwarded := crlf(`From: Demo <demo@kagubird.dev>
+To: trip+abc@in.kagubird.com
+Subject: Fwd: Your flight
+Message-ID: <fwd-1@mail.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: text/plain
+
+See the attached confirmation.
+--outer
+Content-Type: message/rfc822
+
+From: United <noreply@united.example>
+Subject: Your flight
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: base64
+
+` + base64.StdEncoding.EncodeToString([]byte(flightJSONLD)) + `
+--outer--
+`)
+
+	tests := []struct {
+		name    string
+		message string
+		want    *Message
+		wantErr bool
+	}{
+		{
+			name: "html message",
+			message: crlf(`From: "Hotels" <Bookings@Hotels.example>
+To: Demo <demo@kagubird.dev>, trip+abc@in.kagubird.com
+Cc: TRIP+ABC@in.kagubird.com
+Delivered-To: trip+def@in.kagubird.com
+Subject: =?UTF-8?Q?Caf=C3=A9_stay_confirmed?=
+Message-ID: <confirm-1@hotels.example>
+Content-Type: text/html
+
+<p>Confirmed</p>
+`),
+			want: &Message{
+				ID:         "confirm-1@hotels.example",
+				From:       "Bookings@Hotels.example",
+				Subject:    "Café stay confirmed",
+				Recipients: []string{"trip+def@in.kagubird.com", "demo@kagubird.dev", "trip+abc@in.kagubird.com"},
+				HTML:       []string{"<p>Confirmed</p>\r\n"},
+			},
+		},
+		{
+			name: "alternative parts",
+			message: crlf(`From: bookings@hotels.example
+Content-Type: multipart/alternative; boundary=b1
+
+--b1
+Content-Type: text/plain
+
+Confirmed
+--b1
+Content-Type: text/html; charset="iso-8859-1"
+Content-Transfer-Encoding: quoted-printable
+
+<p>Caf=E9 confirm=
+ed</p>
+--b1--
+`),
+			want: &Message{
+				From: "bookings@hotels.example",
+				HTML: []string{"<p>Café confirmed</p>"},
+			},
+		},
+		{
+			name:    "forwarded message",
+			message: forwarded,
+			want: &Message{
+				ID:         "fwd-1@mail.example",
+				From:       "demo@kagubird.dev",
+				Subject:    "Fwd: Your flight",
+				Recipients: []string{"trip+abc@in.kagubird.com"},
+				HTML:       []string{flightJSONLD},
+			},
+		},
+		{
+			name: "attached message that can't be read",
+			message: crlf(`From: demo@kagubird.dev
+Content-Type: multipart/mixed; boundary=b1
+
+--b1
+Content-Type: message/rfc822
+
+not a message
+--b1
+Content-Type: text/html
+
+<p>still read</p>
+--b1--
+`),
+			want: &Message{
+				From: "demo@kagubird.dev",
+				HTML: []string{"<p>still read</p>"},
+			},
+		},
+		{
+			name: "part without a type",
+			message: crlf(`From: demo@kagubird.dev
+Content-Type: nonsense;;
+
+<p>plain text</p>
+`),
+			want: &Message{From: "demo@kagubird.dev"},
+		},
+		{
+			name:    "multipart without a boundary",
+			message: crlf("From: demo@kagubird.dev\nContent-Type: multipart/mixed\n\nbody\n"),
+			wantErr: true,
+		},
+		{
+			name:    "nested too deeply",
+			message: crlf("From: demo@kagubird.dev\n" + strings.Repeat("Content-Type: message/rfc822\n\n", maxDepth+2) + "body\n"),
+			wantErr: true,
+		},
+		{
+			name:    "not a message",
+			message: "no headers here",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMessage(strings.NewReader(tt.message))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\ngot  %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A forwarded confirmation's reservations are read from the attached message.
+func TestForwardedReservations(t *testing.T) {
+	msg, err := ParseMessage(strings.NewReader(crlf(`From: demo@kagubird.dev
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: message/rfc822
+
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/html
+
+` + lodgingJSONLD + `
+--inner
+Content-Type: text/html
+
+` + eventMicrodata + `
+--inner--
+--outer--
+`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, r := range msg.Reservations() {
+		names = append(names, r.Name)
+	}
+
+	if want := []string{"Hilton San Francisco Union Square", "Foo Fighters Concert"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got reservations %q, want %q", names, want)
+	}
+}
+
+func FuzzParseMessage(f *testing.F) {
+	f.Add(crlf("From: a@b.example\nContent-Type: text/html\n\n" + lodgingJSONLD))
+	f.Add(crlf("Content-Type: multipart/mixed; boundary=b\n\n--b\nContent-Type: message/rfc822\n\nContent-Type: text/html\n\n" + eventMicrodata + "\n--b--\n"))
+	f.Add(crlf("Content-Type: text/html\nContent-Transfer-Encoding: base64\n\n" + base64.StdEncoding.EncodeToString([]byte(flightJSONLD))))
+	f.Add(crlf("Subject: =?iso-8859-1?q?caf=E9?=\nContent-Type: text/html; charset=latin1\nContent-Transfer-Encoding: quoted-printable\n\n<p>=E9</p>"))
+
+	f.Fuzz(func(t *testing.T, message string) {
+		msg, err := ParseMessage(strings.NewReader(message))
+		if err != nil {
+			return
+		}
+
+		// whatever was read has to be safe to look for reservations in
+		msg.Reservations()
+	})
+}
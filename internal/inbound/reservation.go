@@ -0,0 +1,347 @@
+package inbound
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kinds of reservation read from markup.
+const (
+	KindLodging = "lodging"
+	KindFlight  = "flight"
+	KindEvent   = "event"
+)
+
+// Reservation is a booking described by schema.org markup in an email, such
+// as a LodgingReservation, FlightReservation or EventReservation. Fields the
+// markup didn't include are left empty.
+type Reservation struct {
+	Kind   string
+	Number string
+	// Status is the schema.org reservation status without its vocabulary,
+	// such as ReservationConfirmed or ReservationCancelled.
+	Status   string
+	Provider string
+
+	// Name is the hotel, event or flight the reservation is for, and Type the
+	// schema.org type of that, such as Hotel or MusicEvent.
+	Name      string
+	Type      string
+	Address   string
+	Telephone string
+	URL       string
+	Lat       float64
+	Lng       float64
+
+	// Start and End are the check-in and check-out times, the departure and
+	// arrival times, or when the event runs. Times written without an offset
+	// are taken as UTC. StartHasTime and EndHasTime are false for dates.
+	Start        time.Time
+	End          time.Time
+	StartHasTime bool
+	EndHasTime   bool
+
+	// Cost is the total price in the minor unit of Currency, or nil without
+	// a price.
+	Cost     *int64
+	Currency string
+
+	Airline          string
+	FlightNumber     string
+	DepartureAirport string
+	ArrivalAirport   string
+	Seat             string
+}
+
+// reservations returns the supported reservations marked up in an HTML
+// document. A reservation marked up with both JSON-LD and microdata is only
+// returned once, and ones that aren't usable aren't returned at all.
+func reservations(doc *node) []Reservation {
+	var found []Reservation
+	seen := make(map[string]bool)
+
+	for _, item := range things(doc) {
+		var r Reservation
+
+		switch {
+		case item.is("LodgingReservation"):
+			r = lodging(item)
+		case item.is("FlightReservation"):
+			r = flight(item)
+		case item.is("EventReservation"):
+			r = event(item)
+		default:
+			continue
+		}
+
+		if !r.usable() {
+			continue
+		}
+
+		key := fmt.Sprint(r.Kind, r.Number, r.Name, r.Start.Unix())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		found = append(found, r)
+	}
+
+	return found
+}
+
+// usable reports whether a reservation says enough to be worth a draft: what
+// it's for or when it is. Markup with neither, like a bare FlightReservation,
+// would only make an empty draft for someone to throw away.
+func (r Reservation) usable() bool {
+	named := r.Name != ""
+	if r.Kind == KindFlight {
+		// flights are always named, from whatever else the markup had
+		named = r.FlightNumber != "" || r.DepartureAirport != "" || r.ArrivalAirport != ""
+	}
+
+	return named || !r.Start.IsZero()
+}
+
+// reservation reads the properties every kind of reservation shares.
+func reservation(kind string, item thing) Reservation {
+	r := Reservation{
+		Kind:     kind,
+		Number:   item.text("reservationNumber"),
+		Status:   typeName(item.text("reservationStatus")),
+		Provider: item.text("broker"),
+	}
+
+	if r.Provider == "" {
+		r.Provider = item.text("provider")
+	}
+
+	for _, path := range [][]string{{"totalPrice"}, {"price"}, {"totalPrice", "price"}} {
+		price := item.text(path...)
+		if price == "" {
+			continue
+		}
+
+		currency := item.text("priceCurrency")
+		if currency == "" {
+			currency = item.text(append(path[:1:1], "priceCurrency")...)
+		}
+
+		r.Currency = strings.ToUpper(currency)
+		r.Cost = minorUnits(price, r.Currency)
+
+		if r.Cost != nil {
+			break
+		}
+	}
+
+	place := item.thing("reservationFor")
+	if place != nil {
+		r.Name = place.text("name")
+		if types := place.types(); len(types) > 0 {
+			r.Type = types[0]
+		}
+		r.Telephone = place.text("telephone")
+		r.URL = place.text("url")
+	}
+
+	return r
+}
+
+func lodging(item thing) Reservation {
+	r := reservation(KindLodging, item)
+
+	place := item.thing("reservationFor")
+	if place != nil {
+		r.Address = address(place)
+		r.Lat = number(place.text("geo", "latitude"))
+		r.Lng = number(place.text("geo", "longitude"))
+	}
+
+	// checkinDate is what Gmail documents, checkinTime is what schema.org does
+	for _, name := range []string{"checkinTime", "checkinDate"} {
+		if t, hasTime, ok := parseTime(item.text(name)); ok {
+			r.Start, r.StartHasTime = t, hasTime
+			break
+		}
+	}
+
+	for _, name := range []string{"checkoutTime", "checkoutDate"} {
+		if t, hasTime, ok := parseTime(item.text(name)); ok {
+			r.End, r.EndHasTime = t, hasTime
+			break
+		}
+	}
+
+	return r
+}
+
+func flight(item thing) Reservation {
+	r := reservation(KindFlight, item)
+
+	r.Airline = item.text("reservationFor", "airline")
+	code := item.text("reservationFor", "airline", "iataCode")
+	if r.Airline == "" {
+		r.Airline = code
+	}
+
+	r.FlightNumber = item.text("reservationFor", "flightNumber")
+	if code != "" && r.FlightNumber != "" && !strings.HasPrefix(r.FlightNumber, code) {
+		r.FlightNumber = code + " " + r.FlightNumber
+	}
+
+	if r.Provider == "" {
+		r.Provider = r.Airline
+	}
+
+	r.DepartureAirport = airport(item.thing("reservationFor", "departureAirport"))
+	r.ArrivalAirport = airport(item.thing("reservationFor", "arrivalAirport"))
+
+	r.Name = "Flight"
+	if r.FlightNumber != "" {
+		r.Name += " " + r.FlightNumber
+	}
+	if r.DepartureAirport != "" && r.ArrivalAirport != "" {
+		r.Name += " " + r.DepartureAirport + " to " + r.ArrivalAirport
+	}
+
+	r.Start, r.StartHasTime, _ = parseTime(item.text("reservationFor", "departureTime"))
+	r.End, r.EndHasTime, _ = parseTime(item.text("reservationFor", "arrivalTime"))
+
+	r.Seat = item.text("reservedTicket", "ticketedSeat", "seatNumber")
+	if r.Seat == "" {
+		r.Seat = item.text("airplaneSeat")
+	}
+
+	return r
+}
+
+func event(item thing) Reservation {
+	r := reservation(KindEvent, item)
+
+	venue := item.thing("reservationFor", "location")
+	if venue != nil {
+		r.Address = address(venue)
+		if name := venue.text("name"); name != "" && r.Address != "" && !strings.HasPrefix(r.Address, name) {
+			r.Address = name + ", " + r.Address
+		}
+		r.Lat = number(venue.text("geo", "latitude"))
+		r.Lng = number(venue.text("geo", "longitude"))
+	}
+
+	r.Start, r.StartHasTime, _ = parseTime(item.text("reservationFor", "startDate"))
+	r.End, r.EndHasTime, _ = parseTime(item.text("reservationFor", "endDate"))
+
+	return r
+}
+
+// address formats a place's address, which is either text or a PostalAddress.
+func address(place thing) string {
+	postal := place.thing("address")
+	if postal == nil {
+		return place.text("address")
+	}
+
+	region := strings.TrimSpace(postal.text("addressRegion") + " " + postal.text("postalCode"))
+
+	var parts []string
+	for _, part := range []string{postal.text("streetAddress"), postal.text("addressLocality"), region, postal.text("addressCountry")} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// airport names an airport by its IATA code if it has one.
+func airport(place thing) string {
+	if place == nil {
+		return ""
+	}
+
+	if code := place.text("iataCode"); code != "" {
+		return code
+	}
+
+	return place.text("name")
+}
+
+// timeLayouts are the forms of ISO 8601 dates and times seen in markup, most
+// specific first. Layouts without a time mark date-only values.
+var timeLayouts = []struct {
+	layout  string
+	hasTime bool
+}{
+	{time.RFC3339, true},
+	{"2006-01-02T15:04Z07:00", true},
+	{"2006-01-02T15:04:05", true},
+	{"2006-01-02T15:04", true},
+	{"2006-01-02 15:04:05Z07:00", true},
+	{"2006-01-02 15:04:05", true},
+	{"2006-01-02 15:04", true},
+	{time.DateOnly, false},
+}
+
+func parseTime(s string) (t time.Time, hasTime bool, ok bool) {
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout.layout, s)
+		if err == nil {
+			return t, layout.hasTime, true
+		}
+	}
+
+	return time.Time{}, false, false
+}
+
+func number(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// minorUnitDigits are the currencies that don't have two decimal places.
+var minorUnitDigits = map[string]int{
+	"BHD": 3, "CLP": 0, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3,
+	"OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0, "XAF": 0, "XOF": 0,
+}
+
+// minorUnits converts a decimal price, such as "1,234.50" or "1.234,50",
+// into the minor unit of currency. It returns nil if price isn't a number.
+func minorUnits(price, currency string) *int64 {
+	var b strings.Builder
+	for _, r := range price {
+		if r >= '0' && r <= '9' || r == '.' || r == ',' {
+			b.WriteRune(r)
+		}
+	}
+	price = b.String()
+
+	// whichever separator comes last is the decimal one, unless it's a comma
+	// followed by three digits, which groups thousands
+	last := strings.LastIndexAny(price, ".,")
+	whole, fraction := price, ""
+	if last >= 0 && !(price[last] == ',' && len(price)-last-1 == 3) {
+		whole, fraction = price[:last], price[last+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+
+	if whole == "" && fraction == "" {
+		return nil
+	}
+
+	digits, ok := minorUnitDigits[currency]
+	if !ok {
+		digits = 2
+	}
+
+	fraction = (fraction + strings.Repeat("0", digits))[:digits]
+
+	cost, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	return &cost
+}
@@ -0,0 +1,327 @@
+package inbound
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// The samples are the markup senders put in their confirmation emails, after
+// the examples in Google's email markup documentation.
+const (
+	lodgingJSONLD = `<html><head><script type="application/ld+json">
+{
+  "@context": "http://schema.org",
+  "@type": "LodgingReservation",
+  "reservationNumber": "abc456",
+  "reservationStatus": "http://schema.org/ReservationConfirmed",
+  "underName": {"@type": "Person", "name": "John Smith"},
+  "reservationFor": {
+    "@type": "LodgingBusiness",
+    "name": "Hilton San Francisco Union Square",
+    "address": {
+      "@type": "PostalAddress",
+      "streetAddress": "333 O'Farrell St",
+      "addressLocality": "San Francisco",
+      "addressRegion": "CA",
+      "postalCode": "94102",
+      "addressCountry": "US"
+    },
+    "telephone": "415-771-1400",
+    "geo": {"@type": "GeoCoordinates", "latitude": 37.7858, "longitude": -122.4101}
+  },
+  "checkinDate": "2027-04-11T16:00:00-08:00",
+  "checkoutDate": "2027-04-13T11:00:00-08:00",
+  "totalPrice": "389.00",
+  "priceCurrency": "USD"
+}
+</script></head><body><p>Your reservation is confirmed.</p></body></html>`
+
+	lodgingMicrodata = `<div itemscope itemtype="http://schema.org/LodgingReservation">
+  <meta itemprop="reservationNumber" content="abc456">
+  <link itemprop="reservationStatus" href="http://schema.org/ReservationConfirmed">
+  <div itemprop="reservationFor" itemscope itemtype="http://schema.org/Hostel">
+    <meta itemprop="name" content="Generator Paris">
+    <div itemprop="address" itemscope itemtype="http://schema.org/PostalAddress">
+      <meta itemprop="streetAddress" content="9-11 Place du Colonel Fabien">
+      <meta itemprop="addressLocality" content="Paris">
+      <meta itemprop="addressCountry" content="FR">
+    </div>
+  </div>
+  <meta itemprop="checkinDate" content="2027-05-02">
+  <meta itemprop="checkoutDate" content="2027-05-05">
+  <span itemprop="totalPrice">1.234,50</span> <meta itemprop="priceCurrency" content="eur">
+</div>`
+
+	flightJSONLD = `<script type="application/ld+json">
+{
+  "@context": "http://schema.org",
+  "@type": "FlightReservation",
+  "reservationNumber": "RXJ34P",
+  "reservationStatus": "http://schema.org/ReservationConfirmed",
+  "underName": {"@type": "Person", "name": "Eva Green"},
+  "reservationFor": {
+    "@type": "Flight",
+    "flightNumber": "110",
+    "airline": {"@type": "Airline", "name": "United", "iataCode": "UA"},
+    "departureAirport": {"@type": "Airport", "name": "San Francisco Airport", "iataCode": "SFO"},
+    "departureTime": "2027-03-04T20:15:00-08:00",
+    "arrivalAirport": {"@type": "Airport", "name": "John F. Kennedy International Airport", "iataCode": "JFK"},
+    "arrivalTime": "2027-03-05T06:30:00-05:00"
+  },
+  "airplaneSeat": "9A",
+  "totalPrice": "412.30",
+  "priceCurrency": "usd"
+}
+</script>`
+
+	eventMicrodata = `<div itemscope itemtype="http://schema.org/EventReservation">
+  <meta itemprop="reservationNumber" content="E123456789">
+  <link itemprop="reservationStatus" href="http://schema.org/ReservationPending">
+  <div itemprop="underName" itemscope itemtype="http://schema.org/Person">
+    <meta itemprop="name" content="John Smith">
+  </div>
+  <div itemprop="reservationFor" itemscope itemtype="http://schema.org/MusicEvent">
+    <span itemprop="name">Foo Fighters Concert</span>
+    <time itemprop="startDate" datetime="2027-03-06T19:30:00-08:00">March 6th, 7:30pm</time>
+    <div itemprop="location" itemscope itemtype="http://schema.org/Place">
+      <meta itemprop="name" content="AT&amp;T Park">
+      <div itemprop="address" itemscope itemtype="http://schema.org/PostalAddress">
+        <meta itemprop="streetAddress" content="24 Willie Mays Plaza">
+        <meta itemprop="addressLocality" content="San Francisco">
+        <meta itemprop="addressRegion" content="CA">
+        <meta itemprop="postalCode" content="94107">
+        <meta itemprop="addressCountry" content="US">
+      </div>
+    </div>
+  </div>
+  <a itemprop="url" href="https://tickets.example/e/123">View tickets</a>
+</div>`
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
+
+func cents(n int64) *int64 {
+	return &n
+}
+
+// assertReservations compares reservations field by field, comparing times
+// with Equal since their locations are parsed afresh.
+func assertReservations(t *testing.T, got, want []Reservation) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d reservations, want %d: %+v", len(got), len(want), got)
+	}
+
+	for i := range got {
+		g, w := got[i], want[i]
+
+		if !g.Start.Equal(w.Start) || !g.End.Equal(w.End) {
+			t.Errorf("reservation %d: got times %v to %v, want %v to %v", i, g.Start, g.End, w.Start, w.End)
+		}
+
+		g.Start, g.End, w.Start, w.End = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("reservation %d:\ngot  %+v\nwant %+v", i, g, w)
+		}
+	}
+}
+
+func TestReservations(t *testing.T) {
+	hilton := Reservation{
+		Kind:         KindLodging,
+		Number:       "abc456",
+		Status:       "ReservationConfirmed",
+		Name:         "Hilton San Francisco Union Square",
+		Type:         "LodgingBusiness",
+		Address:      "333 O'Farrell St, San Francisco, CA 94102, US",
+		Telephone:    "415-771-1400",
+		Lat:          37.7858,
+		Lng:          -122.4101,
+		Start:        mustTime(t, "2027-04-11T16:00:00-08:00"),
+		End:          mustTime(t, "2027-04-13T11:00:00-08:00"),
+		StartHasTime: true,
+		EndHasTime:   true,
+		Cost:         cents(38900),
+		Currency:     "USD",
+	}
+
+	generator := Reservation{
+		Kind:     KindLodging,
+		Number:   "abc456",
+		Status:   "ReservationConfirmed",
+		Name:     "Generator Paris",
+		Type:     "Hostel",
+		Address:  "9-11 Place du Colonel Fabien, Paris, FR",
+		Start:    mustTime(t, "2027-05-02T00:00:00Z"),
+		End:      mustTime(t, "2027-05-05T00:00:00Z"),
+		Cost:     cents(123450),
+		Currency: "EUR",
+	}
+
+	united := Reservation{
+		Kind:             KindFlight,
+		Number:           "RXJ34P",
+		Status:           "ReservationConfirmed",
+		Provider:         "United",
+		Name:             "Flight UA 110 SFO to JFK",
+		Type:             "Flight",
+		Start:            mustTime(t, "2027-03-04T20:15:00-08:00"),
+		End:              mustTime(t, "2027-03-05T06:30:00-05:00"),
+		StartHasTime:     true,
+		EndHasTime:       true,
+		Cost:             cents(41230),
+		Currency:         "USD",
+		Airline:          "United",
+		FlightNumber:     "UA 110",
+		DepartureAirport: "SFO",
+		ArrivalAirport:   "JFK",
+		Seat:             "9A",
+	}
+
+	concert := Reservation{
+		Kind:         KindEvent,
+		Number:       "E123456789",
+		Status:       "ReservationPending",
+		Name:         "Foo Fighters Concert",
+		Type:         "MusicEvent",
+		Address:      "AT&T Park, 24 Willie Mays Plaza, San Francisco, CA 94107, US",
+		Start:        mustTime(t, "2027-03-06T19:30:00-08:00"),
+		StartHasTime: true,
+	}
+
+	tests := []struct {
+		name string
+		html string
+		want []Reservation
+	}{
+		{"lodging in JSON-LD", lodgingJSONLD, []Reservation{hilton}},
+		{"lodging in microdata", lodgingMicrodata, []Reservation{generator}},
+		{"flight in JSON-LD", flightJSONLD, []Reservation{united}},
+		{"event in microdata", eventMicrodata, []Reservation{concert}},
+		{
+			name: "JSON-LD before microdata",
+			html: eventMicrodata + flightJSONLD,
+			want: []Reservation{united, concert},
+		},
+		{
+			name: "a graph of reservations",
+			html: `<script type="application/ld+json">{"@context": "http://schema.org", "@graph": [
+				{"@type": "EventReservation", "reservationFor": {"@type": "Event", "name": "Tour", "startDate": "2027-03-07"}},
+				{"@type": "Order", "orderNumber": "1"}
+			]}</script>`,
+			want: []Reservation{{Kind: KindEvent, Name: "Tour", Type: "Event", Start: mustTime(t, "2027-03-07T00:00:00Z")}},
+		},
+		{
+			name: "an array of reservations, hidden in a comment",
+			html: `<script type="application/ld+json"><!--[
+				{"@type": "schema:EventReservation", "reservationFor": {"name": "Show", "startDate": "2027-03-07 20:00"}}
+			]--></script>`,
+			want: []Reservation{{Kind: KindEvent, Name: "Show", Start: mustTime(t, "2027-03-07T20:00:00Z"), StartHasTime: true}},
+		},
+		{
+			name: "the same reservation in JSON-LD and microdata",
+			html: `<script type="application/ld+json">{"@type": "EventReservation", "reservationNumber": "1", "reservationFor": {"name": "Show"}}</script>
+				<div itemscope itemtype="http://schema.org/EventReservation"><meta itemprop="reservationNumber" content="1">
+				<div itemprop="reservationFor" itemscope><meta itemprop="name" content="Show"></div></div>`,
+			want: []Reservation{{Kind: KindEvent, Number: "1", Name: "Show"}},
+		},
+		{
+			name: "a cancellation",
+			html: `<script type="application/ld+json">{"@type": "LodgingReservation", "reservationStatus": "http://schema.org/ReservationCancelled",
+				"reservationFor": {"@type": "Hotel", "name": "Inn"}, "checkinTime": "2027-01-01T15:00"}</script>`,
+			want: []Reservation{{Kind: KindLodging, Status: "ReservationCancelled", Name: "Inn", Type: "Hotel", Start: mustTime(t, "2027-01-01T15:00:00Z"), StartHasTime: true}},
+		},
+		{
+			name: "prices in yen",
+			html: `<script type="application/ld+json">{"@type": "EventReservation", "reservationFor": {"name": "Kabuki"},
+				"totalPrice": {"@type": "PriceSpecification", "price": "12,000", "priceCurrency": "JPY"}}</script>`,
+			want: []Reservation{{Kind: KindEvent, Name: "Kabuki", Cost: cents(12000), Currency: "JPY"}},
+		},
+		{
+			name: "reservations without anything usable",
+			html: `<script type="application/ld+json">[
+				{"@type": "FlightReservation", "reservationNumber": "XYZ"},
+				{"@type": "EventReservation", "reservationNumber": "ABC", "totalPrice": "10"}
+			]</script>`,
+			want: nil,
+		},
+		{
+			name: "other types",
+			html: `<script type="application/ld+json">{"@type": "Order", "orderNumber": "1"}</script>
+				<div itemscope itemtype="http://schema.org/Hotel"><meta itemprop="name" content="Inn"></div>`,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertReservations(t, reservations(parseHTML(tt.html)), tt.want)
+		})
+	}
+}
+
+func TestMinorUnits(t *testing.T) {
+	tests := []struct {
+		price    string
+		currency string
+		want     *int64
+	}{
+		{"412.30", "USD", cents(41230)},
+		{"$1,234.50", "USD", cents(123450)},
+		{"1.234,50", "EUR", cents(123450)},
+		{"1,234", "USD", cents(123400)},
+		{"12.5", "USD", cents(1250)},
+		{"12,000", "JPY", cents(12000)},
+		{"1.250", "KWD", cents(1250)},
+		{"free", "USD", nil},
+		{"", "USD", nil},
+	}
+
+	for _, tt := range tests {
+		got := minorUnits(tt.price, tt.currency)
+
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("minorUnits(%q, %q): got %v, want %v", tt.price, tt.currency, deref(got), deref(tt.want))
+		}
+	}
+}
+
+func deref(n *int64) any {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+func FuzzReservations(f *testing.F) {
+	for _, html := range []string{lodgingJSONLD, lodgingMicrodata, flightJSONLD, eventMicrodata, "<div itemscope><p itemprop=x itemscope>", `<script type="application/ld+json">[[{}]]`} {
+		f.Add(html)
+	}
+
+	kinds := []string{KindLodging, KindFlight, KindEvent}
+
+	f.Fuzz(func(t *testing.T, html string) {
+		for _, r := range reservations(parseHTML(html)) {
+			if !slices.Contains(kinds, r.Kind) {
+				t.Errorf("got kind %q", r.Kind)
+			}
+
+			if !r.usable() {
+				t.Errorf("got an unusable reservation %+v", r)
+			}
+		}
+	})
+}
@@ -0,0 +1,374 @@
+// Package migrate applies the SQL migrations embedded in the binary. It keeps
+// its state in the same schema_migrations table, and takes the same advisory
+// lock, as the golang-migrate CLI, so databases migrated with either tool can
+// be picked up by the other.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// nilVersion is how golang-migrate records "no migrations applied" while a
+// down migration to nothing is in progress.
+const nilVersion = -1
+
+// advisoryLockSalt is the salt golang-migrate mixes into its lock keys.
+const advisoryLockSalt uint32 = 1486364155
+
+var (
+	ErrUnknownVersion = errors.New("migrate: no migration with that version")
+	ErrNoMigrations   = errors.New("migrate: no migrations found")
+)
+
+// DirtyError is returned when a previous migration failed part way through.
+// The database has to be fixed by hand and schema_migrations updated before
+// anything else will run.
+type DirtyError struct {
+	Version int64
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("migrate: database is dirty at version %d, fix it and update schema_migrations by hand", e.Version)
+}
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is where a database is compared to the binary's migrations. Version
+// is 0 when nothing has been applied.
+type Status struct {
+	Version uint
+	Dirty   bool
+	Latest  uint
+}
+
+// Behind reports whether the database needs migrations the binary has.
+func (s Status) Behind() bool {
+	return s.Dirty || s.Version < s.Latest
+}
+
+type Migrator struct {
+	db         *sql.DB
+	logger     *slog.Logger
+	migrations []Migration
+}
+
+var filenameRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// New reads the migrations in fsys, which are named like golang-migrate's
+// NNNNNN_name.up.sql and NNNNNN_name.down.sql.
+func New(db *sql.DB, fsys fs.FS, logger *slog.Logger) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+
+	for _, entry := range entries {
+		matches := filenameRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("migrate: invalid version in %s", entry.Name())
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[uint(version)]
+		if !ok {
+			migration = &Migration{Version: uint(version), Name: matches[2]}
+			byVersion[uint(version)] = migration
+		} else if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migrate: more than one migration with version %d", version)
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	if len(byVersion) == 0 {
+		return nil, ErrNoMigrations
+	}
+
+	m := &Migrator{db: db, logger: logger}
+
+	for _, migration := range byVersion {
+		m.migrations = append(m.migrations, *migration)
+	}
+
+	slices.SortFunc(m.migrations, func(a, b Migration) int {
+		return int(a.Version) - int(b.Version)
+	})
+
+	return m, nil
+}
+
+// Latest is the version the binary expects the database to be at.
+func (m *Migrator) Latest() uint {
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status reads the database's version without taking the lock or creating the
+// schema_migrations table.
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	status := Status{Latest: m.Latest()}
+
+	var table sql.NullString
+
+	err := m.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations')::text`).Scan(&table)
+	if err != nil || !table.Valid {
+		return status, err
+	}
+
+	version, dirty, err := readVersion(ctx, m.db)
+	if err != nil {
+		return status, err
+	}
+
+	status.Version = uint(max(version, 0))
+	status.Dirty = dirty
+
+	return status, nil
+}
+
+// Up applies every migration the database doesn't have yet.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.migrate(ctx, func(current int64) (uint, error) {
+		return max(m.Latest(), uint(max(current, 0))), nil
+	})
+}
+
+// Down reverts the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.migrate(ctx, func(current int64) (uint, error) {
+		i := m.index(current)
+		switch {
+		case current == nilVersion:
+			return 0, nil
+		case i < 0:
+			return 0, ErrUnknownVersion
+		case i == 0:
+			return 0, nil
+		default:
+			return m.migrations[i-1].Version, nil
+		}
+	})
+}
+
+// To migrates up or down to version. A version of 0 reverts everything.
+func (m *Migrator) To(ctx context.Context, version uint) error {
+	if version != 0 && m.index(int64(version)) < 0 {
+		return ErrUnknownVersion
+	}
+
+	return m.migrate(ctx, func(int64) (uint, error) {
+		return version, nil
+	})
+}
+
+func (m *Migrator) index(version int64) int {
+	return slices.IndexFunc(m.migrations, func(migration Migration) bool {
+		return int64(migration.Version) == version
+	})
+}
+
+// migrate moves the database to the version target picks, one migration at a
+// time. Everything runs on a single connection holding the advisory lock, so a
+// second instance starting at the same time waits and then finds nothing to do.
+func (m *Migrator) migrate(ctx context.Context, target func(current int64) (uint, error)) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	unlock, err := lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	_, err = conn.ExecContext(ctx, `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version bigint NOT NULL PRIMARY KEY,
+        dirty boolean NOT NULL
+    )`)
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := readVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return &DirtyError{Version: current}
+	}
+
+	to, err := target(current)
+	if err != nil {
+		return err
+	}
+
+	// up
+	for _, migration := range m.migrations {
+		if int64(migration.Version) <= current || migration.Version > to {
+			continue
+		}
+
+		m.logger.Info("applying migration", "version", migration.Version, "name", migration.Name)
+
+		err = m.run(ctx, conn, migration.Up, int64(migration.Version))
+		if err != nil {
+			return err
+		}
+
+		current = int64(migration.Version)
+	}
+
+	// down
+	for int64(to) < current {
+		i := m.index(current)
+		if i < 0 {
+			return fmt.Errorf("%w: the database is at version %d", ErrUnknownVersion, current)
+		}
+
+		previous := int64(nilVersion)
+		if i > 0 {
+			previous = int64(m.migrations[i-1].Version)
+		}
+
+		m.logger.Info("reverting migration", "version", m.migrations[i].Version, "name", m.migrations[i].Name)
+
+		err = m.run(ctx, conn, m.migrations[i].Down, previous)
+		if err != nil {
+			return err
+		}
+
+		current = previous
+	}
+
+	return nil
+}
+
+// run executes one migration file the way golang-migrate does: the version is
+// marked dirty first and only cleaned once the file has run. Files manage
+// their own transactions.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, body string, version int64) error {
+	err := setVersion(ctx, conn, version, true)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(body) != "" {
+		_, err = conn.ExecContext(ctx, body)
+		if err != nil {
+			return fmt.Errorf("migrate: version %d: %w", version, err)
+		}
+	}
+
+	return setVersion(ctx, conn, version, false)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func readVersion(ctx context.Context, db queryRower) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nilVersion, false, nil
+	}
+
+	return version, dirty, err
+}
+
+func setVersion(ctx context.Context, conn *sql.Conn, version int64, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `TRUNCATE schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	if version >= 0 || dirty {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, version, dirty)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// lock takes the session advisory lock golang-migrate uses for the current
+// database and schema, waiting for anyone else migrating to finish.
+func lock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	var database, schema string
+
+	err := conn.QueryRowContext(ctx, `SELECT current_database(), current_schema()`).Scan(&database, &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	key := lockKey(database, schema)
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+		if err != nil {
+			// closing the session releases the lock, rather than handing the
+			// connection back to the pool still holding it
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}, nil
+}
+
+// lockKey is golang-migrate's advisory lock key for the schema_migrations table
+// in a database and schema: the CRC-32 of their names, joined by NUL bytes in
+// the order schema, table, database, times advisoryLockSalt.
+func lockKey(database, schema string) int64 {
+	names := schema + "\x00" + "schema_migrations" + "\x00" + database
+
+	return int64(crc32.ChecksumIEEE([]byte(names)) * advisoryLockSalt)
+}
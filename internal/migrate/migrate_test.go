@@ -0,0 +1,437 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/lib/pq"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+// abc creates tables a, b and c, one migration each.
+var abc = fstest.MapFS{
+	"000001_create_a.up.sql":   file(`CREATE TABLE a (id int);`),
+	"000001_create_a.down.sql": file(`DROP TABLE a;`),
+	"000002_create_b.up.sql":   file(`CREATE TABLE b (id int);`),
+	"000002_create_b.down.sql": file(`DROP TABLE b;`),
+	"000003_create_c.up.sql":   file(`CREATE TABLE c (id int);`),
+	"000003_create_c.down.sql": file(`DROP TABLE c;`),
+}
+
+func TestNew(t *testing.T) {
+	m, err := New(nil, fstest.MapFS{
+		"10_ten.up.sql":     file("ten"),
+		"2_two.down.sql":    file("two down"),
+		"2_two.up.sql":      file("two up"),
+		"1_one.up.sql":      file("one"),
+		"README.md":         file("not a migration"),
+		"3_three.sql":       file("not a migration either"),
+		"sub/4_four.up.sql": file("in a directory"),
+	}, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Migration{
+		{Version: 1, Name: "one", Up: "one"},
+		{Version: 2, Name: "two", Up: "two up", Down: "two down"},
+		{Version: 10, Name: "ten", Up: "ten"},
+	}
+
+	if !slices.Equal(m.migrations, want) {
+		t.Errorf("got migrations %+v, want %+v", m.migrations, want)
+	}
+
+	if m.Latest() != 10 {
+		t.Errorf("got latest %d, want 10", m.Latest())
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"no migrations", fstest.MapFS{"README.md": file("")}},
+		{"version zero", fstest.MapFS{"0_zero.up.sql": file("")}},
+		{"version too large", fstest.MapFS{"99999999999999999999_big.up.sql": file("")}},
+		{"two names for a version", fstest.MapFS{"1_one.up.sql": file(""), "1_uno.down.sql": file("")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(nil, tt.fsys, discard)
+			if err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+
+	_, err := New(nil, fstest.MapFS{}, discard)
+	if !errors.Is(err, ErrNoMigrations) {
+		t.Errorf("got %v for an empty directory, want %v", err, ErrNoMigrations)
+	}
+}
+
+// The lock keys are golang-migrate v4's, from GenerateAdvisoryLockId with the
+// names its postgres driver passes, so the two tools wait for each other.
+func TestLockKey(t *testing.T) {
+	tests := []struct {
+		database, schema string
+		key              int64
+	}{
+		{"kagubird", "public", 2648888115},
+		{"kagubird_test", "migrate_test", 3118495268},
+	}
+
+	for _, tt := range tests {
+		if got := lockKey(tt.database, tt.schema); got != tt.key {
+			t.Errorf("lockKey(%q, %q) = %d, want %d", tt.database, tt.schema, got, tt.key)
+		}
+	}
+}
+
+// openTestDB connects to the database named by KAGUBIRD_TEST_DB_DSN, with a
+// schema of its own that's dropped after the test, so migrations here don't
+// touch the tables the store tests use.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("KAGUBIRD_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("KAGUBIRD_TEST_DB_DSN isn't set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "migrate_" + strings.ToLower(strings.ReplaceAll(t.Name(), "/", "_"))
+
+	_, err = admin.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE; CREATE SCHEMA ` + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		admin.Exec(`DROP SCHEMA IF EXISTS ` + schema + ` CASCADE`)
+	})
+
+	// lib/pq passes parameters it doesn't know to postgres, so every
+	// connection in the pool starts in the schema
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// tables lists the tables in the test's schema, besides schema_migrations.
+func tables(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+
+	rows, err := db.Query(`
+    SELECT table_name FROM information_schema.tables
+    WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'
+    ORDER BY table_name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return names
+}
+
+func TestMigrate(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	m, err := New(db, abc, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		migrate func() error
+		version uint
+		tables  []string
+	}{
+		{"nothing yet", func() error { return nil }, 0, []string{}},
+		{"up", func() error { return m.Up(ctx) }, 3, []string{"a", "b", "c"}},
+		{"up again", func() error { return m.Up(ctx) }, 3, []string{"a", "b", "c"}},
+		{"down", func() error { return m.Down(ctx) }, 2, []string{"a", "b"}},
+		{"to 1", func() error { return m.To(ctx, 1) }, 1, []string{"a"}},
+		{"to 3", func() error { return m.To(ctx, 3) }, 3, []string{"a", "b", "c"}},
+		{"to 0", func() error { return m.To(ctx, 0) }, 0, []string{}},
+		{"down from nothing", func() error { return m.Down(ctx) }, 0, []string{}},
+		{"to 2", func() error { return m.To(ctx, 2) }, 2, []string{"a", "b"}},
+		{"down twice", func() error {
+			if err := m.Down(ctx); err != nil {
+				return err
+			}
+			return m.Down(ctx)
+		}, 0, []string{}},
+	}
+
+	for _, step := range steps {
+		err := step.migrate()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		status, err := m.Status(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if want := (Status{Version: step.version, Latest: 3}); status != want {
+			t.Errorf("%s: got status %+v, want %+v", step.name, status, want)
+		}
+
+		if got := tables(t, db); !slices.Equal(got, step.tables) {
+			t.Errorf("%s: got tables %q, want %q", step.name, got, step.tables)
+		}
+	}
+
+	err = m.To(ctx, 4)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("got %v migrating to a version that doesn't exist, want %v", err, ErrUnknownVersion)
+	}
+}
+
+// A database migrated further than the binary knows about is left alone by Up,
+// and can't be taken down past what the binary has.
+func TestMigrateNewerDatabase(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	newer, err := New(db, abc, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = newer.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	older := fstest.MapFS{}
+	for name, f := range abc {
+		if !strings.HasPrefix(name, "000003") {
+			older[name] = f
+		}
+	}
+
+	m, err := New(db, older, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Status{Version: 3, Latest: 2}); status != want || status.Behind() {
+		t.Errorf("got status %+v, want %+v and not behind", status, want)
+	}
+
+	err = m.Down(ctx)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("got %v going down from version 3, want %v", err, ErrUnknownVersion)
+	}
+}
+
+// A migration that fails leaves the database dirty at its version, and
+// nothing more runs until that's fixed by hand.
+func TestMigrateDirty(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	broken := fstest.MapFS{
+		"000001_create_a.up.sql": abc["000001_create_a.up.sql"],
+		"000002_broken.up.sql":   file(`CREATE TABLE b (id int); SELECT * FROM missing;`),
+		"000003_create_c.up.sql": abc["000003_create_c.up.sql"],
+	}
+
+	m, err := New(db, broken, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Up(ctx)
+	if err == nil {
+		t.Fatal("got no error from a migration that fails")
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Status{Version: 2, Dirty: true, Latest: 3}); status != want || !status.Behind() {
+		t.Errorf("got status %+v, want %+v and behind", status, want)
+	}
+
+	// the statements in the failed file ran as one, so none of them stuck
+	if got := tables(t, db); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("got tables %q, want just a", got)
+	}
+
+	for name, migrate := range map[string]func() error{
+		"up":   func() error { return m.Up(ctx) },
+		"down": func() error { return m.Down(ctx) },
+		"to":   func() error { return m.To(ctx, 1) },
+	} {
+		var dirty *DirtyError
+
+		err := migrate()
+		if !errors.As(err, &dirty) || dirty.Version != 2 {
+			t.Errorf("%s: got %v, want a DirtyError at version 2", name, err)
+		}
+	}
+
+	_, err = db.Exec(`UPDATE schema_migrations SET version = 1, dirty = false`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixed := fstest.MapFS{
+		"000001_create_a.up.sql": broken["000001_create_a.up.sql"],
+		"000002_broken.up.sql":   file(`CREATE TABLE b (id int);`),
+		"000003_create_c.up.sql": broken["000003_create_c.up.sql"],
+	}
+
+	m, err = New(db, fixed, discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := tables(t, db); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("got tables %q after fixing the migration, want a, b and c", got)
+	}
+}
+
+// While one session holds the migration lock no other can take it, and it's
+// released when migrating is done.
+func TestLock(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	var database, schema string
+
+	err = db.QueryRowContext(ctx, `SELECT current_database(), current_schema()`).Scan(&database, &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := lockKey(database, schema)
+
+	tryLock := func() bool {
+		t.Helper()
+
+		other, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer other.Close()
+
+		var locked bool
+
+		err = other.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if locked {
+			_, err = other.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return locked
+	}
+
+	unlock, err := lock(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tryLock() {
+		t.Error("another session took the lock while it was held")
+	}
+
+	unlock()
+
+	if !tryLock() {
+		t.Error("another session couldn't take the lock once it was released")
+	}
+}
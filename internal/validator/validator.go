@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
 	"slices"
 )
@@ -50,6 +51,16 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+// URL returns true if value is an absolute http or https URL with a host.
+func URL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // Generic function which returns true if all values in a slice are unique.
 func Unique[T comparable](values []T) bool {
 	uniqueValues := make(map[T]bool)
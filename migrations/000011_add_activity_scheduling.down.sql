@@ -0,0 +1,23 @@
+BEGIN;
+
+DROP INDEX IF EXISTS activities_trip_id_idx;
+
+ALTER TABLE activities DROP CONSTRAINT IF EXISTS activities_schedule_check;
+
+-- Ideas can't be represented without a time slot, and date-only activities fall
+-- back to spanning whole days.
+DELETE FROM activities WHERE schedule = 'unscheduled';
+
+UPDATE activities
+SET start_time = start_date::timestamptz, end_time = (end_date + 1)::timestamptz
+WHERE schedule IN ('all_day', 'date');
+
+ALTER TABLE activities DROP COLUMN IF EXISTS position;
+ALTER TABLE activities DROP COLUMN IF EXISTS end_date;
+ALTER TABLE activities DROP COLUMN IF EXISTS start_date;
+ALTER TABLE activities DROP COLUMN IF EXISTS schedule;
+
+ALTER TABLE activities ALTER COLUMN end_time SET NOT NULL;
+ALTER TABLE activities ALTER COLUMN start_time SET NOT NULL;
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+-- Ideas have no time slot and all-day or date-only activities only have dates, so
+-- the timestamps become optional.
+ALTER TABLE activities ALTER COLUMN start_time DROP NOT NULL;
+ALTER TABLE activities ALTER COLUMN end_time DROP NOT NULL;
+
+ALTER TABLE activities ADD COLUMN schedule text NOT NULL DEFAULT 'timed';
+ALTER TABLE activities ADD COLUMN start_date date;
+ALTER TABLE activities ADD COLUMN end_date date;
+
+-- Manual ordering for unscheduled ideas.
+ALTER TABLE activities ADD COLUMN position integer NOT NULL DEFAULT 0;
+
+ALTER TABLE activities ADD CONSTRAINT activities_schedule_check
+CHECK (schedule IN ('unscheduled', 'timed', 'all_day', 'date'));
+
+CREATE INDEX IF NOT EXISTS activities_trip_id_idx ON activities (trip_id);
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP INDEX IF EXISTS stays_tags_idx;
+ALTER TABLE stays DROP CONSTRAINT IF EXISTS stays_type_check;
+ALTER TABLE stays DROP COLUMN IF EXISTS tags;
+
+DROP INDEX IF EXISTS activities_tags_idx;
+ALTER TABLE activities DROP CONSTRAINT IF EXISTS activities_category_check;
+ALTER TABLE activities DROP COLUMN IF EXISTS tags;
+ALTER TABLE activities DROP COLUMN IF EXISTS category;
+
+COMMIT;
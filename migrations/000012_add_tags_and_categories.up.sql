@@ -0,0 +1,31 @@
+BEGIN;
+
+ALTER TABLE activities ADD COLUMN category text NOT NULL DEFAULT 'other';
+ALTER TABLE activities ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE activities ADD CONSTRAINT activities_category_check
+CHECK (category IN ('food', 'drinks', 'sightseeing', 'outdoors', 'entertainment', 'shopping', 'transport', 'work', 'other'));
+
+CREATE INDEX IF NOT EXISTS activities_tags_idx ON activities USING GIN (tags);
+
+ALTER TABLE stays ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
+
+-- stays.type used to be free text, so map what people wrote onto the catalog.
+-- Anything unrecognised was most likely somewhere that takes bookings, so it
+-- becomes a hotel.
+UPDATE stays SET type = lower(trim(type));
+UPDATE stays SET type = CASE
+    WHEN type ~ '(airbnb|vrbo|rental|apartment|condo|house|cabin|cottage|villa)' THEN 'rental'
+    WHEN type ~ 'hostel' THEN 'hostel'
+    WHEN type ~ '(camp|tent|\mrv\M)' THEN 'camping'
+    WHEN type ~ '(friend|family)' THEN 'friends'
+    ELSE 'hotel'
+END
+WHERE type NOT IN ('hotel', 'rental', 'hostel', 'camping', 'friends');
+
+ALTER TABLE stays ADD CONSTRAINT stays_type_check
+CHECK (type IN ('hotel', 'rental', 'hostel', 'camping', 'friends'));
+
+CREATE INDEX IF NOT EXISTS stays_tags_idx ON stays USING GIN (tags);
+
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+
+DROP INDEX IF EXISTS stays_trip_id_idx;
+DROP INDEX IF EXISTS locations_activity_id_idx;
+DROP INDEX IF EXISTS trip_goers_user_id_idx;
+DROP INDEX IF EXISTS trips_created_by_idx;
+
+ALTER TABLE stays DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE locations DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE activities DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE trips DROP COLUMN IF EXISTS search_vector;
+
+COMMIT;
@@ -0,0 +1,35 @@
+BEGIN;
+
+-- Weighted search documents: A for names, B for secondary identifiers like cities
+-- and addresses, C for free-form notes.
+ALTER TABLE trips ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(city, '')), 'B')
+) STORED;
+
+ALTER TABLE activities ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(notes, '')), 'C')
+) STORED;
+
+ALTER TABLE locations ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(address, '')), 'B')
+) STORED;
+
+ALTER TABLE stays ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS trips_search_vector_idx ON trips USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS activities_search_vector_idx ON activities USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS locations_search_vector_idx ON locations USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS stays_search_vector_idx ON stays USING GIN (search_vector);
+
+-- Searches are limited to trips the caller created or is going on.
+CREATE INDEX IF NOT EXISTS trips_created_by_idx ON trips (created_by);
+CREATE INDEX IF NOT EXISTS trip_goers_user_id_idx ON trip_goers (user_id);
+CREATE INDEX IF NOT EXISTS locations_activity_id_idx ON locations (activity_id);
+CREATE INDEX IF NOT EXISTS stays_trip_id_idx ON stays (trip_id);
+
+COMMIT;
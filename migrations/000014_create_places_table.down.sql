@@ -0,0 +1,52 @@
+BEGIN;
+
+DROP TABLE IF EXISTS stay_places;
+
+-- Copy each place back onto the locations that link to it.
+ALTER TABLE locations
+    ADD COLUMN name TEXT,
+    ADD COLUMN address TEXT,
+    ADD COLUMN google_place_id TEXT,
+    ADD COLUMN lat decimal(9, 6),
+    ADD COLUMN lng decimal(9, 6),
+    ADD COLUMN website TEXT,
+    ADD COLUMN phone TEXT,
+    ADD COLUMN version integer NOT NULL DEFAULT 1,
+    ADD COLUMN updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+UPDATE locations
+SET name = places.name,
+    address = places.address,
+    google_place_id = COALESCE(places.google_place_id, ''),
+    lat = places.lat,
+    lng = places.lng,
+    website = places.website,
+    phone = places.phone,
+    version = places.version,
+    updated_at = places.updated_at
+FROM places
+WHERE places.id = locations.place_id;
+
+ALTER TABLE locations
+    ALTER COLUMN name SET NOT NULL,
+    ALTER COLUMN address SET NOT NULL,
+    ALTER COLUMN google_place_id SET NOT NULL,
+    ALTER COLUMN lat SET NOT NULL,
+    ALTER COLUMN lng SET NOT NULL,
+    ALTER COLUMN website SET NOT NULL,
+    ALTER COLUMN phone SET NOT NULL;
+
+ALTER TABLE locations ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(address, '')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS locations_search_vector_idx ON locations USING GIN (search_vector);
+
+DROP INDEX IF EXISTS locations_place_id_idx;
+ALTER TABLE locations DROP CONSTRAINT IF EXISTS locations_activity_id_place_id_key;
+ALTER TABLE locations DROP COLUMN place_id;
+
+DROP TABLE IF EXISTS places;
+
+COMMIT;
@@ -0,0 +1,80 @@
+BEGIN;
+
+-- places is the canonical record of somewhere, shared by every activity and
+-- stay that goes there. Editing a place changes it everywhere it's used.
+CREATE TABLE places (
+    id bigserial PRIMARY KEY,
+    google_place_id TEXT,
+    name TEXT NOT NULL,
+    address TEXT NOT NULL,
+    lat decimal(9, 6) NOT NULL,
+    lng decimal(9, 6) NOT NULL,
+    website TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(address, '')), 'B')
+    ) STORED
+);
+
+CREATE INDEX IF NOT EXISTS places_search_vector_idx ON places USING GIN (search_vector);
+
+-- Places entered by hand have no Google place ID. Only real ones are unique.
+CREATE UNIQUE INDEX IF NOT EXISTS places_google_place_id_key ON places (google_place_id) WHERE google_place_id IS NOT NULL;
+
+-- Fold locations into places, keeping the most recently edited copy of each.
+-- Locations saved without a place ID each get a place of their own, which
+-- location_id links back to until they're joined up.
+INSERT INTO places (google_place_id, name, address, lat, lng, website, phone)
+SELECT DISTINCT ON (google_place_id) google_place_id, name, address, lat, lng, website, phone
+FROM locations
+WHERE google_place_id <> ''
+ORDER BY google_place_id, updated_at DESC, id DESC;
+
+ALTER TABLE places ADD COLUMN location_id bigint;
+
+INSERT INTO places (location_id, name, address, lat, lng, website, phone)
+SELECT id, name, address, lat, lng, website, phone
+FROM locations
+WHERE google_place_id = '';
+
+-- locations becomes the link between activities and places.
+ALTER TABLE locations ADD COLUMN place_id bigint REFERENCES places ON DELETE CASCADE;
+UPDATE locations SET place_id = places.id FROM places WHERE places.google_place_id = locations.google_place_id;
+UPDATE locations SET place_id = places.id FROM places WHERE places.location_id = locations.id;
+
+ALTER TABLE places DROP COLUMN location_id;
+
+DELETE FROM locations l USING locations dup
+WHERE dup.activity_id = l.activity_id AND dup.place_id = l.place_id AND dup.id < l.id;
+
+ALTER TABLE locations ALTER COLUMN place_id SET NOT NULL;
+ALTER TABLE locations ADD CONSTRAINT locations_activity_id_place_id_key UNIQUE (activity_id, place_id);
+CREATE INDEX IF NOT EXISTS locations_place_id_idx ON locations (place_id);
+
+DROP INDEX IF EXISTS locations_search_vector_idx;
+ALTER TABLE locations
+    DROP COLUMN search_vector,
+    DROP COLUMN name,
+    DROP COLUMN address,
+    DROP COLUMN google_place_id,
+    DROP COLUMN lat,
+    DROP COLUMN lng,
+    DROP COLUMN website,
+    DROP COLUMN phone,
+    DROP COLUMN version,
+    DROP COLUMN updated_at;
+
+CREATE TABLE stay_places (
+    stay_id bigint NOT NULL REFERENCES stays ON DELETE CASCADE,
+    place_id bigint NOT NULL REFERENCES places ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (stay_id, place_id)
+);
+
+CREATE INDEX IF NOT EXISTS stay_places_place_id_idx ON stay_places (place_id);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS saved_places;
+DROP TABLE IF EXISTS lists;
+
+COMMIT;
@@ -0,0 +1,28 @@
+BEGIN;
+
+-- Personal lists of saved places, such as "Best coffee". A list belongs to one
+-- user and isn't tied to any trip.
+CREATE TABLE lists (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE saved_places (
+    list_id bigint NOT NULL REFERENCES lists ON DELETE CASCADE,
+    place_id bigint NOT NULL REFERENCES places ON DELETE CASCADE,
+    notes TEXT NOT NULL DEFAULT '',
+    rating smallint CHECK (rating BETWEEN 1 AND 5),
+    version integer NOT NULL DEFAULT 1,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (list_id, place_id)
+);
+
+CREATE INDEX IF NOT EXISTS saved_places_place_id_idx ON saved_places (place_id);
+
+COMMIT;
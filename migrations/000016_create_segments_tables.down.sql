@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS segment_travelers;
+DROP TABLE IF EXISTS segments;
+
+COMMIT;
@@ -0,0 +1,39 @@
+BEGIN;
+
+-- Segments are the legs travelers take to get around on a trip: flights, trains,
+-- buses and car rentals. Each end records the place's own time zone, so times
+-- can be shown the way they're printed on the ticket.
+CREATE TABLE segments (
+    id bigserial PRIMARY KEY,
+    trip_id bigint NOT NULL REFERENCES trips ON DELETE CASCADE,
+    mode TEXT NOT NULL CHECK (mode IN ('flight', 'train', 'bus', 'car_rental')),
+    carrier TEXT NOT NULL DEFAULT '',
+    number TEXT NOT NULL DEFAULT '',
+    departure_name TEXT NOT NULL,
+    departure_place_id bigint REFERENCES places ON DELETE SET NULL,
+    departure_time timestamp with time zone NOT NULL,
+    departure_time_zone TEXT NOT NULL,
+    arrival_name TEXT NOT NULL,
+    arrival_place_id bigint REFERENCES places ON DELETE SET NULL,
+    arrival_time timestamp with time zone NOT NULL,
+    arrival_time_zone TEXT NOT NULL,
+    confirmation_code TEXT NOT NULL DEFAULT '',
+    seat TEXT NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CHECK (arrival_time > departure_time)
+);
+
+CREATE INDEX IF NOT EXISTS segments_trip_id_idx ON segments (trip_id, departure_time);
+
+-- The tripgoers booked on a segment.
+CREATE TABLE segment_travelers (
+    segment_id bigint NOT NULL REFERENCES segments ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    PRIMARY KEY (segment_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS segment_travelers_user_id_idx ON segment_travelers (user_id);
+
+COMMIT;
@@ -0,0 +1,25 @@
+BEGIN;
+
+ALTER TABLE stays DROP CONSTRAINT IF EXISTS stays_booking_status_check;
+ALTER TABLE stays DROP COLUMN IF EXISTS payment_reminded_at;
+ALTER TABLE stays DROP COLUMN IF EXISTS cancellation_reminded_at;
+ALTER TABLE stays DROP COLUMN IF EXISTS payment_due;
+ALTER TABLE stays DROP COLUMN IF EXISTS cancellation_deadline;
+ALTER TABLE stays DROP COLUMN IF EXISTS currency;
+ALTER TABLE stays DROP COLUMN IF EXISTS cost;
+ALTER TABLE stays DROP COLUMN IF EXISTS provider;
+ALTER TABLE stays DROP COLUMN IF EXISTS confirmation_number;
+ALTER TABLE stays DROP COLUMN IF EXISTS booking_status;
+
+ALTER TABLE activities DROP CONSTRAINT IF EXISTS activities_booking_status_check;
+ALTER TABLE activities DROP COLUMN IF EXISTS payment_reminded_at;
+ALTER TABLE activities DROP COLUMN IF EXISTS cancellation_reminded_at;
+ALTER TABLE activities DROP COLUMN IF EXISTS payment_due;
+ALTER TABLE activities DROP COLUMN IF EXISTS cancellation_deadline;
+ALTER TABLE activities DROP COLUMN IF EXISTS currency;
+ALTER TABLE activities DROP COLUMN IF EXISTS cost;
+ALTER TABLE activities DROP COLUMN IF EXISTS provider;
+ALTER TABLE activities DROP COLUMN IF EXISTS confirmation_number;
+ALTER TABLE activities DROP COLUMN IF EXISTS booking_status;
+
+COMMIT;
@@ -0,0 +1,32 @@
+BEGIN;
+
+-- Booking details for activities and stays. Cost is in the currency's minor
+-- unit, such as cents. The *_reminded_at columns record when a reminder went
+-- out for a deadline, and are cleared when the deadline changes.
+ALTER TABLE activities ADD COLUMN booking_status TEXT NOT NULL DEFAULT 'idea';
+ALTER TABLE activities ADD COLUMN confirmation_number TEXT NOT NULL DEFAULT '';
+ALTER TABLE activities ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+ALTER TABLE activities ADD COLUMN cost bigint;
+ALTER TABLE activities ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+ALTER TABLE activities ADD COLUMN cancellation_deadline timestamp with time zone;
+ALTER TABLE activities ADD COLUMN payment_due date;
+ALTER TABLE activities ADD COLUMN cancellation_reminded_at timestamp(0) with time zone;
+ALTER TABLE activities ADD COLUMN payment_reminded_at timestamp(0) with time zone;
+
+ALTER TABLE activities ADD CONSTRAINT activities_booking_status_check
+CHECK (booking_status IN ('idea', 'needs_booking', 'booked', 'cancelled'));
+
+ALTER TABLE stays ADD COLUMN booking_status TEXT NOT NULL DEFAULT 'idea';
+ALTER TABLE stays ADD COLUMN confirmation_number TEXT NOT NULL DEFAULT '';
+ALTER TABLE stays ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+ALTER TABLE stays ADD COLUMN cost bigint;
+ALTER TABLE stays ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+ALTER TABLE stays ADD COLUMN cancellation_deadline timestamp with time zone;
+ALTER TABLE stays ADD COLUMN payment_due date;
+ALTER TABLE stays ADD COLUMN cancellation_reminded_at timestamp(0) with time zone;
+ALTER TABLE stays ADD COLUMN payment_reminded_at timestamp(0) with time zone;
+
+ALTER TABLE stays ADD CONSTRAINT stays_booking_status_check
+CHECK (booking_status IN ('idea', 'needs_booking', 'booked', 'cancelled'));
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS drafts;
+DROP TABLE IF EXISTS trip_inboxes;
+
+COMMIT;
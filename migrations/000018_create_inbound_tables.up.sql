@@ -0,0 +1,32 @@
+BEGIN;
+
+-- Each trip can have an address that booking confirmations are forwarded to,
+-- trip+<token>@ the inbound domain. Rotating the token retires the old address.
+CREATE TABLE trip_inboxes (
+    trip_id bigint PRIMARY KEY REFERENCES trips ON DELETE CASCADE,
+    token TEXT NOT NULL UNIQUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Stays and activities read from forwarded emails, waiting for someone on the
+-- trip to confirm or discard them. item holds the stay or activity as JSON, and
+-- part which of the message's reservations it was read from.
+CREATE TABLE drafts (
+    id bigserial PRIMARY KEY,
+    trip_id bigint NOT NULL REFERENCES trips ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('activity', 'stay')),
+    item jsonb NOT NULL,
+    message_id TEXT NOT NULL DEFAULT '',
+    part integer NOT NULL DEFAULT 0,
+    sender TEXT NOT NULL DEFAULT '',
+    subject TEXT NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS drafts_trip_id_idx ON drafts (trip_id, message_id);
+
+-- A message delivered twice at once, as MTAs retrying do, only makes its
+-- drafts once.
+CREATE UNIQUE INDEX IF NOT EXISTS drafts_message_part_idx ON drafts (trip_id, message_id, part) WHERE message_id <> '';
+
+COMMIT;
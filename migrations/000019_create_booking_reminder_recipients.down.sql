@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS booking_reminder_recipients;
+
+COMMIT;
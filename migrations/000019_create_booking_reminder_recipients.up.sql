@@ -0,0 +1,20 @@
+BEGIN;
+
+-- The travelers a booking reminder has reached, so that when sending fails for
+-- some of them only they get it on the next pass. Rows are keyed by when the
+-- deadline is due, so a changed deadline reminds everyone again, and they're
+-- cleared once the reminder has reached everyone.
+CREATE TABLE booking_reminder_recipients (
+    activity_id bigint REFERENCES activities ON DELETE CASCADE,
+    stay_id bigint REFERENCES stays ON DELETE CASCADE,
+    deadline TEXT NOT NULL,
+    due timestamp with time zone NOT NULL,
+    email citext NOT NULL,
+    reminded_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CHECK ((activity_id IS NULL) <> (stay_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS booking_reminder_recipients_key
+ON booking_reminder_recipients (COALESCE(activity_id, 0), COALESCE(stay_id, 0), deadline, due, email);
+
+COMMIT;
@@ -49,10 +49,6 @@ apt --yes install locales-all
 # Install fail2ban.
 apt --yes install fail2ban
 
-# Install migrate CLI tool
-curl -L https://github.com/golang-migrate/migrate/releases/download/v4.14.1/migrate.linux-amd64.tar.gz | tar xvz
-mv migrate.linux-amd64 /usr/local/bin/migrate
-
 # Install postgreSQL
 apt --yes install postgresql
 